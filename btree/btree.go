@@ -3,60 +3,236 @@ package btree
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
 )
 
 const (
-	// MaxItems is the maximum number of items in a node (soft cap; actual fit is enforced by size estimation)
+	// MaxItems is the maximum number of items in a node. A node splits when
+	// it holds more, or when its items no longer fit in a page, whichever
+	// comes first. The smallest item, an empty key and value, takes 6
+	// bytes (14 in an internal node, with its child pointer), so a page
+	// could hold about 680 of them; MaxItems is the limit that binds for
+	// small items. Both stay far below the 65535 a page's uint16 item
+	// count can record, which the assertions below keep true should the
+	// page size or MaxItems grow.
 	MaxItems = 255
 
 	// MinItems is the minimum number of items in a node
 	MinItems = MaxItems / 2
+
+	// DefaultAppendFillFactor is the share of a page an append split leaves
+	// in the left node when Options.AppendFillFactor is zero
+	DefaultAppendFillFactor = 0.9
+)
+
+// A node's item count is stored as a uint16; these fail to compile if
+// MaxItems, or the items that could fit in a page, could exceed it
+const (
+	_ uint16 = MaxItems
+	_ uint16 = (NodeSize - NodeHeaderSize) / (2 + 4)
 )
 
 var (
 	ErrKeyNotFound   = errors.New("key not found")
 	ErrKeyTooLarge   = errors.New("key too large")
+	ErrEmptyKey      = errors.New("empty key")
 	ErrValueTooLarge = errors.New("value too large")
+	ErrReservedKey   = errors.New("key is reserved for shared values")
 )
 
+// Op is a single mutation applied by Batch
+type Op struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// Cond is a precondition checked by Txn: Key must hold exactly Value, or,
+// with Absent set, must not exist
+type Cond struct {
+	Key    []byte
+	Value  []byte
+	Absent bool
+}
+
+// BatchOptions tunes how Batch writes pages
+type BatchOptions struct {
+	// Sequential allocates fresh, contiguous page IDs for the batch instead of
+	// reusing freed pages, so the commit writes one sequential file region.
+	// Pages freed before or during the batch are reused by later writes.
+	Sequential bool
+}
+
 // BTree represents a B-tree
 type BTree struct {
-	mu      sync.RWMutex
-	storage *Storage
+	mu         sync.RWMutex
+	storage    *Storage
+	appendFill float64
+
+	yieldEvery  int
+	yieldLocker sync.Locker
+	// closed is set by Close
+	closed bool
+	// pins holds traversals that may pause; Compact keeps the pages under
+	// their roots
+	pinMu sync.Mutex
+	pins  map[*traversal]struct{}
+	// maxReaders caps len(pins); zero is no cap
+	maxReaders int
+	// paused counts traversals paused with the lock released; pages may
+	// only be overwritten in place while there are none
+	paused atomic.Int32
+
+	overwriteInPlace   bool
+	truncateSeparators bool
+	maxSeparatorBytes  int
+	splitStrategy      SplitStrategy
+
+	// pinnedKeys are the keys whose paths DropCache keeps; see Pin
+	pinnedKeys     map[string]struct{}
+	maxPinnedPages int
+
+	// dedupMinValueSize is the smallest value Compact shares between keys;
+	// zero disables it. released collects the hashes of shared values a
+	// write dropped a reference to.
+	dedupMinValueSize int
+	released          [][]byte
+
+	// readahead is how many pages ahead Scan prefetches; prefetchers holds
+	// a token for each prefetch in flight
+	readahead   int
+	prefetchers chan struct{}
 }
 
 // NewBTree creates a new B-tree
 func NewBTree(storagePath string) (*BTree, error) {
-	storage, err := OpenStorage(storagePath)
+	return NewBTreeWithOptions(storagePath, Options{})
+}
+
+// NewBTreeWithOptions opens a B-tree with the given storage options
+func NewBTreeWithOptions(storagePath string, opts Options) (*BTree, error) {
+	storage, err := OpenStorageWithOptions(storagePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newBTree(storage, opts), nil
+}
+
+// NewBTreeWithStore opens a B-tree over pages, such as a MemPageStore, instead
+// of a file. The tree owns pages and closes it on Close.
+func NewBTreeWithStore(pages PageStore, opts Options) (*BTree, error) {
+	storage, err := OpenStorageWithStore(pages, opts)
 	if err != nil {
 		return nil, err
 	}
+	return newBTree(storage, opts), nil
+}
+
+// OpenReadOnlyReaderAt opens the tree stored in the first size bytes of r,
+// such as a database file embedded in a binary or fetched from object
+// storage, for Get and Scan. Every write fails with ErrReadOnly. Closing the
+// tree leaves r open.
+func OpenReadOnlyReaderAt(r io.ReaderAt, size int64) (*BTree, error) {
+	return OpenReadOnlyReaderAtWithOptions(r, size, Options{})
+}
+
+// OpenReadOnlyReaderAtWithOptions is OpenReadOnlyReaderAt with options, such
+// as the key of an encrypted file; ReadOnly is implied
+func OpenReadOnlyReaderAtWithOptions(r io.ReaderAt, size int64, opts Options) (*BTree, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: empty image", ErrCorrupt)
+	}
+	opts.ReadOnly = true
+	return NewBTreeWithStore(&readerAtPageStore{r: r, size: size}, opts)
+}
+
+// newBTree wraps opened storage in a tree configured by opts
+func newBTree(storage *Storage, opts Options) *BTree {
+	fill := opts.AppendFillFactor
+	if fill == 0 {
+		fill = DefaultAppendFillFactor
+	}
+	maxPinned := opts.MaxPinnedPages
+	if maxPinned <= 0 {
+		maxPinned = DefaultMaxPinnedPages
+	}
 
 	return &BTree{
-		storage: storage,
-	}, nil
+		storage:     storage,
+		appendFill:  min(fill, 1),
+		yieldEvery:  opts.YieldEvery,
+		yieldLocker: opts.YieldLocker,
+		pins:        make(map[*traversal]struct{}),
+		maxReaders:  opts.MaxReaders,
+
+		overwriteInPlace:   opts.UnsafeOverwriteInPlace,
+		truncateSeparators: opts.TruncateSeparators,
+		maxSeparatorBytes:  max(opts.MaxSeparatorBytes, 0),
+		splitStrategy:      opts.SplitStrategy,
+		pinnedKeys:         make(map[string]struct{}),
+		maxPinnedPages:     maxPinned,
+		dedupMinValueSize:  opts.DedupMinValueSize,
+		readahead:          min(max(opts.Readahead, 0), MaxReadahead),
+		prefetchers:        make(chan struct{}, maxPrefetchers),
+	}
 }
 
-// Reload refreshes in-memory metadata to reflect external changes.
+// Reload refreshes in-memory metadata to reflect external changes, and
+// drops every cached node if another writer has committed since. A value
+// overwritten in place (Options.UnsafeOverwriteInPlace) commits no header,
+// so a reader that cached its page still sees the old value.
 func (t *BTree) Reload() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	return t.storage.ReloadHeader()
 }
 
+// DropCache empties the node cache so later reads deserialize pages from
+// disk again, returning how many nodes it dropped. Pages on the paths to
+// pinned keys are kept. Nodes already handed to a paused traversal stay
+// valid.
+func (t *BTree) DropCache() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// If the paths cannot be walked, drop them too rather than fail
+	keep, _ := t.pinnedPages()
+	return t.storage.dropCache(keep)
+}
+
+// Seal permanently marks the tree's file as read-only
+func (t *BTree) Seal() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.storage.Seal()
+}
+
+// Sealed reports whether the tree's file has been sealed
+func (t *BTree) Sealed() bool {
+	return t.storage.Sealed()
+}
+
+// FreeSpace returns the bytes available on the file system holding the
+// tree's file
+func (t *BTree) FreeSpace() (uint64, error) {
+	return t.storage.FreeSpace()
+}
+
 // Close closes the B-tree
 func (t *BTree) Close() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	t.closed = true
 	return t.storage.Close()
 }
 
 // Get gets a value from the B-tree
 func (t *BTree) Get(key []byte) ([]byte, error) {
-	if len(key) > MaxKeySize {
-		return nil, ErrKeyTooLarge
+	if err := checkKey(key); err != nil {
+		return nil, err
 	}
 
 	t.mu.RLock()
@@ -72,16 +248,25 @@ func (t *BTree) Get(key []byte) ([]byte, error) {
 	return t.search(root, key)
 }
 
-// search searches for a key in the B-tree
-func (t *BTree) search(node *Node, key []byte) ([]byte, error) {
+// search returns the value of key in the tree rooted at root
+func (t *BTree) search(root *Node, key []byte) ([]byte, error) {
+	item, err := t.searchItem(root, key)
+	if err != nil {
+		return nil, err
+	}
+	return t.resolve(root, item)
+}
+
+// searchItem finds the leaf item for key in the subtree rooted at node
+func (t *BTree) searchItem(node *Node, key []byte) (Item, error) {
 	if node.nodeType == LeafNode {
 		// Search in leaf node
 		for _, item := range node.items {
 			if bytes.Equal(item.Key, key) {
-				return item.Value, nil
+				return item, nil
 			}
 		}
-		return nil, ErrKeyNotFound
+		return Item{}, ErrKeyNotFound
 	}
 
 	// Search in internal node
@@ -89,16 +274,93 @@ func (t *BTree) search(node *Node, key []byte) ([]byte, error) {
 	childID := node.children[childPos]
 	child, err := t.storage.GetNode(childID)
 	if err != nil {
-		return nil, err
+		return Item{}, err
+	}
+
+	return t.searchItem(child, key)
+}
+
+// Scan calls fn for each key-value pair with a key >= start, in ascending key
+// order, until fn returns false. A nil start scans from the smallest key.
+// The slices passed to fn are owned by the tree and must be copied if retained.
+//
+// The scan sees the tree at one instant, as committed when it began. With
+// YieldEvery set, writes, and Compact, may run while it is paused without
+// changing what it returns. If the tree is closed meanwhile it returns
+// ErrClosed.
+func (t *BTree) Scan(start []byte, fn func(key, value []byte) bool) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tr, err := t.newTraversal()
+	if err != nil {
+		return err
+	}
+	defer tr.finish()
+	root, err := t.storage.GetNode(tr.root)
+	if err != nil {
+		return err
+	}
+
+	var stepErr error
+	_, err = t.scan(root, root, start, func(key, value []byte) bool {
+		if !fn(key, value) {
+			return false
+		}
+		stepErr = tr.step()
+		return stepErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return stepErr
+}
+
+// scan walks the subtree rooted at node, part of the tree rooted at root, in
+// key order, reporting whether the caller should continue with the next
+// subtree
+func (t *BTree) scan(root, node *Node, start []byte, fn func(key, value []byte) bool) (bool, error) {
+	if node.nodeType == LeafNode {
+		for _, item := range node.items {
+			if start != nil && bytes.Compare(item.Key, start) < 0 {
+				continue
+			}
+			value, err := t.resolve(root, item)
+			if err != nil {
+				return false, err
+			}
+			if !fn(item.Key, value) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	// Skip subtrees that only hold keys below start
+	pos := 0
+	if start != nil {
+		pos = node.FindChildPos(start)
+	}
+	queued := pos
+	for i, childID := range node.children[pos:] {
+		queued = t.readAhead(node.children, pos+i, queued)
+		child, err := t.storage.GetNode(childID)
+		if err != nil {
+			return false, err
+		}
+		cont, err := t.scan(root, child, start, fn)
+		if err != nil || !cont {
+			return cont, err
+		}
 	}
 
-	return t.search(child, key)
+	return true, nil
 }
 
 // Put puts a key-value pair in the B-tree
 func (t *BTree) Put(key []byte, value []byte) error {
-	if len(key) > MaxKeySize {
-		return ErrKeyTooLarge
+	if err := checkWriteKey(key); err != nil {
+		return err
 	}
 	if len(value) > MaxValueSize {
 		return ErrValueTooLarge
@@ -107,71 +369,198 @@ func (t *BTree) Put(key []byte, value []byte) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	if t.overwriteInPlace && t.paused.Load() == 0 {
+		if done, err := t.overwrite(key, value); done || err != nil {
+			return err
+		}
+	}
+
 	// Begin transaction
 	if err := t.storage.BeginTransaction(); err != nil {
 		return err
 	}
 
+	if err := t.putTx(key, value); err != nil {
+		t.storage.abortTransaction()
+		return err
+	}
+
+	// Commit transaction
+	return t.storage.CommitTransaction()
+}
+
+// putTx inserts a key-value pair inside the caller's transaction
+func (t *BTree) putTx(key []byte, value []byte) error {
+	return t.putItemTx(Item{Key: key, Value: value})
+}
+
+// putItemTx inserts item inside the caller's transaction, releasing the
+// shared value the item it replaces referred to
+func (t *BTree) putItemTx(item Item) error {
+	t.released = nil
+
 	// Get the root node
 	root, err := t.storage.GetRootNode()
 	if err != nil {
-		t.storage.abortTransaction()
 		return err
 	}
 
 	// Insert the key-value pair
-	newRoot, split, err := t.insert(root, key, value)
+	newRoot, sibling, sep, err := t.insert(root, item, true)
 	if err != nil {
-		t.storage.abortTransaction()
 		return err
 	}
 
-	// Handle root split
-	if split {
-		// Create a new root
-		newRootID := t.storage.nodePool.Allocate()
-		rootNode := NewInternalNode(newRootID)
-
-		// Add the old root as a child
-		if err := rootNode.AddChild(0, root.id); err != nil {
-			t.storage.abortTransaction()
+	// Handle root split by growing the tree one level
+	if sibling != nil {
+		rootNode := NewInternalNode(t.storage.nodePool.Allocate())
+		if err := rootNode.AddChild(0, newRoot.id); err != nil {
 			return err
 		}
-
-		// Add the new node (returned from insert) as a child
-		if err := rootNode.AddChild(1, newRoot.id); err != nil {
-			t.storage.abortTransaction()
+		if err := rootNode.AddChild(1, sibling.id); err != nil {
 			return err
 		}
+		rootNode.AddItem(Item{Key: sep, Value: nil})
+		newRoot = rootNode
+	}
+
+	// Publish the path-copied root
+	if err := t.storage.SetRootNode(newRoot); err != nil {
+		return err
+	}
+	if err := t.releaseRefs(); err != nil {
+		return err
+	}
+	return t.storage.spillDirty()
+}
+
+// Batch applies ops atomically in a single transaction: either all of them
+// are committed or none are. Deleting a key that does not exist is not an error.
+func (t *BTree) Batch(ops []Op, opts BatchOptions) error {
+	if err := validateOps(ops); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Begin transaction
+	if err := t.storage.BeginTransaction(); err != nil {
+		return err
+	}
+
+	if opts.Sequential {
+		t.storage.nodePool.SetSequential(true)
+		defer t.storage.nodePool.SetSequential(false)
+	}
+
+	if err := t.applyOpsTx(ops); err != nil {
+		t.storage.abortTransaction()
+		return err
+	}
+
+	// Commit transaction
+	return t.storage.CommitTransaction()
+}
+
+// Txn applies ops atomically, like Batch, but only if every condition holds
+// when the transaction starts. It reports whether the ops were applied; a
+// failed condition is not an error and leaves the tree untouched.
+func (t *BTree) Txn(conds []Cond, ops []Op) (bool, error) {
+	if err := validateOps(ops); err != nil {
+		return false, err
+	}
+	for _, cond := range conds {
+		if err := checkKey(cond.Key); err != nil {
+			return false, err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-		// Add the split key from the new node
-		rootNode.AddItem(Item{Key: newRoot.items[0].Key, Value: nil})
+	if err := t.storage.BeginTransaction(); err != nil {
+		return false, err
+	}
 
-		// Update children's parent pointers
-		if err := t.setParent(root.id, rootNode.id); err != nil {
+	for _, cond := range conds {
+		value, exists, err := t.lookupTx(cond.Key)
+		if err != nil {
 			t.storage.abortTransaction()
-			return err
+			return false, err
 		}
-		if err := t.setParent(newRoot.id, rootNode.id); err != nil {
+		if cond.Absent == exists || exists && !bytes.Equal(value, cond.Value) {
 			t.storage.abortTransaction()
-			return err
+			return false, nil
 		}
+	}
 
-		// Set the new root
-		if err := t.storage.SetRootNode(rootNode); err != nil {
-			t.storage.abortTransaction()
+	if err := t.applyOpsTx(ops); err != nil {
+		t.storage.abortTransaction()
+		return false, err
+	}
+
+	if err := t.storage.CommitTransaction(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkKey rejects keys the tree cannot hold. The empty key is refused
+// rather than stored as the smallest key: the API has no way to name it,
+// and an embedded caller passing one is far more likely to have a bug.
+func checkKey(key []byte) error {
+	switch {
+	case len(key) == 0:
+		return ErrEmptyKey
+	case len(key) > MaxKeySize:
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// checkWriteKey is checkKey for keys about to be written, which must also
+// stay clear of the shared values Compact keeps under sharedPrefix: a
+// caller overwriting one would corrupt every key referring to it
+func checkWriteKey(key []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if bytes.HasPrefix(key, sharedPrefix) {
+		return ErrReservedKey
+	}
+	return nil
+}
+
+// validateOps checks keys and value sizes before a transaction begins
+func validateOps(ops []Op) error {
+	for _, op := range ops {
+		if err := checkWriteKey(op.Key); err != nil {
 			return err
 		}
-	} else if newRoot != nil && newRoot.id != root.id {
-		// Set the new root (no split but path-copied root)
-		if err := t.storage.SetRootNode(newRoot); err != nil {
-			t.storage.abortTransaction()
-			return err
+		if !op.Delete && len(op.Value) > MaxValueSize {
+			return ErrValueTooLarge
 		}
 	}
+	return nil
+}
 
-	// Commit transaction
-	return t.storage.CommitTransaction()
+// applyOpsTx applies ops in order inside the caller's transaction
+func (t *BTree) applyOpsTx(ops []Op) error {
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			if err = t.deleteTx(op.Key); errors.Is(err, ErrKeyNotFound) {
+				err = nil
+			}
+		} else {
+			err = t.putTx(op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // estimateNodeSize computes the size if node had its current content;
@@ -196,218 +585,219 @@ func estimateNodeSize(node *Node, withItem *Item, withNewChild int) int {
 	return size
 }
 
-// insert inserts a key-value pair in a node
-func (t *BTree) insert(node *Node, key []byte, value []byte) (*Node, bool, error) {
-	if node.nodeType == LeafNode {
-		// Check if the key already exists
-		pos := node.FindKey(key)
-		if pos >= 0 {
-			// Update the value
-			node.items[pos].Value = value
-			return node, false, t.storage.PutNode(node)
-		}
+// overfull reports whether node no longer fits in a page of limit bytes
+func overfull(node *Node, limit int) bool {
+	return len(node.items) > MaxItems || estimateNodeSize(node, nil, -1) > limit
+}
 
-		// Create a copy of the node (copy-on-write)
-		nodeCopy, err := t.storage.CloneNode(node)
-		if err != nil {
-			return nil, false, err
-		}
+// insert inserts a key-value pair into the subtree rooted at node. It returns
+// the copy that replaces node and, when that copy had to split, the new right
+// sibling together with the separator key that routes to it. rightmost is set
+// when node is the last node on its level, where appends land.
+func (t *BTree) insert(node *Node, item Item, rightmost bool) (*Node, *Node, []byte, error) {
+	key := item.Key
+	// Create a copy of the node (copy-on-write)
+	nodeCopy, err := t.storage.CloneNode(node)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-		// Ensure adding the item will fit the page; if not, split first
-		candidate := Item{Key: key, Value: value}
-		if estimateNodeSize(nodeCopy, &candidate, -1) > NodeSize || len(nodeCopy.items)+1 > MaxItems {
-			// Split first, then insert into the appropriate half by recursing
-			newSibling, _, err := t.splitLeaf(nodeCopy)
-			if err != nil {
-				return nil, false, err
-			}
-			// Decide target: compare to split boundary (first key of sibling)
-			if bytes.Compare(key, newSibling.items[0].Key) < 0 {
-				// insert into left (nodeCopy)
-				nodeCopy.AddItem(candidate)
-				if err := t.storage.PutNode(nodeCopy); err != nil {
-					return nil, false, err
-				}
-				return newSibling, true, nil
-			}
-			// insert into right (newSibling)
-			newSibling.AddItem(candidate)
-			if err := t.storage.PutNode(newSibling); err != nil {
-				return nil, false, err
-			}
-			return newSibling, true, nil
+	if nodeCopy.nodeType == LeafNode {
+		if pos := nodeCopy.FindKey(key); pos >= 0 {
+			// Update the value; a longer one may still overflow the page
+			t.release(nodeCopy.items[pos])
+			nodeCopy.items[pos].Value = item.Value
+			nodeCopy.items[pos].ref = item.ref
+		} else {
+			nodeCopy.AddItem(item)
 		}
-
-		// Add the item
-		nodeCopy.AddItem(candidate)
-
-		// Check if the node needs to be split by count (secondary guard)
-		if len(nodeCopy.items) > MaxItems || estimateNodeSize(nodeCopy, nil, -1) > NodeSize {
-			return t.splitLeaf(nodeCopy)
+		if !overfull(nodeCopy, t.storage.maxNodeBytes) {
+			return nodeCopy, nil, nil, nil
 		}
 
-		return nodeCopy, false, nil
+		// A key past the current maximum of the last leaf is an append
+		appending := rightmost && bytes.Equal(nodeCopy.items[len(nodeCopy.items)-1].Key, key)
+		sibling, err := t.splitLeaf(nodeCopy, appending, nodeCopy.FindKey(key))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return nodeCopy, sibling, t.leafSeparator(nodeCopy, sibling), nil
 	}
 
-	// Internal node
-	childPos := node.FindChildPos(key)
-	childID := node.children[childPos]
-	child, err := t.storage.GetNode(childID)
+	// Internal node: descend into the child that owns the key
+	childPos := nodeCopy.FindChildPos(key)
+	child, err := t.storage.GetNode(nodeCopy.children[childPos])
 	if err != nil {
-		return nil, false, err
+		return nil, nil, nil, err
 	}
 
-	// Recursively insert in the child
-	newChild, split, err := t.insert(child, key, value)
+	lastChild := childPos == len(nodeCopy.children)-1
+	newChild, childSibling, childSep, err := t.insert(child, item, rightmost && lastChild)
 	if err != nil {
-		return nil, false, err
+		return nil, nil, nil, err
 	}
+	nodeCopy.children[childPos] = newChild.id
 
-	if !split {
-		// No split occurred, update the child pointer if needed
-		if newChild != nil && newChild.id != child.id {
-			// Create a copy of the node (copy-on-write)
-			nodeCopy, err := t.storage.CloneNode(node)
-			if err != nil {
-				return nil, false, err
-			}
-
-			// Update the child pointer
-			nodeCopy.children[childPos] = newChild.id
-
-			// Maintain child's parent pointer
-			if err := t.setParent(newChild.id, nodeCopy.id); err != nil {
-				return nil, false, err
-			}
-
-			return nodeCopy, false, nil
-		}
+	if childSibling == nil {
+		return nodeCopy, nil, nil, nil
+	}
 
-		return node, false, nil
+	// Child split: link the new sibling right after it
+	nodeCopy.AddItem(Item{Key: childSep, Value: nil})
+	if err := nodeCopy.AddChild(childPos+1, childSibling.id); err != nil {
+		return nil, nil, nil, err
+	}
+	if !overfull(nodeCopy, t.storage.maxNodeBytes) {
+		return nodeCopy, nil, nil, nil
 	}
 
-	// Split occurred in child, create a copy of this node (copy-on-write)
-	nodeCopy, err := t.storage.CloneNode(node)
+	sibling, sep, err := t.splitInternal(nodeCopy, rightmost && lastChild)
 	if err != nil {
-		return nil, false, err
+		return nil, nil, nil, err
 	}
+	return nodeCopy, sibling, sep, nil
+}
 
-	// Add the new child and split key
-	splitKey := newChild.items[0].Key
-	// Ensure capacity for key and new child pointer
-	if estimateNodeSize(nodeCopy, &Item{Key: splitKey, Value: nil}, childPos+1) > NodeSize || len(nodeCopy.items)+1 > MaxItems {
-		// Split this internal node before inserting
-		promoted, _, err := t.splitInternal(nodeCopy)
-		if err != nil {
-			return nil, false, err
-		}
-		// After splitting, the caller handles parent propagation
-		return promoted, true, nil
-	}
+// itemSize is the serialized size of an item
+func itemSize(it Item) int {
+	return 2 + len(it.Key) + 4 + len(it.Value)
+}
 
-	nodeCopy.AddItem(Item{Key: splitKey, Value: nil})
-	if err := nodeCopy.AddChild(childPos+1, newChild.id); err != nil {
-		return nil, false, err
+// splitPoint picks the index of the first item that moves to the right half.
+// It starts at mid and shifts it until both halves fit in limit bytes, which
+// matters when a few large values sit next to many small ones.
+func splitPoint(items []Item, mid, limit int, fixed func(left int) (int, int)) int {
+	for {
+		leftFixed, rightFixed := fixed(mid)
+		left, right := leftFixed, rightFixed
+		for _, it := range items[:mid] {
+			left += itemSize(it)
+		}
+		for _, it := range items[mid:] {
+			right += itemSize(it)
+		}
+		switch {
+		case left > limit && mid > 1:
+			mid--
+		case right > limit && mid < len(items)-1:
+			mid++
+		default:
+			return mid
+		}
 	}
+}
 
-	// Maintain new child's parent pointer
-	if err := t.setParent(newChild.id, nodeCopy.id); err != nil {
-		return nil, false, err
+// splitStart returns where a split of items begins its search. Ordinary
+// splits start at the midpoint, by count or by bytes as the split strategy
+// says. Splits caused by appending past the rightmost key keep the left node
+// filled to the append fill factor instead, since sequential inserts never
+// come back to it; a midpoint split would leave every leaf of a time-series
+// half empty. Under SplitAppend an insert at items[at] in the upper half is
+// taken to extend one of several ascending runs sharing the node, and the
+// split starts there, no further right than an append split would.
+func (t *BTree) splitStart(items []Item, appending bool, at int, fixed func(left int) (int, int)) int {
+	mid := len(items) / 2
+	if t.appendFill > 0.5 {
+		if appending {
+			return t.appendStart(items, fixed)
+		}
+		if t.splitStrategy == SplitAppend && at > mid {
+			return min(at, t.appendStart(items, fixed))
+		}
 	}
-
-	// Check if the node needs to be split
-	if len(nodeCopy.items) > MaxItems || estimateNodeSize(nodeCopy, nil, -1) > NodeSize {
-		return t.splitInternal(nodeCopy)
+	if t.splitStrategy == SplitByBytes {
+		return byteMedian(items, fixed)
 	}
-
-	return nodeCopy, false, nil
+	return mid
 }
 
-// setParent updates a child's parent pointer and persists it in the current tx
-func (t *BTree) setParent(childID NodeID, parentID NodeID) error {
-	child, err := t.storage.GetNode(childID)
-	if err != nil {
-		return err
+// appendStart returns the split point that leaves the left node filled to
+// the append fill factor
+func (t *BTree) appendStart(items []Item, fixed func(left int) (int, int)) int {
+	leftFixed := func(left int) int {
+		l, _ := fixed(left)
+		return l
 	}
-	childCopy, err := t.storage.CloneNode(child)
-	if err != nil {
-		return err
+
+	budget := int(t.appendFill * float64(t.storage.maxNodeBytes))
+	maxLeft := int(t.appendFill * MaxItems)
+	size, mid := 0, 0
+	for ; mid < len(items)-1 && mid < maxLeft; mid++ {
+		if leftFixed(mid+1)+size+itemSize(items[mid]) > budget {
+			break
+		}
+		size += itemSize(items[mid])
 	}
-	childCopy.SetParent(parentID)
-	return t.storage.PutNode(childCopy)
+	return max(mid, 1)
 }
 
-// splitLeaf splits a leaf node
-func (t *BTree) splitLeaf(node *Node) (*Node, bool, error) {
+// splitLeaf moves the upper part of node's items into a new right sibling.
+// An append split leaves the left node nearly full. at is where the insert
+// that overfilled node landed.
+func (t *BTree) splitLeaf(node *Node, appending bool, at int) (*Node, error) {
 	// Create a new node
-	newNodeID := t.storage.nodePool.Allocate()
-	newNode := NewLeafNode(newNodeID)
+	newNode := NewLeafNode(t.storage.nodePool.Allocate())
 
-	// Move half of the items to the new node
-	mid := len(node.items) / 2
+	fixed := func(int) (int, int) { return NodeHeaderSize, NodeHeaderSize }
+	start := t.splitStart(node.items, appending, at, fixed)
+	mid := splitPoint(node.items, start, t.storage.maxNodeBytes, fixed)
+	mid = t.boundSeparator(node.items, mid)
 	newNode.items = append(newNode.items, node.items[mid:]...)
-	node.items = node.items[:mid]
+	node.items = append([]Item(nil), node.items[:mid]...)
 	node.count = uint16(len(node.items))
 	newNode.count = uint16(len(newNode.items))
 
-	// Set parents (new node inherits node.parent)
-	newNode.parent = node.parent
-
 	// Save the nodes
 	if err := t.storage.PutNode(node); err != nil {
-		return nil, false, err
+		return nil, err
 	}
 	if err := t.storage.PutNode(newNode); err != nil {
-		return nil, false, err
+		return nil, err
 	}
 
-	return newNode, true, nil
+	return newNode, nil
 }
 
-// splitInternal splits an internal node
-func (t *BTree) splitInternal(node *Node) (*Node, bool, error) {
+// splitInternal moves the upper part of node's separators and children into
+// a new right sibling and returns the separator promoted to the parent. An
+// append split leaves the left node nearly full.
+func (t *BTree) splitInternal(node *Node, appending bool) (*Node, []byte, error) {
 	// Create a new node
-	newNodeID := t.storage.nodePool.Allocate()
-	newNode := NewInternalNode(newNodeID)
-
-	// Move half of the items to the new node
-	mid := len(node.items) / 2
-	newNode.items = append(newNode.items, node.items[mid+1:]...)
-	splitItem := node.items[mid]
-	node.items = node.items[:mid]
-	node.count = uint16(len(node.items))
-	newNode.count = uint16(len(newNode.items))
+	newNode := NewInternalNode(t.storage.nodePool.Allocate())
 
-	// Move half of the children to the new node
-	newNode.children = append(newNode.children, node.children[mid+1:]...)
-	node.children = node.children[:mid+1]
-
-	// Update parent pointers for children moved to newNode
-	for _, childID := range newNode.children {
-		if err := t.setParent(childID, newNode.id); err != nil {
-			return nil, false, err
-		}
+	// Children pointers add 8 bytes each; the promoted separator leaves both halves
+	fixed := func(mid int) (int, int) {
+		return NodeHeaderSize + 8*mid, NodeHeaderSize + 8*(len(node.items)-mid+1)
 	}
+	start := t.splitStart(node.items, appending, -1, fixed)
+	mid := splitPoint(node.items, start, t.storage.maxNodeBytes, fixed)
+	if mid == 0 {
+		mid = 1
+	}
+	promoted := node.items[mid-1].Key
+
+	newNode.items = append(newNode.items, node.items[mid:]...)
+	newNode.children = append(newNode.children, node.children[mid:]...)
+	node.items = append([]Item(nil), node.items[:mid-1]...)
+	node.children = append([]NodeID(nil), node.children[:mid]...)
+	node.count = uint16(len(node.items))
+	newNode.count = uint16(len(newNode.items))
 
 	// Save the nodes
 	if err := t.storage.PutNode(node); err != nil {
-		return nil, false, err
+		return nil, nil, err
 	}
 	if err := t.storage.PutNode(newNode); err != nil {
-		return nil, false, err
+		return nil, nil, err
 	}
 
-	// Return the split item
-	newNode.items = append([]Item{splitItem}, newNode.items...)
-	newNode.count = uint16(len(newNode.items))
-
-	return newNode, true, nil
+	return newNode, promoted, nil
 }
 
 // Delete deletes a key from the B-tree
 func (t *BTree) Delete(key []byte) error {
-	if len(key) > MaxKeySize {
-		return ErrKeyTooLarge
+	if err := checkWriteKey(key); err != nil {
+		return err
 	}
 
 	t.mu.Lock()
@@ -418,33 +808,133 @@ func (t *BTree) Delete(key []byte) error {
 		return err
 	}
 
-	// Get the root node
-	root, err := t.storage.GetRootNode()
-	if err != nil {
+	if err := t.deleteTx(key); err != nil {
 		t.storage.abortTransaction()
 		return err
 	}
 
-	// Delete the key
-	newRoot, err := t.delete(root, key)
-	if err != nil {
-		t.storage.abortTransaction()
+	// Commit transaction
+	return t.storage.CommitTransaction()
+}
+
+// PutReturningOld stores value under key and returns the value it replaced,
+// reading and writing within the same transaction
+func (t *BTree) PutReturningOld(key, value []byte) ([]byte, bool, error) {
+	if err := checkWriteKey(key); err != nil {
+		return nil, false, err
+	}
+	if len(value) > MaxValueSize {
+		return nil, false, ErrValueTooLarge
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.storage.BeginTransaction(); err != nil {
+		return nil, false, err
+	}
+
+	old, existed, err := t.lookupTx(key)
+	if err == nil {
+		err = t.putTx(key, value)
+	}
+	if err != nil {
+		t.storage.abortTransaction()
+		return nil, false, err
+	}
+
+	if err := t.storage.CommitTransaction(); err != nil {
+		return nil, false, err
+	}
+	return old, existed, nil
+}
+
+// DeleteReturningOld removes key and returns the value it held. Deleting a
+// missing key is not an error; existed reports whether there was one.
+func (t *BTree) DeleteReturningOld(key []byte) ([]byte, bool, error) {
+	if err := checkWriteKey(key); err != nil {
+		return nil, false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.storage.BeginTransaction(); err != nil {
+		return nil, false, err
+	}
+
+	old, existed, err := t.lookupTx(key)
+	if err == nil && existed {
+		err = t.deleteTx(key)
+	}
+	if err != nil {
+		t.storage.abortTransaction()
+		return nil, false, err
+	}
+
+	if err := t.storage.CommitTransaction(); err != nil {
+		return nil, false, err
+	}
+	return old, existed, nil
+}
+
+// lookupTx returns a copy of the value stored under key, if any
+func (t *BTree) lookupTx(key []byte) ([]byte, bool, error) {
+	root, err := t.storage.GetRootNode()
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, err := t.search(root, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return append([]byte(nil), value...), true, nil
+}
+
+// deleteTx removes a key inside the caller's transaction, releasing the
+// shared value it referred to
+func (t *BTree) deleteTx(key []byte) error {
+	t.released = nil
+
+	// Get the root node
+	root, err := t.storage.GetRootNode()
+	if err != nil {
 		return err
 	}
 
-	// Update the root if needed
-	if newRoot != nil && newRoot.id != root.id {
-		if err := t.storage.SetRootNode(newRoot); err != nil {
-			t.storage.abortTransaction()
+	// Delete the key
+	newRoot, err := t.delete(root, key)
+	if err != nil {
+		return err
+	}
+
+	// Merges can leave the root with a single child; that child becomes the
+	// root and the tree shrinks by a level
+	for newRoot.nodeType == InternalNode && len(newRoot.children) == 1 {
+		child, err := t.storage.GetNode(newRoot.children[0])
+		if err != nil {
 			return err
 		}
+		t.storage.discardNode(newRoot.id)
+		newRoot = child
 	}
 
-	// Commit transaction
-	return t.storage.CommitTransaction()
+	if err := t.storage.SetRootNode(newRoot); err != nil {
+		return err
+	}
+	if err := t.releaseRefs(); err != nil {
+		return err
+	}
+	return t.storage.spillDirty()
 }
 
-// delete deletes a key from a node
+// delete removes key from the subtree rooted at node and returns the copy
+// that replaces node. Children left underfull are merged with or refilled
+// from a sibling on the way back up.
 func (t *BTree) delete(node *Node, key []byte) (*Node, error) {
 	if node.nodeType == LeafNode {
 		// Find the key
@@ -458,31 +948,19 @@ func (t *BTree) delete(node *Node, key []byte) (*Node, error) {
 		if err != nil {
 			return nil, err
 		}
+		t.storage.discardNode(node.id)
 
 		// Remove the item
+		t.release(nodeCopy.items[pos])
 		if err := nodeCopy.RemoveItem(pos); err != nil {
 			return nil, err
 		}
-
-		// Check if the node is underflowing
-		if nodeCopy.count < MinItems && nodeCopy.parent != 0 {
-			// Get the parent
-			parent, err := t.storage.GetNode(nodeCopy.parent)
-			if err != nil {
-				return nil, err
-			}
-
-			// Rebalance
-			return t.rebalanceLeaf(nodeCopy, parent)
-		}
-
 		return nodeCopy, nil
 	}
 
 	// Internal node
 	childPos := node.FindChildPos(key)
-	childID := node.children[childPos]
-	child, err := t.storage.GetNode(childID)
+	child, err := t.storage.GetNode(node.children[childPos])
 	if err != nil {
 		return nil, err
 	}
@@ -498,497 +976,189 @@ func (t *BTree) delete(node *Node, key []byte) (*Node, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	// Update the child pointer
+	t.storage.discardNode(node.id)
 	nodeCopy.children[childPos] = newChild.id
 
-	// Check if the node is underflowing
-	if newChild.count < MinItems && newChild.parent != 0 {
-		// Rebalance
-		return t.rebalanceInternal(newChild, nodeCopy)
+	if underfull(newChild) {
+		if err := t.rebalance(nodeCopy, childPos, newChild); err != nil {
+			return nil, err
+		}
 	}
-
 	return nodeCopy, nil
 }
 
-// rebalanceLeaf rebalances a leaf node
-func (t *BTree) rebalanceLeaf(node *Node, parent *Node) (*Node, error) {
-	// Find the position of the node in the parent
-	pos := -1
-	for i, childID := range parent.children {
-		if childID == node.id {
-			pos = i
-			break
-		}
-	}
-	if pos < 0 {
-		return nil, errors.New("node not found in parent")
-	}
-
-	// Try to borrow from the left sibling
-	if pos > 0 {
-		leftSiblingID := parent.children[pos-1]
-		leftSibling, err := t.storage.GetNode(leftSiblingID)
-		if err != nil {
-			return nil, err
-		}
+// minFillSize is the serialized size below which a node is merged with or
+// refilled from a sibling after a delete
+const minFillSize = NodeSize / 4
 
-		if leftSibling.count > MinItems {
-			// Create copies (copy-on-write)
-			nodeCopy, err := t.storage.CloneNode(node)
-			if err != nil {
-				return nil, err
-			}
-			leftSiblingCopy, err := t.storage.CloneNode(leftSibling)
-			if err != nil {
-				return nil, err
-			}
-			parentCopy, err := t.storage.CloneNode(parent)
-			if err != nil {
-				return nil, err
-			}
-
-			// Borrow the rightmost item from the left sibling
-			item := leftSiblingCopy.items[leftSiblingCopy.count-1]
-			nodeCopy.AddItem(item)
-			if err := leftSiblingCopy.RemoveItem(int(leftSiblingCopy.count) - 1); err != nil {
-				return nil, err
-			}
-
-			// Update the parent's key
-			parentCopy.items[pos-1].Key = nodeCopy.items[0].Key
-
-			// Save the nodes
-			if err := t.storage.PutNode(nodeCopy); err != nil {
-				return nil, err
-			}
-			if err := t.storage.PutNode(leftSiblingCopy); err != nil {
-				return nil, err
-			}
-			if err := t.storage.PutNode(parentCopy); err != nil {
-				return nil, err
-			}
+// underfull reports whether node holds too little to stand on its own. An
+// empty leaf, or an internal node left with a single child, always is.
+func underfull(node *Node) bool {
+	return len(node.items) == 0 || estimateNodeSize(node, nil, -1) < minFillSize
+}
 
-			return parentCopy, nil
-		}
+// rebalance fixes child, the underfull copy at parent.children[pos], by
+// merging it with an adjacent sibling or, when the two do not fit in one
+// page, by sharing their items evenly. parent must be a copy owned by the
+// current transaction; it is updated in place.
+func (t *BTree) rebalance(parent *Node, pos int, child *Node) error {
+	// Pair the child with its left sibling, or its right one if it is first
+	li := pos - 1
+	if pos == 0 {
+		li = 0
 	}
-
-	// Try to borrow from the right sibling
-	if pos < len(parent.children)-1 {
-		rightSiblingID := parent.children[pos+1]
-		rightSibling, err := t.storage.GetNode(rightSiblingID)
-		if err != nil {
-			return nil, err
-		}
-
-		if rightSibling.count > MinItems {
-			// Create copies (copy-on-write)
-			nodeCopy, err := t.storage.CloneNode(node)
-			if err != nil {
-				return nil, err
-			}
-			rightSiblingCopy, err := t.storage.CloneNode(rightSibling)
-			if err != nil {
-				return nil, err
-			}
-			parentCopy, err := t.storage.CloneNode(parent)
-			if err != nil {
-				return nil, err
-			}
-
-			// Borrow the leftmost item from the right sibling
-			item := rightSiblingCopy.items[0]
-			nodeCopy.AddItem(item)
-			if err := rightSiblingCopy.RemoveItem(0); err != nil {
-				return nil, err
-			}
-
-			// Update the parent's key
-			parentCopy.items[pos].Key = rightSiblingCopy.items[0].Key
-
-			// Save the nodes
-			if err := t.storage.PutNode(nodeCopy); err != nil {
-				return nil, err
-			}
-			if err := t.storage.PutNode(rightSiblingCopy); err != nil {
-				return nil, err
-			}
-			if err := t.storage.PutNode(parentCopy); err != nil {
-				return nil, err
-			}
-
-			return parentCopy, nil
-		}
+	ri := li + 1
+	sibPos := li
+	if sibPos == pos {
+		sibPos = ri
 	}
 
-	// Merge with a sibling
-	if pos > 0 {
-		// Merge with left sibling
-		leftSiblingID := parent.children[pos-1]
-		leftSibling, err := t.storage.GetNode(leftSiblingID)
-		if err != nil {
-			return nil, err
-		}
-
-		// Create a copy of the left sibling (copy-on-write)
-		leftSiblingCopy, err := t.storage.CloneNode(leftSibling)
-		if err != nil {
-			return nil, err
-		}
-
-		// Merge the node into the left sibling
-		leftSiblingCopy.items = append(leftSiblingCopy.items, node.items...)
-		leftSiblingCopy.count = uint16(len(leftSiblingCopy.items))
-
-		// Create a copy of the parent (copy-on-write)
-		parentCopy, err := t.storage.CloneNode(parent)
-		if err != nil {
-			return nil, err
-		}
-
-		// Remove the node from the parent
-		if err := parentCopy.RemoveItem(pos - 1); err != nil {
-			return nil, err
-		}
-		if err := parentCopy.RemoveChild(pos); err != nil {
-			return nil, err
-		}
-
-		// Save the nodes
-		if err := t.storage.PutNode(leftSiblingCopy); err != nil {
-			return nil, err
-		}
-		if err := t.storage.PutNode(parentCopy); err != nil {
-			return nil, err
-		}
-
-		// Delete the node
-		if err := t.storage.DeleteNode(node.id); err != nil {
-			return nil, err
-		}
-
-		return parentCopy, nil
-	} else {
-		// Merge with right sibling
-		rightSiblingID := parent.children[pos+1]
-		rightSibling, err := t.storage.GetNode(rightSiblingID)
-		if err != nil {
-			return nil, err
-		}
-
-		// Create a copy of the node (copy-on-write)
-		nodeCopy, err := t.storage.CloneNode(node)
-		if err != nil {
-			return nil, err
-		}
-
-		// Merge the right sibling into the node
-		nodeCopy.items = append(nodeCopy.items, rightSibling.items...)
-		nodeCopy.count = uint16(len(nodeCopy.items))
-
-		// Create a copy of the parent (copy-on-write)
-		parentCopy, err := t.storage.CloneNode(parent)
-		if err != nil {
-			return nil, err
-		}
-
-		// Remove the right sibling from the parent
-		if err := parentCopy.RemoveItem(pos); err != nil {
-			return nil, err
-		}
-		if err := parentCopy.RemoveChild(pos + 1); err != nil {
-			return nil, err
-		}
-
-		// Save the nodes
-		if err := t.storage.PutNode(nodeCopy); err != nil {
-			return nil, err
-		}
-		if err := t.storage.PutNode(parentCopy); err != nil {
-			return nil, err
+	sibling, err := t.storage.GetNode(parent.children[sibPos])
+	if err != nil {
+		return err
+	}
+	left, right := sibling, child
+	if sibPos == ri {
+		left, right = child, sibling
+	}
+	sep := parent.items[li].Key
+
+	items, children := joinNodes(left, sep, right)
+	if fitsOnePage(items, children, t.storage.maxNodeBytes) {
+		// Merge into the child, which this transaction already owns
+		child.items = items
+		child.children = children
+		child.count = uint16(len(items))
+		parent.children[li] = child.id
+		if err := parent.RemoveChild(ri); err != nil {
+			return err
 		}
-
-		// Delete the right sibling
-		if err := t.storage.DeleteNode(rightSibling.id); err != nil {
-			return nil, err
+		if err := parent.RemoveItem(li); err != nil {
+			return err
 		}
-
-		return parentCopy, nil
+		t.storage.discardNode(sibling.id)
+		return nil
 	}
-}
 
-// rebalanceInternal rebalances an internal node
-func (t *BTree) rebalanceInternal(node *Node, parent *Node) (*Node, error) {
-	// Find the position of the node in the parent
-	pos := -1
-	for i, childID := range parent.children {
-		if childID == node.id {
-			pos = i
-			break
-		}
+	// Too much for one page: split the combined contents afresh
+	siblingCopy, err := t.storage.CloneNode(sibling)
+	if err != nil {
+		return err
 	}
-	if pos < 0 {
-		return nil, errors.New("node not found in parent")
+	t.storage.discardNode(sibling.id)
+	left, right = siblingCopy, child
+	if sibPos == ri {
+		left, right = child, siblingCopy
 	}
-
-	// Try to borrow from the left sibling
-	if pos > 0 {
-		leftSiblingID := parent.children[pos-1]
-		leftSibling, err := t.storage.GetNode(leftSiblingID)
-		if err != nil {
-			return nil, err
-		}
-
-		if leftSibling.count > MinItems {
-			// Create copies (copy-on-write)
-			nodeCopy, err := t.storage.CloneNode(node)
-			if err != nil {
-				return nil, err
-			}
-			leftSiblingCopy, err := t.storage.CloneNode(leftSibling)
-			if err != nil {
-				return nil, err
-			}
-			parentCopy, err := t.storage.CloneNode(parent)
-			if err != nil {
-				return nil, err
-			}
-
-			// Move the parent's key down to the node
-			nodeCopy.items = append([]Item{{Key: parentCopy.items[pos-1].Key, Value: nil}}, nodeCopy.items...)
-			nodeCopy.count = uint16(len(nodeCopy.items))
-
-			// Move the left sibling's rightmost key up to the parent
-			parentCopy.items[pos-1].Key = leftSiblingCopy.items[leftSiblingCopy.count-1].Key
-			if err := leftSiblingCopy.RemoveItem(int(leftSiblingCopy.count) - 1); err != nil {
-				return nil, err
-			}
-
-			// Move the left sibling's rightmost child to the node
-			childID := leftSiblingCopy.children[len(leftSiblingCopy.children)-1]
-			nodeCopy.children = append([]NodeID{childID}, nodeCopy.children...)
-			leftSiblingCopy.children = leftSiblingCopy.children[:len(leftSiblingCopy.children)-1]
-
-			// Update the child's parent
-			if err := t.setParent(childID, nodeCopy.id); err != nil {
-				return nil, err
-			}
-
-			// Save the nodes
-			if err := t.storage.PutNode(nodeCopy); err != nil {
-				return nil, err
-			}
-			if err := t.storage.PutNode(leftSiblingCopy); err != nil {
-				return nil, err
-			}
-			if err := t.storage.PutNode(parentCopy); err != nil {
-				return nil, err
-			}
-
-			return parentCopy, nil
-		}
+	parent.items[li].Key = redistribute(left, right, items, children, t.storage.maxNodeBytes)
+	if left.nodeType == LeafNode {
+		parent.items[li].Key = t.leafSeparator(left, right)
 	}
+	parent.children[li] = left.id
+	parent.children[ri] = right.id
+	return nil
+}
 
-	// Try to borrow from the right sibling
-	if pos < len(parent.children)-1 {
-		rightSiblingID := parent.children[pos+1]
-		rightSibling, err := t.storage.GetNode(rightSiblingID)
-		if err != nil {
-			return nil, err
-		}
-
-		if rightSibling.count > MinItems {
-			// Create copies (copy-on-write)
-			nodeCopy, err := t.storage.CloneNode(node)
-			if err != nil {
-				return nil, err
-			}
-			rightSiblingCopy, err := t.storage.CloneNode(rightSibling)
-			if err != nil {
-				return nil, err
-			}
-			parentCopy, err := t.storage.CloneNode(parent)
-			if err != nil {
-				return nil, err
-			}
-
-			// Move the parent's key down to the node
-			nodeCopy.items = append(nodeCopy.items, Item{Key: parentCopy.items[pos].Key, Value: nil})
-			nodeCopy.count = uint16(len(nodeCopy.items))
-
-			// Move the right sibling's leftmost key up to the parent
-			parentCopy.items[pos].Key = rightSiblingCopy.items[0].Key
-			if err := rightSiblingCopy.RemoveItem(0); err != nil {
-				return nil, err
-			}
-
-			// Move the right sibling's leftmost child to the node
-			childID := rightSiblingCopy.children[0]
-			nodeCopy.children = append(nodeCopy.children, childID)
-			rightSiblingCopy.children = rightSiblingCopy.children[1:]
-
-			// Update the child's parent
-			if err := t.setParent(childID, nodeCopy.id); err != nil {
-				return nil, err
-			}
-
-			// Save the nodes
-			if err := t.storage.PutNode(nodeCopy); err != nil {
-				return nil, err
-			}
-			if err := t.storage.PutNode(rightSiblingCopy); err != nil {
-				return nil, err
-			}
-			if err := t.storage.PutNode(parentCopy); err != nil {
-				return nil, err
-			}
-
-			return parentCopy, nil
-		}
+// leafSeparator returns the parent key routing to right, the leaf after
+// left: right's first key, or with TruncateSeparators its shortest prefix
+// that sorts above left's last key
+func (t *BTree) leafSeparator(left, right *Node) []byte {
+	if !t.truncateSeparators {
+		return right.items[0].Key
 	}
+	return shortSeparator(left.items[len(left.items)-1].Key, right.items[0].Key)
+}
 
-	// Merge with a sibling
-	if pos > 0 {
-		// Merge with left sibling
-		leftSiblingID := parent.children[pos-1]
-		leftSibling, err := t.storage.GetNode(leftSiblingID)
-		if err != nil {
-			return nil, err
-		}
-
-		// Create copies (copy-on-write)
-		leftSiblingCopy, err := t.storage.CloneNode(leftSibling)
-		if err != nil {
-			return nil, err
-		}
-		parentCopy, err := t.storage.CloneNode(parent)
-		if err != nil {
-			return nil, err
-		}
-
-		// Move the parent's key down to the left sibling
-		leftSiblingCopy.items = append(leftSiblingCopy.items, Item{Key: parentCopy.items[pos-1].Key, Value: nil})
-
-		// Merge the node's items into the left sibling
-		leftSiblingCopy.items = append(leftSiblingCopy.items, node.items...)
-		leftSiblingCopy.count = uint16(len(leftSiblingCopy.items))
-
-		// Merge the node's children into the left sibling
-		leftSiblingCopy.children = append(leftSiblingCopy.children, node.children...)
-
-		// Update the children's parent
-		for _, childID := range node.children {
-			if err := t.setParent(childID, leftSiblingCopy.id); err != nil {
-				return nil, err
-			}
-		}
-
-		// Remove the node from the parent
-		if err := parentCopy.RemoveItem(pos - 1); err != nil {
-			return nil, err
-		}
-		if err := parentCopy.RemoveChild(pos); err != nil {
-			return nil, err
-		}
-
-		// Save the nodes
-		if err := t.storage.PutNode(leftSiblingCopy); err != nil {
-			return nil, err
+// boundSeparator returns a leaf split point near mid whose separator is at
+// most maxSeparatorBytes long, searching outwards up to a quarter of the
+// items either way for one where both halves still fit a page. Failing
+// that it returns the point with the shortest separator seen, mid itself
+// if none is shorter.
+func (t *BTree) boundSeparator(items []Item, mid int) int {
+	if !t.truncateSeparators || t.maxSeparatorBytes == 0 {
+		return mid
+	}
+	best, bestLen := mid, separatorLen(items[mid-1].Key, items[mid].Key)
+	fits := func(m int) bool {
+		left, right := NodeHeaderSize, NodeHeaderSize
+		for _, it := range items[:m] {
+			left += itemSize(it)
 		}
-		if err := t.storage.PutNode(parentCopy); err != nil {
-			return nil, err
+		for _, it := range items[m:] {
+			right += itemSize(it)
 		}
-
-		// Delete the node
-		if err := t.storage.DeleteNode(node.id); err != nil {
-			return nil, err
-		}
-
-		// Check if the parent is the root and has no items
-		if parentCopy.id == t.storage.rootNodeID && parentCopy.count == 0 {
-			// Make the left sibling the new root
-			if err := t.storage.SetRootNode(leftSiblingCopy); err != nil {
-				return nil, err
+		return left <= t.storage.maxNodeBytes && right <= t.storage.maxNodeBytes
+	}
+	for d := 1; d <= len(items)/4 && bestLen > t.maxSeparatorBytes; d++ {
+		for _, m := range []int{mid - d, mid + d} {
+			if m < 1 || m >= len(items) {
+				continue
 			}
-			// Delete the parent
-			if err := t.storage.DeleteNode(parentCopy.id); err != nil {
-				return nil, err
-			}
-			return leftSiblingCopy, nil
-		}
-
-		return parentCopy, nil
-	} else {
-		// Merge with right sibling
-		rightSiblingID := parent.children[pos+1]
-		rightSibling, err := t.storage.GetNode(rightSiblingID)
-		if err != nil {
-			return nil, err
-		}
-
-		// Create copies (copy-on-write)
-		nodeCopy, err := t.storage.CloneNode(node)
-		if err != nil {
-			return nil, err
-		}
-		parentCopy, err := t.storage.CloneNode(parent)
-		if err != nil {
-			return nil, err
-		}
-
-		// Move the parent's key down to the node
-		nodeCopy.items = append(nodeCopy.items, Item{Key: parentCopy.items[pos].Key, Value: nil})
-
-		// Merge the right sibling's items into the node
-		nodeCopy.items = append(nodeCopy.items, rightSibling.items...)
-		nodeCopy.count = uint16(len(nodeCopy.items))
-
-		// Merge the right sibling's children into the node
-		nodeCopy.children = append(nodeCopy.children, rightSibling.children...)
-
-		// Update the children's parent
-		for _, childID := range rightSibling.children {
-			if err := t.setParent(childID, nodeCopy.id); err != nil {
-				return nil, err
+			if n := separatorLen(items[m-1].Key, items[m].Key); n < bestLen && fits(m) {
+				best, bestLen = m, n
 			}
 		}
+	}
+	return best
+}
 
-		// Remove the right sibling from the parent
-		if err := parentCopy.RemoveItem(pos); err != nil {
-			return nil, err
-		}
-		if err := parentCopy.RemoveChild(pos + 1); err != nil {
-			return nil, err
-		}
+// joinNodes returns the items and children of left and right concatenated.
+// For internal nodes the separator between them comes down between the two
+// halves, as merging removes it from the parent.
+func joinNodes(left *Node, sep []byte, right *Node) ([]Item, []NodeID) {
+	items := make([]Item, 0, len(left.items)+len(right.items)+1)
+	items = append(items, left.items...)
+	if left.nodeType == LeafNode {
+		return append(items, right.items...), nil
+	}
+	items = append(items, Item{Key: sep})
+	items = append(items, right.items...)
 
-		// Save the nodes
-		if err := t.storage.PutNode(nodeCopy); err != nil {
-			return nil, err
-		}
-		if err := t.storage.PutNode(parentCopy); err != nil {
-			return nil, err
-		}
+	children := make([]NodeID, 0, len(left.children)+len(right.children))
+	children = append(children, left.children...)
+	return items, append(children, right.children...)
+}
 
-		// Delete the right sibling
-		if err := t.storage.DeleteNode(rightSibling.id); err != nil {
-			return nil, err
-		}
+// fitsOnePage reports whether a node holding items and children fits in a
+// page of limit bytes
+func fitsOnePage(items []Item, children []NodeID, limit int) bool {
+	if len(items) > MaxItems {
+		return false
+	}
+	size := NodeHeaderSize + 8*len(children)
+	for _, it := range items {
+		size += itemSize(it)
+	}
+	return size <= limit
+}
 
-		// Check if the parent is the root and has no items
-		if parentCopy.id == t.storage.rootNodeID && parentCopy.count == 0 {
-			// Make the node the new root
-			if err := t.storage.SetRootNode(nodeCopy); err != nil {
-				return nil, err
-			}
-			// Delete the parent
-			if err := t.storage.DeleteNode(parentCopy.id); err != nil {
-				return nil, err
-			}
-			return nodeCopy, nil
-		}
+// redistribute splits the joined items and children of two siblings evenly
+// between left and right, each within limit bytes, and returns the separator
+// the parent now needs
+func redistribute(left, right *Node, items []Item, children []NodeID, limit int) []byte {
+	if left.nodeType == LeafNode {
+		mid := splitPoint(items, len(items)/2, limit, func(int) (int, int) { return NodeHeaderSize, NodeHeaderSize })
+		left.items = items[:mid:mid]
+		right.items = append([]Item(nil), items[mid:]...)
+		left.count = uint16(len(left.items))
+		right.count = uint16(len(right.items))
+		return right.items[0].Key
+	}
 
-		return parentCopy, nil
+	mid := splitPoint(items, len(items)/2, limit, func(mid int) (int, int) {
+		return NodeHeaderSize + 8*mid, NodeHeaderSize + 8*(len(items)-mid+1)
+	})
+	if mid == 0 {
+		mid = 1
 	}
+	promoted := items[mid-1].Key
+	left.items = items[: mid-1 : mid-1]
+	left.children = children[:mid:mid]
+	right.items = append([]Item(nil), items[mid:]...)
+	right.children = append([]NodeID(nil), children[mid:]...)
+	left.count = uint16(len(left.items))
+	right.count = uint16(len(right.items))
+	return promoted
 }
 
 // Sync syncs the B-tree to disk
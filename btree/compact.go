@@ -0,0 +1,275 @@
+package btree
+
+import "fmt"
+
+// maxCompactPasses bounds how often Compact relocates pages. Each pass moves
+// every page above the live page count into a lower free slot; a second pass
+// is only needed when ancestors of moved pages could not fit below the limit.
+const maxCompactPasses = 4
+
+// CompactStats reports what a Compact call reclaimed
+type CompactStats struct {
+	LivePages  int    `json:"live_pages"`
+	PagesFreed int    `json:"pages_freed"`
+	PagesAfter uint64 `json:"pages_after"`
+	BytesAfter int64  `json:"bytes_after"`
+	BytesFreed int64  `json:"bytes_freed"`
+	// ValuesShared counts the values DedupMinValueSize replaced with
+	// references to a single stored copy
+	ValuesShared int `json:"values_shared,omitempty"`
+}
+
+// Compact reclaims every page not reachable from the root, moves live pages
+// from the tail of the file into the freed slots, and truncates the file past
+// the highest page still referenced. Pages under the root a paused Scan,
+// Verify or full Stats began with count as live until it finishes.
+func (t *BTree) Compact() (CompactStats, error) {
+	return t.CompactWithProgress(Progress{})
+}
+
+// CompactWithProgress is Compact, reporting the pages walked so far and
+// stopping with the context's error if it is cancelled. A cancelled Compact
+// leaves the tree intact; pages it already moved stay moved, and the file is
+// not truncated until a later Compact completes.
+func (t *BTree) CompactWithProgress(p Progress) (CompactStats, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var (
+		stats CompactStats
+		err   error
+	)
+	if t.storage.Sealed() {
+		return stats, ErrSealed
+	}
+	if t.storage.readOnly {
+		return stats, ErrReadOnly
+	}
+
+	if t.dedupMinValueSize > 0 {
+		if stats.ValuesShared, err = t.dedup(); err != nil {
+			return stats, err
+		}
+	}
+
+	pagesOnDisk, err := t.storage.pages.Pages()
+	if err != nil {
+		return stats, err
+	}
+	sizeBefore := int64(pagesOnDisk) * NodeSize
+	pagesBefore, _ := t.storage.nodePool.Stats()
+	// Each pass walks the live pages twice, and a last walk follows; until
+	// the first walk counts them, guess every allocated page is live
+	tr := NewProgressCounter(p, 3*int(pagesBefore-1))
+
+	for pass := 0; pass < maxCompactPasses; pass++ {
+		live, highest, err := t.livePages(tr)
+		if err != nil {
+			return stats, err
+		}
+		t.storage.reclaim(live, highest)
+		if int(highest) <= len(live) {
+			tr.total = tr.done + len(live)
+			break
+		}
+		tr.total = tr.done + 2*len(live)
+		moved, err := t.relocate(NodeID(len(live)), tr)
+		if err != nil {
+			return stats, err
+		}
+		if !moved {
+			break
+		}
+	}
+
+	live, highest, err := t.livePages(tr)
+	if err != nil {
+		return stats, err
+	}
+	t.storage.reclaim(live, highest)
+	if err := t.storage.truncate(highest); err != nil {
+		return stats, err
+	}
+	tr.Finish()
+
+	stats.LivePages = len(live)
+	stats.PagesAfter = uint64(highest)
+	stats.PagesFreed = int(pagesBefore-1) - int(highest)
+	stats.BytesAfter = int64(HeaderSize) + int64(highest)*int64(NodeSize)
+	stats.BytesFreed = sizeBefore - stats.BytesAfter
+	return stats, nil
+}
+
+// livePages returns the set of pages reachable from the root, or from the
+// root of a paused traversal, and the highest of them. Keeping the latter
+// lets a traversal carry on over the tree it began with, at the cost of
+// reclaiming less until it finishes.
+func (t *BTree) livePages(tr *ProgressCounter) (map[NodeID]struct{}, NodeID, error) {
+	live := make(map[NodeID]struct{})
+	var highest NodeID
+
+	var walk func(id NodeID) error
+	walk = func(id NodeID) error {
+		if _, seen := live[id]; seen {
+			return fmt.Errorf("page %d is referenced twice", id)
+		}
+		live[id] = struct{}{}
+		if id > highest {
+			highest = id
+		}
+		if err := tr.Step(); err != nil {
+			return err
+		}
+		node, err := t.storage.GetNode(id)
+		if err != nil {
+			return err
+		}
+		for _, child := range node.children {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(t.storage.rootNodeID); err != nil {
+		return nil, 0, err
+	}
+
+	// Older roots share unchanged subtrees with the current one; those
+	// are already counted
+	var walkPinned func(id NodeID) error
+	walkPinned = func(id NodeID) error {
+		if _, seen := live[id]; seen {
+			return nil
+		}
+		live[id] = struct{}{}
+		highest = max(highest, id)
+		node, err := t.storage.GetNode(id)
+		if err != nil {
+			return err
+		}
+		for _, child := range node.children {
+			if err := walkPinned(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, root := range t.pinnedRoots() {
+		if err := walkPinned(root); err != nil {
+			return nil, 0, err
+		}
+	}
+	return live, highest, nil
+}
+
+// relocate copies every page above limit, and the ancestors that point at
+// it, into free slots in one transaction. It reports whether anything moved.
+func (t *BTree) relocate(limit NodeID, tr *ProgressCounter) (bool, error) {
+	// Relocation only fills free slots below the end of the file, so it runs
+	// even when the disk is too full for ordinary writes
+	if err := t.storage.beginTransaction(false); err != nil {
+		return false, err
+	}
+
+	root, err := t.storage.GetRootNode()
+	if err != nil {
+		t.storage.abortTransaction()
+		return false, err
+	}
+	newRoot, moved, err := t.relocateNode(root, limit, tr)
+	if err != nil {
+		t.storage.abortTransaction()
+		return false, err
+	}
+	if !moved {
+		t.storage.abortTransaction()
+		return false, nil
+	}
+	if err := t.storage.SetRootNode(newRoot); err != nil {
+		t.storage.abortTransaction()
+		return false, err
+	}
+
+	return true, t.storage.CommitTransaction()
+}
+
+// relocateNode returns node, or a low-numbered copy of it if it or any of its
+// descendants had to move
+func (t *BTree) relocateNode(node *Node, limit NodeID, tr *ProgressCounter) (*Node, bool, error) {
+	if err := tr.Step(); err != nil {
+		return nil, false, err
+	}
+	var children []NodeID
+	for i, childID := range node.children {
+		child, err := t.storage.GetNode(childID)
+		if err != nil {
+			return nil, false, err
+		}
+		newChild, moved, err := t.relocateNode(child, limit, tr)
+		if err != nil {
+			return nil, false, err
+		}
+		if moved {
+			if children == nil {
+				children = append([]NodeID(nil), node.children...)
+			}
+			children[i] = newChild.id
+		}
+	}
+
+	if children == nil && node.id <= limit {
+		return node, false, nil
+	}
+
+	clone, err := t.storage.CloneNode(node)
+	if err != nil {
+		return nil, false, err
+	}
+	if children != nil {
+		clone.children = children
+	}
+	return clone, true, nil
+}
+
+// reclaim replaces the free list with every unreferenced page up to highest.
+// The list is ordered so Allocate hands out the lowest IDs first.
+func (s *Storage) reclaim(live map[NodeID]struct{}, highest NodeID) {
+	free := make([]NodeID, 0, int(highest)-len(live))
+	for id := highest; id >= 1; id-- {
+		if _, ok := live[id]; !ok {
+			free = append(free, id)
+		}
+	}
+
+	s.nodePool.mu.Lock()
+	defer s.nodePool.mu.Unlock()
+	s.nodePool.freeNodeIDs = free
+	s.nodePool.nextNodeID = highest + 1
+}
+
+// truncate shrinks the file to end at page highest, which must be the highest
+// page referenced by the committed tree
+func (s *Storage) truncate(highest NodeID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Persist the smaller allocation bound before cutting the pages it no
+	// longer covers
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	if err := s.sync(); err != nil {
+		return err
+	}
+	if err := s.pages.Truncate(uint64(highest) + 1); err != nil {
+		return err
+	}
+	for id := range s.nodeCache {
+		if id > highest {
+			delete(s.nodeCache, id)
+		}
+	}
+	return s.sync()
+}
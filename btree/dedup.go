@@ -0,0 +1,220 @@
+package btree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Shared values are stored once under a reserved key, and each key holding
+// one keeps a reference, the value's SHA-256, flagged in its page:
+//
+//	\x00d:<sha256(value)>       reference count (big-endian uint64), value
+//
+// A write that replaces or deletes a reference decrements the count within
+// its transaction and deletes the shared value once it reaches zero.
+var sharedPrefix = []byte("\x00d:")
+
+// valueRefFlag marks an item's value length in its page as a reference.
+// Value lengths are far below it, so unflagged pages read as before.
+const valueRefFlag = 1 << 31
+
+// sharedKey is the reserved key holding the value with the given hash
+func sharedKey(hash []byte) []byte {
+	return append(append([]byte(nil), sharedPrefix...), hash...)
+}
+
+// resolve returns the value item holds, looking up a shared value in the
+// tree rooted at root
+func (t *BTree) resolve(root *Node, item Item) ([]byte, error) {
+	if !item.ref {
+		return item.Value, nil
+	}
+	shared, err := t.searchItem(root, sharedKey(item.Value))
+	if err != nil || len(shared.Value) < 8 {
+		return nil, fmt.Errorf("%w: key %q refers to missing shared value %x: %v", ErrCorrupt, item.Key, item.Value, err)
+	}
+	return shared.Value[8:], nil
+}
+
+// release notes that the write in progress drops item, so a shared value it
+// refers to loses a reference once the write's structural change is done
+func (t *BTree) release(item Item) {
+	if item.ref {
+		t.released = append(t.released, item.Value)
+	}
+}
+
+// releaseRefs drops the references noted by release
+func (t *BTree) releaseRefs() error {
+	hashes := t.released
+	t.released = nil
+	for _, hash := range hashes {
+		root, err := t.storage.GetRootNode()
+		if err != nil {
+			return err
+		}
+		key := sharedKey(hash)
+		shared, err := t.searchItem(root, key)
+		if err != nil || len(shared.Value) < 8 {
+			return fmt.Errorf("%w: released shared value %x is missing: %v", ErrCorrupt, hash, err)
+		}
+		count := binary.BigEndian.Uint64(shared.Value)
+		if count <= 1 {
+			err = t.deleteTx(key)
+		} else {
+			err = t.putItemTx(Item{Key: key, Value: sharedValue(count-1, shared.Value[8:])})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sharedValue(count uint64, value []byte) []byte {
+	return append(binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(value)), count), value...)
+}
+
+// sharedGroup describes one value of at least dedupMinValueSize bytes:
+// how many keys hold it inline and how many refer to a shared copy
+type sharedGroup struct {
+	value  []byte
+	inline uint64
+	refs   uint64
+	shared bool
+}
+
+// dedup shares repeated values in a transaction of its own; see dedupTx
+func (t *BTree) dedup() (int, error) {
+	if err := t.storage.BeginTransaction(); err != nil {
+		return 0, err
+	}
+	n, err := t.dedupTx()
+	if err != nil {
+		t.storage.abortTransaction()
+		return 0, err
+	}
+	return n, t.storage.CommitTransaction()
+}
+
+// dedupTx replaces inline values of at least dedupMinValueSize bytes held
+// by more than one key, or already shared, with references, inside the
+// caller's transaction. It returns how many values it replaced.
+//
+// Swapping values for references in place would leave leaves mostly empty,
+// and pages only merge on delete, so the file would not shrink. Instead the
+// tree is rebuilt under a new root in key order, which packs leaves as an
+// append does; Compact then reclaims the old pages.
+func (t *BTree) dedupTx() (int, error) {
+	oldRoot, err := t.storage.GetRootNode()
+	if err != nil {
+		return 0, err
+	}
+
+	groups := make(map[string]*sharedGroup)
+	group := func(hash []byte) *sharedGroup {
+		g, ok := groups[string(hash)]
+		if !ok {
+			g = &sharedGroup{}
+			groups[string(hash)] = g
+		}
+		return g
+	}
+	err = t.walkLeaves(oldRoot, func(item Item) error {
+		switch {
+		case bytes.HasPrefix(item.Key, sharedPrefix):
+			if len(item.Value) >= 8 {
+				g := group(item.Key[len(sharedPrefix):])
+				g.refs, g.shared = binary.BigEndian.Uint64(item.Value), true
+			}
+		case !item.ref && len(item.Value) >= t.dedupMinValueSize:
+			sum := sha256.Sum256(item.Value)
+			g := group(sum[:])
+			g.value = item.Value
+			g.inline++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Only values held twice, or already shared, are worth a shared copy
+	var added []Item
+	for h, g := range groups {
+		switch {
+		case g.inline > 1 && !g.shared:
+			added = append(added, Item{Key: sharedKey([]byte(h)), Value: sharedValue(g.inline, g.value)})
+		case g.inline > 0 && g.shared:
+		default:
+			delete(groups, h)
+		}
+	}
+	if len(groups) == 0 {
+		return 0, nil
+	}
+	// Older releases would read a flagged value length as a huge value
+	t.storage.markSharedValues()
+	sort.Slice(added, func(i, j int) bool { return bytes.Compare(added[i].Key, added[j].Key) < 0 })
+
+	if err := t.storage.SetRootNode(NewLeafNode(t.storage.nodePool.Allocate())); err != nil {
+		return 0, err
+	}
+	replaced := 0
+	err = t.walkLeaves(oldRoot, func(item Item) error {
+		// New shared copies go in key order too
+		for len(added) > 0 && bytes.Compare(added[0].Key, item.Key) < 0 {
+			if err := t.putItemTx(added[0]); err != nil {
+				return err
+			}
+			added = added[1:]
+		}
+		switch {
+		case bytes.HasPrefix(item.Key, sharedPrefix):
+			if g, ok := groups[string(item.Key[len(sharedPrefix):])]; ok {
+				item = Item{Key: item.Key, Value: sharedValue(g.refs+g.inline, item.Value[8:])}
+			}
+		case !item.ref && len(item.Value) >= t.dedupMinValueSize:
+			sum := sha256.Sum256(item.Value)
+			if _, ok := groups[string(sum[:])]; ok {
+				item = Item{Key: item.Key, Value: sum[:], ref: true}
+				replaced++
+			}
+		}
+		return t.putItemTx(item)
+	})
+	if err != nil {
+		return replaced, err
+	}
+	for _, item := range added {
+		if err := t.putItemTx(item); err != nil {
+			return replaced, err
+		}
+	}
+	return replaced, nil
+}
+
+// walkLeaves calls fn for every leaf item under node, in key order
+func (t *BTree) walkLeaves(node *Node, fn func(Item) error) error {
+	if node.nodeType == LeafNode {
+		for _, item := range node.items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, childID := range node.children {
+		child, err := t.storage.GetNode(childID)
+		if err != nil {
+			return err
+		}
+		if err := t.walkLeaves(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || dragonfly)
+
+package btree
+
+// syncDir is a no-op where directories cannot be synced; the rename is
+// left to the file system's own ordering
+func syncDir(path string) error {
+	return nil
+}
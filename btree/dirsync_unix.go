@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd || dragonfly
+
+package btree
+
+import "os"
+
+// syncDir fsyncs the directory at path, making renames within it durable
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errDiskSpaceUnsupported is returned by diskFree where free space cannot be
+// queried; the MinFreeBytes check is then skipped
+var errDiskSpaceUnsupported = errors.New("free disk space is not available on this platform")
+
+// FreeSpace returns the bytes available to this process on the file system
+// holding the storage file. Stores other than a file report
+// errDiskSpaceUnsupported.
+func (s *Storage) FreeSpace() (uint64, error) {
+	f, ok := s.pages.(*filePageStore)
+	if !ok {
+		return 0, errDiskSpaceUnsupported
+	}
+	return diskFree(f.file)
+}
+
+// checkSpace fails with ErrDiskFull when the file system has less than
+// minFree bytes available, so a commit is refused before any page is written
+func (s *Storage) checkSpace() error {
+	if s.minFree == 0 {
+		return nil
+	}
+	free, err := s.FreeSpace()
+	if errors.Is(err, errDiskSpaceUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if free < s.minFree {
+		return fmt.Errorf("%w: %d bytes free, %d required", ErrDiskFull, free, s.minFree)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package btree
+
+import "os"
+
+// diskFree is unsupported here
+func diskFree(f *os.File) (uint64, error) {
+	return 0, errDiskSpaceUnsupported
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package btree
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskFree returns the bytes available to unprivileged users on f's file
+// system
+func diskFree(f *os.File) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Fstatfs(int(f.Fd()), &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,143 @@
+package btree
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// An encrypted file seals every node page with AES-256-GCM. The page key is
+// derived from the caller's key and a random salt stored in the header,
+// next to an HMAC of a fixed string under the caller's key that tells a
+// wrong key from a corrupt page without revealing either key.
+//
+// The header is sealed the same way, but only from the root ID through the
+// free list: the magic number, version, salt, key check and flags stay in
+// the clear, since they are needed before the key can be checked, and are
+// authenticated as associated data so none can be altered unnoticed.
+//
+// A page is laid out as nonce, ciphertext, tag, with the node's ID as
+// associated data so a page copied to another slot fails to open. Nonces
+// are random rather than derived from the node ID: freed IDs are reused and
+// UnsafeOverwriteInPlace rewrites a page where it is, so an ID-derived nonce
+// would repeat under one key, which GCM does not survive.
+const (
+	// EncryptionKeySize is the length of Options.EncryptionKey
+	EncryptionKeySize = 32
+
+	encryptionSaltSize = 16
+	pageNonceSize      = 12
+	pageTagSize        = 16
+
+	// encryptedNodeBytes is how much of an encrypted page a serialized node
+	// may fill; the tag replaces the checksum
+	encryptedNodeBytes = NodeSize - pageNonceSize - pageTagSize
+
+	// headerEncryptionOffset places the salt and key check just before the
+	// header flags, shortening the free list an encrypted header can hold
+	headerEncryptionOffset = headerFlagsOffset - encryptionSaltSize - sha256.Size
+
+	// headerClearBytes is the magic number and version, which lead every
+	// header in the clear
+	headerClearBytes = 8
+)
+
+var (
+	ErrEncryptionKeyRequired = errors.New("file is encrypted; an encryption key is required")
+	ErrWrongEncryptionKey    = errors.New("encryption key does not match the file")
+	ErrNotEncrypted          = errors.New("file is not encrypted; existing files are not converted")
+)
+
+// pageCipher seals and opens the pages of one encrypted file
+type pageCipher struct {
+	aead  cipher.AEAD
+	salt  []byte
+	check []byte
+}
+
+// newPageCipher derives the page key for a file with the given salt
+func newPageCipher(key, salt []byte) (*pageCipher, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(key))
+	}
+	block, err := aes.NewCipher(keyMAC(key, "conuredb page key", salt))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &pageCipher{
+		aead:  aead,
+		salt:  salt,
+		check: keyMAC(key, "conuredb key check", salt),
+	}, nil
+}
+
+// keyMAC returns the HMAC-SHA256 of label and salt under key
+func keyMAC(key []byte, label string, salt []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+// newSalt returns a random salt for a new encrypted file
+func newSalt() ([]byte, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// seal encrypts body, encryptedNodeBytes long, into a page for nodeID
+func (c *pageCipher) seal(nodeID NodeID, body []byte) ([]byte, error) {
+	page := make([]byte, pageNonceSize, NodeSize)
+	if _, err := rand.Read(page); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(page, page, body, binary.LittleEndian.AppendUint64(nil, uint64(nodeID))), nil
+}
+
+// sealHeader encrypts fields, the header from the root ID to the end of the
+// free list, authenticating clear, the header bytes left in the clear
+func (c *pageCipher) sealHeader(fields, clear []byte) ([]byte, error) {
+	sealed := make([]byte, pageNonceSize, pageNonceSize+len(fields)+pageTagSize)
+	if _, err := rand.Read(sealed); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(sealed, sealed, fields, clear), nil
+}
+
+// openHeader authenticates and decrypts what sealHeader produced
+func (c *pageCipher) openHeader(sealed, clear []byte) ([]byte, error) {
+	fields, err := c.aead.Open(nil, sealed[:pageNonceSize], sealed[pageNonceSize:], clear)
+	if err != nil {
+		return nil, fmt.Errorf("%w: header failed authentication", ErrPageChecksum)
+	}
+	return fields, nil
+}
+
+// headerAD is the associated data for a header page: the bytes it keeps in
+// the clear, before and after the sealed fields
+func headerAD(page []byte) []byte {
+	return append(bytes.Clone(page[:headerClearBytes]), page[headerEncryptionOffset:HeaderSize]...)
+}
+
+// open authenticates and decrypts the page of nodeID. A page that was
+// altered, torn or moved fails as a checksum mismatch would.
+func (c *pageCipher) open(nodeID NodeID, page []byte) ([]byte, error) {
+	body, err := c.aead.Open(nil, page[:pageNonceSize], page[pageNonceSize:], binary.LittleEndian.AppendUint64(nil, uint64(nodeID)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: node %d failed authentication", ErrPageChecksum, nodeID)
+	}
+	return body, nil
+}
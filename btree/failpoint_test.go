@@ -0,0 +1,187 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failAt returns a failpoint that fails the nth call at site (1-based)
+func failAt(site string, nth int) func(string) error {
+	calls := 0
+	return func(s string) error {
+		if s != site {
+			return nil
+		}
+		calls++
+		if calls == nth {
+			return errInjected
+		}
+		return nil
+	}
+}
+
+// TestCommitFailureKeepsPriorState injects a failure at each commit stage and
+// checks the tree, both in process and after reopening, still holds exactly
+// the previously committed data
+func TestCommitFailureKeepsPriorState(t *testing.T) {
+	sites := []struct {
+		name string
+		fp   func(string) error
+	}{
+		{"first node write", failAt(failWriteNode, 1)},
+		{"later node write", failAt(failWriteNode, 3)},
+		{"header write", failAt(failWriteHeader, 1)},
+	}
+
+	for _, tc := range sites {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "failpoint.db")
+			tree, err := NewBTree(path)
+			if err != nil {
+				t.Fatalf("Failed to create tree: %v", err)
+			}
+
+			const committed = 500
+			for i := 0; i < committed; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("v1")); err != nil {
+					t.Fatalf("Failed to put key %d: %v", i, err)
+				}
+			}
+
+			// A batch big enough to dirty several pages, then a failing commit
+			var ops []Op
+			for i := 0; i < 300; i++ {
+				ops = append(ops, Op{Key: []byte(fmt.Sprintf("key-%04d", i*3)), Value: []byte("v2")})
+			}
+			tree.storage.failpoint = tc.fp
+			if err := tree.Batch(ops, BatchOptions{}); !errors.Is(err, errInjected) {
+				t.Fatalf("Expected injected error, got %v", err)
+			}
+			tree.storage.failpoint = nil
+
+			check := func(stage string) {
+				t.Helper()
+				for i := 0; i < committed; i++ {
+					got, err := tree.Get([]byte(fmt.Sprintf("key-%04d", i)))
+					if err != nil || string(got) != "v1" {
+						t.Fatalf("%s: key %d = %q, %v; want v1", stage, i, got, err)
+					}
+				}
+			}
+			check("after failed commit")
+
+			// The tree must accept writes again once the failure clears
+			if err := tree.Put([]byte("after"), []byte("ok")); err != nil {
+				t.Fatalf("Failed to put after failed commit: %v", err)
+			}
+			if err := tree.Close(); err != nil {
+				t.Fatalf("Failed to close tree: %v", err)
+			}
+
+			tree, err = NewBTree(path)
+			if err != nil {
+				t.Fatalf("Failed to reopen tree: %v", err)
+			}
+			defer func() {
+				if closeErr := tree.Close(); closeErr != nil {
+					t.Logf("Warning: failed to close tree: %v", closeErr)
+				}
+			}()
+			check("after reopen")
+			if got, err := tree.Get([]byte("after")); err != nil || string(got) != "ok" {
+				t.Fatalf("Write after failed commit lost: %q, %v", got, err)
+			}
+		})
+	}
+}
+
+// failWith returns a failpoint that fails the nth call at site with err, and
+// counts the calls there
+func failWith(site string, nth int, err error, calls *int) func(string) error {
+	return func(s string) error {
+		if s != site {
+			return nil
+		}
+		*calls++
+		if *calls == nth {
+			return err
+		}
+		return nil
+	}
+}
+
+// TestCommitRetriesTransientFailures fails one write or sync of a commit
+// and checks the commit completes when the error is transient and retries
+// are on, and aborts at once otherwise
+func TestCommitRetriesTransientFailures(t *testing.T) {
+	cases := []struct {
+		name    string
+		site    string
+		err     error
+		retries int
+		ok      bool
+	}{
+		{"interrupted node write", failWriteNode, syscall.EINTR, 3, true},
+		{"busy header write", failWriteHeader, syscall.EAGAIN, 3, true},
+		{"interrupted sync", failSync, syscall.EINTR, 3, true},
+		{"failed sync", failSync, syscall.EIO, 3, false},
+		{"permanent node write", failWriteNode, syscall.EIO, 3, false},
+		{"retries off", failWriteNode, syscall.EINTR, 0, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := NewBTreeWithOptions(filepath.Join(t.TempDir(), "retry.db"), Options{
+				WriteRetries:      tc.retries,
+				WriteRetryBackoff: time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create tree: %v", err)
+			}
+			defer func() {
+				if closeErr := tree.Close(); closeErr != nil {
+					t.Logf("Warning: failed to close tree: %v", closeErr)
+				}
+			}()
+
+			var ops []Op
+			for i := 0; i < 300; i++ {
+				ops = append(ops, Op{Key: []byte(fmt.Sprintf("key-%04d", i)), Value: []byte("v")})
+			}
+			calls := 0
+			tree.storage.failpoint = failWith(tc.site, 1, tc.err, &calls)
+			err = tree.Batch(ops, BatchOptions{})
+			tree.storage.failpoint = nil
+
+			if !tc.ok {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("Expected the commit to fail with %v, got %v", tc.err, err)
+				}
+				if calls != 1 {
+					t.Fatalf("Expected no retry, got %d calls at %s", calls, tc.site)
+				}
+				if _, err := tree.Get([]byte("key-0000")); !errors.Is(err, ErrKeyNotFound) {
+					t.Fatalf("Expected the failed batch to be absent, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected the commit to survive one %v, got %v", tc.err, err)
+			}
+			for _, op := range ops {
+				if got, err := tree.Get(op.Key); err != nil || string(got) != "v" {
+					t.Fatalf("Get %s after retried commit: %q, %v", op.Key, got, err)
+				}
+			}
+			if err := tree.Verify(); err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+		})
+	}
+}
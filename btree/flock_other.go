@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package btree
+
+import "os"
+
+// lockFile is a no-op where advisory locks are unavailable; keeping one
+// writer per file is left to the caller
+func lockFile(f *os.File) error {
+	return nil
+}
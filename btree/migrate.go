@@ -0,0 +1,118 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// migrateBatch is how many keys a rebuild copies per transaction
+const migrateBatch = 1000
+
+// migrationFrom returns the step that upgrades a file from version to the
+// next one by writing an upgraded copy of the file at src to dst, or nil if
+// there is none
+func migrationFrom(version uint32) func(src, dst string) error {
+	switch version {
+	case 1:
+		// Version 2 checksums every page. The checksum takes room a full
+		// version 1 page may be using, so pages cannot be converted one by
+		// one and the tree is rebuilt instead.
+		return rebuild
+	}
+	return nil
+}
+
+// MigrateFile upgrades the file at path to the current format, one version
+// at a time. Each step writes the upgraded file beside the original and
+// renames it over it once synced, so a crash leaves one version or the other
+// intact, never a mix. A file already current is left alone.
+func MigrateFile(path string) error {
+	for {
+		version, err := fileVersion(path)
+		if err != nil {
+			return err
+		}
+		if version >= Version {
+			return nil
+		}
+
+		step := migrationFrom(version)
+		if step == nil {
+			return fmt.Errorf("%w: no migration from version %d", ErrInvalidVersion, version)
+		}
+
+		tmp := path + ".migrate"
+		if err := step(path, tmp); err != nil {
+			if removeErr := os.Remove(tmp); removeErr != nil && !os.IsNotExist(removeErr) {
+				fmt.Fprintf(os.Stderr, "Warning: failed to remove temp file after migration error: %v\n", removeErr)
+			}
+			return fmt.Errorf("migrate %s from version %d: %w", path, version, err)
+		}
+		if err := ReplaceFile(tmp, path); err != nil {
+			return err
+		}
+	}
+}
+
+// fileVersion reads the format version from the header of the file at path
+func fileVersion(path string) (uint32, error) {
+	s, err := OpenStorageWithOptions(path, Options{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	version := s.version
+	return version, s.Close()
+}
+
+// rebuild copies every key of src into a new file at dst written in the
+// current format, carrying over the sealed flag
+func rebuild(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	old, err := NewBTreeWithOptions(src, Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := old.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close file being migrated: %v\n", closeErr)
+		}
+	}()
+	fresh, err := NewBTreeWithOptions(dst, Options{NoSync: true})
+	if err != nil {
+		return err
+	}
+
+	ops := make([]Op, 0, migrateBatch)
+	var batchErr error
+	err = old.Scan(nil, func(key, value []byte) bool {
+		if bytes.HasPrefix(key, sharedPrefix) {
+			// Scan resolved the references to it into the keys holding them
+			return true
+		}
+		ops = append(ops, Op{Key: append([]byte(nil), key...), Value: append([]byte(nil), value...)})
+		if len(ops) == migrateBatch {
+			batchErr = fresh.Batch(ops, BatchOptions{Sequential: true})
+			ops = ops[:0]
+		}
+		return batchErr == nil
+	})
+	if err == nil {
+		err = batchErr
+	}
+	if err == nil && len(ops) > 0 {
+		err = fresh.Batch(ops, BatchOptions{Sequential: true})
+	}
+	if err == nil && old.Sealed() {
+		err = fresh.Seal()
+	}
+	if err == nil {
+		err = fresh.Sync()
+	}
+	if closeErr := fresh.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
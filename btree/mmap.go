@@ -0,0 +1,87 @@
+package btree
+
+import "errors"
+
+// errMmapUnsupported is returned by mmapFile on platforms without a usable
+// shared read mapping; Storage then keeps reading with ReadAt
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+const (
+	// minMmapSize is the smallest mapping created, so a small file that
+	// grows does not remap on every commit
+	minMmapSize = 1 << 20
+
+	// maxMmapStep caps how far past the file a mapping reserves once the
+	// file is large; below it the mapping doubles
+	maxMmapStep = 1 << 30
+)
+
+// mmapCapacity returns the mapping length to reserve for a file of size
+// bytes. Only the first size bytes are ever read: touching a mapped page past
+// the end of the file faults.
+func mmapCapacity(size int64) int {
+	capacity := int64(minMmapSize)
+	for capacity < size {
+		if capacity >= maxMmapStep {
+			capacity += maxMmapStep
+		} else {
+			capacity *= 2
+		}
+	}
+	return int(capacity)
+}
+
+// refreshMmap brings a file store's mapping in line with the file size. It
+// must be called with s.mu held for writing, so no reader is slicing the old
+// mapping.
+func (s *Storage) refreshMmap() {
+	if f, ok := s.pages.(*filePageStore); ok {
+		f.refreshMmap()
+	}
+}
+
+// refreshMmap remaps when the file has outgrown the mapping. If the file
+// cannot be mapped, reads fall back to ReadAt instead of failing.
+func (f *filePageStore) refreshMmap() {
+	if !f.useMmap {
+		return
+	}
+
+	info, err := f.file.Stat()
+	if err != nil {
+		f.unmap()
+		return
+	}
+	size := info.Size()
+	if size <= int64(len(f.mmap)) {
+		f.mmapSize = size
+		return
+	}
+
+	f.unmap()
+	data, err := mmapFile(f.file, mmapCapacity(size))
+	if err != nil {
+		return
+	}
+	f.mmap = data
+	f.mmapSize = size
+}
+
+// unmap releases the mapping; reads use ReadAt until the next refreshMmap
+func (f *filePageStore) unmap() {
+	if f.mmap == nil {
+		return
+	}
+	_ = munmapFile(f.mmap)
+	f.mmap = nil
+	f.mmapSize = 0
+}
+
+// mappedPage returns the page at offset from the mapping, or nil if it lies
+// beyond the part of the file known to exist when the mapping was refreshed
+func (f *filePageStore) mappedPage(offset int64) []byte {
+	if f.mmap == nil || offset+int64(NodeSize) > f.mmapSize {
+		return nil
+	}
+	return f.mmap[offset : offset+int64(NodeSize)]
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || dragonfly)
+
+package btree
+
+import "os"
+
+// mmapFile is unsupported here. OpenBSD is excluded on purpose: without a
+// unified buffer cache, pages written with WriteAt may not show through a
+// mapping.
+func mmapFile(f *os.File, length int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+// munmapFile is a no-op where mmapFile always fails
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkRandomRead reads random pages straight from storage, bypassing
+// the node cache, with ReadAt and with the mmap read path
+func BenchmarkRandomRead(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "bench.db")
+	tree, err := NewBTreeWithOptions(path, Options{NoSync: true})
+	if err != nil {
+		b.Fatalf("Failed to create tree: %v", err)
+	}
+	var ops []Op
+	for i := 0; i < 20000; i++ {
+		ops = append(ops, Op{Key: []byte(fmt.Sprintf("key-%06d", i)), Value: make([]byte, 100)})
+	}
+	if err := tree.Batch(ops, BatchOptions{}); err != nil {
+		b.Fatalf("Failed to load tree: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		b.Fatalf("Failed to close tree: %v", err)
+	}
+
+	for _, mode := range []struct {
+		name string
+		opts Options
+	}{
+		{"pread", Options{ReadOnly: true}},
+		{"mmap", Options{ReadOnly: true, UseMmap: true}},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			s, err := OpenStorageWithOptions(path, mode.opts)
+			if err != nil {
+				b.Fatalf("Failed to open storage: %v", err)
+			}
+			defer func() {
+				if closeErr := s.Close(); closeErr != nil {
+					b.Logf("Warning: failed to close storage: %v", closeErr)
+				}
+			}()
+			if mode.opts.UseMmap && s.pages.(*filePageStore).mmap == nil {
+				b.Skip("mmap is not available on this platform")
+			}
+
+			pages, _ := s.nodePool.Stats()
+			rng := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.readNode(NodeID(rng.Int63n(int64(pages-1))) + 1); err != nil {
+					b.Fatalf("Failed to read page: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd || netbsd || dragonfly
+
+package btree
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps length bytes of f read-only and shared, so pages written with
+// WriteAt are visible through the mapping without remapping
+func mmapFile(f *os.File, length int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, length, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile releases a mapping created by mmapFile
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
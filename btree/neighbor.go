@@ -0,0 +1,83 @@
+package btree
+
+import "bytes"
+
+// Next returns the item with the smallest key strictly greater than key, or
+// ErrKeyNotFound if key is at or past the end of the tree. The item's slices
+// are owned by the tree and must be copied if retained.
+func (t *BTree) Next(key []byte) (Item, error) {
+	return t.neighborOf(key, true)
+}
+
+// Prev returns the item with the largest key strictly less than key, or
+// ErrKeyNotFound if key is at or before the start of the tree. The item's
+// slices are owned by the tree and must be copied if retained.
+func (t *BTree) Prev(key []byte) (Item, error) {
+	return t.neighborOf(key, false)
+}
+
+func (t *BTree) neighborOf(key []byte, next bool) (Item, error) {
+	if err := checkKey(key); err != nil {
+		return Item{}, err
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	root, err := t.storage.GetRootNode()
+	if err != nil {
+		return Item{}, err
+	}
+	item, found, err := t.neighbor(root, key, next)
+	if err != nil {
+		return Item{}, err
+	}
+	if !found {
+		return Item{}, ErrKeyNotFound
+	}
+	value, err := t.resolve(root, item)
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Key: item.Key, Value: value}, nil
+}
+
+// neighbor finds the item after (or before) key in the subtree rooted at
+// node. Pages keep no sibling links, so when the child key descends into has
+// no neighbor the search moves on to the child beside it, which holds one
+// unless it is empty.
+func (t *BTree) neighbor(node *Node, key []byte, next bool) (Item, bool, error) {
+	if node.nodeType == LeafNode {
+		if next {
+			for _, item := range node.items {
+				if bytes.Compare(item.Key, key) > 0 {
+					return item, true, nil
+				}
+			}
+		} else {
+			for i := len(node.items) - 1; i >= 0; i-- {
+				if bytes.Compare(node.items[i].Key, key) < 0 {
+					return node.items[i], true, nil
+				}
+			}
+		}
+		return Item{}, false, nil
+	}
+
+	pos := node.FindChildPos(key)
+	step := 1
+	if !next {
+		step = -1
+	}
+	for ; pos >= 0 && pos < len(node.children); pos += step {
+		child, err := t.storage.GetNode(node.children[pos])
+		if err != nil {
+			return Item{}, false, err
+		}
+		item, found, err := t.neighbor(child, key, next)
+		if err != nil || found {
+			return item, found, err
+		}
+	}
+	return Item{}, false, nil
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"math"
 )
 
 const (
@@ -19,9 +21,29 @@ const (
 	MaxValueSize = 1024
 
 	// NodeHeaderSize is the size of the node header in bytes
-	NodeHeaderSize = 16
+	// (id 8 + type 1 + count 2 + parent 8)
+	NodeHeaderSize = 19
+
+	// PageChecksumSize is the CRC-32C of the rest of the page, stored in its
+	// last bytes
+	PageChecksumSize = 4
+
+	// MaxNodeBytes is how much of a page a serialized node may fill; less
+	// in an encrypted file, see Options.EncryptionKey
+	MaxNodeBytes = NodeSize - PageChecksumSize
 )
 
+// ErrPageChecksum is returned when a page read back does not match the
+// checksum it was written with
+var ErrPageChecksum = errors.New("page checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// pageChecksumOK reports whether a page's trailer matches its contents
+func pageChecksumOK(page []byte) bool {
+	return binary.LittleEndian.Uint32(page[MaxNodeBytes:]) == crc32.Checksum(page[:MaxNodeBytes], castagnoli)
+}
+
 // NodeType represents the type of a node
 type NodeType uint8
 
@@ -41,7 +63,7 @@ type Node struct {
 	id       NodeID
 	nodeType NodeType
 	count    uint16
-	parent   NodeID
+	parent   NodeID // not maintained; see Parent
 	items    []Item
 	children []NodeID // Only used for internal nodes
 }
@@ -50,6 +72,10 @@ type Node struct {
 type Item struct {
 	Key   []byte
 	Value []byte
+
+	// ref marks a leaf item whose Value is the hash of a value stored once
+	// for many keys; see DedupMinValueSize
+	ref bool
 }
 
 // NewLeafNode creates a new leaf node
@@ -91,7 +117,11 @@ func (n *Node) Count() uint16 {
 	return n.count
 }
 
-// Parent returns the parent of the node
+// Parent returns the parent recorded in the node's page. The tree does not
+// maintain it: copy-on-write gives a page a new parent on every commit that
+// touches the path, and updating each child for that would double the pages
+// written. Inserts and deletes find parents on their way down instead, so
+// the field is kept only for the page format and may be stale.
 func (n *Node) Parent() NodeID {
 	return n.parent
 }
@@ -113,19 +143,21 @@ func (n *Node) Children() []NodeID {
 
 // AddItem inserts an item while keeping items sorted by key
 func (n *Node) AddItem(item Item) {
-	// Find the position to insert the item using linear scan (items are small)
-	pos := 0
-	for pos < len(n.items) && bytes.Compare(n.items[pos].Key, item.Key) < 0 {
-		pos++
+	// Binary search for the first item not below the key
+	low, high := 0, len(n.items)
+	for low < high {
+		mid := (low + high) / 2
+		if bytes.Compare(n.items[mid].Key, item.Key) < 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
 	}
 
-	// Insert the item
-	if pos < len(n.items) {
-		n.items = append(n.items[:pos+1], n.items[pos:]...)
-		n.items[pos] = item
-	} else {
-		n.items = append(n.items, item)
-	}
+	// Grow by one and shift the tail up in a single copy
+	n.items = append(n.items, Item{})
+	copy(n.items[low+1:], n.items[low:])
+	n.items[low] = item
 	n.count++
 }
 
@@ -211,8 +243,45 @@ func (n *Node) FindChildPos(key []byte) int {
 	return low
 }
 
-// Serialize serializes the node to a fixed-size page (NodeSize)
+// shortSeparator returns the shortest prefix of right that sorts above left,
+// for left < right. It routes exactly as right would between two leaves
+// whose keys are at most left and at least right.
+func shortSeparator(left, right []byte) []byte {
+	return append([]byte(nil), right[:separatorLen(left, right)]...)
+}
+
+// separatorLen is the length of shortSeparator(left, right)
+func separatorLen(left, right []byte) int {
+	n := 0
+	for n < len(left) && n < len(right) && left[n] == right[n] {
+		n++
+	}
+	// right[n] > left[n], or left is a prefix of right; either way
+	// right[:n+1] sorts above left and at most right
+	return min(n+1, len(right))
+}
+
+// Serialize serializes the node to a fixed-size page (NodeSize) ending in
+// its checksum
 func (n *Node) Serialize() ([]byte, error) {
+	body, err := n.encode(MaxNodeBytes)
+	if err != nil {
+		return nil, err
+	}
+	// Seal the page with its checksum
+	sum := crc32.Checksum(body, castagnoli)
+	return binary.LittleEndian.AppendUint32(body, sum), nil
+}
+
+// encode serializes the node padded to size bytes, with room left in the
+// slice for a page trailer
+func (n *Node) encode(size int) ([]byte, error) {
+	// The count is a uint16; refuse to write one that wrapped rather than
+	// a page that misreads its own items
+	if len(n.items) > math.MaxUint16 || int(n.count) != len(n.items) {
+		return nil, fmt.Errorf("node %d holds %d items but counts %d", n.id, len(n.items), n.count)
+	}
+
 	buf := bytes.NewBuffer(make([]byte, 0, NodeSize))
 
 	// Write header
@@ -242,8 +311,11 @@ func (n *Node) Serialize() ([]byte, error) {
 			return nil, err
 		}
 
-		// Write value length
+		// Write value length, flagging a reference to a shared value
 		valueLen := uint32(len(item.Value))
+		if item.ref {
+			valueLen |= valueRefFlag
+		}
 		if err := binary.Write(buf, binary.LittleEndian, valueLen); err != nil {
 			return nil, err
 		}
@@ -263,14 +335,14 @@ func (n *Node) Serialize() ([]byte, error) {
 		}
 	}
 
-	// Check if we've exceeded NodeSize
+	// Check if we've exceeded the room left by the page trailer
 	currentSize := buf.Len()
-	if currentSize > NodeSize {
-		return nil, fmt.Errorf("node size %d exceeds maximum size %d", currentSize, NodeSize)
+	if currentSize > size {
+		return nil, fmt.Errorf("node size %d exceeds maximum size %d", currentSize, size)
 	}
 
-	// Pad to NodeSize
-	padding := make([]byte, NodeSize-currentSize)
+	// Pad up to the trailer
+	padding := make([]byte, size-currentSize)
 	if _, err := buf.Write(padding); err != nil {
 		return nil, err
 	}
@@ -283,7 +355,12 @@ func DeserializeNode(data []byte) (*Node, error) {
 	if len(data) != NodeSize {
 		return nil, errors.New("invalid data size")
 	}
+	return decodeNode(data)
+}
 
+// decodeNode deserializes a node from the start of data, ignoring the
+// padding and any trailer after it
+func decodeNode(data []byte) (*Node, error) {
 	buf := bytes.NewReader(data)
 	node := &Node{}
 
@@ -301,43 +378,64 @@ func DeserializeNode(data []byte) (*Node, error) {
 		return nil, err
 	}
 
-	// Read items
+	// Read items. The page is in memory, so a failed read means an item
+	// runs off its end, most likely because the item count is wrong.
 	node.items = make([]Item, node.count)
 	for i := uint16(0); i < node.count; i++ {
+		overrun := func() error {
+			return corrupt(node.id, "item %d of %d runs past the end of the page", i, node.count)
+		}
+
 		// Read key length
 		var keyLen uint16
 		if err := binary.Read(buf, binary.LittleEndian, &keyLen); err != nil {
-			return nil, err
+			return nil, overrun()
 		}
 
 		// Read key
+		if int(keyLen) > buf.Len() {
+			return nil, overrun()
+		}
 		key := make([]byte, keyLen)
 		if _, err := io.ReadFull(buf, key); err != nil {
-			return nil, err
+			return nil, overrun()
 		}
 
 		// Read value length
 		var valueLen uint32
 		if err := binary.Read(buf, binary.LittleEndian, &valueLen); err != nil {
-			return nil, err
+			return nil, overrun()
 		}
 
 		// Read value
+		ref := valueLen&valueRefFlag != 0
+		valueLen &^= valueRefFlag
+		if int64(valueLen) > int64(buf.Len()) {
+			return nil, overrun()
+		}
 		value := make([]byte, valueLen)
 		if _, err := io.ReadFull(buf, value); err != nil {
-			return nil, err
+			return nil, overrun()
 		}
 
-		node.items[i] = Item{Key: key, Value: value}
+		node.items[i] = Item{Key: key, Value: value, ref: ref}
 	}
 
-	// Read children for internal nodes
+	// Read children for internal nodes, one more than the items. The page
+	// does not store the child count, so a corrupt item count shows up as
+	// children missing from the page or read from its zero padding.
 	if node.nodeType == InternalNode {
+		if need := 8 * (int(node.count) + 1); buf.Len() < need {
+			return nil, corrupt(node.id, "internal node with %d items needs %d children, page has room for %d", node.count, node.count+1, buf.Len()/8)
+		}
 		node.children = make([]NodeID, node.count+1)
 		for i := uint16(0); i <= node.count; i++ {
 			if err := binary.Read(buf, binary.LittleEndian, &node.children[i]); err != nil {
 				return nil, err
 			}
+			if node.children[i] == 0 {
+				return nil, corrupt(node.id, "internal node with %d items has child %d pointing at the header page", node.count, i)
+			}
 		}
 	}
 
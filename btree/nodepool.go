@@ -9,6 +9,7 @@ type NodePool struct {
 	mu          sync.Mutex
 	freeNodeIDs []NodeID
 	nextNodeID  NodeID
+	sequential  bool
 }
 
 // NewNodePool creates a new node pool
@@ -25,7 +26,7 @@ func (p *NodePool) Allocate() NodeID {
 	defer p.mu.Unlock()
 
 	// Reuse a free node ID if available
-	if len(p.freeNodeIDs) > 0 {
+	if !p.sequential && len(p.freeNodeIDs) > 0 {
 		nodeID := p.freeNodeIDs[len(p.freeNodeIDs)-1]
 		p.freeNodeIDs = p.freeNodeIDs[:len(p.freeNodeIDs)-1]
 		return nodeID
@@ -37,6 +38,15 @@ func (p *NodePool) Allocate() NodeID {
 	return nodeID
 }
 
+// SetSequential makes Allocate hand out fresh, contiguous IDs from the end of
+// the file instead of reusing freed ones. Freed IDs stay on the free list.
+func (p *NodePool) SetSequential(on bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sequential = on
+}
+
 // Free returns a node ID to the pool for reuse
 func (p *NodePool) Free(nodeID NodeID) {
 	if nodeID == 0 {
@@ -0,0 +1,32 @@
+package btree
+
+// overwrite replaces the value of an existing key by rewriting its leaf's
+// page in place, when the new value is no longer than the old one. It
+// reports false, leaving the tree untouched, when the fast path does not
+// apply. The caller holds t.mu and has checked no traversal is paused,
+// since a paused traversal relies on committed pages not changing.
+func (t *BTree) overwrite(key, value []byte) (bool, error) {
+	node, err := t.storage.GetRootNode()
+	if err != nil {
+		return false, err
+	}
+	for node.nodeType == InternalNode {
+		if node, err = t.storage.GetNode(node.children[node.FindChildPos(key)]); err != nil {
+			return false, err
+		}
+	}
+	i := node.FindKey(key)
+	if i < 0 || node.items[i].ref || len(value) > len(node.items[i].Value) {
+		return false, nil
+	}
+
+	// Values returned by Get alias the cached node, so swap in a new slice
+	// rather than writing over the old bytes
+	old := node.items[i].Value
+	node.items[i].Value = append([]byte(nil), value...)
+	if err := t.storage.rewriteNode(node); err != nil {
+		node.items[i].Value = old
+		return true, err
+	}
+	return true, nil
+}
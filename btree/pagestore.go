@@ -0,0 +1,229 @@
+package btree
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// PageStore holds the fixed-size pages a tree lives in. Page 0 is the
+// header and node N is page N. Storage keeps everything above plain page
+// I/O: the node cache, transactions and copy-on-write, the free list
+// (persisted in the header), checksums and encryption.
+//
+// Storage serializes access: WritePage, Truncate, Sync and Close never run
+// alongside another call, while ReadPage may run concurrently with itself.
+type PageStore interface {
+	// ReadPage returns page id, NodeSize bytes long. The slice may be the
+	// store's own memory; the caller only reads it, and not after the next
+	// write.
+	ReadPage(id NodeID) ([]byte, error)
+
+	// WritePage stores page, NodeSize bytes long, as page id, growing the
+	// store as needed
+	WritePage(id NodeID, page []byte) error
+
+	// Pages returns how many pages the store holds, the header included
+	Pages() (uint64, error)
+
+	// Truncate drops every page from n on
+	Truncate(n uint64) error
+
+	// Sync makes the pages written so far durable
+	Sync() error
+
+	Close() error
+}
+
+// filePageStore keeps pages in a file, optionally reading them through a
+// shared mapping
+type filePageStore struct {
+	file *os.File
+
+	// mmap maps the file when useMmap is set; only its first mmapSize bytes
+	// are backed by the file and may be read
+	useMmap  bool
+	mmap     []byte
+	mmapSize int64
+}
+
+// openFilePageStore opens or creates the file at path
+func openFilePageStore(path string, readOnly, useMmap bool) (*filePageStore, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	// One writer per file: a second one would commit over pages the first
+	// still has cached, and its header over the first one's
+	if !readOnly {
+		if err := lockFile(file); err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return &filePageStore{file: file, useMmap: useMmap}, nil
+}
+
+func (f *filePageStore) ReadPage(id NodeID) ([]byte, error) {
+	offset := int64(id) * NodeSize
+
+	// Serve straight from the mapping when the page is mapped; pages past
+	// it, such as ones another process appended, take the ReadAt path
+	if page := f.mappedPage(offset); page != nil {
+		return page, nil
+	}
+
+	data := make([]byte, NodeSize)
+	n, err := f.file.ReadAt(data, offset)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if n != NodeSize {
+		return nil, fmt.Errorf("short read for page %d: read %d of %d", id, n, NodeSize)
+	}
+	return data, nil
+}
+
+func (f *filePageStore) WritePage(id NodeID, page []byte) error {
+	n, err := f.file.WriteAt(page, int64(id)*NodeSize)
+	if err != nil {
+		return err
+	}
+	if n != len(page) {
+		return fmt.Errorf("short write for page %d: wrote %d of %d", id, n, len(page))
+	}
+	return nil
+}
+
+func (f *filePageStore) Pages() (uint64, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	// Count a torn last page, so a file that is not empty never reads as
+	// an empty store
+	return (uint64(info.Size()) + NodeSize - 1) / NodeSize, nil
+}
+
+func (f *filePageStore) Truncate(n uint64) error {
+	if err := f.file.Truncate(int64(n) * NodeSize); err != nil {
+		return err
+	}
+	f.refreshMmap()
+	return nil
+}
+
+func (f *filePageStore) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *filePageStore) Close() error {
+	f.unmap()
+	return f.file.Close()
+}
+
+// MemPageStore keeps pages in memory, for trees that need no persistence
+// and for tests. Its zero value is an empty store.
+type MemPageStore struct {
+	mu    sync.RWMutex
+	pages [][]byte
+}
+
+// NewMemPageStore returns an empty in-memory store
+func NewMemPageStore() *MemPageStore {
+	return &MemPageStore{}
+}
+
+func (m *MemPageStore) ReadPage(id NodeID) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if uint64(id) >= uint64(len(m.pages)) {
+		return nil, fmt.Errorf("short read for page %d: store holds %d pages", id, len(m.pages))
+	}
+	return m.pages[id], nil
+}
+
+func (m *MemPageStore) WritePage(id NodeID, page []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for uint64(len(m.pages)) <= uint64(id) {
+		m.pages = append(m.pages, make([]byte, NodeSize))
+	}
+	// Replace rather than overwrite, so pages handed out stay intact
+	m.pages[id] = append([]byte(nil), page...)
+	return nil
+}
+
+func (m *MemPageStore) Pages() (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return uint64(len(m.pages)), nil
+}
+
+func (m *MemPageStore) Truncate(n uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n < uint64(len(m.pages)) {
+		m.pages = m.pages[:n]
+	}
+	return nil
+}
+
+func (m *MemPageStore) Sync() error {
+	return nil
+}
+
+func (m *MemPageStore) Close() error {
+	return nil
+}
+
+// readerAtPageStore serves pages from an io.ReaderAt of a fixed size, such as
+// a file image held in memory or fetched from object storage. It refuses
+// every write.
+type readerAtPageStore struct {
+	r    io.ReaderAt
+	size int64
+}
+
+func (s *readerAtPageStore) ReadPage(id NodeID) ([]byte, error) {
+	offset := int64(id) * NodeSize
+	data := make([]byte, NodeSize)
+	var n int
+	var err error
+	if offset < s.size {
+		n, err = s.r.ReadAt(data[:min(NodeSize, s.size-offset)], offset)
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n != NodeSize {
+		return nil, fmt.Errorf("short read for page %d: read %d of %d", id, n, NodeSize)
+	}
+	return data, nil
+}
+
+func (s *readerAtPageStore) WritePage(NodeID, []byte) error {
+	return ErrReadOnly
+}
+
+func (s *readerAtPageStore) Pages() (uint64, error) {
+	return (uint64(s.size) + NodeSize - 1) / NodeSize, nil
+}
+
+func (s *readerAtPageStore) Truncate(uint64) error {
+	return ErrReadOnly
+}
+
+func (s *readerAtPageStore) Sync() error {
+	return nil
+}
+
+// Close leaves the reader to its owner
+func (s *readerAtPageStore) Close() error {
+	return nil
+}
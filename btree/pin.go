@@ -0,0 +1,95 @@
+package btree
+
+import "errors"
+
+// DefaultMaxPinnedPages bounds the pages Pin keeps cached when
+// Options.MaxPinnedPages is zero
+const DefaultMaxPinnedPages = 1024
+
+// ErrTooManyPinned is returned by Pin when the pages on the paths to the
+// pinned keys would exceed Options.MaxPinnedPages
+var ErrTooManyPinned = errors.New("too many pinned pages")
+
+// Pin keeps the pages on the path from the root to each of keys in the node
+// cache, so reading those keys never goes to disk, DropCache included. The
+// pages are found afresh as writes move the keys, and a key need not exist
+// yet. If the pinned pages would exceed MaxPinnedPages it fails with
+// ErrTooManyPinned and pins none of keys. Pins last until Unpin or Close.
+func (t *BTree) Pin(keys [][]byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var added []string
+	for _, key := range keys {
+		if err := checkKey(key); err != nil {
+			t.unpin(added)
+			return err
+		}
+		if _, ok := t.pinnedKeys[string(key)]; !ok {
+			t.pinnedKeys[string(key)] = struct{}{}
+			added = append(added, string(key))
+		}
+	}
+
+	// Walking the paths also reads any pinned page not yet cached
+	pages, err := t.pinnedPages()
+	if err == nil && len(pages) > t.maxPinnedPages {
+		err = ErrTooManyPinned
+	}
+	if err != nil {
+		t.unpin(added)
+	}
+	return err
+}
+
+// Unpin releases keys pinned by Pin; their pages may then be dropped
+func (t *BTree) Unpin(keys [][]byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		delete(t.pinnedKeys, string(key))
+	}
+}
+
+// PinnedKeys returns the keys currently pinned, in no particular order
+func (t *BTree) PinnedKeys() [][]byte {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	keys := make([][]byte, 0, len(t.pinnedKeys))
+	for key := range t.pinnedKeys {
+		keys = append(keys, []byte(key))
+	}
+	return keys
+}
+
+func (t *BTree) unpin(keys []string) {
+	for _, key := range keys {
+		delete(t.pinnedKeys, key)
+	}
+}
+
+// pinnedPages returns the pages on the paths from the committed root to the
+// pinned keys. The caller holds t.mu.
+func (t *BTree) pinnedPages() (map[NodeID]struct{}, error) {
+	pages := make(map[NodeID]struct{})
+	if len(t.pinnedKeys) == 0 {
+		return pages, nil
+	}
+	root, err := t.storage.GetRootNode()
+	if err != nil {
+		return nil, err
+	}
+	for key := range t.pinnedKeys {
+		node := root
+		for {
+			pages[node.id] = struct{}{}
+			if node.nodeType == LeafNode {
+				break
+			}
+			if node, err = t.storage.GetNode(node.children[node.FindChildPos([]byte(key))]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,66 @@
+package btree
+
+import "context"
+
+// progressEvery is how many pages or keys a long operation handles between
+// checks of its Progress
+const progressEvery = 64
+
+// Progress lets the caller of a long operation follow it and stop it early
+type Progress struct {
+	// Context stops the operation with its error once done; nil never does
+	Context context.Context
+
+	// Report, if set, is called every so often with the pages or keys
+	// handled so far and an estimate of the total, or 0 if unknown
+	Report func(done, total int)
+}
+
+// ProgressCounter counts the work of one operation against its Progress
+type ProgressCounter struct {
+	p     Progress
+	done  int
+	total int
+}
+
+// NewProgressCounter starts counting towards total, an estimate of the
+// pages or keys the operation will handle, or 0 if unknown
+func NewProgressCounter(p Progress, total int) *ProgressCounter {
+	return &ProgressCounter{p: p, total: total}
+}
+
+// Step counts one page or key, reporting and checking for cancellation
+// every progressEvery steps
+func (tr *ProgressCounter) Step() error {
+	tr.done++
+	if tr.done%progressEvery != 0 {
+		return nil
+	}
+	return tr.check()
+}
+
+// check reports progress now and returns the context's error, if any
+func (tr *ProgressCounter) check() error {
+	if tr.p.Report != nil {
+		done := tr.done
+		if tr.total > 0 && done >= tr.total {
+			// The total is an estimate; don't claim to be finished early
+			done = tr.total - 1
+		}
+		tr.p.Report(done, tr.total)
+	}
+	if tr.p.Context != nil {
+		return tr.p.Context.Err()
+	}
+	return nil
+}
+
+// Finish reports the operation as complete
+func (tr *ProgressCounter) Finish() {
+	if tr.total < tr.done {
+		tr.total = tr.done
+	}
+	if tr.p.Report != nil {
+		tr.p.Report(tr.total, tr.total)
+	}
+}
@@ -0,0 +1,76 @@
+package btree
+
+// MaxReadahead caps Options.Readahead. The node cache has no size limit, so
+// this is what bounds the pages one scan can pull into it ahead of use.
+const MaxReadahead = 256
+
+// maxPrefetchers bounds the prefetches in flight across all scans of a tree;
+// a scan that finds them all busy reads its pages itself as usual
+const maxPrefetchers = 4
+
+// readAhead is called as a scan moves on to children[i] and prefetches the
+// pages after it once fewer than half the readahead depth are queued, split
+// between the free prefetchers so the reads overlap. queued is how far into
+// children earlier calls prefetched; the new value is returned.
+func (t *BTree) readAhead(children []NodeID, i, queued int) int {
+	if t.readahead == 0 || queued > i+t.readahead/2 {
+		return queued
+	}
+	from := max(queued, i+1)
+	to := min(i+1+t.readahead, len(children))
+	chunk := max(1, t.readahead/maxPrefetchers)
+	for from < to {
+		select {
+		case t.prefetchers <- struct{}{}:
+		default:
+			return max(queued, from)
+		}
+		ids := append([]NodeID(nil), children[from:min(from+chunk, to)]...)
+		go func() {
+			defer func() { <-t.prefetchers }()
+			t.prefetch(ids)
+		}()
+		from += len(ids)
+	}
+	return max(queued, to)
+}
+
+// prefetch reads the pages ids into the node cache. A page that fails to
+// read is left for the scan to read, and report, itself.
+func (t *BTree) prefetch(ids []NodeID) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, id := range ids {
+		if t.closed {
+			return
+		}
+		t.storage.prefetch(id)
+	}
+}
+
+// prefetch reads node id into the cache unless it is already there, without
+// counting a cache miss
+func (s *Storage) prefetch(id NodeID) {
+	s.mu.RLock()
+	_, cached := s.nodeCache[id]
+	s.mu.RUnlock()
+	if cached {
+		return
+	}
+	if node, err := s.readNode(id); err == nil {
+		s.cacheNode(id, node)
+	}
+}
+
+// cacheNode adds node, just read from disk as page id, to the cache and
+// returns the cached copy, which is an earlier one if another reader cached
+// the page first
+func (s *Storage) cacheNode(id NodeID, node *Node) *Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.nodeCache[id]; ok {
+		return cached
+	}
+	s.nodeCache[id] = node
+	return node
+}
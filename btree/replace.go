@@ -0,0 +1,20 @@
+package btree
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// syncParent syncs the directory holding a replaced file; tests swap it to
+// observe or fail the sync
+var syncParent = syncDir
+
+// ReplaceFile renames tmp over path and syncs the directory holding them.
+// The rename alone is atomic but not durable: until the directory entry
+// reaches disk, a power loss can bring back the old file, or none at all.
+func ReplaceFile(tmp, path string) error {
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncParent(filepath.Dir(path))
+}
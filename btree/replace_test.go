@@ -0,0 +1,47 @@
+package btree
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReplaceFileSyncsParent checks ReplaceFile syncs the directory holding
+// the target after the rename, and fails when that sync does
+func TestReplaceFileSyncsParent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.db")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(path, []byte("old"), 0666); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(tmp, []byte("new"), 0666); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+
+	var synced []string
+	defer func(orig func(string) error) { syncParent = orig }(syncParent)
+	syncParent = func(d string) error {
+		// The rename must already be done when the directory is synced
+		if data, err := os.ReadFile(path); err != nil || string(data) != "new" {
+			t.Errorf("Directory synced before the rename: %q, %v", data, err)
+		}
+		synced = append(synced, d)
+		return syncDir(d)
+	}
+	if err := ReplaceFile(tmp, path); err != nil {
+		t.Fatalf("ReplaceFile failed: %v", err)
+	}
+	if len(synced) != 1 || synced[0] != dir {
+		t.Fatalf("Expected one sync of %s, got %v", dir, synced)
+	}
+
+	if err := os.WriteFile(tmp, []byte("newer"), 0666); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	syncParent = func(string) error { return errInjected }
+	if err := ReplaceFile(tmp, path); !errors.Is(err, errInjected) {
+		t.Fatalf("Expected the directory sync failure, got %v", err)
+	}
+}
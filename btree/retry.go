@@ -0,0 +1,80 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// DefaultWriteRetryBackoff is the pause before the first retry of a failed
+// commit write when WriteRetryBackoff is zero
+const DefaultWriteRetryBackoff = 10 * time.Millisecond
+
+// retryWrite runs a page write of the commit path, retrying it up to
+// writeRetries times with a doubling pause while it fails with a transient
+// error: an interrupted call, a busy device, or ENOSPC after space has come
+// back. A disk that is still full fails at once with ErrDiskFull.
+func (s *Storage) retryWrite(write func() error) error {
+	return s.retry(write, func(err error) (bool, error) {
+		switch {
+		case errors.Is(err, syscall.EINTR), errors.Is(err, syscall.EAGAIN):
+			return true, nil
+		case errors.Is(err, syscall.ENOSPC):
+			if spaceErr := s.checkWriteSpace(); spaceErr != nil {
+				return false, spaceErr
+			}
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+// retrySync runs a commit's sync, retrying it only when interrupted. After
+// any other failure the kernel may already have dropped the dirty pages, so
+// a retry that succeeds would not mean they reached the disk.
+func (s *Storage) retrySync() error {
+	return s.retry(s.sync, func(err error) (bool, error) {
+		return errors.Is(err, syscall.EINTR), nil
+	})
+}
+
+// retry runs op until it succeeds, retryable reports its error permanent,
+// or the retries run out. retryable may replace the error it is given.
+func (s *Storage) retry(op func() error, retryable func(error) (bool, error)) error {
+	backoff := s.writeRetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultWriteRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= s.writeRetries {
+			return err
+		}
+		ok, replaced := retryable(err)
+		if replaced != nil {
+			return replaced
+		}
+		if !ok {
+			return err
+		}
+		time.Sleep(backoff << attempt)
+	}
+}
+
+// checkWriteSpace fails with ErrDiskFull unless the file system has room
+// for at least a page beyond the MinFreeBytes reserve. Where free space
+// cannot be queried it allows the retry.
+func (s *Storage) checkWriteSpace() error {
+	free, err := s.FreeSpace()
+	if errors.Is(err, errDiskSpaceUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if need := s.minFree + NodeSize; free < need {
+		return fmt.Errorf("%w: %d bytes free, %d required", ErrDiskFull, free, need)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package btree
+
+import (
+	"fmt"
+	"time"
+)
+
+// CorruptPage is a page Scrub could not read back intact
+type CorruptPage struct {
+	ID  NodeID
+	Err error
+}
+
+// ScrubResult reports one Scrub pass
+type ScrubResult struct {
+	// Pages is how many pages were read back from disk
+	Pages int
+	// Corrupt lists the pages that failed their checksum, or their
+	// authentication in an encrypted file, or could not be read or decoded.
+	// The subtrees under them were not scrubbed.
+	Corrupt []CorruptPage
+}
+
+// Scrub reads every page of the committed tree back from disk, bypassing the
+// node cache, and checks it against its checksum, so bit rot is found before
+// a read that misses the cache serves it. Unlike Verify it carries on past a
+// bad page and reports them all. With pagesPerSecond above zero it sleeps
+// between pages to hold its I/O to that rate, letting writes in meanwhile;
+// like a yielding Scan it still sees the tree as committed when it began.
+// Files older than page checksums are only checked for readability.
+func (t *BTree) Scrub(pagesPerSecond int, p Progress) (ScrubResult, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tr, err := t.newPausingTraversal()
+	if err != nil {
+		return ScrubResult{}, err
+	}
+	defer tr.finish()
+
+	next, free := t.storage.nodePool.Stats()
+	s := &scrubber{
+		t:        t,
+		tr:       tr,
+		progress: NewProgressCounter(p, int(next)-1-free),
+		seen:     make(map[NodeID]struct{}),
+	}
+	if pagesPerSecond > 0 {
+		s.interval = time.Second / time.Duration(pagesPerSecond)
+	}
+	if err := s.walk(tr.root); err != nil {
+		return s.result, err
+	}
+	s.progress.Finish()
+	return s.result, nil
+}
+
+// scrubber carries the state of one Scrub pass
+type scrubber struct {
+	t        *BTree
+	tr       *traversal
+	progress *ProgressCounter
+	interval time.Duration
+	seen     map[NodeID]struct{}
+	result   ScrubResult
+}
+
+// walk scrubs the subtree at id
+func (s *scrubber) walk(id NodeID) error {
+	if _, ok := s.seen[id]; ok {
+		s.result.Corrupt = append(s.result.Corrupt, CorruptPage{ID: id, Err: corrupt(id, "referenced twice")})
+		return nil
+	}
+	s.seen[id] = struct{}{}
+	if s.interval > 0 {
+		if err := s.tr.sleep(s.interval); err != nil {
+			return err
+		}
+	} else if err := s.tr.step(); err != nil {
+		return err
+	}
+	if err := s.progress.Step(); err != nil {
+		return err
+	}
+
+	node, err := s.t.storage.readBack(id)
+	s.result.Pages++
+	if err != nil {
+		s.result.Corrupt = append(s.result.Corrupt, CorruptPage{ID: id, Err: err})
+		return nil
+	}
+	if node.nodeType == LeafNode {
+		return nil
+	}
+	for _, child := range node.children {
+		if err := s.walk(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBack reads node id from disk, skipping the cache, and checks and
+// decodes it as a cache miss would
+func (s *Storage) readBack(id NodeID) (*Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	node, err := s.readNode(id)
+	if err != nil {
+		return nil, err
+	}
+	if node.id != id {
+		return nil, fmt.Errorf("%w: page %d holds page %d", ErrCorrupt, id, node.id)
+	}
+	return node, nil
+}
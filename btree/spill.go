@@ -0,0 +1,84 @@
+package btree
+
+import "sync/atomic"
+
+// DirtyCounters tracks the pages write transactions hold in memory until
+// they commit. Trees given the same counters through Options.DirtyCounters
+// add up into them.
+type DirtyCounters struct {
+	pages   atomic.Int64
+	spills  atomic.Uint64
+	spilled atomic.Uint64
+}
+
+// DirtyStats is a snapshot of DirtyCounters
+type DirtyStats struct {
+	// Pages is how many pages the transactions in progress have written to
+	// memory only
+	Pages int64 `json:"dirty_pages"`
+
+	// Spills counts the times a transaction over Options.MaxDirtyPages
+	// wrote its dirty pages out early, and SpilledPages the pages written
+	Spills       uint64 `json:"spills"`
+	SpilledPages uint64 `json:"spilled_pages"`
+}
+
+// Stats returns the current counts
+func (c *DirtyCounters) Stats() DirtyStats {
+	return DirtyStats{
+		Pages:        c.pages.Load(),
+		Spills:       c.spills.Load(),
+		SpilledPages: c.spilled.Load(),
+	}
+}
+
+// DirtyStats reports the pages the tree's write transactions hold in
+// memory. Unlike Stats it does not wait for a transaction in progress.
+func (t *BTree) DirtyStats() DirtyStats {
+	return t.storage.dirty.Stats()
+}
+
+// markDirty adds a page to the transaction's dirty set
+func (s *Storage) markDirty(nodeID NodeID) {
+	if _, ok := s.dirtyNodes[nodeID]; ok {
+		return
+	}
+	s.dirtyNodes[nodeID] = struct{}{}
+	s.dirty.pages.Add(1)
+}
+
+// resetDirty empties the dirty set at the start or end of a transaction
+func (s *Storage) resetDirty() {
+	s.dirty.pages.Add(-int64(len(s.dirtyNodes)))
+	s.dirtyNodes = make(map[NodeID]struct{})
+	s.spilled = make(map[NodeID]struct{})
+}
+
+// spillDirty writes out the dirty pages of a transaction holding more than
+// Options.MaxDirtyPages and drops them from the cache, to be read back if
+// the transaction needs them again. Only the header publishes pages, and it
+// is still written at commit, so the transaction stays atomic. The tree
+// calls it between operations, when no dirty node is still being modified
+// in memory.
+func (s *Storage) spillDirty() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.transaction || s.maxDirty <= 0 || len(s.dirtyNodes) <= s.maxDirty {
+		return nil
+	}
+	if err := s.writeDirty(); err != nil {
+		return err
+	}
+	n := len(s.dirtyNodes)
+	for nodeID := range s.dirtyNodes {
+		delete(s.nodeCache, nodeID)
+		s.spilled[nodeID] = struct{}{}
+	}
+	s.dirtyNodes = make(map[NodeID]struct{})
+	s.dirty.pages.Add(-int64(n))
+	s.dirty.spills.Add(1)
+	s.dirty.spilled.Add(uint64(n))
+	s.refreshMmap()
+	return nil
+}
@@ -0,0 +1,48 @@
+package btree
+
+// SplitStrategy chooses where a full page splits. Whatever the strategy, the
+// point is then moved only as far as both halves need to fit in a page.
+type SplitStrategy int
+
+const (
+	// SplitByCount splits at the middle item, and an insert past the largest
+	// key of the tree leaves the left page AppendFillFactor full
+	SplitByCount SplitStrategy = iota
+
+	// SplitByBytes splits where the two halves come closest to the same
+	// serialized size, which keeps both roomy when value sizes vary widely
+	// and a count split would leave one half nearly full. Inserts past the
+	// largest key of the tree still fill by AppendFillFactor.
+	SplitByBytes
+
+	// SplitAppend also splits a page just before an insert in its upper
+	// half, leaving the left page at most AppendFillFactor full, since such
+	// an insert usually extends one of several ascending runs sharing the
+	// page. It packs pages under many interleaved sequences, such as
+	// timestamps under per-device prefixes, that SplitByCount leaves half
+	// full, at the cost of emptier right pages under random inserts.
+	SplitAppend
+)
+
+// byteMedian returns the index of the first item of the right half that
+// makes the halves' sizes, as splitPoint measures them, closest
+func byteMedian(items []Item, fixed func(left int) (int, int)) int {
+	total := 0
+	for _, it := range items {
+		total += itemSize(it)
+	}
+	best, bestDiff := 1, -1
+	left := 0
+	for mid := 1; mid < len(items); mid++ {
+		left += itemSize(items[mid-1])
+		leftFixed, rightFixed := fixed(mid)
+		diff := leftFixed + left - (rightFixed + total - left)
+		if diff < 0 {
+			diff = -diff
+		}
+		if bestDiff < 0 || diff < bestDiff {
+			best, bestDiff = mid, diff
+		}
+	}
+	return best
+}
@@ -0,0 +1,161 @@
+package btree
+
+import (
+	"math/bits"
+	"time"
+)
+
+// Stats describes the shape of a B-tree file
+type Stats struct {
+	PageSize  int    `json:"page_size"`
+	PageCount uint64 `json:"page_count"`
+	FreePages int    `json:"free_pages"`
+	Sealed    bool   `json:"sealed"`
+
+	// DiskFree is the space available on the file's file system, when the
+	// platform reports it; MinDiskFree is the threshold below which writes
+	// are refused
+	DiskFree    uint64 `json:"disk_free_bytes,omitempty"`
+	MinDiskFree uint64 `json:"min_disk_free_bytes,omitempty"`
+
+	// CacheHits and CacheMisses count page reads served from the node cache
+	// and from the file since the tree was opened
+	CacheHits   uint64 `json:"cache_hits"`
+	CacheMisses uint64 `json:"cache_misses"`
+
+	// PagesWritten counts node pages written since the tree was opened
+	PagesWritten uint64 `json:"pages_written"`
+
+	// OpenReaders counts yielding traversals in progress, each keeping the
+	// pages under the root it began from out of Compact's reach, and
+	// OldestReaderSeconds is how long the oldest of them has run
+	OpenReaders         int     `json:"open_readers"`
+	OldestReaderSeconds float64 `json:"oldest_reader_seconds,omitempty"`
+
+	// The fields below are only filled in by a full traversal
+	Full          bool `json:"full"`
+	Depth         int  `json:"depth,omitempty"`
+	LeafPages     int  `json:"leaf_pages,omitempty"`
+	InternalPages int  `json:"internal_pages,omitempty"`
+	// Keys counts the keys users stored, and ReservedKeys those starting
+	// with a NUL byte that hold metadata such as versions and buckets
+	Keys         int       `json:"keys,omitempty"`
+	ReservedKeys int       `json:"reserved_keys,omitempty"`
+	KeySizes     Histogram `json:"key_sizes,omitempty"`
+	ValueSizes   Histogram `json:"value_sizes,omitempty"`
+
+	// SeparatorSizes are the sizes of the keys internal pages route by
+	SeparatorSizes Histogram `json:"separator_sizes,omitempty"`
+}
+
+// Bucket counts sizes in (UpperBound/2, UpperBound]; the first bucket also
+// holds zero-length entries
+type Bucket struct {
+	UpperBound int `json:"le"`
+	Count      int `json:"count"`
+}
+
+// Histogram is a power-of-two size histogram, one bucket per power of two up
+// to the largest size observed
+type Histogram []Bucket
+
+// Observe adds one entry of the given size
+func (h *Histogram) Observe(size int) {
+	idx := 0
+	if size > 1 {
+		idx = bits.Len(uint(size - 1))
+	}
+	for len(*h) <= idx {
+		*h = append(*h, Bucket{UpperBound: 1 << len(*h)})
+	}
+	(*h)[idx].Count++
+}
+
+// Stats reports page usage. When full is set it also walks every page to
+// count keys and build key and value size histograms, which reads the whole
+// file and should not be done on a hot path.
+func (t *BTree) Stats(full bool) (Stats, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	next, free := t.storage.nodePool.Stats()
+	stats := Stats{
+		PageSize:  NodeSize,
+		PageCount: uint64(next),
+		FreePages: free,
+		Sealed:    t.storage.Sealed(),
+
+		MinDiskFree: t.storage.minFree,
+
+		CacheHits:   t.storage.cacheHits.Load(),
+		CacheMisses: t.storage.cacheMisses.Load(),
+
+		PagesWritten: t.storage.pagesWritten.Load(),
+	}
+	if free, err := t.storage.FreeSpace(); err == nil {
+		stats.DiskFree = free
+	}
+	readers, oldest := t.readers()
+	stats.OpenReaders = readers
+	if readers > 0 {
+		stats.OldestReaderSeconds = time.Since(oldest).Seconds()
+	}
+	if !full {
+		return stats, nil
+	}
+
+	tr, err := t.newTraversal()
+	if err != nil {
+		return stats, err
+	}
+	defer tr.finish()
+	root, err := t.storage.GetNode(tr.root)
+	if err != nil {
+		return stats, err
+	}
+	walked := stats
+	walked.Full = true
+	if err := t.collectStats(root, 1, &walked, tr); err != nil {
+		return stats, err
+	}
+	return walked, nil
+}
+
+// collectStats accumulates the subtree rooted at node into stats
+func (t *BTree) collectStats(node *Node, depth int, stats *Stats, tr *traversal) error {
+	if err := tr.step(); err != nil {
+		return err
+	}
+	if depth > stats.Depth {
+		stats.Depth = depth
+	}
+
+	if node.nodeType == LeafNode {
+		stats.LeafPages++
+		for _, item := range node.items {
+			if len(item.Key) > 0 && item.Key[0] == 0 {
+				stats.ReservedKeys++
+				continue
+			}
+			stats.Keys++
+			stats.KeySizes.Observe(len(item.Key))
+			stats.ValueSizes.Observe(len(item.Value))
+		}
+		return nil
+	}
+
+	stats.InternalPages++
+	for _, item := range node.items {
+		stats.SeparatorSizes.Observe(len(item.Key))
+	}
+	for _, childID := range node.children {
+		child, err := t.storage.GetNode(childID)
+		if err != nil {
+			return err
+		}
+		if err := t.collectStats(child, depth+1, stats, tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
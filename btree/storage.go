@@ -2,68 +2,357 @@ package btree
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
-	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	// Magic number for file format identification
 	MagicNumber uint32 = 0x434F4E55 // "CONU" in ASCII
 
-	// Version of the file format
-	Version uint32 = 1
+	// Version of the file format. Version 2 added page checksums; older
+	// files are upgraded on open, see Options.AllowMigration.
+	Version uint32 = 2
+
+	// checksumVersion is the first version whose pages carry checksums
+	checksumVersion uint32 = 2
 
 	// HeaderSize defines the size of the file header region in bytes.
 	// We reserve a full page to simplify offset math and avoid variable-length headers.
 	HeaderSize = NodeSize
+
+	// headerFlagsOffset places a flags word in the last 4 bytes of the header
+	// page, past the bounded free list, so older files read as all flags off
+	headerFlagsOffset = HeaderSize - 4
+
+	// headerFlagSealed marks a file that must never be written again
+	headerFlagSealed uint32 = 1 << 0
+
+	// headerFlagEncrypted marks a file whose pages and header fields are
+	// encrypted; its salt and key check sit at headerEncryptionOffset
+	headerFlagEncrypted uint32 = 1 << 1
+
+	// headerFlagSharedValues marks a file whose leaves may hold references
+	// to shared values, flagged in their value lengths; see
+	// Options.DedupMinValueSize. It is set before the first reference is
+	// written and never cleared.
+	headerFlagSharedValues uint32 = 1 << 2
+
+	// knownHeaderFlags are the flags this version understands. A file with
+	// any other flag set was written by a newer release whose format this
+	// one could misread or damage, so it is refused.
+	knownHeaderFlags = headerFlagSealed | headerFlagEncrypted | headerFlagSharedValues
 )
 
 var (
 	ErrInvalidMagicNumber = errors.New("invalid magic number")
 	ErrInvalidVersion     = errors.New("invalid version")
+	ErrUnknownFlags       = errors.New("file uses features this version does not support")
 	ErrNodeNotFound       = errors.New("node not found")
+	ErrReadOnly           = errors.New("storage is read-only")
+	ErrSealed             = errors.New("storage is sealed")
+	ErrDiskFull           = errors.New("insufficient disk space")
+	ErrNeedsMigration     = errors.New("file format is older than this version; open with migration allowed to upgrade it")
+	// ErrLocked is returned when opening a file for writing that another
+	// writer, in this process or another, already holds open
+	ErrLocked = errors.New("file is already open for writing")
 )
 
+// Options configures how a storage file is opened
+type Options struct {
+	// ReadOnly opens the file without write access; every write fails with
+	// ErrReadOnly. The file must already exist.
+	ReadOnly bool
+
+	// NoSync skips the fsync at the end of each commit. A crash may lose
+	// recent commits but never tears a committed tree; call Sync to flush.
+	NoSync bool
+
+	// UseMmap serves node reads from a shared read-only mapping of the file
+	// instead of a ReadAt per node. Writes still use WriteAt. On platforms
+	// without a suitable mmap, or if mapping fails, reads fall back to ReadAt.
+	// A reader must not map a file that another process may Compact: reading
+	// a mapped page after the file is truncated crashes the process.
+	UseMmap bool
+
+	// AppendFillFactor is the share of a page a split leaves in the left
+	// node when it was caused by inserting past the largest key, so
+	// sequential inserts pack pages instead of leaving them half full. Zero
+	// means DefaultAppendFillFactor; 0.5 or less splits at the midpoint.
+	AppendFillFactor float64
+
+	// SplitStrategy chooses where full pages split: at the middle item, the
+	// default, at the middle byte, or just before an insert that extends an
+	// ascending run. Trees split either way read the same.
+	SplitStrategy SplitStrategy
+
+	// MinFreeBytes refuses to begin a write transaction, with ErrDiskFull,
+	// while the file system has less free space than this, rather than
+	// failing partway through a commit. Zero disables the check.
+	MinFreeBytes uint64
+
+	// YieldEvery makes Scan, Verify and full Stats pause after every
+	// YieldEvery items or pages, releasing the read lock so writers waiting
+	// on it can commit. Zero never pauses. A paused traversal carries on over
+	// the tree as it was when it began; see Scan.
+	YieldEvery int
+
+	// MaxReaders caps how many traversals may pause at once, each of which
+	// keeps the pages under the root it began from out of Compact's reach.
+	// Past it Scan, Verify and full Stats fail with ErrTooManyReaders, so a
+	// reader that never finishes shows up as errors rather than a file that
+	// only grows. Zero is no cap; it has no effect without YieldEvery.
+	MaxReaders int
+
+	// TruncateSeparators stores in internal pages only the shortest prefix of
+	// a leaf's first key that still separates it from the leaf before, so
+	// long keys sharing a short distinguishing prefix leave room for more
+	// children per page. Trees written with and without it read the same.
+	TruncateSeparators bool
+
+	// MaxSeparatorBytes bounds the separators TruncateSeparators leaves. A
+	// leaf split whose separator would be longer moves, by up to a quarter
+	// of the leaf's items either way, to the nearest point whose separator
+	// fits and whose halves still fit a page. Keys sharing a long prefix
+	// throughout the leaf leave no such point; the split then stays put and
+	// the full distinguishing prefix is stored, which internal splits, made
+	// by bytes, absorb at the cost of fanout. Zero is no bound.
+	MaxSeparatorBytes int
+
+	// UnsafeOverwriteInPlace lets Put replace a value with one no longer
+	// than it by rewriting just the leaf's page rather than copying the path
+	// from the root. It is skipped while a yielding traversal is paused.
+	// Unlike a commit, the rewrite is not atomic: a crash during it can tear
+	// the page and lose every key on it, committed or not, which its
+	// checksum then reports. Readers in other processes may likewise see a
+	// page mid-write. Use it only for data that can be rebuilt.
+	UnsafeOverwriteInPlace bool
+
+	// WriteRetries is how many times a commit retries a page write that
+	// failed with a transient error (EINTR, EAGAIN, or ENOSPC once space has
+	// been freed) before aborting the transaction. Syncs are retried only
+	// when interrupted. A disk still short of MinFreeBytes plus a page fails
+	// at once with ErrDiskFull. Zero never retries.
+	WriteRetries int
+
+	// WriteRetryBackoff is the pause before the first retry, doubling with
+	// each one. Zero means DefaultWriteRetryBackoff.
+	WriteRetryBackoff time.Duration
+
+	// MaxPinnedPages bounds how many pages the paths to keys pinned with
+	// BTree.Pin may span. Zero means DefaultMaxPinnedPages.
+	MaxPinnedPages int
+
+	// DedupMinValueSize makes Compact store a value of at least this many
+	// bytes held by more than one key once, each key keeping a reference to
+	// it. Reads resolve references transparently, and overwriting or
+	// deleting a key drops its reference. Zero disables it. Versions of the
+	// tree without it cannot read a file it has been used on.
+	DedupMinValueSize int
+
+	// Readahead makes Scan prefetch the next Readahead pages of the level
+	// it is walking, in the background, so the next leaf is usually cached
+	// by the time the scan reaches it rather than read while it waits. It
+	// helps long scans over pages not yet cached. Zero disables it; it is
+	// capped at MaxReadahead.
+	Readahead int
+
+	// MaxDirtyPages is a soft cap on the pages a write transaction keeps in
+	// memory until it commits. Past it, the transaction's pages are written
+	// to the file between operations and dropped from the node cache, which
+	// bounds the memory a huge Batch or Update takes. The header still only
+	// changes at commit, so the transaction stays atomic, and a crash leaves
+	// the pages written early unreferenced. Zero keeps every page in memory
+	// until commit.
+	MaxDirtyPages int
+
+	// DirtyCounters, if set, receives the dirty page counts of the tree's
+	// transactions, so several trees can report into one; see DirtyStats
+	DirtyCounters *DirtyCounters
+
+	// YieldLocker is a lock the caller holds in read mode around each of
+	// those calls. It is released and retaken with the tree's own lock at
+	// each pause.
+	YieldLocker sync.Locker
+
+	// EncryptionKey, EncryptionKeySize bytes, encrypts a new file at rest
+	// and is required to open one encrypted before; a wrong key fails with
+	// ErrWrongEncryptionKey. An existing unencrypted file is not converted
+	// and fails to open with ErrNotEncrypted. Encrypted pages hold 24 fewer
+	// bytes of keys and values, for the cipher's nonce and tag in place of
+	// the checksum.
+	EncryptionKey []byte
+
+	// AllowMigration upgrades a file written in an older format when it is
+	// opened for writing, rewriting it to a temp file that is renamed over
+	// the original. Without it such a file fails to open with
+	// ErrNeedsMigration. Read-only opens never migrate; they read older
+	// files as they are.
+	AllowMigration bool
+}
+
 // Storage manages the on-disk storage of nodes
 type Storage struct {
 	mu           sync.RWMutex
-	file         *os.File
+	pages        PageStore
 	nodeCache    map[NodeID]*Node
 	rootNodeID   NodeID
 	nodePool     *NodePool
 	dirtyNodes   map[NodeID]struct{}
 	transaction  bool
 	originalRoot NodeID
+	readOnly     bool
+	noSync       bool
+	sealed       bool
+	minFree      uint64
+
+	// sharedValues mirrors headerFlagSharedValues
+	sharedValues bool
+
+	// version is the format of the open file; older ones are only read
+	version uint32
+
+	// key is Options.EncryptionKey; cipher is set once the file is known
+	// to be encrypted. maxNodeBytes is how much of a page a node may fill.
+	key          []byte
+	cipher       *pageCipher
+	maxNodeBytes int
+
+	// cacheHits and cacheMisses count GetNode calls served from nodeCache
+	// and from the file since the storage was opened
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+
+	// pagesWritten counts node pages written since the storage was opened
+	pagesWritten atomic.Uint64
+
+	// maxDirty is Options.MaxDirtyPages, and spilled the pages the
+	// transaction in progress has written out early. dirty backs DirtyStats.
+	maxDirty int
+	spilled  map[NodeID]struct{}
+	dirty    *DirtyCounters
+
+	// writeRetries and writeRetryBackoff bound the retries of failed commit
+	// writes; see Options
+	writeRetries      int
+	writeRetryBackoff time.Duration
+
+	// failpoint, when set by a test, is consulted before each write and sync
+	// and may return an error to simulate an I/O failure at that site.
+	// It is never set outside tests.
+	failpoint func(site string) error
+}
+
+// Failpoint sites
+const (
+	failWriteNode   = "writeNode"
+	failWriteHeader = "writeHeader"
+	failSync        = "sync"
+)
+
+// fail returns the error injected at site, if any
+func (s *Storage) fail(site string) error {
+	if s.failpoint == nil {
+		return nil
+	}
+	return s.failpoint(site)
 }
 
 // OpenStorage opens a storage file
 func OpenStorage(path string) (*Storage, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	return OpenStorageWithOptions(path, Options{})
+}
+
+// OpenStorageWithOptions opens a storage file with the given options
+func OpenStorageWithOptions(path string, opts Options) (*Storage, error) {
+	file, err := openFilePageStore(path, opts.ReadOnly, opts.UseMmap)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := openStorage(file, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	// Only a file in the current format may be written to
+	if storage.version < Version && !opts.ReadOnly {
+		from := storage.version
+		if err := storage.pages.Close(); err != nil {
+			return nil, err
+		}
+		if !opts.AllowMigration {
+			return nil, fmt.Errorf("%w: %s is version %d, current is %d", ErrNeedsMigration, path, from, Version)
+		}
+		if err := MigrateFile(path); err != nil {
+			return nil, err
+		}
+		return OpenStorageWithOptions(path, opts)
+	}
+	storage.refreshMmap()
+
+	return storage, nil
+}
+
+// OpenStorageWithStore opens storage over pages, initializing an empty store.
+// The storage takes ownership of pages and closes it on Close or when
+// opening fails. Options.UseMmap and AllowMigration only apply to files;
+// pages in an older format open read-only or fail with ErrNeedsMigration.
+func OpenStorageWithStore(pages PageStore, opts Options) (*Storage, error) {
+	storage, err := openStorage(pages, opts)
+	if err != nil {
+		return nil, err
+	}
+	if storage.version < Version && !opts.ReadOnly {
+		from := storage.version
+		if err := pages.Close(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: store is version %d, current is %d", ErrNeedsMigration, from, Version)
+	}
+	return storage, nil
+}
+
+// openStorage reads the header of pages, or initializes them if the store is
+// empty, closing pages on failure
+func openStorage(pages PageStore, opts Options) (*Storage, error) {
 	storage := &Storage{
-		file:       file,
-		nodeCache:  make(map[NodeID]*Node),
-		nodePool:   NewNodePool(),
-		dirtyNodes: make(map[NodeID]struct{}),
+		pages:             pages,
+		version:           Version,
+		nodeCache:         make(map[NodeID]*Node),
+		nodePool:          NewNodePool(),
+		dirtyNodes:        make(map[NodeID]struct{}),
+		spilled:           make(map[NodeID]struct{}),
+		maxDirty:          opts.MaxDirtyPages,
+		dirty:             opts.DirtyCounters,
+		readOnly:          opts.ReadOnly,
+		noSync:            opts.NoSync,
+		minFree:           opts.MinFreeBytes,
+		writeRetries:      opts.WriteRetries,
+		writeRetryBackoff: opts.WriteRetryBackoff,
+		key:               opts.EncryptionKey,
+	}
+	if storage.dirty == nil {
+		storage.dirty = &DirtyCounters{}
 	}
 
-	// Check if the file is empty
-	info, err := file.Stat()
+	// Check if the store is empty
+	n, err := pages.Pages()
 	if err != nil {
+		_ = pages.Close()
 		return nil, err
 	}
 
-	if info.Size() == 0 {
-		// Initialize a new file
+	if n == 0 {
+		// Initialize a new store
 		if err := storage.initializeNewFile(); err != nil {
-			if closeErr := file.Close(); closeErr != nil {
+			if closeErr := pages.Close(); closeErr != nil {
 				return nil, fmt.Errorf("failed to initialize file: %v (also failed to close: %v)", err, closeErr)
 			}
 			return nil, err
@@ -71,7 +360,7 @@ func OpenStorage(path string) (*Storage, error) {
 	} else {
 		// Read the header
 		if err := storage.readHeader(); err != nil {
-			if closeErr := file.Close(); closeErr != nil {
+			if closeErr := pages.Close(); closeErr != nil {
 				return nil, fmt.Errorf("failed to read header: %v (also failed to close: %v)", err, closeErr)
 			}
 			return nil, err
@@ -90,7 +379,7 @@ func (s *Storage) Close() error {
 		s.abortTransaction()
 	}
 
-	return s.file.Close()
+	return s.pages.Close()
 }
 
 // initializeNewFile initializes a new file with header and root node
@@ -98,6 +387,21 @@ func (s *Storage) initializeNewFile() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.key != nil {
+		salt, err := newSalt()
+		if err != nil {
+			return err
+		}
+		if err := s.setCipher(salt); err != nil {
+			return err
+		}
+	} else {
+		s.maxNodeBytes = MaxNodeBytes
+	}
+
 	// Write an empty header first so subsequent writes land after a full page
 	if err := s.writeHeader(); err != nil {
 		return err
@@ -118,37 +422,52 @@ func (s *Storage) initializeNewFile() error {
 	return s.writeHeader()
 }
 
+// HeaderVersion checks that page is the header page of a tree file in a
+// format this package reads and returns the format version, without opening
+// anything. It lets a file arriving as a stream be vetted before it is kept.
+func HeaderVersion(page []byte) (uint32, error) {
+	if len(page) < HeaderSize {
+		return 0, fmt.Errorf("header too small: %d bytes", len(page))
+	}
+	if binary.LittleEndian.Uint32(page) != MagicNumber {
+		return 0, ErrInvalidMagicNumber
+	}
+	version := binary.LittleEndian.Uint32(page[4:])
+	if version == 0 || version > Version {
+		return 0, ErrInvalidVersion
+	}
+	if unknown := binary.LittleEndian.Uint32(page[headerFlagsOffset:]) &^ knownHeaderFlags; unknown != 0 {
+		return 0, fmt.Errorf("%w: header flags %#x", ErrUnknownFlags, unknown)
+	}
+	return version, nil
+}
+
 // readHeader reads the file header
 func (s *Storage) readHeader() error {
 	// Read exactly one header page
-	head := make([]byte, HeaderSize)
-	n, err := s.file.ReadAt(head, 0)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+	head, err := s.pages.ReadPage(0)
+	if err != nil {
 		return err
 	}
-	if n < 28 { // minimally need fixed fields
-		return fmt.Errorf("header too small: %d bytes", n)
-	}
-
-	r := bytes.NewReader(head)
-
-	// Read magic number
-	var magic uint32
-	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+	version, err := HeaderVersion(head)
+	if err != nil {
 		return err
 	}
-	if magic != MagicNumber {
-		return ErrInvalidMagicNumber
-	}
+	s.version = version
 
-	// Read version
-	var version uint32
-	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+	flags := binary.LittleEndian.Uint32(head[headerFlagsOffset:])
+	s.sealed = flags&headerFlagSealed != 0
+	s.sharedValues = flags&headerFlagSharedValues != 0
+	if err := s.checkEncryption(flags, head); err != nil {
 		return err
 	}
-	if version != Version {
-		return ErrInvalidVersion
+	fields := head[headerClearBytes:]
+	if s.cipher != nil {
+		if fields, err = s.cipher.openHeader(head[headerClearBytes:headerEncryptionOffset], headerAD(head)); err != nil {
+			return err
+		}
 	}
+	r := bytes.NewReader(fields)
 
 	// Read root node ID
 	if err := binary.Read(r, binary.LittleEndian, &s.rootNodeID); err != nil {
@@ -169,9 +488,9 @@ func (s *Storage) readHeader() error {
 		return err
 	}
 
-	// Compute how many NodeIDs fit after fixed fields
+	// Compute how many NodeIDs fit between the fixed fields and the flags
 	const fixedFields = 4 + 4 + 8 + 8 + 4 // magic + version + root + next + count
-	maxFree := uint32((HeaderSize - fixedFields) / 8)
+	maxFree := uint32((s.freeListEnd() - fixedFields) / 8)
 	if freeNodeCount > maxFree {
 		freeNodeCount = maxFree
 	}
@@ -189,8 +508,61 @@ func (s *Storage) readHeader() error {
 	return nil
 }
 
+// checkEncryption matches the key the storage was opened with against the
+// header's encryption flag and key check, setting up the page cipher
+func (s *Storage) checkEncryption(flags uint32, head []byte) error {
+	if flags&headerFlagEncrypted == 0 {
+		if s.key != nil {
+			return ErrNotEncrypted
+		}
+		s.maxNodeBytes = MaxNodeBytes
+		return nil
+	}
+	if s.key == nil {
+		return ErrEncryptionKeyRequired
+	}
+	salt := head[headerEncryptionOffset : headerEncryptionOffset+encryptionSaltSize]
+	if s.cipher != nil && bytes.Equal(s.cipher.salt, salt) {
+		return nil
+	}
+	c, err := newPageCipher(s.key, bytes.Clone(salt))
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(c.check, head[headerEncryptionOffset+encryptionSaltSize:headerFlagsOffset]) {
+		return ErrWrongEncryptionKey
+	}
+	s.cipher = c
+	s.maxNodeBytes = encryptedNodeBytes
+	return nil
+}
+
+// setCipher encrypts a new file's pages under a key derived with salt
+func (s *Storage) setCipher(salt []byte) error {
+	c, err := newPageCipher(s.key, salt)
+	if err != nil {
+		return err
+	}
+	s.cipher = c
+	s.maxNodeBytes = encryptedNodeBytes
+	return nil
+}
+
+// freeListEnd is the header offset the persisted free list must stop at.
+// An encrypted header loses room for the nonce and tag that seal it.
+func (s *Storage) freeListEnd() int {
+	if s.cipher != nil {
+		return headerEncryptionOffset - pageNonceSize - pageTagSize
+	}
+	return headerFlagsOffset
+}
+
 // writeHeader writes the file header
 func (s *Storage) writeHeader() error {
+	if err := s.fail(failWriteHeader); err != nil {
+		return err
+	}
+
 	// Build a fixed-size header page
 	buf := bytes.NewBuffer(make([]byte, 0, HeaderSize))
 
@@ -217,7 +589,7 @@ func (s *Storage) writeHeader() error {
 
 	// Determine how many free node IDs we can persist in the header page
 	const fixedFields = 4 + 4 + 8 + 8 + 4
-	maxFree := (HeaderSize - fixedFields) / 8
+	maxFree := (s.freeListEnd() - fixedFields) / 8
 	freeNodeCount := len(s.nodePool.freeNodeIDs)
 	if freeNodeCount > maxFree {
 		freeNodeCount = maxFree
@@ -235,105 +607,203 @@ func (s *Storage) writeHeader() error {
 		}
 	}
 
-	// Pad the rest of the header page
-	if buf.Len() > HeaderSize {
-		return fmt.Errorf("header size %d exceeds reserved header page %d", buf.Len(), HeaderSize)
+	// Pad up to the encryption fields and flags word at the end of the page
+	if buf.Len() > s.freeListEnd() {
+		return fmt.Errorf("header size %d exceeds reserved header page %d", buf.Len(), s.freeListEnd())
 	}
-	padding := make([]byte, HeaderSize-buf.Len())
+	padding := make([]byte, s.freeListEnd()-buf.Len())
 	if _, err := buf.Write(padding); err != nil {
 		return err
 	}
+	var flags uint32
+	if s.sealed {
+		flags |= headerFlagSealed
+	}
+	if s.sharedValues {
+		flags |= headerFlagSharedValues
+	}
+	if s.cipher == nil {
+		if err := binary.Write(buf, binary.LittleEndian, flags); err != nil {
+			return err
+		}
+		// Write header as the first page
+		return s.pages.WritePage(0, buf.Bytes())
+	}
 
-	// Write header at the beginning of the file
-	data := buf.Bytes()
-	n, err := s.file.WriteAt(data, 0)
+	// Seal everything past the magic number and version, leaving the salt,
+	// key check and flags in the clear to check the key with on open
+	flags |= headerFlagEncrypted
+	page := make([]byte, HeaderSize)
+	copy(page, buf.Bytes()[:headerClearBytes])
+	copy(page[headerEncryptionOffset:], s.cipher.salt)
+	copy(page[headerEncryptionOffset+encryptionSaltSize:], s.cipher.check)
+	binary.LittleEndian.PutUint32(page[headerFlagsOffset:], flags)
+	sealed, err := s.cipher.sealHeader(buf.Bytes()[headerClearBytes:], headerAD(page))
 	if err != nil {
 		return err
 	}
-	if n != len(data) {
-		return fmt.Errorf("short write for header: wrote %d of %d", n, len(data))
-	}
-
-	return nil
+	copy(page[headerClearBytes:], sealed)
+	return s.pages.WritePage(0, page)
 }
 
 // ReloadHeader refreshes in-memory header state from disk.
 // Intended for read-only consumers to observe updates made by another process.
+// When the header shows another commit, the node cache is emptied as well.
 func (s *Storage) ReloadHeader() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.readHeader()
+	root, next := s.rootNodeID, s.nodePool.nextNodeID
+	if err := s.readHeader(); err != nil {
+		return err
+	}
+	s.refreshMmap()
+	// Another writer committed: page IDs it freed and reused may hold other
+	// nodes now than the ones cached under them
+	if s.rootNodeID != root || s.nodePool.nextNodeID != next {
+		s.nodeCache = make(map[NodeID]*Node)
+	}
+	return nil
 }
 
 // GetNode gets a node from storage
 func (s *Storage) GetNode(nodeID NodeID) (*Node, error) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
 
 	// Check if the node is in cache
 	if node, ok := s.nodeCache[nodeID]; ok {
+		s.mu.RUnlock()
+		s.cacheHits.Add(1)
 		return node, nil
 	}
+	s.mu.RUnlock()
+	s.cacheMisses.Add(1)
 
-	// Read the node from disk
+	// Read the node from disk. Readers hold the tree's read lock, which
+	// keeps writes and Close out, so the read needs no storage lock and
+	// does not hold up other readers caching what they read.
 	node, err := s.readNode(nodeID)
 	if err != nil {
 		return nil, err
 	}
-
-	// Add the node to cache
-	s.nodeCache[nodeID] = node
-
-	return node, nil
+	return s.cacheNode(nodeID, node), nil
 }
 
 // readNode reads a node from disk
 func (s *Storage) readNode(nodeID NodeID) (*Node, error) {
-	// Calculate the offset (header occupies one full page)
-	offset := int64(HeaderSize) + int64(nodeID-1)*int64(NodeSize)
-
-	// Read the node data
-	data := make([]byte, NodeSize)
-	n, err := s.file.ReadAt(data, offset)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+	// The header occupies page 0, so node N is page N
+	page, err := s.pages.ReadPage(nodeID)
+	if err != nil {
 		return nil, err
 	}
-	if n != NodeSize {
-		return nil, fmt.Errorf("short read for node %d: read %d of %d", nodeID, n, NodeSize)
-	}
 
 	// Deserialize the node
-	node, err := DeserializeNode(data)
+	return s.decodePage(nodeID, page)
+}
+
+// decodePage checks or decrypts a page read from disk and deserializes it
+func (s *Storage) decodePage(nodeID NodeID, page []byte) (*Node, error) {
+	var node *Node
+	if s.cipher == nil {
+		if err := s.checkPage(nodeID, page); err != nil {
+			return nil, err
+		}
+		var err error
+		if node, err = DeserializeNode(page); err != nil {
+			return nil, err
+		}
+	} else {
+		body, err := s.cipher.open(nodeID, page)
+		if err != nil {
+			return nil, err
+		}
+		if node, err = decodeNode(body); err != nil {
+			return nil, err
+		}
+	}
+	return node, s.checkChildren(node)
+}
+
+// checkChildren rejects an internal node pointing past the last page the
+// header has allocated
+func (s *Storage) checkChildren(node *Node) error {
+	if node.nodeType != InternalNode {
+		return nil
+	}
+	next, _ := s.nodePool.Stats()
+	for i, child := range node.children {
+		if child >= next {
+			return corrupt(node.id, "child %d is page %d, at or past page %d, the next to be allocated", i, child, next)
+		}
+	}
+	return nil
+}
+
+// encodePage serializes node into a page, encrypting it in an encrypted file
+func (s *Storage) encodePage(node *Node) ([]byte, error) {
+	if s.cipher == nil {
+		return node.Serialize()
+	}
+	body, err := node.encode(encryptedNodeBytes)
 	if err != nil {
 		return nil, err
 	}
+	return s.cipher.seal(node.id, body)
+}
 
-	return node, nil
+// checkPage verifies a page's checksum, in files that have them
+func (s *Storage) checkPage(nodeID NodeID, page []byte) error {
+	if s.version < checksumVersion || pageChecksumOK(page) {
+		return nil
+	}
+	return fmt.Errorf("%w: node %d", ErrPageChecksum, nodeID)
 }
 
 // writeNode writes a node to disk
 func (s *Storage) writeNode(node *Node) error {
-	// Calculate the offset (header occupies one full page)
-	offset := int64(HeaderSize) + int64(node.id-1)*int64(NodeSize)
+	if err := s.fail(failWriteNode); err != nil {
+		return err
+	}
 
 	// Serialize the node
-	data, err := node.Serialize()
+	data, err := s.encodePage(node)
 	if err != nil {
 		return err
 	}
 
 	// Write the node data
-	n, err := s.file.WriteAt(data, offset)
-	if err != nil {
+	if err := s.pages.WritePage(node.id, data); err != nil {
 		return err
 	}
-	if n != len(data) {
-		return fmt.Errorf("short write for node %d: wrote %d of %d", node.id, n, len(data))
-	}
+	s.pagesWritten.Add(1)
 
 	return nil
 }
 
+// rewriteNode writes a committed node back over its own page, for
+// UnsafeOverwriteInPlace
+func (s *Storage) rewriteNode(node *Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sealed {
+		return ErrSealed
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.transaction {
+		return errors.New("transaction already in progress")
+	}
+	if err := s.writeNode(node); err != nil {
+		return err
+	}
+	s.refreshMmap()
+	if s.noSync {
+		return nil
+	}
+	return s.sync()
+}
+
 // GetRootNode gets the root node
 func (s *Storage) GetRootNode() (*Node, error) {
 	return s.GetNode(s.rootNodeID)
@@ -346,10 +816,10 @@ func (s *Storage) SetRootNode(node *Node) error {
 
 	s.rootNodeID = node.id
 	s.nodeCache[node.id] = node
-	s.dirtyNodes[node.id] = struct{}{}
 
 	// During a transaction we defer header persistence until commit
 	if s.transaction {
+		s.markDirty(node.id)
 		return nil
 	}
 
@@ -358,16 +828,33 @@ func (s *Storage) SetRootNode(node *Node) error {
 
 // BeginTransaction begins a transaction
 func (s *Storage) BeginTransaction() error {
+	return s.beginTransaction(true)
+}
+
+// beginTransaction starts a transaction, checking free disk space first
+// when checkSpace is set
+func (s *Storage) beginTransaction(checkSpace bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.sealed {
+		return ErrSealed
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
 	if s.transaction {
 		return errors.New("transaction already in progress")
 	}
+	if checkSpace {
+		if err := s.checkSpace(); err != nil {
+			return err
+		}
+	}
 
 	s.transaction = true
 	s.originalRoot = s.rootNodeID
-	s.dirtyNodes = make(map[NodeID]struct{})
+	s.resetDirty()
 
 	return nil
 }
@@ -381,35 +868,103 @@ func (s *Storage) CommitTransaction() error {
 		return errors.New("no transaction in progress")
 	}
 
-	// Write all dirty nodes
-	for nodeID := range s.dirtyNodes {
-		node, ok := s.nodeCache[nodeID]
-		if !ok {
-			return fmt.Errorf("dirty node %d not found in cache", nodeID)
-		}
+	// A failed commit never reached the header, or its header is not known
+	// to be durable; fall back to the last committed root either way
+	if err := s.flushTransaction(); err != nil {
+		s.abortTransaction()
+		return err
+	}
 
-		if err := s.writeNode(node); err != nil {
-			return err
-		}
+	// Reset transaction state
+	s.transaction = false
+	s.resetDirty()
+	s.refreshMmap()
+
+	return nil
+}
+
+// Seal permanently marks the file read-only: the flag is persisted in the
+// header, and every later write, in this or any other process, fails with
+// ErrSealed.
+func (s *Storage) Seal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sealed {
+		return nil
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.transaction {
+		return errors.New("transaction in progress")
 	}
 
-	// Update header
+	s.sealed = true
 	if err := s.writeHeader(); err != nil {
+		s.sealed = false
 		return err
 	}
+	return s.sync()
+}
 
-	// Ensure durability by syncing to disk
-	if err := s.file.Sync(); err != nil {
+// markSharedValues sets headerFlagSharedValues with the next header written
+func (s *Storage) markSharedValues() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sharedValues = true
+}
+
+// Sealed reports whether the file has been sealed
+func (s *Storage) Sealed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sealed
+}
+
+// flushTransaction writes the dirty nodes, then the header that publishes
+// them, and syncs
+func (s *Storage) flushTransaction() error {
+	if err := s.writeDirty(); err != nil {
 		return err
 	}
 
-	// Reset transaction state
-	s.transaction = false
-	s.dirtyNodes = make(map[NodeID]struct{})
+	// Update header
+	if err := s.retryWrite(s.writeHeader); err != nil {
+		return err
+	}
+
+	// Ensure durability by syncing to disk
+	if !s.noSync {
+		if err := s.retrySync(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// writeDirty writes the dirty nodes in page order, so the writes are as
+// sequential as the allocation allows
+func (s *Storage) writeDirty() error {
+	dirty := make([]NodeID, 0, len(s.dirtyNodes))
+	for nodeID := range s.dirtyNodes {
+		dirty = append(dirty, nodeID)
+	}
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i] < dirty[j] })
+	for _, nodeID := range dirty {
+		node, ok := s.nodeCache[nodeID]
+		if !ok {
+			return fmt.Errorf("dirty node %d not found in cache", nodeID)
+		}
+
+		if err := s.retryWrite(func() error { return s.writeNode(node) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // abortTransaction aborts a transaction
 func (s *Storage) abortTransaction() {
 	if !s.transaction {
@@ -421,7 +976,7 @@ func (s *Storage) abortTransaction() {
 
 	// Reset transaction state
 	s.transaction = false
-	s.dirtyNodes = make(map[NodeID]struct{})
+	s.resetDirty()
 }
 
 // PutNode puts a node in storage with copy-on-write
@@ -431,7 +986,7 @@ func (s *Storage) PutNode(node *Node) error {
 
 	if s.transaction {
 		// Mark the node as dirty
-		s.dirtyNodes[node.id] = struct{}{}
+		s.markDirty(node.id)
 		// Update the cache
 		s.nodeCache[node.id] = node
 		return nil
@@ -480,7 +1035,7 @@ func (s *Storage) CloneNode(node *Node) (*Node, error) {
 
 	if s.transaction {
 		// Mark the node as dirty
-		s.dirtyNodes[newNodeID] = struct{}{}
+		s.markDirty(newNodeID)
 	} else {
 		// Write the node immediately if not in a transaction
 		if err := s.writeNode(newNode); err != nil {
@@ -505,10 +1060,70 @@ func (s *Storage) DeleteNode(nodeID NodeID) error {
 	return nil
 }
 
+// dropCache empties the node cache except for the nodes in keep and those
+// written in the current transaction and not yet on disk, returning how many
+// it dropped. The
+// allocator is left alone: its free list can be ahead of the header, so it
+// cannot be rebuilt from disk.
+func (s *Storage) dropCache(keep map[NodeID]struct{}) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := 0
+	for id := range s.nodeCache {
+		if _, dirty := s.dirtyNodes[id]; dirty && s.transaction {
+			continue
+		}
+		if _, ok := keep[id]; ok {
+			continue
+		}
+		delete(s.nodeCache, id)
+		dropped++
+	}
+	return dropped
+}
+
+// discardNode releases a page written earlier in the current transaction
+// that the new tree no longer references, whether still in memory or
+// spilled. Pages of the committed tree are left alone, since the committed
+// tree stays readable until the header switches over; Compact reclaims them
+// later.
+func (s *Storage) discardNode(nodeID NodeID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.transaction {
+		return
+	}
+	if _, dirty := s.dirtyNodes[nodeID]; dirty {
+		delete(s.dirtyNodes, nodeID)
+		delete(s.nodeCache, nodeID)
+		s.dirty.pages.Add(-1)
+	} else if _, spilled := s.spilled[nodeID]; spilled {
+		delete(s.spilled, nodeID)
+	} else {
+		return
+	}
+	s.nodePool.Free(nodeID)
+}
+
 // Sync syncs the storage to disk
 func (s *Storage) Sync() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return s.file.Sync()
+	if s.readOnly || s.sealed {
+		return nil
+	}
+
+	return s.sync()
+}
+
+// sync fsyncs the file
+func (s *Storage) sync() error {
+	if err := s.fail(failSync); err != nil {
+		return err
+	}
+
+	return s.pages.Sync()
 }
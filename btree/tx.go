@@ -0,0 +1,70 @@
+package btree
+
+import "errors"
+
+// Tx is a read-write view of the tree inside Update. Reads see the
+// transaction's own writes. A Tx must not be used after Update returns.
+type Tx struct {
+	t *BTree
+}
+
+// Update runs fn inside a single write transaction, committing everything it
+// wrote if fn returns nil and discarding it all otherwise. fn's error is
+// returned unchanged.
+func (t *BTree) Update(fn func(tx *Tx) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.storage.BeginTransaction(); err != nil {
+		return err
+	}
+
+	if err := fn(&Tx{t: t}); err != nil {
+		t.storage.abortTransaction()
+		return err
+	}
+
+	return t.storage.CommitTransaction()
+}
+
+// Get returns a copy of the value stored under key, and whether it exists
+func (tx *Tx) Get(key []byte) ([]byte, bool, error) {
+	if err := checkKey(key); err != nil {
+		return nil, false, err
+	}
+	return tx.t.lookupTx(key)
+}
+
+// Put puts a key-value pair
+func (tx *Tx) Put(key, value []byte) error {
+	if err := checkWriteKey(key); err != nil {
+		return err
+	}
+	if len(value) > MaxValueSize {
+		return ErrValueTooLarge
+	}
+	return tx.t.putTx(key, value)
+}
+
+// Delete deletes key, returning ErrKeyNotFound if it does not exist
+func (tx *Tx) Delete(key []byte) error {
+	if err := checkWriteKey(key); err != nil {
+		return err
+	}
+	err := tx.t.deleteTx(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// Scan works like BTree.Scan over the transaction's view of the tree. fn
+// must not write through tx.
+func (tx *Tx) Scan(start []byte, fn func(key, value []byte) bool) error {
+	root, err := tx.t.storage.GetRootNode()
+	if err != nil {
+		return err
+	}
+	_, err = tx.t.scan(root, root, start, fn)
+	return err
+}
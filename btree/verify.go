@@ -0,0 +1,202 @@
+package btree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrCorrupt is wrapped by every structural problem Verify reports
+var ErrCorrupt = errors.New("tree is corrupt")
+
+// Verify walks the whole tree and checks its structure: every referenced
+// page is allocated, not on the free list and referenced once; keys are
+// ordered within each page and fall between the separators that route to
+// it; internal pages have one more child than separators; no page other than
+// the root is empty; every leaf sits at the same depth; and every reference
+// to a shared value finds it, with a count matching the references and the
+// hash it is stored under. It reads every
+// page, so it is meant for tests and offline checks. With YieldEvery set it
+// pauses like Scan, checking the tree as it was when it began.
+func (t *BTree) Verify() error {
+	return t.VerifyWithProgress(Progress{})
+}
+
+// VerifyWithProgress is Verify, reporting the pages checked so far and
+// stopping with the context's error if it is cancelled
+func (t *BTree) VerifyWithProgress(p Progress) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tr, err := t.newTraversal()
+	if err != nil {
+		return err
+	}
+	defer tr.finish()
+	v := &verifier{
+		t:      t,
+		tr:     tr,
+		seen:   make(map[NodeID]struct{}),
+		free:   make(map[NodeID]struct{}),
+		refs:   make(map[string]uint64),
+		shared: make(map[string]uint64),
+	}
+	t.storage.nodePool.mu.Lock()
+	v.next = t.storage.nodePool.nextNodeID
+	for _, id := range t.storage.nodePool.freeNodeIDs {
+		v.free[id] = struct{}{}
+	}
+	t.storage.nodePool.mu.Unlock()
+	v.progress = NewProgressCounter(p, int(v.next)-1-len(v.free))
+
+	if err := v.walk(tr.root, nil, nil, 1, true); err != nil {
+		return err
+	}
+	if err := v.checkShared(); err != nil {
+		return err
+	}
+	v.progress.Finish()
+	return nil
+}
+
+// verifier carries the state of one Verify walk
+type verifier struct {
+	t         *BTree
+	tr        *traversal
+	progress  *ProgressCounter
+	next      NodeID
+	free      map[NodeID]struct{}
+	seen      map[NodeID]struct{}
+	leafDepth int
+
+	// refs counts the references to each shared value by hash, and shared
+	// the count each shared value records
+	refs   map[string]uint64
+	shared map[string]uint64
+}
+
+// corrupt formats a structural problem with page id, found by Verify or
+// while decoding it
+func corrupt(id NodeID, format string, args ...any) error {
+	return fmt.Errorf("%w: page %d: %s", ErrCorrupt, id, fmt.Sprintf(format, args...))
+}
+
+// walk checks the subtree at id, whose keys must lie in [lo, hi); a nil
+// bound is open
+func (v *verifier) walk(id NodeID, lo, hi []byte, depth int, root bool) error {
+	if id == 0 || id >= v.next {
+		return corrupt(id, "outside the allocated range [1, %d)", v.next)
+	}
+	if _, ok := v.free[id]; ok {
+		return corrupt(id, "referenced but on the free list")
+	}
+	if _, ok := v.seen[id]; ok {
+		return corrupt(id, "referenced twice")
+	}
+	v.seen[id] = struct{}{}
+	if err := v.tr.step(); err != nil {
+		return err
+	}
+	if err := v.progress.Step(); err != nil {
+		return err
+	}
+
+	node, err := v.t.storage.GetNode(id)
+	if err != nil {
+		return corrupt(id, "unreadable: %v", err)
+	}
+	if node.id != id {
+		return corrupt(id, "holds page %d", node.id)
+	}
+	if len(node.items) == 0 && !root && node.nodeType == LeafNode {
+		return corrupt(id, "empty leaf")
+	}
+	if estimateNodeSize(node, nil, -1) > NodeSize {
+		return corrupt(id, "larger than a page")
+	}
+	if len(node.items) > MaxItems {
+		return corrupt(id, "holds %d items, more than %d", len(node.items), MaxItems)
+	}
+	for i, it := range node.items {
+		if i > 0 && bytes.Compare(node.items[i-1].Key, it.Key) >= 0 {
+			return corrupt(id, "keys out of order at %d", i)
+		}
+		if lo != nil && bytes.Compare(it.Key, lo) < 0 || hi != nil && bytes.Compare(it.Key, hi) >= 0 {
+			return corrupt(id, "key %q outside its parent's range", it.Key)
+		}
+	}
+
+	if node.nodeType == LeafNode {
+		if v.leafDepth == 0 {
+			v.leafDepth = depth
+		} else if depth != v.leafDepth {
+			return corrupt(id, "leaf at depth %d, expected %d", depth, v.leafDepth)
+		}
+		return v.leafValues(id, node)
+	}
+
+	if len(node.items) == 0 {
+		return corrupt(id, "internal page with a single child")
+	}
+	if len(node.children) != len(node.items)+1 {
+		return corrupt(id, "%d children for %d separators", len(node.children), len(node.items))
+	}
+	for i, child := range node.children {
+		childLo, childHi := lo, hi
+		if i > 0 {
+			childLo = node.items[i-1].Key
+		}
+		if i < len(node.items) {
+			childHi = node.items[i].Key
+		}
+		if err := v.walk(child, childLo, childHi, depth+1, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leafValues tallies the references and shared values in leaf id
+func (v *verifier) leafValues(id NodeID, node *Node) error {
+	for _, it := range node.items {
+		switch {
+		case it.ref:
+			if len(it.Value) != sha256.Size {
+				return corrupt(id, "key %q holds a %d-byte reference", it.Key, len(it.Value))
+			}
+			v.refs[string(it.Value)]++
+		case bytes.HasPrefix(it.Key, sharedPrefix):
+			hash := it.Key[len(sharedPrefix):]
+			if len(it.Value) < 8 {
+				return corrupt(id, "shared value %x has no reference count", hash)
+			}
+			if sum := sha256.Sum256(it.Value[8:]); !bytes.Equal(sum[:], hash) {
+				return corrupt(id, "shared value %x does not match its hash", hash)
+			}
+			v.shared[string(hash)] = binary.BigEndian.Uint64(it.Value)
+		}
+	}
+	return nil
+}
+
+// checkShared matches the references found against the shared values'
+// counts, once every leaf has been walked
+func (v *verifier) checkShared() error {
+	for hash, n := range v.refs {
+		count, ok := v.shared[hash]
+		switch {
+		case !ok:
+			return fmt.Errorf("%w: %d keys refer to missing shared value %x", ErrCorrupt, n, hash)
+		case count != n:
+			return fmt.Errorf("%w: shared value %x counts %d references, found %d", ErrCorrupt, hash, count, n)
+		}
+	}
+	for hash, count := range v.shared {
+		if _, ok := v.refs[hash]; !ok {
+			return fmt.Errorf("%w: shared value %x counts %d references, found none", ErrCorrupt, hash, count)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// ErrClosed is returned by a traversal whose tree was closed while it was
+// paused, e.g. because a restore replaced the database file
+var ErrClosed = errors.New("tree is closed")
+
+// ErrTooManyReaders is returned by Scan, Verify and full Stats when
+// Options.MaxReaders traversals already pin pages
+var ErrTooManyReaders = errors.New("too many open readers")
+
+// traversal paces one long read of the tree, pausing every yieldEvery steps
+// so waiting writers can take the lock. It reads the tree under the root
+// committed when it began. Committed pages are never rewritten in place, and
+// while the traversal is pinned Compact keeps every page under that root, so
+// the whole traversal sees one point in time however many commits land
+// during its pauses.
+type traversal struct {
+	t       *BTree
+	root    NodeID
+	steps   int
+	started time.Time
+	pinned  bool
+}
+
+// newTraversal starts a traversal, pinning the committed root when it may
+// pause; the caller holds t.mu read-locked and must call finish. It fails
+// with ErrTooManyReaders when maxReaders traversals are already pinned.
+func (t *BTree) newTraversal() (*traversal, error) {
+	return t.startTraversal(t.yieldEvery > 0)
+}
+
+// newPausingTraversal is newTraversal for a traversal that pauses on its own
+// schedule through sleep, so it pins the root even if the tree never yields
+func (t *BTree) newPausingTraversal() (*traversal, error) {
+	return t.startTraversal(true)
+}
+
+func (t *BTree) startTraversal(pin bool) (*traversal, error) {
+	tr := &traversal{t: t, root: t.storage.rootNodeID, started: time.Now(), pinned: pin}
+	if pin {
+		t.pinMu.Lock()
+		defer t.pinMu.Unlock()
+		if t.maxReaders > 0 && len(t.pins) >= t.maxReaders {
+			return nil, fmt.Errorf("%w: %d pin pages", ErrTooManyReaders, len(t.pins))
+		}
+		t.pins[tr] = struct{}{}
+	}
+	return tr, nil
+}
+
+// finish unpins the traversal's root
+func (tr *traversal) finish() {
+	if tr.pinned {
+		tr.t.pinMu.Lock()
+		delete(tr.t.pins, tr)
+		tr.t.pinMu.Unlock()
+	}
+}
+
+// pinnedRoots returns the roots of traversals in progress
+func (t *BTree) pinnedRoots() []NodeID {
+	t.pinMu.Lock()
+	defer t.pinMu.Unlock()
+	roots := make([]NodeID, 0, len(t.pins))
+	for tr := range t.pins {
+		roots = append(roots, tr.root)
+	}
+	return roots
+}
+
+// readers returns how many traversals pin pages and when the oldest began
+func (t *BTree) readers() (int, time.Time) {
+	t.pinMu.Lock()
+	defer t.pinMu.Unlock()
+	var oldest time.Time
+	for tr := range t.pins {
+		if oldest.IsZero() || tr.started.Before(oldest) {
+			oldest = tr.started
+		}
+	}
+	return len(t.pins), oldest
+}
+
+// step counts one item or page and pauses when due. It returns ErrClosed
+// if the tree was closed during the pause.
+func (tr *traversal) step() error {
+	t := tr.t
+	if t.yieldEvery <= 0 {
+		return nil
+	}
+	tr.steps++
+	if tr.steps%t.yieldEvery != 0 {
+		return nil
+	}
+	return tr.pause(runtime.Gosched)
+}
+
+// sleep pauses a traversal started by newPausingTraversal for d, letting
+// writers in meanwhile. It returns ErrClosed if the tree was closed during
+// the pause.
+func (tr *traversal) sleep(d time.Duration) error {
+	return tr.pause(func() { time.Sleep(d) })
+}
+
+// pause releases the traversal's locks while wait runs
+func (tr *traversal) pause(wait func()) error {
+	t := tr.t
+
+	// Release in the reverse of the order callers acquire, and take back
+	// the same way
+	t.paused.Add(1)
+	t.mu.RUnlock()
+	if t.yieldLocker != nil {
+		t.yieldLocker.Unlock()
+	}
+	wait()
+	if t.yieldLocker != nil {
+		t.yieldLocker.Lock()
+	}
+	t.mu.RLock()
+	t.paused.Add(-1)
+
+	if t.closed {
+		return ErrClosed
+	}
+	return nil
+}
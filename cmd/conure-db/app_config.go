@@ -15,17 +15,97 @@ func LoadEffectiveConfig() (config.Config, error) {
 		dataDir    string
 		raftAddr   string
 		httpAddr   string
+		httpAdv    string
+		respAddr   string
 		bootstrap  settableBool
 		barrier    settableDuration
+		maxScan    int
+		maxTxnOps  int
+		maxTxnSize int64
+		lagAlert   uint64
+		advertise  string
+		maxPool    int
+		raftTO     settableDuration
+		hotKeys    settableBool
+		httpRead   settableDuration
+		httpWrite  settableDuration
+		httpIdle   settableDuration
+		maxHeader  int
+		heartbeat  settableDuration
+		election   settableDuration
+		lease      settableDuration
+		commit     settableDuration
+		maxAppend  int
+		maxCommand int
+		batchApply settableBool
+		accessLog  settableBool
+		redact     settableBool
+		minFree    uint64
+		compress   string
+		migrate    settableBool
+		backupTick settableDuration
+		backupDest string
+		backupKeep int
+		keyFile    string
+		waitLeader settableBool
+		startupTO  settableDuration
+		rejoin     settableDuration
+		degraded   settableBool
+		scrubEvery settableDuration
+		scrubRate  int
+		maxDirty   int
+		deadAfter  settableDuration
+		removeDead settableBool
+		inPlace    settableBool
 	)
 
 	flag.StringVar(&configPath, "config", "", "path to YAML config file")
 	flag.StringVar(&nodeID, "node-id", "", "unique node ID")
 	flag.StringVar(&dataDir, "data-dir", "", "data directory for node state")
-	flag.StringVar(&raftAddr, "raft-addr", "", "raft bind/advertise address host:port")
+	flag.StringVar(&raftAddr, "raft-addr", "", "raft bind address host:port")
+	flag.StringVar(&advertise, "raft-advertise", "", "raft address advertised to peers (defaults to --raft-addr)")
 	flag.StringVar(&httpAddr, "http-addr", "", "http bind address")
+	flag.StringVar(&httpAdv, "advertise-http-addr", "", "base URL of this node's HTTP API as clients reach it, sent in leader redirects (e.g., http://conure-1.conure-hs:8081)")
+	flag.StringVar(&respAddr, "resp-addr", "", "serve the Redis protocol (GET, SET, DEL, SCAN) on this address (disabled by default)")
 	flag.Var(&bootstrap, "bootstrap", "bootstrap single-node cluster if no existing state")
 	flag.Var(&barrier, "barrier-timeout", "raft barrier timeout (e.g., 3s)")
+	flag.IntVar(&maxScan, "max-scan-results", 0, "maximum items returned by a single /scan request")
+	flag.IntVar(&maxTxnOps, "max-txn-ops", 0, "maximum conditions plus ops in one /txn request (default 10000)")
+	flag.Int64Var(&maxTxnSize, "max-txn-bytes", 0, "maximum body size of one /txn request (default 4194304)")
+	flag.Uint64Var(&lagAlert, "lag-alert-threshold", 0, "warn when a follower trails the leader by more than this many entries (0 disables)")
+	flag.IntVar(&maxPool, "raft-max-pool", 0, "idle raft connections kept per peer")
+	flag.Var(&raftTO, "raft-timeout", "raft transport I/O timeout (e.g., 10s)")
+	flag.Var(&hotKeys, "track-hot-keys", "count accesses per key for /debug/hotkeys")
+	flag.Var(&httpRead, "http-read-timeout", "maximum time to read an HTTP request (e.g., 30s)")
+	flag.Var(&httpWrite, "http-write-timeout", "maximum time to write an HTTP response (e.g., 30s)")
+	flag.Var(&httpIdle, "http-idle-timeout", "how long idle keep-alive connections stay open (e.g., 2m)")
+	flag.IntVar(&maxHeader, "http-max-header-bytes", 0, "maximum size of HTTP request headers")
+	flag.Var(&heartbeat, "heartbeat-timeout", "raft heartbeat timeout (default 1s)")
+	flag.Var(&election, "election-timeout", "raft election timeout (default 1s)")
+	flag.Var(&lease, "leader-lease-timeout", "raft leader lease timeout, at most the heartbeat timeout (default 500ms)")
+	flag.Var(&commit, "commit-timeout", "raft commit timeout (default 50ms)")
+	flag.IntVar(&maxAppend, "raft-max-append-entries", 0, "most raft log entries written and fsynced as one batch (default 64, max 1024)")
+	flag.IntVar(&maxCommand, "max-command-bytes", 0, "largest encoded write accepted as one raft log entry (default 8 MiB)")
+	flag.Var(&batchApply, "raft-batch-apply", "queue writes arriving during a raft log write for the next batch")
+	flag.Var(&accessLog, "access-log", "log every API request to stdout")
+	flag.Var(&redact, "access-log-redact", "omit keys and prefixes from the access log")
+	flag.Uint64Var(&minFree, "min-free-disk-bytes", 0, "refuse writes with 507 while free disk space is below this (0 disables)")
+	flag.StringVar(&compress, "snapshot-compression", "", "compress raft snapshots: none or gzip (default none)")
+	flag.Var(&migrate, "allow-migration", "upgrade a data file written in an older storage format on startup")
+	flag.StringVar(&keyFile, "encryption-key-file", "", "file holding the hex-encoded key that encrypts the data file (default $"+encryptionKeyEnv+")")
+	flag.Var(&backupTick, "backup-interval", "upload a snapshot from the leader this often (requires --backup-destination)")
+	flag.StringVar(&backupDest, "backup-destination", "", "backup directory or s3://bucket/prefix")
+	flag.IntVar(&backupKeep, "backup-retain", 0, "number of newest backups to keep (0 keeps all)")
+	flag.Var(&waitLeader, "wait-for-leader", "answer only /healthz, /status, /version and /metrics until the node knows a leader")
+	flag.Var(&startupTO, "startup-timeout", "with --wait-for-leader, serve the API anyway after this long (e.g., 1m; 0 waits indefinitely)")
+	flag.Var(&rejoin, "rejoin-interval", "check this often that the node is still a cluster member and join again if it was removed (e.g., 30s; 0 disables)")
+	flag.Var(&degraded, "degraded-reads", "while there is no leader, e.g. after losing quorum, serve every read from local data, flagged as degraded, and refuse writes with 503")
+	flag.Var(&scrubEvery, "scrub-interval", "read every page back and check its checksum in the background, pausing this long between passes (e.g., 1h; 0 disables)")
+	flag.IntVar(&scrubRate, "scrub-pages-per-second", 0, "pages the scrubber reads per second (default 100)")
+	flag.IntVar(&maxDirty, "max-dirty-pages", 0, "pages a write transaction holds in memory before writing them out early (0 holds all until commit)")
+	flag.Var(&deadAfter, "dead-node-timeout", "on the leader, warn about a server that has not answered for this long (e.g., 6h; 0 disables)")
+	flag.Var(&removeDead, "remove-dead-nodes", "with --dead-node-timeout, also remove such a server from the raft configuration while a quorum remains")
+	flag.Var(&inPlace, "restore-in-place", "restore raft snapshots straight over the data file instead of staging a copy, needing half the disk but leaving the database empty if a restore fails partway")
 	flag.Parse()
 
 	cfgFile, err := config.Load(configPath)
@@ -34,10 +114,28 @@ func LoadEffectiveConfig() (config.Config, error) {
 	}
 
 	cli := CLIOverrides{
-		NodeID:   nodeID,
-		DataDir:  dataDir,
-		RaftAddr: raftAddr,
-		HTTPAddr: httpAddr,
+		NodeID:         nodeID,
+		DataDir:        dataDir,
+		RaftAddr:       raftAddr,
+		HTTPAddr:       httpAddr,
+		AdvertiseHTTP:  httpAdv,
+		RESPAddr:       respAddr,
+		MaxScanResults: maxScan,
+		MaxTxnOps:      maxTxnOps,
+		MaxTxnBytes:    maxTxnSize,
+		LagAlert:       lagAlert,
+		RaftAdvertise:  advertise,
+		RaftMaxPool:    maxPool,
+		RaftMaxAppend:  maxAppend,
+		MaxCommand:     maxCommand,
+		HTTPMaxHeader:  maxHeader,
+		MinFreeDisk:    minFree,
+		SnapCompress:   compress,
+		BackupDest:     backupDest,
+		BackupRetain:   backupKeep,
+		EncryptKeyFile: keyFile,
+		ScrubRate:      scrubRate,
+		MaxDirtyPages:  maxDirty,
 	}
 	if bootstrap.set {
 		cli.Bootstrap = &bootstrap.val
@@ -45,6 +143,72 @@ func LoadEffectiveConfig() (config.Config, error) {
 	if barrier.set {
 		cli.BarrierTimeout = &barrier.val
 	}
+	if raftTO.set {
+		cli.RaftTimeout = &raftTO.val
+	}
+	if hotKeys.set {
+		cli.TrackHotKeys = &hotKeys.val
+	}
+	if httpRead.set {
+		cli.HTTPRead = &httpRead.val
+	}
+	if httpWrite.set {
+		cli.HTTPWrite = &httpWrite.val
+	}
+	if httpIdle.set {
+		cli.HTTPIdle = &httpIdle.val
+	}
+	if heartbeat.set {
+		cli.Heartbeat = &heartbeat.val
+	}
+	if election.set {
+		cli.Election = &election.val
+	}
+	if lease.set {
+		cli.LeaderLease = &lease.val
+	}
+	if commit.set {
+		cli.Commit = &commit.val
+	}
+	if batchApply.set {
+		cli.RaftBatchApply = &batchApply.val
+	}
+	if accessLog.set {
+		cli.AccessLog = &accessLog.val
+	}
+	if redact.set {
+		cli.AccessRedact = &redact.val
+	}
+	if migrate.set {
+		cli.AllowMigrate = &migrate.val
+	}
+	if backupTick.set {
+		cli.BackupEvery = &backupTick.val
+	}
+	if waitLeader.set {
+		cli.WaitForLeader = &waitLeader.val
+	}
+	if startupTO.set {
+		cli.StartupTimeout = &startupTO.val
+	}
+	if rejoin.set {
+		cli.RejoinInterval = &rejoin.val
+	}
+	if degraded.set {
+		cli.DegradedReads = &degraded.val
+	}
+	if scrubEvery.set {
+		cli.ScrubInterval = &scrubEvery.val
+	}
+	if deadAfter.set {
+		cli.DeadNodeAfter = &deadAfter.val
+	}
+	if removeDead.set {
+		cli.RemoveDead = &removeDead.val
+	}
+	if inPlace.set {
+		cli.RestoreInPlace = &inPlace.val
+	}
 
 	cfg := mergeConfig(cfgFile, cli)
 	return cfg, nil
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// encryptionKeyEnv holds the data file's encryption key when no key file
+// is configured
+const encryptionKeyEnv = "CONURE_ENCRYPTION_KEY"
+
+// loadEncryptionKey reads the hex-encoded key from path, or from
+// CONURE_ENCRYPTION_KEY when path is empty. It returns nil when neither is
+// set, leaving the data file unencrypted.
+func loadEncryptionKey(path string) ([]byte, error) {
+	text, source := os.Getenv(encryptionKeyEnv), encryptionKeyEnv
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text, source = string(data), path
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(text)
+	if err != nil || len(key) != btree.EncryptionKeySize {
+		return nil, fmt.Errorf("%s: encryption key must be %d hex-encoded bytes, e.g. from `openssl rand -hex %d`", source, btree.EncryptionKeySize, btree.EncryptionKeySize)
+	}
+	return key, nil
+}
@@ -4,14 +4,19 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/conuredb/conuredb/btree"
 	"github.com/conuredb/conuredb/db"
 	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/conuredb/conuredb/pkg/backup"
+	"github.com/conuredb/conuredb/pkg/join"
 	"github.com/conuredb/conuredb/pkg/raftnode"
+	"github.com/conuredb/conuredb/pkg/resp"
 )
 
 func main() {
@@ -19,6 +24,27 @@ func main() {
 	log.SetOutput(io.Discard)
 	appLog := log.New(os.Stdout, "", log.LstdFlags)
 
+	if len(os.Args) > 1 && (os.Args[1] == "import-csv" || os.Args[1] == "export-csv") {
+		if err := runCSVCommand(os.Args[1], os.Args[2:]); err != nil {
+			// stdout may be carrying the export, so report on stderr
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiffCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "reset-raft" {
+		os.Exit(runResetRaftCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheckCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	cfg, err := LoadEffectiveConfig()
 	if err != nil {
 		appLog.Fatalf("load config: %v", err)
@@ -29,7 +55,16 @@ func main() {
 	}
 
 	dbPath := filepath.Join(cfg.DataDir, "conure.db")
-	store, err := db.Open(dbPath)
+	key, err := loadEncryptionKey(cfg.EncryptionKeyFile)
+	if err != nil {
+		appLog.Fatalf("config: %v", err)
+	}
+	store, err := db.OpenWithOptions(dbPath, db.Options{
+		TrackHotKeys:   cfg.TrackHotKeys,
+		AllowMigration: cfg.AllowMigration,
+		EncryptionKey:  key,
+		MaxDirtyPages:  cfg.MaxDirtyPages,
+	})
 	if err != nil {
 		appLog.Fatalf("open db: %v", err)
 	}
@@ -39,30 +74,171 @@ func main() {
 		}
 	}()
 
-	fsm := &raftnode.FSM{DB: store}
+	compression, err := raftnode.ParseSnapshotCompression(cfg.SnapshotCompress)
+	if err != nil {
+		appLog.Fatalf("config: %v", err)
+	}
+	var accessLog *slog.Logger
+	if cfg.AccessLog {
+		accessLog = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	}
+	fsm := &raftnode.FSM{DB: store, SnapshotCompression: compression, Logger: accessLog, RestoreInPlace: cfg.RestoreInPlace}
 	node, err := raftnode.StartNode(raftnode.Config{
 		NodeID:    cfg.NodeID,
 		RaftAddr:  cfg.RaftAddr,
 		DataDir:   cfg.DataDir,
 		Bootstrap: cfg.Bootstrap,
+
+		AdvertiseAddr:    cfg.RaftAdvertise,
+		MaxPool:          cfg.RaftMaxPool,
+		TransportTimeout: cfg.RaftTimeout,
+
+		HeartbeatTimeout:   cfg.HeartbeatTimeout,
+		ElectionTimeout:    cfg.ElectionTimeout,
+		LeaderLeaseTimeout: cfg.LeaderLeaseTimeout,
+		CommitTimeout:      cfg.CommitTimeout,
+
+		MaxAppendEntries: cfg.RaftMaxAppend,
+		BatchApply:       cfg.RaftBatchApply,
+		MaxCommandBytes:  cfg.MaxCommandBytes,
 	}, fsm)
 	if err != nil {
 		appLog.Fatalf("start raft: %v", err)
 	}
 
+	if cfg.LagAlertThreshold > 0 {
+		stop := node.WatchReplicationLag(10*time.Second, cfg.LagAlertThreshold, func(p raftnode.PeerReplication) {
+			appLog.Printf("WARNING: follower %s is %d entries behind (match index %d)", p.ID, p.Lag, p.MatchIndex)
+		})
+		defer stop()
+	}
+
+	if cfg.DeadNodeTimeout > 0 {
+		stop := node.WatchDeadPeers(raftnode.DeadPeerOptions{
+			After:  cfg.DeadNodeTimeout,
+			Remove: cfg.RemoveDeadNodes,
+			OnDead: func(p raftnode.DeadPeer) {
+				appLog.Printf("WARNING: server %s (%s) has not answered the leader for over %v; remove it with /remove if it is gone for good", p.ID, p.Address, cfg.DeadNodeTimeout)
+			},
+			OnRemove: func(p raftnode.DeadPeer, err error) {
+				if err != nil {
+					appLog.Printf("WARNING: failed to remove dead server %s: %v", p.ID, err)
+					return
+				}
+				appLog.Printf("WARNING: removed dead server %s (%s) from the cluster configuration", p.ID, p.Address)
+			},
+		})
+		defer stop()
+	} else if cfg.RemoveDeadNodes {
+		appLog.Fatalf("config: remove_dead_nodes requires dead_node_timeout")
+	}
+
+	if cfg.ScrubInterval > 0 {
+		stop := store.StartScrubber(db.ScrubOptions{
+			Interval:       cfg.ScrubInterval,
+			PagesPerSecond: cfg.ScrubPagesPerSec,
+			OnCorrupt: func(page btree.CorruptPage) {
+				appLog.Printf("ERROR: scrub found corrupt page %d: %v", page.ID, page.Err)
+			},
+			OnError: func(err error) {
+				appLog.Printf("WARNING: scrub failed: %v", err)
+			},
+		})
+		defer stop()
+	}
+
+	if b := cfg.Backup; b.Destination != "" && b.Interval > 0 {
+		backups, err := backup.NewStore(b.Destination, backup.StoreOptions{
+			S3Endpoint:     b.S3Endpoint,
+			S3Region:       b.S3Region,
+			S3AccessKey:    b.S3AccessKey,
+			S3SecretKey:    b.S3SecretKey,
+			S3SessionToken: b.S3SessionToken,
+		})
+		if err != nil {
+			appLog.Fatalf("backup: %v", err)
+		}
+		stop := backup.Start(store, backups, backup.Options{
+			Interval:  b.Interval,
+			Retain:    b.Retain,
+			ShouldRun: node.IsLeader,
+			OnError: func(err error) {
+				appLog.Printf("WARNING: backup failed: %v", err)
+			},
+		})
+		defer stop()
+	}
+
+	mux := http.NewServeMux()
+	apiServer := api.New(node, store).
+		WithBarrierTimeout(cfg.BarrierTimeout).
+		WithMaxScanResults(cfg.MaxScanResults).
+		WithTxnLimits(cfg.MaxTxnOps, cfg.MaxTxnBytes).
+		WithMinFreeDisk(cfg.MinFreeDiskBytes).
+		WithAdminToken(cfg.AdminToken).
+		WithAdvertiseHTTP(cfg.AdvertiseHTTP)
+	if len(cfg.ACL) > 0 {
+		rules := make([]api.ACLRule, 0, len(cfg.ACL))
+		for _, rule := range cfg.ACL {
+			rules = append(rules, api.ACLRule{Token: rule.Token, Read: rule.Read, Write: rule.Write})
+		}
+		apiServer.WithACL(rules)
+	}
+	if accessLog != nil {
+		apiServer.WithAccessLog(accessLog, api.AccessLogOptions{Redact: cfg.AccessLogRedact})
+	}
+	if cfg.WaitForLeader {
+		apiServer.WithWaitForLeader(cfg.StartupTimeout)
+		appLog.Printf("Serving only /healthz, /status, /version and /metrics until a leader is known")
+	}
+	if cfg.DegradedReads {
+		apiServer.WithDegradedReads()
+	}
+	apiServer.Register(mux)
+
 	// Auto-join when not bootstrapping
+	seeds := parseSeeds()
+	advertise := join.Advertise{HTTPAddr: cfg.AdvertiseHTTP, OnPeers: apiServer.AddPeerHTTP}
 	if !cfg.Bootstrap {
 		appLog.Printf("Starting auto-join process for node %s", cfg.NodeID)
-		go joinCluster(cfg.NodeID, cfg.RaftAddr, 2*time.Second, 0)
+		go join.Cluster(cfg.NodeID, cfg.RaftAdvertise, seeds, 2*time.Second, 0, advertise)
 	} else {
 		appLog.Printf("Node %s is configured as bootstrap node", cfg.NodeID)
 	}
+	if cfg.RejoinInterval > 0 {
+		stop := join.StartRejoin(cfg.NodeID, cfg.RaftAdvertise, seeds, join.RejoinOptions{Interval: cfg.RejoinInterval, Advertise: advertise})
+		defer stop()
+	}
 
-	mux := http.NewServeMux()
-	api.New(node, store).WithBarrierTimeout(cfg.BarrierTimeout).Register(mux)
-	appLog.Printf("conure-db running: http=%s raft=%s id=%s", cfg.HTTPAddr, cfg.RaftAddr, cfg.NodeID)
-	fmt.Println("Endpoints: /kv (GET, PUT, DELETE), /join (POST), /remove (POST), /status (GET), /raft/config, /raft/stats")
-	if err := http.ListenAndServe(cfg.HTTPAddr, mux); err != nil {
+	if cfg.RESPAddr != "" {
+		// RESP has no bearer tokens, so it would bypass the ACLs
+		if len(cfg.ACL) > 0 {
+			appLog.Fatalf("config: resp_addr cannot be used with acl")
+		}
+		respServer := resp.New(node, store).
+			WithBarrierTimeout(cfg.BarrierTimeout).
+			WithMaxScanCount(cfg.MaxScanResults)
+		go func() {
+			if err := respServer.ListenAndServe(cfg.RESPAddr); err != nil {
+				appLog.Fatalf("resp: %v", err)
+			}
+		}()
+		defer func() {
+			if closeErr := respServer.Close(); closeErr != nil {
+				appLog.Printf("Warning: failed to close RESP listener: %v", closeErr)
+			}
+		}()
+		appLog.Printf("RESP listening on %s", cfg.RESPAddr)
+	}
+	appLog.Printf("conure-db running: http=%s raft=%s (advertise %s) id=%s", cfg.HTTPAddr, cfg.RaftAddr, cfg.RaftAdvertise, cfg.NodeID)
+	fmt.Println("Endpoints: /kv (GET, PUT, DELETE), /kv/pipeline (POST), /kv/next (GET), /kv/prev (GET), /scan (GET), /catalog (GET), /buckets (GET, POST), /txn (POST), /lease (GET), /lease/acquire, /lease/renew, /lease/release (POST), /join (POST), /remove (POST), /leave (POST), /status (GET), /version (GET), /stats (GET), /compact (POST), /verify (POST), /raft/config, /raft/stats, /metrics, /debug/hotkeys, /admin/config (GET, POST), /admin/ops (GET), /admin/dropcache (POST), /admin/replace (POST), /admin/import (POST), /healthz (GET)")
+	srv := api.NewHTTPServer(cfg.HTTPAddr, mux, api.HTTPOptions{
+		ReadTimeout:    cfg.HTTPReadTimeout,
+		WriteTimeout:   cfg.HTTPWriteTimeout,
+		IdleTimeout:    cfg.HTTPIdleTimeout,
+		MaxHeaderBytes: cfg.HTTPMaxHeaderBytes,
+	})
+	if err := srv.ListenAndServe(); err != nil {
 		appLog.Fatalf("http: %v", err)
 	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// runResetRaftCommand runs the reset-raft subcommand: with the node stopped,
+// it moves the raft state under --data-dir aside, keeping the database, so
+// the node joins its cluster again as a fresh member when next started
+// without --bootstrap. --confirm must repeat the data directory, so a reset
+// is never run against the wrong node by accident.
+func runResetRaftCommand(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("reset-raft", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	dataDir := fs.String("data-dir", "", "data directory of the stopped node")
+	confirm := fs.String("confirm", "", "the data directory again, to confirm the reset")
+	fs.Usage = func() {
+		fmt.Fprintln(errOut, "usage: conure-db reset-raft --data-dir dir --confirm dir")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dataDir == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if filepath.Clean(*confirm) != filepath.Clean(*dataDir) {
+		fmt.Fprintf(errOut, "reset-raft: refusing to reset %s without --confirm %s\n", *dataDir, *dataDir)
+		return 2
+	}
+
+	aside, err := raftnode.ResetState(*dataDir, "")
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(errOut, "reset-raft: no raft state in %s\n", *dataDir)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "reset-raft: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "raft state moved to %s; the database was kept\n", aside)
+	fmt.Fprintln(out, "start the node without --bootstrap to join the cluster as a new member")
+	return 0
+}
@@ -13,8 +13,48 @@ type CLIOverrides struct {
 	DataDir        string
 	RaftAddr       string
 	HTTPAddr       string
+	AdvertiseHTTP  string
+	RESPAddr       string
 	Bootstrap      *bool
 	BarrierTimeout *time.Duration
+	MaxScanResults int
+	MaxTxnOps      int
+	MaxTxnBytes    int64
+	LagAlert       uint64
+	RaftAdvertise  string
+	RaftMaxPool    int
+	RaftTimeout    *time.Duration
+	TrackHotKeys   *bool
+	HTTPRead       *time.Duration
+	HTTPWrite      *time.Duration
+	HTTPIdle       *time.Duration
+	HTTPMaxHeader  int
+	Heartbeat      *time.Duration
+	Election       *time.Duration
+	LeaderLease    *time.Duration
+	Commit         *time.Duration
+	RaftMaxAppend  int
+	MaxCommand     int
+	RaftBatchApply *bool
+	AccessLog      *bool
+	AccessRedact   *bool
+	MinFreeDisk    uint64
+	SnapCompress   string
+	AllowMigrate   *bool
+	EncryptKeyFile string
+	BackupEvery    *time.Duration
+	BackupDest     string
+	BackupRetain   int
+	WaitForLeader  *bool
+	StartupTimeout *time.Duration
+	RejoinInterval *time.Duration
+	DegradedReads  *bool
+	ScrubInterval  *time.Duration
+	ScrubRate      int
+	MaxDirtyPages  int
+	DeadNodeAfter  *time.Duration
+	RemoveDead     *bool
+	RestoreInPlace *bool
 }
 
 func mergeConfig(fileCfg config.Config, cli CLIOverrides) config.Config {
@@ -33,12 +73,132 @@ func mergeConfig(fileCfg config.Config, cli CLIOverrides) config.Config {
 	if cli.HTTPAddr != "" {
 		cfg.HTTPAddr = cli.HTTPAddr
 	}
+	if cli.AdvertiseHTTP != "" {
+		cfg.AdvertiseHTTP = cli.AdvertiseHTTP
+	}
+	if cli.RESPAddr != "" {
+		cfg.RESPAddr = cli.RESPAddr
+	}
 	if cli.Bootstrap != nil {
 		cfg.Bootstrap = *cli.Bootstrap
 	}
 	if cli.BarrierTimeout != nil {
 		cfg.BarrierTimeout = *cli.BarrierTimeout
 	}
+	if cli.MaxScanResults > 0 {
+		cfg.MaxScanResults = cli.MaxScanResults
+	}
+	if cli.LagAlert > 0 {
+		cfg.LagAlertThreshold = cli.LagAlert
+	}
+	if cli.RaftAdvertise != "" {
+		cfg.RaftAdvertise = cli.RaftAdvertise
+	}
+	if cli.RaftMaxPool > 0 {
+		cfg.RaftMaxPool = cli.RaftMaxPool
+	}
+	if cli.RaftTimeout != nil {
+		cfg.RaftTimeout = *cli.RaftTimeout
+	}
+	if cli.TrackHotKeys != nil {
+		cfg.TrackHotKeys = *cli.TrackHotKeys
+	}
+	if cli.HTTPRead != nil {
+		cfg.HTTPReadTimeout = *cli.HTTPRead
+	}
+	if cli.HTTPWrite != nil {
+		cfg.HTTPWriteTimeout = *cli.HTTPWrite
+	}
+	if cli.HTTPIdle != nil {
+		cfg.HTTPIdleTimeout = *cli.HTTPIdle
+	}
+	if cli.HTTPMaxHeader > 0 {
+		cfg.HTTPMaxHeaderBytes = cli.HTTPMaxHeader
+	}
+	if cli.Heartbeat != nil {
+		cfg.HeartbeatTimeout = *cli.Heartbeat
+	}
+	if cli.Election != nil {
+		cfg.ElectionTimeout = *cli.Election
+	}
+	if cli.LeaderLease != nil {
+		cfg.LeaderLeaseTimeout = *cli.LeaderLease
+	}
+	if cli.Commit != nil {
+		cfg.CommitTimeout = *cli.Commit
+	}
+	if cli.RaftMaxAppend > 0 {
+		cfg.RaftMaxAppend = cli.RaftMaxAppend
+	}
+	if cli.MaxCommand > 0 {
+		cfg.MaxCommandBytes = cli.MaxCommand
+	}
+	if cli.RaftBatchApply != nil {
+		cfg.RaftBatchApply = *cli.RaftBatchApply
+	}
+	if cli.AccessLog != nil {
+		cfg.AccessLog = *cli.AccessLog
+	}
+	if cli.AccessRedact != nil {
+		cfg.AccessLogRedact = *cli.AccessRedact
+	}
+	if cli.MaxTxnOps > 0 {
+		cfg.MaxTxnOps = cli.MaxTxnOps
+	}
+	if cli.MaxTxnBytes > 0 {
+		cfg.MaxTxnBytes = cli.MaxTxnBytes
+	}
+	if cli.MinFreeDisk > 0 {
+		cfg.MinFreeDiskBytes = cli.MinFreeDisk
+	}
+	if cli.SnapCompress != "" {
+		cfg.SnapshotCompress = cli.SnapCompress
+	}
+	if cli.AllowMigrate != nil {
+		cfg.AllowMigration = *cli.AllowMigrate
+	}
+	if cli.EncryptKeyFile != "" {
+		cfg.EncryptionKeyFile = cli.EncryptKeyFile
+	}
+	if cli.BackupEvery != nil {
+		cfg.Backup.Interval = *cli.BackupEvery
+	}
+	if cli.BackupDest != "" {
+		cfg.Backup.Destination = cli.BackupDest
+	}
+	if cli.BackupRetain > 0 {
+		cfg.Backup.Retain = cli.BackupRetain
+	}
+	if cli.WaitForLeader != nil {
+		cfg.WaitForLeader = *cli.WaitForLeader
+	}
+	if cli.StartupTimeout != nil {
+		cfg.StartupTimeout = *cli.StartupTimeout
+	}
+	if cli.RejoinInterval != nil {
+		cfg.RejoinInterval = *cli.RejoinInterval
+	}
+	if cli.DegradedReads != nil {
+		cfg.DegradedReads = *cli.DegradedReads
+	}
+	if cli.ScrubInterval != nil {
+		cfg.ScrubInterval = *cli.ScrubInterval
+	}
+	if cli.ScrubRate > 0 {
+		cfg.ScrubPagesPerSec = cli.ScrubRate
+	}
+	if cli.MaxDirtyPages > 0 {
+		cfg.MaxDirtyPages = cli.MaxDirtyPages
+	}
+	if cli.DeadNodeAfter != nil {
+		cfg.DeadNodeTimeout = *cli.DeadNodeAfter
+	}
+	if cli.RemoveDead != nil {
+		cfg.RemoveDeadNodes = *cli.RemoveDead
+	}
+	if cli.RestoreInPlace != nil {
+		cfg.RestoreInPlace = *cli.RestoreInPlace
+	}
 
 	// Defaults for any still-empty values
 	if cfg.NodeID == "" {
@@ -56,6 +216,33 @@ func mergeConfig(fileCfg config.Config, cli CLIOverrides) config.Config {
 	if cfg.BarrierTimeout == 0 {
 		cfg.BarrierTimeout = 3 * time.Second
 	}
+	if cfg.MaxScanResults <= 0 {
+		cfg.MaxScanResults = 1000
+	}
+	if cfg.RaftAdvertise == "" {
+		cfg.RaftAdvertise = cfg.RaftAddr
+	}
+	if cfg.RaftMaxPool <= 0 {
+		cfg.RaftMaxPool = 3
+	}
+	if cfg.RaftTimeout == 0 {
+		cfg.RaftTimeout = 10 * time.Second
+	}
+	if cfg.HTTPReadTimeout == 0 {
+		cfg.HTTPReadTimeout = 30 * time.Second
+	}
+	if cfg.HTTPWriteTimeout == 0 {
+		cfg.HTTPWriteTimeout = 30 * time.Second
+	}
+	if cfg.HTTPIdleTimeout == 0 {
+		cfg.HTTPIdleTimeout = 2 * time.Minute
+	}
+	if cfg.HTTPMaxHeaderBytes <= 0 {
+		cfg.HTTPMaxHeaderBytes = 64 << 10
+	}
+	if cfg.ScrubPagesPerSec <= 0 {
+		cfg.ScrubPagesPerSec = 100
+	}
 
 	return cfg
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// outputFormat is how get prints a value
+type outputFormat string
+
+const (
+	// formatEscaped prints printable characters as they are and every other
+	// byte as \xNN, so binary values cannot garble the terminal
+	formatEscaped outputFormat = "escaped"
+	// formatRaw prints the value's bytes unchanged
+	formatRaw outputFormat = "raw"
+	// formatHex prints the value as lowercase hex
+	formatHex outputFormat = "hex"
+	// formatJSON prints the value as a JSON string; bytes that are not
+	// valid UTF-8 become U+FFFD
+	formatJSON outputFormat = "json"
+)
+
+// parseFormat checks s names an output format
+func parseFormat(s string) (outputFormat, error) {
+	switch f := outputFormat(s); f {
+	case formatEscaped, formatRaw, formatHex, formatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q: want escaped, raw, hex or json", s)
+}
+
+// formatValue renders val for display in format f
+func formatValue(val string, f outputFormat) string {
+	switch f {
+	case formatRaw:
+		return val
+	case formatHex:
+		return hex.EncodeToString([]byte(val))
+	case formatJSON:
+		b, _ := json.Marshal(val)
+		return string(b)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(val); {
+		r, size := utf8.DecodeRuneInString(val[i:])
+		switch {
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r != utf8.RuneError && unicode.IsPrint(r):
+			sb.WriteRune(r)
+		default:
+			for _, c := range []byte(val[i : i+size]) {
+				fmt.Fprintf(&sb, `\x%02x`, c)
+			}
+		}
+		i += size
+	}
+	return sb.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"container/list"
+	"net/url"
+	"sync"
+)
+
+// defaultMaxLeaders bounds the leader cache when MaxLeaders is unset
+const defaultMaxLeaders = 64
+
+// leaderCache remembers the leader found for each cluster, keyed by the
+// cluster's configured base URL. Beyond its bound it forgets the least
+// recently used cluster, which then starts again from its base URL. The
+// zero value is an empty cache, safe for concurrent use.
+type leaderCache struct {
+	mu      sync.Mutex
+	order   *list.List // of *leaderEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type leaderEntry struct {
+	cluster string
+	leader  *url.URL
+}
+
+// get returns the cached leader of cluster, if any
+func (c *leaderCache) get(cluster string) (*url.URL, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[cluster]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*leaderEntry).leader, true
+}
+
+// put records leader for cluster, evicting down to max entries
+func (c *leaderCache) put(cluster string, leader *url.URL, max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.order = list.New()
+	}
+	if e, ok := c.entries[cluster]; ok {
+		e.Value.(*leaderEntry).leader = leader
+		c.order.MoveToFront(e)
+	} else {
+		c.entries[cluster] = c.order.PushFront(&leaderEntry{cluster: cluster, leader: leader})
+	}
+	for c.order.Len() > max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*leaderEntry).cluster)
+	}
+}
+
+// forget drops cluster's leader, as when it stops answering
+func (c *leaderCache) forget(cluster string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[cluster]; ok {
+		c.order.Remove(e)
+		delete(c.entries, cluster)
+	}
+}
+
+// len returns how many clusters have a cached leader
+func (c *leaderCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
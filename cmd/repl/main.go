@@ -3,14 +3,74 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strings"
 )
 
+// routeFlags collects repeated --route flags
+type routeFlags []Route
+
+func (r *routeFlags) String() string {
+	parts := make([]string, len(*r))
+	for i, route := range *r {
+		parts[i] = route.Start + "=" + route.Base.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *routeFlags) Set(s string) error {
+	route, err := ParseRoute(s)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, route)
+	return nil
+}
+
 func main() {
 	var serverFlag = flag.String("server", "http://127.0.0.1:8081", "HTTP base URL for the server (replicated mode)")
+	var redirectsFlag = flag.Int("max-redirects", 8, "leader redirects to follow before giving up")
+	var balanceFlag = flag.Bool("balance-reads", false, "spread get over the followers as stale reads; writes still go to the leader")
+	var scriptFlag = flag.String("script", "", "run the commands in this file, one per line, then exit; non-zero status on the first failure")
+	var keepGoingFlag = flag.Bool("k", false, "with --script, run every command and exit non-zero at the end if any failed")
+	var formatFlag = flag.String("format", string(formatEscaped), "how get prints values: escaped (non-printable bytes as \\xNN), raw, hex or json")
+	var routes routeFlags
+	flag.Var(&routes, "route", "start=url: send keys from start up to the next route's start to the cluster at url (repeatable); keys before every route go to --server")
 	flag.Parse()
 
+	client, err := newRemoteClient(*serverFlag, *redirectsFlag, routes, *balanceFlag, *formatFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *scriptFlag != "" {
+		os.Exit(runScriptFile(client, *scriptFlag, *keepGoingFlag))
+	}
+
 	fmt.Println("Conure DB - B-tree based key-value store with copy-on-write")
 	fmt.Println("Type 'help' for available commands")
 	fmt.Printf("Using remote server: %s\n", *serverFlag)
-	runRemoteREPL(*serverFlag)
+	for _, route := range routes {
+		fmt.Printf("Routing keys from %q to %s\n", route.Start, route.Base)
+	}
+	runRemoteREPL(client)
+}
+
+// runScriptFile runs --script and returns the process exit status
+func runScriptFile(client *RemoteClient, path string, keepGoing bool) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close script: %v\n", closeErr)
+		}
+	}()
+	if err := runScript(client, f, os.Stdout, os.Stderr, keepGoing); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
 }
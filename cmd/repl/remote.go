@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,23 +9,114 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/chzyer/readline"
 )
 
 type leaderHint struct {
 	Leader string `json:"leader"`
+	// LeaderHTTP is the leader's advertised HTTP API, when the node knows it
+	LeaderHTTP string `json:"leader_http"`
 }
 
-// RemoteClient talks to the HTTP API and follows leader redirects.
+const (
+	defaultMaxRedirects    = 8
+	defaultRedirectBackoff = 50 * time.Millisecond
+	maxRedirectBackoff     = 2 * time.Second
+)
+
+// errNoStableLeader is returned when redirects never settle on a leader.
+var errNoStableLeader = errors.New("no stable leader")
+
+// errNoLeader is returned when the cluster still knows no leader after the
+// last retry
+var errNoLeader = errors.New("no leader")
+
+// Route sends the keys from Start up to the next route's Start to the
+// cluster at Base
+type Route struct {
+	Start string
+	Base  *url.URL
+}
+
+// RemoteClient talks to the HTTP API and follows leader redirects. It is
+// safe for concurrent use once configured: Base and Routes are never
+// modified, and the leaders redirects lead to are cached per cluster.
+// Format is changed only by the format command, from the shell's loop.
 type RemoteClient struct {
 	HTTP *http.Client
 	Base *url.URL
+
+	// Routes optionally splits the key space across clusters, sorted by
+	// Start. A key goes to the last route starting at or before it; keys
+	// before the first route, or all keys without routes, go to Base.
+	// Redirects are followed within the cluster a key was sent to.
+	Routes []Route
+
+	// BalanceReads sends Get to the followers listed by the cluster's
+	// /cluster endpoint in turn, as stale reads that may lag the leader.
+	// Writes go to the leader, as do reads when no follower answers.
+	BalanceReads bool
+
+	// mu guards followers and nextFollower
+	mu sync.Mutex
+	// followers caches each cluster's follower URLs, keyed by its base URL
+	followers    map[string][]string
+	nextFollower int
+
+	// leaders caches each cluster's leader, keyed by its base URL
+	leaders leaderCache
+	// MaxLeaders bounds how many clusters' leaders are cached; the least
+	// recently used beyond it are found again by redirect
+	MaxLeaders int
+
+	// MaxRedirects bounds how many leader redirects one call follows
+	MaxRedirects int
+	// RedirectBackoff is the pause before following a redirect. It doubles
+	// each time a leader hint repeats, as happens while an election settles.
+	RedirectBackoff time.Duration
+
+	// Format is how get prints values; empty means formatEscaped
+	Format outputFormat
+}
+
+// ParseRoute parses a route given as start=url
+func ParseRoute(s string) (Route, error) {
+	start, raw, ok := strings.Cut(s, "=")
+	if !ok {
+		return Route{}, fmt.Errorf("route %q is not start=url", s)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Route{}, fmt.Errorf("route %q: %v", s, err)
+	}
+	return Route{Start: start, Base: u}, nil
+}
+
+// baseFor returns the configured base URL of the cluster that serves key
+func (rc *RemoteClient) baseFor(key string) *url.URL {
+	i := sort.Search(len(rc.Routes), func(i int) bool { return rc.Routes[i].Start > key })
+	if i == 0 {
+		return rc.Base
+	}
+	return rc.Routes[i-1].Base
 }
 
-func (rc *RemoteClient) do(method, path string, q url.Values, body io.Reader) (*http.Response, error) {
-	u := *rc.Base
+// leaderFor returns the cached leader of the cluster at base, or base itself
+func (rc *RemoteClient) leaderFor(base *url.URL) *url.URL {
+	if leader, ok := rc.leaders.get(base.String()); ok {
+		return leader
+	}
+	return base
+}
+
+func (rc *RemoteClient) do(base *url.URL, method, path string, q url.Values, body io.Reader) (*http.Response, error) {
+	u := *base
 	u.Path = path
 	u.RawQuery = q.Encode()
 	req, err := http.NewRequest(method, u.String(), body)
@@ -34,103 +126,257 @@ func (rc *RemoteClient) do(method, path string, q url.Values, body io.Reader) (*
 	return rc.HTTP.Do(req)
 }
 
-func (rc *RemoteClient) withLeader(h leaderHint) {
+// withLeader returns base pointed at the hinted leader: its advertised HTTP
+// address if the hint has one, else the host of its raft address with
+// base's port
+func withLeader(base *url.URL, h leaderHint) *url.URL {
+	if h.LeaderHTTP != "" {
+		if u, err := url.Parse(h.LeaderHTTP); err == nil && u.Host != "" {
+			b := *base
+			b.Scheme, b.Host = u.Scheme, u.Host
+			return &b
+		}
+	}
 	if h.Leader == "" {
-		return
+		return base
 	}
 	leaderHost := h.Leader
 	if h, _, ok := strings.Cut(leaderHost, ":"); ok {
 		leaderHost = h
 	}
-	port := rc.Base.Port()
+	port := base.Port()
 	if port == "" {
 		port = "8081"
 	}
-	b := *rc.Base
+	b := *base
 	b.Host = leaderHost + ":" + port
-	rc.Base = &b
+	return &b
 }
 
-func (rc *RemoteClient) Get(key string) (string, error) {
-	for retries := 0; retries < 3; retries++ {
-		q := url.Values{"key": {key}}
-		resp, err := rc.do(http.MethodGet, "/kv", q, nil)
+// sendToLeader issues a request for key to the cluster that serves it,
+// following 409 leader hints with a backoff that grows while the same hints
+// keep coming back. A 503 with Retry-After, sent while the cluster has no
+// leader, is retried from the cluster's base URL after the delay it asks
+// for. It returns the response body of the first 200, and caches the node
+// that sent it as the cluster's leader.
+func (rc *RemoteClient) sendToLeader(method, key string, body *string) (string, error) {
+	cluster := rc.baseFor(key)
+	base := rc.leaderFor(cluster)
+	q := url.Values{"key": {key}}
+	maxRedirects := rc.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	backoff := rc.RedirectBackoff
+	if backoff <= 0 {
+		backoff = defaultRedirectBackoff
+	}
+
+	seen := make(map[string]int)
+	for attempt := 0; ; attempt++ {
+		// Rebuild the body each attempt; a reader is drained by the first send
+		var r io.Reader
+		if body != nil {
+			r = strings.NewReader(*body)
+		}
+		resp, err := rc.do(base, method, "/kv", q, r)
 		if err != nil {
+			// The leader may be gone; start from the base URL next time
+			rc.leaders.forget(cluster.String())
 			return "", err
 		}
-		defer func() {
-			if closeErr := resp.Body.Close(); closeErr != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to close response body in Get: %v\n", closeErr)
+		b, _ := io.ReadAll(resp.Body)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close response body: %v\n", closeErr)
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			maxLeaders := rc.MaxLeaders
+			if maxLeaders <= 0 {
+				maxLeaders = defaultMaxLeaders
 			}
-		}()
-		if resp.StatusCode == http.StatusOK {
-			b, _ := io.ReadAll(resp.Body)
+			rc.leaders.put(cluster.String(), base, maxLeaders)
 			return strings.TrimSuffix(string(b), "\n"), nil
-		}
-		if resp.StatusCode == http.StatusConflict {
-			var h leaderHint
-			_ = json.NewDecoder(resp.Body).Decode(&h)
-			rc.withLeader(h)
+		case http.StatusConflict:
+		case http.StatusServiceUnavailable:
+			retryAfter := resp.Header.Get("Retry-After")
+			if retryAfter == "" {
+				return "", errors.New(strings.TrimSpace(string(b)))
+			}
+			if attempt+1 >= maxRedirects {
+				return "", fmt.Errorf("%w after %d attempts", errNoLeader, maxRedirects)
+			}
+			// No node to redirect to yet; wait for an election and ask
+			// the cluster afresh, since a cached leader may be the one gone
+			rc.leaders.forget(cluster.String())
+			time.Sleep(retryDelay(retryAfter, backoff))
+			base = cluster
 			continue
+		default:
+			return "", errors.New(strings.TrimSpace(string(b)))
 		}
-		b, _ := io.ReadAll(resp.Body)
-		return "", errors.New(strings.TrimSpace(string(b)))
+
+		if attempt+1 >= maxRedirects {
+			return "", fmt.Errorf("%w after %d redirects", errNoStableLeader, maxRedirects)
+		}
+
+		var h leaderHint
+		_ = json.Unmarshal(b, &h)
+		// A repeated hint means nodes are pointing at each other; wait longer
+		// for the election to settle instead of hammering them
+		seen[h.Leader]++
+		delay := backoff << (seen[h.Leader] - 1)
+		if delay > maxRedirectBackoff || delay <= 0 {
+			delay = maxRedirectBackoff
+		}
+		time.Sleep(delay)
+		base = withLeader(base, h)
 	}
-	return "", fmt.Errorf("leader redirect loop")
 }
 
-func (rc *RemoteClient) Put(key, value string) error {
-	for retries := 0; retries < 3; retries++ {
-		q := url.Values{"key": {key}}
-		resp, err := rc.do(http.MethodPut, "/kv", q, strings.NewReader(value))
-		if err != nil {
-			return err
+// retryDelay returns the wait a Retry-After of whole seconds asks for, capped
+// at maxRedirectBackoff, or fallback when it is zero or not a number
+func retryDelay(retryAfter string, fallback time.Duration) time.Duration {
+	secs, err := strconv.Atoi(retryAfter)
+	if err != nil || secs <= 0 {
+		return fallback
+	}
+	if d := time.Duration(secs) * time.Second; d < maxRedirectBackoff {
+		return d
+	}
+	return maxRedirectBackoff
+}
+
+func (rc *RemoteClient) Get(key string) (string, error) {
+	if rc.BalanceReads {
+		if value, ok, err := rc.followerGet(key); ok {
+			return value, err
 		}
-		defer func() {
-			if closeErr := resp.Body.Close(); closeErr != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to close response body in Put: %v\n", closeErr)
-			}
-		}()
-		if resp.StatusCode == http.StatusOK {
-			return nil
-		}
-		if resp.StatusCode == http.StatusConflict {
-			var h leaderHint
-			_ = json.NewDecoder(resp.Body).Decode(&h)
-			rc.withLeader(h)
-			continue
+	}
+	return rc.sendToLeader(http.MethodGet, key, nil)
+}
+
+// followerGet reads key from the next follower of its cluster. It reports
+// false, to fall back to the leader, when there is no follower or the one
+// tried did not answer; that follower list is then fetched afresh.
+func (rc *RemoteClient) followerGet(key string) (string, bool, error) {
+	base := rc.baseFor(key)
+	cluster := base.String()
+	rc.mu.Lock()
+	followers, ok := rc.followers[cluster]
+	rc.mu.Unlock()
+	if !ok {
+		var err error
+		if followers, err = rc.fetchFollowers(rc.leaderFor(base)); err != nil {
+			return "", false, nil
+		}
+		rc.mu.Lock()
+		if rc.followers == nil {
+			rc.followers = make(map[string][]string)
+		}
+		rc.followers[cluster] = followers
+		rc.mu.Unlock()
+	}
+	if len(followers) == 0 {
+		return "", false, nil
+	}
+	rc.mu.Lock()
+	target := followers[rc.nextFollower%len(followers)]
+	rc.nextFollower++
+	rc.mu.Unlock()
+
+	u, err := url.Parse(target)
+	if err != nil {
+		rc.forgetFollowers(cluster)
+		return "", false, nil
+	}
+	resp, err := rc.do(u, http.MethodGet, "/kv", url.Values{"key": {key}, "stale": {"true"}}, nil)
+	if err != nil {
+		rc.forgetFollowers(cluster)
+		return "", false, nil
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to close response body: %v\n", closeErr)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return strings.TrimSuffix(string(b), "\n"), true, nil
+	case http.StatusNotFound:
+		return "", true, errors.New(strings.TrimSpace(string(b)))
+	}
+	rc.forgetFollowers(cluster)
+	return "", false, nil
+}
+
+// forgetFollowers drops the cached follower list of cluster, so the next
+// read fetches it afresh
+func (rc *RemoteClient) forgetFollowers(cluster string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.followers, cluster)
+}
+
+// fetchFollowers lists the HTTP addresses of the followers in /cluster
+func (rc *RemoteClient) fetchFollowers(base *url.URL) ([]string, error) {
+	resp, err := rc.do(base, http.MethodGet, "/cluster", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close response body: %v\n", closeErr)
 		}
+	}()
+	if resp.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(resp.Body)
-		return errors.New(strings.TrimSpace(string(b)))
+		return nil, errors.New(strings.TrimSpace(string(b)))
+	}
+	var cluster struct {
+		Members []struct {
+			HTTPAddress string `json:"http_address"`
+			Leader      bool   `json:"leader"`
+		} `json:"members"`
 	}
-	return fmt.Errorf("leader redirect loop")
+	if err := json.NewDecoder(resp.Body).Decode(&cluster); err != nil {
+		return nil, err
+	}
+	followers := []string{}
+	for _, m := range cluster.Members {
+		if !m.Leader && m.HTTPAddress != "" {
+			followers = append(followers, m.HTTPAddress)
+		}
+	}
+	return followers, nil
+}
+
+func (rc *RemoteClient) Put(key, value string) error {
+	_, err := rc.sendToLeader(http.MethodPut, key, &value)
+	return err
 }
 
 func (rc *RemoteClient) Delete(key string) error {
-	for retries := 0; retries < 3; retries++ {
-		q := url.Values{"key": {key}}
-		resp, err := rc.do(http.MethodDelete, "/kv", q, nil)
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if closeErr := resp.Body.Close(); closeErr != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to close response body in Delete: %v\n", closeErr)
-			}
-		}()
-		if resp.StatusCode == http.StatusOK {
-			return nil
-		}
-		if resp.StatusCode == http.StatusConflict {
-			var h leaderHint
-			_ = json.NewDecoder(resp.Body).Decode(&h)
-			rc.withLeader(h)
-			continue
+	_, err := rc.sendToLeader(http.MethodDelete, key, nil)
+	return err
+}
+
+// Compact asks the connected node to compact its local database file.
+func (rc *RemoteClient) Compact() (string, error) {
+	resp, err := rc.do(rc.Base, http.MethodPost, "/compact", nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close response body in Compact: %v\n", closeErr)
 		}
-		b, _ := io.ReadAll(resp.Body)
-		return errors.New(strings.TrimSpace(string(b)))
+	}()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(strings.TrimSpace(string(b)))
 	}
-	return fmt.Errorf("leader redirect loop")
+	return strings.TrimSpace(string(b)), nil
 }
 
 // completer provides auto-completion for REPL commands
@@ -139,19 +385,114 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("get"),
 	readline.PcItem("put"),
 	readline.PcItem("delete"),
+	readline.PcItem("compact"),
+	readline.PcItem("format",
+		readline.PcItem(string(formatEscaped)),
+		readline.PcItem(string(formatRaw)),
+		readline.PcItem(string(formatHex)),
+		readline.PcItem(string(formatJSON)),
+	),
 	readline.PcItem("exit"),
 	readline.PcItem("quit"),
 )
 
-func runRemoteREPL(base string) {
-	client := &RemoteClient{HTTP: &http.Client{}}
+// newRemoteClient builds the client for --server and its options
+func newRemoteClient(base string, maxRedirects int, routes []Route, balanceReads bool, format string) (*RemoteClient, error) {
 	u, err := url.Parse(base)
 	if err != nil {
-		fmt.Printf("Invalid --server URL: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("invalid --server URL: %v", err)
+	}
+	f, err := parseFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format: %v", err)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Start < routes[j].Start })
+	return &RemoteClient{HTTP: &http.Client{}, Base: u, MaxRedirects: maxRedirects, Routes: routes, BalanceReads: balanceReads, Format: f}, nil
+}
+
+var (
+	// errExit is returned by runCommand for exit and quit
+	errExit = errors.New("exit")
+
+	errUnknownCommand = errors.New("unknown command")
+)
+
+// usageError is a command given the wrong arguments
+type usageError string
+
+func (e usageError) Error() string {
+	return "usage: " + string(e)
+}
+
+// runCommand executes one command line, writing its output to out. Usage
+// mistakes and failed requests are returned as errors.
+func runCommand(client *RemoteClient, line string, out io.Writer) error {
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		return nil
+	}
+
+	switch parts[0] {
+	case "help":
+		printHelp(out)
+	case "get":
+		if len(parts) != 2 {
+			return usageError("get <key>")
+		}
+		val, err := client.Get(parts[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, formatValue(val, client.Format))
+	case "format":
+		switch len(parts) {
+		case 1:
+			f := client.Format
+			if f == "" {
+				f = formatEscaped
+			}
+			fmt.Fprintln(out, f)
+		case 2:
+			f, err := parseFormat(parts[1])
+			if err != nil {
+				return err
+			}
+			client.Format = f
+			fmt.Fprintln(out, "OK")
+		default:
+			return usageError("format [escaped|raw|hex|json]")
+		}
+	case "put":
+		if len(parts) < 3 {
+			return usageError("put <key> <value>")
+		}
+		if err := client.Put(parts[1], strings.Join(parts[2:], " ")); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "OK")
+	case "delete":
+		if len(parts) != 2 {
+			return usageError("delete <key>")
+		}
+		if err := client.Delete(parts[1]); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "OK")
+	case "compact":
+		res, err := client.Compact()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, res)
+	case "exit", "quit":
+		return errExit
+	default:
+		return fmt.Errorf("%w: %s", errUnknownCommand, parts[0])
 	}
-	client.Base = u
+	return nil
+}
 
+func runRemoteREPL(client *RemoteClient) {
 	// Configure readline with history and completion
 	rl, err := readline.NewEx(&readline.Config{
 		Prompt:          "> ",
@@ -176,65 +517,65 @@ func runRemoteREPL(base string) {
 			break
 		}
 
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+		var usage usageError
+		switch err := runCommand(client, line, os.Stdout); {
+		case errors.Is(err, errExit):
+			fmt.Println("Goodbye!")
+			return
+		case errors.As(err, &usage):
+			fmt.Printf("Usage: %s\n", string(usage))
+		case errors.Is(err, errUnknownCommand):
+			fmt.Printf("Unknown command: %s\n", strings.Fields(line)[0])
+			printHelp(os.Stdout)
+		case err != nil:
+			fmt.Printf("Error: %v\n", err)
 		}
+	}
+}
 
-		parts := strings.Fields(line)
-		if len(parts) == 0 {
+// runScript executes the commands in r one line at a time, skipping blank
+// lines and # comments, and stops at exit or quit. It returns the first
+// failure, naming its line, unless keepGoing is set, in which case it
+// reports each failure to errOut, runs the rest, and returns an error
+// counting them.
+func runScript(client *RemoteClient, r io.Reader, out, errOut io.Writer, keepGoing bool) error {
+	scanner := bufio.NewScanner(r)
+	failed := 0
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-
-		switch parts[0] {
-		case "help":
-			printHelp()
-		case "get":
-			if len(parts) != 2 {
-				fmt.Println("Usage: get <key>")
-				continue
-			}
-			val, err := client.Get(parts[1])
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-				continue
-			}
-			fmt.Printf("%s\n", val)
-		case "put":
-			if len(parts) < 3 {
-				fmt.Println("Usage: put <key> <value>")
-				continue
-			}
-			if err := client.Put(parts[1], strings.Join(parts[2:], " ")); err != nil {
-				fmt.Printf("Error: %v\n", err)
-				continue
-			}
-			fmt.Println("OK")
-		case "delete":
-			if len(parts) != 2 {
-				fmt.Println("Usage: delete <key>")
-				continue
-			}
-			if err := client.Delete(parts[1]); err != nil {
-				fmt.Printf("Error: %v\n", err)
-				continue
-			}
-			fmt.Println("OK")
-		case "exit", "quit":
-			fmt.Println("Goodbye!")
-			return
-		default:
-			fmt.Printf("Unknown command: %s\n", parts[0])
-			printHelp()
+		err := runCommand(client, line, out)
+		if errors.Is(err, errExit) {
+			break
+		}
+		if err == nil {
+			continue
 		}
+		err = fmt.Errorf("line %d: %s: %w", n, line, err)
+		if !keepGoing {
+			return err
+		}
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		failed++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d commands failed", failed)
 	}
+	return nil
 }
 
-func printHelp() {
-	fmt.Println("Available commands:")
-	fmt.Println("  get <key>              - Get a value (leader, linearizable)")
-	fmt.Println("  put <key> <value>      - Put a key-value pair (replicated)")
-	fmt.Println("  delete <key>           - Delete a key (replicated)")
-	fmt.Println("  help                   - Show this help message")
-	fmt.Println("  exit, quit             - Exit the program")
+func printHelp(out io.Writer) {
+	fmt.Fprintln(out, "Available commands:")
+	fmt.Fprintln(out, "  get <key>              - Get a value (leader, linearizable; a follower, stale, with --balance-reads)")
+	fmt.Fprintln(out, "  put <key> <value>      - Put a key-value pair (replicated)")
+	fmt.Fprintln(out, "  delete <key>           - Delete a key (replicated)")
+	fmt.Fprintln(out, "  compact                - Compact the connected node's database file")
+	fmt.Fprintln(out, "  format [<format>]      - Show or set how get prints values: escaped (default), raw, hex or json")
+	fmt.Fprintln(out, "  help                   - Show this help message")
+	fmt.Fprintln(out, "  exit, quit             - Exit the program")
 }
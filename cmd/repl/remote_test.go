@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRedirectFlipFlopBacksOffAndFails points the client at a server whose
+// leader hints alternate between two nodes forever
+func TestRedirectFlipFlopBacksOffAndFails(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		// Both hints resolve back to this server since the client keeps its port
+		leader := "127.0.0.1:7001"
+		if n%2 == 0 {
+			leader = "localhost:7002"
+		}
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]string{"leader": leader})
+	}))
+	defer ts.Close()
+
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	client := &RemoteClient{HTTP: ts.Client(), Base: base, MaxRedirects: 6, RedirectBackoff: 10 * time.Millisecond}
+
+	start := time.Now()
+	err = client.Put("k", "v")
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, errNoStableLeader) {
+		t.Fatalf("Expected errNoStableLeader, got %v", err)
+	}
+	if got := requests.Load(); got != 6 {
+		t.Fatalf("Expected 6 attempts, got %d", got)
+	}
+	// Two alternating hints each repeat: 10+10+20+20+40ms between six attempts
+	if elapsed < 100*time.Millisecond {
+		t.Fatalf("Expected backoff of at least 100ms, took %v", elapsed)
+	}
+}
+
+// TestRedirectResendsBody follows one redirect and checks the retried PUT
+// still carries its value
+func TestRedirectResendsBody(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]string{"leader": "127.0.0.1:7001"})
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != "value" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("missing body\n"))
+			return
+		}
+		_, _ = w.Write([]byte("OK\n"))
+	}))
+	defer ts.Close()
+
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	client := &RemoteClient{HTTP: ts.Client(), Base: base, RedirectBackoff: time.Millisecond}
+	if err := client.Put("k", "value"); err != nil {
+		t.Fatalf("Put after redirect failed: %v", err)
+	}
+}
+
+// TestRedirectFollowsAdvertisedHTTP hints a leader whose raft address would
+// resolve to a dead port and whose advertised HTTP address is another
+// server, and checks the client goes straight to the advertised one
+func TestRedirectFollowsAdvertisedHTTP(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK\n"))
+	}))
+	defer leader.Close()
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]string{"leader": "127.0.0.1:1", "leader_http": leader.URL})
+	}))
+	defer follower.Close()
+
+	base, err := url.Parse(follower.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	client := &RemoteClient{HTTP: follower.Client(), Base: base, MaxRedirects: 2, RedirectBackoff: time.Millisecond}
+	if err := client.Put("k", "v"); err != nil {
+		t.Fatalf("Put through the advertised leader failed: %v", err)
+	}
+}
+
+// TestNoLeaderRetriesInsteadOfRedirecting answers 503 with Retry-After, as a
+// node with no leader does, and checks the client waits and retries until a
+// leader is elected, and gives up with errNoLeader if none is
+func TestNoLeaderRetriesInsteadOfRedirecting(t *testing.T) {
+	var requests, electedAt atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := requests.Add(1); electedAt.Load() == 0 || n < electedAt.Load() {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("no leader known: retry later\n"))
+			return
+		}
+		_, _ = w.Write([]byte("OK\n"))
+	}))
+	defer ts.Close()
+
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	client := &RemoteClient{HTTP: ts.Client(), Base: base, MaxRedirects: 4, RedirectBackoff: time.Millisecond}
+
+	if err := client.Put("k", "v"); !errors.Is(err, errNoLeader) {
+		t.Fatalf("Expected errNoLeader, got %v", err)
+	}
+	if got := requests.Load(); got != 4 {
+		t.Fatalf("Expected 4 attempts, got %d", got)
+	}
+
+	requests.Store(0)
+	electedAt.Store(3)
+	if err := client.Put("k", "v"); err != nil {
+		t.Fatalf("Expected the put to succeed once a leader is elected, got %v", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", got)
+	}
+}
+
+// TestRoutesSendKeysToOwningCluster splits keys across two servers at "m"
+// and checks each request lands on the server that owns its key
+func TestRoutesSendKeysToOwningCluster(t *testing.T) {
+	shard := func(got *[]string) (*httptest.Server, *url.URL) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*got = append(*got, r.Method+" "+r.URL.Query().Get("key"))
+			_, _ = w.Write([]byte("OK\n"))
+		}))
+		base, err := url.Parse(ts.URL)
+		if err != nil {
+			t.Fatalf("Failed to parse server URL: %v", err)
+		}
+		return ts, base
+	}
+	var low, high []string
+	lowServer, lowBase := shard(&low)
+	defer lowServer.Close()
+	highServer, highBase := shard(&high)
+	defer highServer.Close()
+
+	route, err := ParseRoute("m=" + highBase.String())
+	if err != nil {
+		t.Fatalf("ParseRoute failed: %v", err)
+	}
+	client := &RemoteClient{HTTP: &http.Client{}, Base: lowBase, Routes: []Route{route}}
+
+	for _, key := range []string{"apple", "m", "zebra", "lemon"} {
+		if err := client.Put(key, "v"); err != nil {
+			t.Fatalf("Put %s failed: %v", key, err)
+		}
+	}
+	if _, err := client.Get("mango"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	wantLow := []string{"PUT apple", "PUT lemon"}
+	wantHigh := []string{"PUT m", "PUT zebra", "GET mango"}
+	if fmt.Sprint(low) != fmt.Sprint(wantLow) {
+		t.Fatalf("Expected %v on the low shard, got %v", wantLow, low)
+	}
+	if fmt.Sprint(high) != fmt.Sprint(wantHigh) {
+		t.Fatalf("Expected %v on the high shard, got %v", wantHigh, high)
+	}
+}
+
+// TestBalanceReadsRotatesFollowers lists two followers in /cluster and
+// checks reads alternate between them as stale reads while writes go to
+// the leader
+func TestBalanceReadsRotatesFollowers(t *testing.T) {
+	follower := func(hits *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("stale") != "true" {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			hits.Add(1)
+			_, _ = w.Write([]byte("v\n"))
+		}))
+	}
+	var hitsA, hitsB atomic.Int32
+	a, b := follower(&hitsA), follower(&hitsB)
+	defer a.Close()
+	defer b.Close()
+
+	var leaderReads, leaderWrites atomic.Int32
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/cluster":
+			_ = json.NewEncoder(w).Encode(map[string]any{"members": []map[string]any{
+				{"http_address": "http://" + r.Host, "leader": true},
+				{"http_address": a.URL},
+				{"http_address": b.URL},
+			}})
+		case r.Method == http.MethodGet:
+			leaderReads.Add(1)
+			_, _ = w.Write([]byte("v\n"))
+		default:
+			leaderWrites.Add(1)
+			_, _ = w.Write([]byte("OK\n"))
+		}
+	}))
+	defer leader.Close()
+
+	base, err := url.Parse(leader.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	client := &RemoteClient{HTTP: &http.Client{}, Base: base, BalanceReads: true}
+	for i := 0; i < 6; i++ {
+		if v, err := client.Get("k"); err != nil || v != "v" {
+			t.Fatalf("Get returned %q, %v", v, err)
+		}
+	}
+	if err := client.Put("k", "v"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if hitsA.Load() != 3 || hitsB.Load() != 3 || leaderReads.Load() != 0 {
+		t.Fatalf("Expected 3 reads on each follower and none on the leader, got %d, %d and %d", hitsA.Load(), hitsB.Load(), leaderReads.Load())
+	}
+	if leaderWrites.Load() != 1 {
+		t.Fatalf("Expected the write on the leader, got %d", leaderWrites.Load())
+	}
+
+	// A follower that stops answering sends the read back to the leader
+	b.Close()
+	a.Close()
+	if v, err := client.Get("k"); err != nil || v != "v" {
+		t.Fatalf("Get after followers went away returned %q, %v", v, err)
+	}
+	if leaderReads.Load() != 1 {
+		t.Fatalf("Expected the read to fall back to the leader, got %d leader reads", leaderReads.Load())
+	}
+}
+
+// kvServer is a single-node stand-in for /kv backed by a map
+func kvServer(t *testing.T) (*url.URL, map[string]string) {
+	t.Helper()
+	var mu sync.Mutex
+	data := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := r.URL.Query().Get("key")
+		switch r.Method {
+		case http.MethodGet:
+			v, ok := data[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte("key not found\n"))
+				return
+			}
+			_, _ = w.Write([]byte(v + "\n"))
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			data[key] = string(b)
+			_, _ = w.Write([]byte("OK\n"))
+		case http.MethodDelete:
+			delete(data, key)
+			_, _ = w.Write([]byte("OK\n"))
+		}
+	}))
+	t.Cleanup(ts.Close)
+	base, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	return base, data
+}
+
+// TestScriptRunsCommands runs a script with comments, blank lines and a
+// failing read, stopping at the failure and, with -k, carrying on past it
+func TestScriptRunsCommands(t *testing.T) {
+	const script = `# seed some keys
+put a 1
+
+put b two words
+get b
+delete a
+get a
+put c 3
+`
+	for _, keepGoing := range []bool{false, true} {
+		base, data := kvServer(t)
+		client := &RemoteClient{HTTP: &http.Client{}, Base: base}
+		var out, errOut strings.Builder
+		err := runScript(client, strings.NewReader(script), &out, &errOut, keepGoing)
+
+		if err == nil {
+			t.Fatalf("keepGoing=%v: expected the read of a deleted key to fail", keepGoing)
+		}
+		if !keepGoing && !strings.Contains(err.Error(), "line 7") {
+			t.Fatalf("Expected the failure to name line 7, got %v", err)
+		}
+		if data["b"] != "two words" || data["a"] != "" {
+			t.Fatalf("keepGoing=%v: unexpected state %v", keepGoing, data)
+		}
+		if _, ran := data["c"]; ran != keepGoing {
+			t.Fatalf("keepGoing=%v: expected the command after the failure to run only with -k, state %v", keepGoing, data)
+		}
+		if !strings.Contains(out.String(), "two words\n") {
+			t.Fatalf("Expected get output, got %q", out.String())
+		}
+	}
+
+	// The exit status follows the script's result
+	base, _ := kvServer(t)
+	client := &RemoteClient{HTTP: &http.Client{}, Base: base}
+	path := filepath.Join(t.TempDir(), "ok.txt")
+	if err := os.WriteFile(path, []byte("put k v\nget k\nexit\nget missing\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	if code := runScriptFile(client, path, false); code != 0 {
+		t.Fatalf("Expected exit status 0, got %d", code)
+	}
+	if err := os.WriteFile(path, []byte("bogus\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	if code := runScriptFile(client, path, false); code != 1 {
+		t.Fatalf("Expected exit status 1 for an unknown command, got %d", code)
+	}
+}
+
+// TestGetFormats reads a binary value in each output format
+func TestGetFormats(t *testing.T) {
+	base, data := kvServer(t)
+	data["bin"] = "a\x00\xff\tb\\"
+	client, err := newRemoteClient(base.String(), 0, nil, false, "escaped")
+	if err != nil {
+		t.Fatalf("newRemoteClient failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		format string
+		want   string
+	}{
+		{"escaped", `a\x00\xff\x09b\\` + "\n"},
+		{"raw", "a\x00\xff\tb\\\n"},
+		{"hex", "6100ff09625c\n"},
+		{"json", `"a\u0000` + "\ufffd" + `\tb\\"` + "\n"},
+	} {
+		var out strings.Builder
+		if err := runCommand(client, "format "+tc.format, &out); err != nil {
+			t.Fatalf("format %s failed: %v", tc.format, err)
+		}
+		out.Reset()
+		if err := runCommand(client, "get bin", &out); err != nil {
+			t.Fatalf("get in %s failed: %v", tc.format, err)
+		}
+		if out.String() != tc.want {
+			t.Fatalf("format %s: expected %q, got %q", tc.format, tc.want, out.String())
+		}
+	}
+
+	if err := runCommand(client, "format base64", io.Discard); err == nil {
+		t.Fatal("Expected an unknown format to be rejected")
+	}
+	if _, err := newRemoteClient(base.String(), 0, nil, false, "base64"); err == nil {
+		t.Fatal("Expected an unknown --format to be rejected")
+	}
+}
+
+// TestConcurrentRedirectsConvergePerCluster sends puts from many goroutines
+// through one client to two clusters whose base URLs redirect to their
+// leaders, checking each cluster's leader is cached apart from the other's
+// and that later requests go straight to it. Run with -race.
+func TestConcurrentRedirectsConvergePerCluster(t *testing.T) {
+	// Requests to "localhost" play a follower; the hint leads to 127.0.0.1,
+	// which the client reaches on the same port
+	cluster := func(redirects *atomic.Int32) *url.URL {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.Host, "localhost:") {
+				redirects.Add(1)
+				w.WriteHeader(http.StatusConflict)
+				_ = json.NewEncoder(w).Encode(map[string]string{"leader": "127.0.0.1:7000"})
+				return
+			}
+			_, _ = w.Write([]byte("OK\n"))
+		}))
+		t.Cleanup(ts.Close)
+		base, err := url.Parse(strings.Replace(ts.URL, "127.0.0.1", "localhost", 1))
+		if err != nil {
+			t.Fatalf("Failed to parse server URL: %v", err)
+		}
+		return base
+	}
+	var lowRedirects, highRedirects atomic.Int32
+	lowBase, highBase := cluster(&lowRedirects), cluster(&highRedirects)
+	client := &RemoteClient{
+		HTTP:            &http.Client{},
+		Base:            lowBase,
+		Routes:          []Route{{Start: "m", Base: highBase}},
+		RedirectBackoff: time.Millisecond,
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("a%d", i)
+			if i%2 == 1 {
+				key = fmt.Sprintf("z%d", i)
+			}
+			if err := client.Put(key, "v"); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Concurrent put failed: %v", err)
+	}
+
+	for _, base := range []*url.URL{lowBase, highBase} {
+		leader := client.leaderFor(base)
+		if leader.Hostname() != "127.0.0.1" || leader.Port() != base.Port() {
+			t.Fatalf("Expected %s to converge on its own leader, got %s", base, leader)
+		}
+	}
+	if client.Base != lowBase || client.Routes[0].Base != highBase {
+		t.Fatal("Expected the configured base URLs to be left alone")
+	}
+
+	low, high := lowRedirects.Load(), highRedirects.Load()
+	for i := 0; i < 10; i++ {
+		if err := client.Put(fmt.Sprintf("%c%d", "az"[i%2], i), "v"); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if lowRedirects.Load() != low || highRedirects.Load() != high {
+		t.Fatal("Expected requests after convergence to go straight to the leaders")
+	}
+
+	// With room for one cluster, the other is found again by redirect
+	client.MaxLeaders = 1
+	if err := client.Put("a", "v"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if n := client.leaders.len(); n != 1 {
+		t.Fatalf("Expected the cache bounded to 1 cluster, holds %d", n)
+	}
+	if err := client.Put("z", "v"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if lowRedirects.Load() != low || highRedirects.Load() != high+1 {
+		t.Fatalf("Expected only the evicted cluster to redirect again, got %d and %d more",
+			lowRedirects.Load()-low, highRedirects.Load()-high)
+	}
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// Buckets are namespaces carved out of the single keyspace. Keys starting
+// with a NUL byte are reserved for this metadata:
+//
+//	\x00bucket:<name>           registry entry, one per bucket, holding
+//	                            its quota and usage
+//	\x00b/<name>\x00<key>       a key stored in bucket <name>
+//
+// Usage is only maintained by writes through a Bucket; writing bucket keys
+// directly with DB.Put bypasses it.
+var (
+	bucketRegistryPrefix = []byte("\x00bucket:")
+	bucketDataPrefix     = []byte("\x00b/")
+)
+
+// MaxBucketNameSize bounds bucket names so their data prefix stays small
+const MaxBucketNameSize = 64
+
+var (
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+	ErrBucketNotFound    = errors.New("bucket not found")
+)
+
+// ValidateBucketName checks name can be used as a bucket name
+func ValidateBucketName(name string) error {
+	if name == "" || len(name) > MaxBucketNameSize || bytes.IndexByte([]byte(name), 0) >= 0 {
+		return ErrInvalidBucketName
+	}
+	return nil
+}
+
+// BucketRegistryKey is the reserved key that records bucket name exists,
+// along with its quota and usage
+func BucketRegistryKey(name string) []byte {
+	return append(append([]byte(nil), bucketRegistryPrefix...), name...)
+}
+
+// BucketKey is the key under which key is stored in bucket name
+func BucketKey(name string, key []byte) []byte {
+	k := make([]byte, 0, len(bucketDataPrefix)+len(name)+1+len(key))
+	k = append(k, bucketDataPrefix...)
+	k = append(k, name...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// Bucket is a handle to one namespace of a DB
+type Bucket struct {
+	db     *DB
+	name   string
+	update updater
+}
+
+// CreateBucket registers a bucket. Creating an existing bucket is a no-op.
+func (db *DB) CreateBucket(name string) error {
+	return createBucket(db.update, name)
+}
+
+// CreateBucket works like DB.CreateBucket, recording the clock mark
+func (v *Versioned) CreateBucket(name string) error {
+	return createBucket(v.update, name)
+}
+
+func createBucket(update updater, name string) error {
+	if err := ValidateBucketName(name); err != nil {
+		return err
+	}
+	return update(func(tx *btree.Tx) error {
+		key := BucketRegistryKey(name)
+		if _, exists, err := tx.Get(key); err != nil || exists {
+			return err
+		}
+		return tx.Put(key, bucketRecord{}.encode())
+	})
+}
+
+// Bucket returns a handle to an existing bucket
+func (db *DB) Bucket(name string) (*Bucket, error) {
+	return db.bucket(name, db.update)
+}
+
+// Bucket returns a handle to an existing bucket whose writes record the
+// clock mark. Keys in buckets are not versioned.
+func (v *Versioned) Bucket(name string) (*Bucket, error) {
+	return v.db.bucket(name, v.update)
+}
+
+func (db *DB) bucket(name string, update updater) (*Bucket, error) {
+	if err := ValidateBucketName(name); err != nil {
+		return nil, err
+	}
+	if _, err := db.Get(BucketRegistryKey(name)); err != nil {
+		if errors.Is(err, btree.ErrKeyNotFound) {
+			return nil, ErrBucketNotFound
+		}
+		return nil, err
+	}
+	return &Bucket{db: db, name: name, update: update}, nil
+}
+
+// Buckets lists the names of all buckets in ascending order
+func (db *DB) Buckets() ([]string, error) {
+	items, err := db.Scan(bucketRegistryPrefix, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, string(item.Key[len(bucketRegistryPrefix):]))
+	}
+	return names, nil
+}
+
+// BucketKeyCount counts the keys in bucket name. It only walks the bucket
+// if it was created before usage was tracked and has not been written since.
+func (db *DB) BucketKeyCount(name string) (int, error) {
+	info, err := db.BucketInfo(name)
+	if err != nil {
+		return 0, err
+	}
+	return int(info.Usage.Keys), nil
+}
+
+// Name returns the bucket's name
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Get gets a value from the bucket
+func (b *Bucket) Get(key []byte) ([]byte, error) {
+	return b.db.Get(BucketKey(b.name, key))
+}
+
+// Put puts a key-value pair in the bucket, returning ErrQuotaExceeded if
+// that would take the bucket past its quota, or btree.ErrValueTooLarge if the
+// value is over the quota's MaxValueSize
+func (b *Bucket) Put(key, value []byte) error {
+	fullKey := BucketKey(b.name, key)
+	return b.update(func(tx *btree.Tx) error {
+		if b.db.hotKeys != nil {
+			b.db.hotKeys.observe(fullKey)
+		}
+		rec, err := loadBucketRecord(tx, b.name)
+		if err != nil {
+			return err
+		}
+		if limit := rec.quota.MaxValueSize; limit > 0 && uint64(len(value)) > limit {
+			return btree.ErrValueTooLarge
+		}
+		old, existed, err := tx.Get(fullKey)
+		if err != nil {
+			return err
+		}
+
+		usage := rec.usage
+		if existed {
+			usage.Bytes -= uint64(len(key) + len(old))
+		} else {
+			usage.Keys++
+		}
+		usage.Bytes += uint64(len(key) + len(value))
+		if !rec.quota.allows(rec.usage, usage) {
+			return ErrQuotaExceeded
+		}
+
+		if err := tx.Put(fullKey, value); err != nil {
+			return err
+		}
+		rec.usage = usage
+		return tx.Put(BucketRegistryKey(b.name), rec.encode())
+	})
+}
+
+// Delete deletes a key from the bucket
+func (b *Bucket) Delete(key []byte) error {
+	fullKey := BucketKey(b.name, key)
+	return b.update(func(tx *btree.Tx) error {
+		rec, err := loadBucketRecord(tx, b.name)
+		if err != nil {
+			return err
+		}
+		old, existed, err := tx.Get(fullKey)
+		if err != nil {
+			return err
+		}
+		if !existed {
+			return btree.ErrKeyNotFound
+		}
+
+		if err := tx.Delete(fullKey); err != nil {
+			return err
+		}
+		rec.usage.Keys--
+		rec.usage.Bytes -= uint64(len(key) + len(old))
+		return tx.Put(BucketRegistryKey(b.name), rec.encode())
+	})
+}
+
+// Scan works like DB.Scan within the bucket; returned keys exclude the
+// bucket's prefix.
+func (b *Bucket) Scan(prefix, start []byte, limit int) ([]btree.Item, error) {
+	base := BucketKey(b.name, nil)
+	var fullStart []byte
+	if start != nil {
+		fullStart = BucketKey(b.name, start)
+	}
+	items, err := b.db.Scan(BucketKey(b.name, prefix), fullStart, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Key = items[i].Key[len(base):]
+	}
+	return items, nil
+}
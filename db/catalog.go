@@ -0,0 +1,64 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// KeyInfo describes one key of a catalog: the key and the length of its value
+type KeyInfo struct {
+	Key  []byte
+	Size int
+}
+
+// Catalog lists every key with prefix and the size of its value, in key
+// order, without copying any value out of the tree. Keys reserved for
+// metadata, such as versions, are left out unless prefix is reserved itself.
+func (db *DB) Catalog(prefix []byte) ([]KeyInfo, error) {
+	return db.CatalogWithProgress(prefix, nil, 0, btree.Progress{})
+}
+
+// CatalogWithProgress is Catalog starting at start and stopping after limit
+// keys, if above zero, reporting progress as ScanWithProgress does
+func (db *DB) CatalogWithProgress(prefix, start []byte, limit int, p btree.Progress) ([]KeyInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return nil, errors.New("database closed")
+	}
+
+	// Never start before the prefix range
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	skipReserved := !isReserved(prefix)
+
+	var keys []KeyInfo
+	var stopErr error
+	progress := btree.NewProgressCounter(p, max(limit, 0))
+	err := db.tree.Scan(start, func(key, value []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		if skipReserved && isReserved(key) {
+			return true
+		}
+		keys = append(keys, KeyInfo{Key: append([]byte(nil), key...), Size: len(value)})
+		if stopErr = progress.Step(); stopErr != nil {
+			return false
+		}
+		return limit <= 0 || len(keys) < limit
+	})
+	if err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	progress.Finish()
+
+	return keys, nil
+}
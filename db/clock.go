@@ -0,0 +1,68 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// The clock mark is the highest command timestamp a raft node has applied,
+// kept with the data it stamped so a node that restarts or restores a
+// snapshot judges timestamps, and so lease expiry, as its peers do:
+//
+//	\x00clock                   nanoseconds since the epoch, big-endian
+var clockKey = []byte("\x00clock")
+
+// ClockMark returns the clock mark the last write through a Versioned
+// handle with AtClock recorded, or the zero time if none did
+func (db *DB) ClockMark() (time.Time, error) {
+	b, err := db.Get(clockKey)
+	if errors.Is(err, btree.ErrKeyNotFound) || err == nil && len(b) != 8 {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), nil
+}
+
+// AtClock returns a handle writing at the same version that also records
+// mark as the clock mark in every transaction it commits, bucket and lease
+// writes included. The zero time records nothing.
+func (v *Versioned) AtClock(mark time.Time) *Versioned {
+	c := &Versioned{db: v.db, version: v.version}
+	if !mark.IsZero() {
+		c.clock = binary.BigEndian.AppendUint64(nil, uint64(mark.UnixNano()))
+	}
+	return c
+}
+
+// SaveClock records the handle's clock mark on its own, unless a write
+// through the handle already committed it. A raft node calls it for entries
+// that wrote nothing, such as a failed condition, so their timestamps are
+// kept too.
+func (v *Versioned) SaveClock() error {
+	if v.clock == nil || v.stamped {
+		return nil
+	}
+	return v.update(func(tx *btree.Tx) error { return nil })
+}
+
+// update runs fn in a write transaction, recording the clock mark with it
+func (v *Versioned) update(fn func(tx *btree.Tx) error) error {
+	err := v.db.update(func(tx *btree.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if v.clock == nil {
+			return nil
+		}
+		return tx.Put(clockKey, v.clock)
+	})
+	if err == nil {
+		v.stamped = true
+	}
+	return err
+}
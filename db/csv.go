@@ -0,0 +1,152 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// defaultCSVBatchSize is how many rows ImportCSV commits at a time, and how
+// many keys ExportCSV reads per scan
+const defaultCSVBatchSize = 1000
+
+// CSVOptions configures ImportCSVWithOptions and ExportCSVWithOptions
+type CSVOptions struct {
+	// Base64 stores each key and value as standard base64. Plain CSV cannot
+	// carry every byte sequence unchanged (the reader folds \r\n inside
+	// quoted fields to \n), so use it for binary data.
+	Base64 bool
+
+	// BatchSize is the number of rows per Batch on import and per Scan on
+	// export. Zero means 1000.
+	BatchSize int
+
+	// BatchBytes also ends an import batch before its keys and values pass
+	// this many bytes, counting 32 more per row. Zero is no limit.
+	BatchBytes int
+}
+
+func (o CSVOptions) batchSize() int {
+	if o.BatchSize > 0 {
+		return o.BatchSize
+	}
+	return defaultCSVBatchSize
+}
+
+// ImportCSV loads key,value rows from r. See ImportCSVWithOptions.
+func (db *DB) ImportCSV(r io.Reader) error {
+	return db.ImportCSVWithOptions(r, CSVOptions{})
+}
+
+// ImportCSVWithOptions loads RFC 4180 key,value rows from r, with no header
+// row, writing them in batches. A malformed row stops the import; batches
+// committed before it are kept.
+func (db *DB) ImportCSVWithOptions(r io.Reader, opts CSVOptions) error {
+	return ReadCSV(r, opts, func(ops []btree.Op) error {
+		return db.Batch(ops, btree.BatchOptions{})
+	})
+}
+
+// csvRowOverhead is what BatchBytes counts for a row beyond its key and value
+const csvRowOverhead = 32
+
+// ReadCSV reads key,value rows from r as ImportCSVWithOptions does, but hands
+// each batch of them to fn instead of writing it, stopping at fn's first
+// error. fn must not keep ops, which is reused for the next batch.
+func ReadCSV(r io.Reader, opts CSVOptions, fn func(ops []btree.Op) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+
+	size := opts.batchSize()
+	ops := make([]btree.Op, 0, size)
+	batchBytes := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		key, value := []byte(record[0]), []byte(record[1])
+		if opts.Base64 {
+			line, _ := reader.FieldPos(0)
+			if key, err = base64.StdEncoding.DecodeString(record[0]); err != nil {
+				return fmt.Errorf("line %d: key: %w", line, err)
+			}
+			if value, err = base64.StdEncoding.DecodeString(record[1]); err != nil {
+				return fmt.Errorf("line %d: value: %w", line, err)
+			}
+		}
+
+		rowBytes := len(key) + len(value) + csvRowOverhead
+		if opts.BatchBytes > 0 && len(ops) > 0 && batchBytes+rowBytes > opts.BatchBytes {
+			if err := fn(ops); err != nil {
+				return err
+			}
+			ops, batchBytes = ops[:0], 0
+		}
+		ops = append(ops, btree.Op{Key: key, Value: value})
+		batchBytes += rowBytes
+		if len(ops) == size {
+			if err := fn(ops); err != nil {
+				return err
+			}
+			ops, batchBytes = ops[:0], 0
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+	return fn(ops)
+}
+
+// ExportCSV writes every key as a key,value row to w. See
+// ExportCSVWithOptions.
+func (db *DB) ExportCSV(w io.Writer) error {
+	return db.ExportCSVWithOptions(w, CSVOptions{})
+}
+
+// ExportCSVWithOptions writes every key, in key order, as an RFC 4180
+// key,value row to w. Keys are read in pages, so writes made during the
+// export may or may not appear in it; use SnapshotTo for a consistent copy.
+func (db *DB) ExportCSVWithOptions(w io.Writer, opts CSVOptions) error {
+	writer := csv.NewWriter(w)
+	size := opts.batchSize()
+
+	var start []byte
+	for {
+		items, err := db.Scan(nil, start, size)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			record := []string{string(item.Key), string(item.Value)}
+			if opts.Base64 {
+				record = []string{
+					base64.StdEncoding.EncodeToString(item.Key),
+					base64.StdEncoding.EncodeToString(item.Value),
+				}
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+
+		if len(items) < size {
+			break
+		}
+		// Resume just past the last key returned
+		last := items[len(items)-1].Key
+		start = append(last[:len(last):len(last)], 0)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -1,12 +1,10 @@
 package db
 
 import (
+	"bytes"
 	"errors"
-	"fmt"
-	"io"
-	"os"
-	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/conuredb/conuredb/btree"
 )
@@ -16,20 +14,161 @@ type DB struct {
 	mu       sync.RWMutex
 	tree     *btree.BTree
 	path     string
+	opts     Options
+	hotKeys  *hotKeyTracker
+	hooks    []CommitHook
+	scrub    scrubCounters
+	dirty    btree.DirtyCounters
 	isClosed bool
+
+	// replaceMu serializes ReplaceAll, which builds its side file outside mu
+	replaceMu sync.Mutex
+}
+
+// Options configures an embedded database. The zero value is a read-write
+// database that fsyncs every commit.
+type Options struct {
+	// ReadOnly opens an existing file without write access. Writes fail with
+	// btree.ErrReadOnly; Reload picks up commits made by another process.
+	ReadOnly bool
+
+	// NoSync skips the fsync after each commit, trading durability of the
+	// most recent writes for throughput. Call Sync to flush explicitly.
+	NoSync bool
+
+	// TrackHotKeys counts Get and Put calls per key so HotKeys can report the
+	// most accessed keys. It costs a hash and a short lock on every access.
+	TrackHotKeys bool
+
+	// UseMmap reads pages through a memory mapping of the file rather than
+	// a syscall per page. It helps read-heavy workloads whose pages are not
+	// yet cached.
+	UseMmap bool
+
+	// AppendFillFactor is how full a split leaves a page when keys are
+	// inserted in ascending order. Zero selects btree.DefaultAppendFillFactor.
+	AppendFillFactor float64
+
+	// SplitStrategy chooses where a full page splits; see
+	// btree.SplitStrategy. Zero splits at the middle item.
+	SplitStrategy btree.SplitStrategy
+
+	// MinFreeBytes rejects writes with btree.ErrDiskFull while the disk has
+	// less free space than this. Zero disables the check.
+	MinFreeBytes uint64
+
+	// WriteRetries retries a commit's page write up to this many times, with
+	// a doubling pause from WriteRetryBackoff, when it fails with a transient
+	// error such as EINTR, instead of aborting the write. A full disk still
+	// fails fast; see btree.Options. Zero never retries.
+	WriteRetries      int
+	WriteRetryBackoff time.Duration
+
+	// MaxPinnedPages bounds the pages kept cached for keys given to Pin;
+	// zero means btree.DefaultMaxPinnedPages
+	MaxPinnedPages int
+
+	// DedupMinValueSize makes Compact store each value of at least this many
+	// bytes that several keys hold only once; see btree.Options. Zero
+	// disables it.
+	DedupMinValueSize int
+
+	// YieldEvery makes Scan, Verify and full Stats step aside after every
+	// YieldEvery keys or pages so writes are not held up behind them. A
+	// yielding scan still returns the data as of when it began, even if a
+	// Compact runs during it. Zero never yields; see btree.Options.
+	YieldEvery int
+
+	// MaxReaders caps how many yielding Scans, Verifys and full Stats may run
+	// at once; past it they fail with btree.ErrTooManyReaders. Zero is no
+	// cap; see btree.Options.
+	MaxReaders int
+
+	// Readahead makes Scan prefetch this many pages ahead in the background,
+	// so long scans over uncached pages rarely wait on a read. Zero disables
+	// it; see btree.Options.
+	Readahead int
+
+	// MaxDirtyPages caps the pages a write transaction holds in memory
+	// before it writes them out early, still committing atomically; see
+	// btree.Options. Zero keeps them all in memory until commit.
+	MaxDirtyPages int
+
+	// TruncateSeparators keeps only the shortest distinguishing prefix of
+	// each separator key in internal pages; see btree.Options
+	TruncateSeparators bool
+
+	// MaxSeparatorBytes moves leaf splits to keep truncated separators
+	// within this many bytes where it can; see btree.Options. Zero is no
+	// bound.
+	MaxSeparatorBytes int
+
+	// UnsafeOverwriteInPlace rewrites only the leaf page when Put replaces
+	// a value with one no longer than it, instead of copying the path from
+	// the root. A crash during such a write can tear the page and lose the
+	// keys on it; see btree.Options.
+	UnsafeOverwriteInPlace bool
+
+	// EncryptionKey encrypts a new file at rest with AES-256-GCM and opens
+	// one encrypted before; see btree.Options. Every node of a cluster must
+	// use the same key, as snapshots copy the encrypted file as it is.
+	EncryptionKey []byte
+
+	// AllowMigration upgrades a file written by an older version of the
+	// storage format when it is opened, instead of failing with
+	// btree.ErrNeedsMigration. The file is rewritten, so keep a backup.
+	AllowMigration bool
 }
 
-// Open opens a database
+// Open opens a database with default options
 func Open(path string) (*DB, error) {
-	tree, err := btree.NewBTree(path)
+	return OpenWithOptions(path, Options{})
+}
+
+// OpenWithOptions opens a database with the given options
+func OpenWithOptions(path string, opts Options) (*DB, error) {
+	database := &DB{
+		path: path,
+		opts: opts,
+	}
+	tree, err := btree.NewBTreeWithOptions(path, database.treeOptions())
 	if err != nil {
 		return nil, err
 	}
+	database.tree = tree
+	if opts.TrackHotKeys {
+		database.hotKeys = newHotKeyTracker()
+	}
+	return database, nil
+}
 
-	return &DB{
-		tree: tree,
-		path: path,
-	}, nil
+// treeOptions maps db's options onto its tree. Traversals run under db.mu's
+// read lock, so a yielding tree releases that too.
+func (db *DB) treeOptions() btree.Options {
+	o := db.opts
+	return btree.Options{
+		ReadOnly:               o.ReadOnly,
+		NoSync:                 o.NoSync,
+		UseMmap:                o.UseMmap,
+		AppendFillFactor:       o.AppendFillFactor,
+		SplitStrategy:          o.SplitStrategy,
+		MinFreeBytes:           o.MinFreeBytes,
+		WriteRetries:           o.WriteRetries,
+		WriteRetryBackoff:      o.WriteRetryBackoff,
+		YieldEvery:             o.YieldEvery,
+		YieldLocker:            db.mu.RLocker(),
+		MaxReaders:             o.MaxReaders,
+		Readahead:              o.Readahead,
+		MaxDirtyPages:          o.MaxDirtyPages,
+		DirtyCounters:          &db.dirty,
+		MaxPinnedPages:         o.MaxPinnedPages,
+		DedupMinValueSize:      o.DedupMinValueSize,
+		AllowMigration:         o.AllowMigration,
+		UnsafeOverwriteInPlace: o.UnsafeOverwriteInPlace,
+		TruncateSeparators:     o.TruncateSeparators,
+		MaxSeparatorBytes:      o.MaxSeparatorBytes,
+		EncryptionKey:          o.EncryptionKey,
+	}
 }
 
 // Close closes the database
@@ -45,7 +184,10 @@ func (db *DB) Close() error {
 	return db.tree.Close()
 }
 
-// Reload refreshes in-memory metadata to reflect external changes.
+// Reload refreshes in-memory metadata to reflect external changes, dropping
+// cached pages another writer may have reused. Only a read-only DB can see
+// such changes: a file has one writer at a time, and a second Open for
+// writing fails with btree.ErrLocked.
 func (db *DB) Reload() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -55,6 +197,85 @@ func (db *DB) Reload() error {
 	return db.tree.Reload()
 }
 
+// Seal permanently marks the database file immutable. Unlike opening with
+// ReadOnly, the flag is stored in the file: every later Open, by any process,
+// serves reads but rejects writes with btree.ErrSealed.
+func (db *DB) Seal() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+
+	return db.tree.Seal()
+}
+
+// Verify checks the structure of the whole tree and returns an error
+// wrapping btree.ErrCorrupt for the first problem found
+func (db *DB) Verify() error {
+	return db.VerifyWithProgress(btree.Progress{})
+}
+
+// VerifyWithProgress is Verify, reporting progress and stopping early if
+// p's context is cancelled
+func (db *DB) VerifyWithProgress(p btree.Progress) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+
+	return db.tree.VerifyWithProgress(p)
+}
+
+// Pin keeps the pages holding keys cached, so reading them never goes to
+// disk, even after DropCache. Past MaxPinnedPages it fails with
+// btree.ErrTooManyPinned and pins none of keys. Pins survive RestoreFrom.
+func (db *DB) Pin(keys [][]byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+	return db.tree.Pin(keys)
+}
+
+// Unpin releases keys pinned by Pin
+func (db *DB) Unpin(keys [][]byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+	db.tree.Unpin(keys)
+	return nil
+}
+
+// DropCache empties the node cache, forcing later reads back to disk, and
+// returns how many nodes it dropped. Pinned keys stay cached.
+func (db *DB) DropCache() (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isClosed {
+		return 0, errors.New("database closed")
+	}
+
+	return db.tree.DropCache(), nil
+}
+
+// Sealed reports whether the database file has been sealed
+func (db *DB) Sealed() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return !db.isClosed && db.tree.Sealed()
+}
+
 // Get gets a value from the database
 func (db *DB) Get(key []byte) ([]byte, error) {
 	db.mu.RLock()
@@ -64,10 +285,50 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, errors.New("database closed")
 	}
 
+	if db.hotKeys != nil {
+		db.hotKeys.observe(key)
+	}
 	return db.tree.Get(key)
 }
 
-// Put puts a key-value pair in the database
+// Next returns a copy of the item with the smallest key strictly greater
+// than key, or btree.ErrKeyNotFound if there is none
+func (db *DB) Next(key []byte) (btree.Item, error) {
+	return db.neighbor(key, db.tree.Next)
+}
+
+// Prev returns a copy of the item with the largest key strictly less than
+// key, or btree.ErrKeyNotFound if there is none. Stepping back from a key
+// that is not reserved never lands on a reserved one.
+func (db *DB) Prev(key []byte) (btree.Item, error) {
+	return db.neighbor(key, db.tree.Prev)
+}
+
+func (db *DB) neighbor(key []byte, step func([]byte) (btree.Item, error)) (btree.Item, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return btree.Item{}, errors.New("database closed")
+	}
+
+	item, err := step(key)
+	if err != nil {
+		return btree.Item{}, err
+	}
+	// Reserved keys sort before every other key, so only Prev can cross
+	// into them
+	if !isReserved(key) && isReserved(item.Key) {
+		return btree.Item{}, btree.ErrKeyNotFound
+	}
+	return btree.Item{
+		Key:   append([]byte(nil), item.Key...),
+		Value: append([]byte(nil), item.Value...),
+	}, nil
+}
+
+// Put puts a key-value pair in the database. Concurrent writes to the same
+// key are serialized, and the one that runs last wins.
 func (db *DB) Put(key, value []byte) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -76,6 +337,14 @@ func (db *DB) Put(key, value []byte) error {
 		return errors.New("database closed")
 	}
 
+	if db.hotKeys != nil {
+		db.hotKeys.observe(key)
+	}
+	if db.hooked() {
+		return db.tree.Update(func(tx *btree.Tx) error {
+			return db.putTx(tx, key, value)
+		})
+	}
 	return db.tree.Put(key, value)
 }
 
@@ -88,99 +357,227 @@ func (db *DB) Delete(key []byte) error {
 		return errors.New("database closed")
 	}
 
+	if db.hooked() {
+		return db.tree.Update(func(tx *btree.Tx) error {
+			return db.deleteTx(tx, key)
+		})
+	}
 	return db.tree.Delete(key)
 }
 
-// Sync syncs the database to disk
-func (db *DB) Sync() error {
+// PutReturningOld puts a key-value pair and returns the value it replaced.
+// The read and the write happen atomically.
+func (db *DB) PutReturningOld(key, value []byte) (old []byte, existed bool, err error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	if db.isClosed {
-		return errors.New("database closed")
+		return nil, false, errors.New("database closed")
 	}
 
-	return db.tree.Sync()
+	if db.hotKeys != nil {
+		db.hotKeys.observe(key)
+	}
+	if db.hooked() {
+		err = db.tree.Update(func(tx *btree.Tx) error {
+			if old, existed, err = tx.Get(key); err != nil {
+				return err
+			}
+			return db.putTx(tx, key, value)
+		})
+		return old, existed, err
+	}
+	return db.tree.PutReturningOld(key, value)
 }
 
-// SnapshotTo streams a durable snapshot of the database file to w.
-// This acquires the DB lock for the duration for simplicity and consistency.
-func (db *DB) SnapshotTo(w io.Writer) error {
+// DeleteReturningOld deletes a key and returns the value it held. Deleting a
+// missing key reports existed=false rather than an error.
+func (db *DB) DeleteReturningOld(key []byte) (old []byte, existed bool, err error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	if db.isClosed {
-		return errors.New("database closed")
+		return nil, false, errors.New("database closed")
 	}
 
-	// Ensure latest state is on disk
-	if err := db.tree.Sync(); err != nil {
-		return err
+	if db.hooked() {
+		err = db.tree.Update(func(tx *btree.Tx) error {
+			if old, existed, err = tx.Get(key); err != nil || !existed {
+				return err
+			}
+			return db.deleteTx(tx, key)
+		})
+		return old, existed, err
 	}
+	return db.tree.DeleteReturningOld(key)
+}
 
-	f, err := os.Open(db.path)
-	if err != nil {
-		return err
+// Batch applies ops atomically: either every operation is committed or none is.
+func (db *DB) Batch(ops []btree.Op, opts btree.BatchOptions) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
 	}
-	defer func() {
-		if closeErr := f.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close database file during snapshot: %v\n", closeErr)
-		}
-	}()
 
-	_, err = io.Copy(w, f)
-	return err
+	if db.hooked() {
+		return db.tree.Update(func(tx *btree.Tx) error {
+			return db.applyOpsTx(tx, ops)
+		})
+	}
+	return db.tree.Batch(ops, opts)
 }
 
-// RestoreFrom replaces the on-disk database with the provided snapshot stream.
-// This closes and reopens the underlying B-Tree atomically via rename.
-func (db *DB) RestoreFrom(r io.Reader) error {
+// Txn applies ops atomically if every condition in conds holds, checking
+// and writing in one transaction so no other write can slip in between. It
+// reports whether the ops were applied.
+func (db *DB) Txn(conds []btree.Cond, ops []btree.Op) (bool, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	if db.isClosed {
-		return errors.New("database closed")
+		return false, errors.New("database closed")
+	}
+
+	if db.hooked() {
+		return db.txnHooked(conds, ops)
 	}
+	return db.tree.Txn(conds, ops)
+}
+
+// Scan returns up to limit key-value pairs whose keys start with prefix and are
+// >= start, in ascending key order. A limit <= 0 returns every match. The
+// pairs are read from the state committed when the scan began; writes
+// applied while it runs are not in it, so a Txn is never seen half applied.
+// Keys reserved for metadata, such as versions and buckets, are left out
+// unless prefix is reserved itself.
+func (db *DB) Scan(prefix, start []byte, limit int) ([]btree.Item, error) {
+	return db.ScanWithProgress(prefix, start, limit, btree.Progress{})
+}
+
+// ScanWithProgress is Scan, reporting the keys gathered so far against limit
+// and stopping with the context's error if p's context is cancelled
+func (db *DB) ScanWithProgress(prefix, start []byte, limit int, p btree.Progress) ([]btree.Item, error) {
+	return db.scan(prefix, start, limit, p, isReserved(prefix))
+}
+
+// firstUnreserved is the smallest key outside the reserved space
+var firstUnreserved = []byte{1}
+
+// scan is ScanWithProgress, with reserved keys only if withReserved
+func (db *DB) scan(prefix, start []byte, limit int, p btree.Progress, withReserved bool) ([]btree.Item, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	// Close the current tree to release file handles
-	if err := db.tree.Close(); err != nil {
-		return err
+	if db.isClosed {
+		return nil, errors.New("database closed")
 	}
 
-	dir := filepath.Dir(db.path)
-	tmpPath := filepath.Join(dir, ".conure.restore.tmp")
-	// Write snapshot to a temp file
-	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
-	if err != nil {
-		return err
+	// Never start before the prefix range, nor among reserved keys, which
+	// sort before every other key, unless they are wanted
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
 	}
-	if _, err := io.Copy(tmpFile, r); err != nil {
-		if closeErr := tmpFile.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close temp file after copy error: %v\n", closeErr)
-		}
-		return err
+	if !withReserved && bytes.Compare(start, firstUnreserved) < 0 {
+		start = firstUnreserved
 	}
-	if err := tmpFile.Sync(); err != nil {
-		if closeErr := tmpFile.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close temp file after sync error: %v\n", closeErr)
+
+	var items []btree.Item
+	var stopErr error
+	progress := btree.NewProgressCounter(p, max(limit, 0))
+	err := db.tree.Scan(start, func(key, value []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
 		}
-		return err
+		items = append(items, btree.Item{
+			Key:   append([]byte(nil), key...),
+			Value: append([]byte(nil), value...),
+		})
+		if stopErr = progress.Step(); stopErr != nil {
+			return false
+		}
+		return limit <= 0 || len(items) < limit
+	})
+	if err == nil {
+		err = stopErr
 	}
-	if err := tmpFile.Close(); err != nil {
-		return err
+	if err != nil {
+		return nil, err
 	}
+	progress.Finish()
+
+	return items, nil
+}
 
-	// Atomically replace the db file
-	if err := os.Rename(tmpPath, db.path); err != nil {
-		return err
+// Stats reports page usage; full additionally walks the whole tree to count
+// keys and build size histograms.
+func (db *DB) Stats(full bool) (btree.Stats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return btree.Stats{}, errors.New("database closed")
 	}
 
-	// Reopen the tree
-	tree, err := btree.NewBTree(db.path)
-	if err != nil {
-		return err
+	return db.tree.Stats(full)
+}
+
+// DirtyStats reports the pages write transactions hold in memory until they
+// commit, without waiting for one in progress
+func (db *DB) DirtyStats() btree.DirtyStats {
+	return db.dirty.Stats()
+}
+
+// FreeSpace returns the bytes available on the file system holding the
+// database file
+func (db *DB) FreeSpace() (uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return 0, errors.New("database closed")
 	}
-	db.tree = tree
 
-	return nil
+	return db.tree.FreeSpace()
+}
+
+// HotKeys returns up to n of the most accessed keys with their estimated
+// access counts, or false if the database was opened without TrackHotKeys.
+func (db *DB) HotKeys(n int) ([]KeyAccess, bool) {
+	if db.hotKeys == nil {
+		return nil, false
+	}
+	return db.hotKeys.top(n), true
+}
+
+// Compact reclaims unreferenced pages and truncates the file past the highest
+// live page.
+func (db *DB) Compact() (btree.CompactStats, error) {
+	return db.CompactWithProgress(btree.Progress{})
+}
+
+// CompactWithProgress is Compact, reporting progress and stopping early if
+// p's context is cancelled
+func (db *DB) CompactWithProgress(p btree.Progress) (btree.CompactStats, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isClosed {
+		return btree.CompactStats{}, errors.New("database closed")
+	}
+
+	return db.tree.CompactWithProgress(p)
+}
+
+// Sync syncs the database to disk
+func (db *DB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+
+	return db.tree.Sync()
 }
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// diffPageSize is how many keys Diff reads from each side at a time
+const diffPageSize = 1000
+
+// DiffResult lists the keys on which two databases disagree, each in key
+// order. Keys the two hold with equal values are only counted.
+type DiffResult struct {
+	// Changed keys are in both with different values
+	Changed [][]byte
+	// Missing keys are only in the first database
+	Missing [][]byte
+	// Extra keys are only in the second database
+	Extra [][]byte
+	// Same counts keys with equal values in both
+	Same int
+}
+
+// Equal reports whether the two databases held the same keys and values
+func (r DiffResult) Equal() bool {
+	return len(r.Changed) == 0 && len(r.Missing) == 0 && len(r.Extra) == 0
+}
+
+// Diff compares the database files at pathA and pathB, such as a follower's
+// and the leader's, or a backup and its source. See DiffWithOptions.
+func Diff(pathA, pathB string) (DiffResult, error) {
+	return DiffWithOptions(pathA, pathB, Options{})
+}
+
+// DiffWithOptions opens both files read-only with opts, for instance to
+// pass the EncryptionKey, and walks their keys side by side in pages, so
+// only the differences are held in memory. Files still being written may
+// report keys that changed during the walk.
+func DiffWithOptions(pathA, pathB string, opts Options) (DiffResult, error) {
+	opts.ReadOnly = true
+	a, err := OpenWithOptions(pathA, opts)
+	if err != nil {
+		return DiffResult{}, fmt.Errorf("%s: %w", pathA, err)
+	}
+	defer closeDiffed(a, pathA)
+	b, err := OpenWithOptions(pathB, opts)
+	if err != nil {
+		return DiffResult{}, fmt.Errorf("%s: %w", pathB, err)
+	}
+	defer closeDiffed(b, pathB)
+
+	var result DiffResult
+	ca, cb := &diffCursor{db: a}, &diffCursor{db: b}
+	for {
+		ia, err := ca.peek()
+		if err != nil {
+			return DiffResult{}, fmt.Errorf("%s: %w", pathA, err)
+		}
+		ib, err := cb.peek()
+		if err != nil {
+			return DiffResult{}, fmt.Errorf("%s: %w", pathB, err)
+		}
+		if ia == nil && ib == nil {
+			return result, nil
+		}
+
+		switch {
+		case ib == nil || (ia != nil && bytes.Compare(ia.Key, ib.Key) < 0):
+			result.Missing = append(result.Missing, ia.Key)
+			ca.advance()
+		case ia == nil || bytes.Compare(ia.Key, ib.Key) > 0:
+			result.Extra = append(result.Extra, ib.Key)
+			cb.advance()
+		default:
+			if bytes.Equal(ia.Value, ib.Value) {
+				result.Same++
+			} else {
+				result.Changed = append(result.Changed, ia.Key)
+			}
+			ca.advance()
+			cb.advance()
+		}
+	}
+}
+
+func closeDiffed(db *DB, path string) {
+	if closeErr := db.Close(); closeErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to close %s: %v\n", path, closeErr)
+	}
+}
+
+// diffCursor steps through a database's keys in order, a page at a time
+type diffCursor struct {
+	db    *DB
+	items []btree.Item
+	start []byte
+	done  bool
+}
+
+// peek returns the current item, or nil once every key has been seen
+func (c *diffCursor) peek() (*btree.Item, error) {
+	if len(c.items) == 0 && !c.done {
+		// Metadata, such as versions and buckets, is compared too
+		items, err := c.db.scan(nil, c.start, diffPageSize, btree.Progress{}, true)
+		if err != nil {
+			return nil, err
+		}
+		c.items = items
+		if len(items) < diffPageSize {
+			c.done = true
+		} else {
+			// Resume just past the last key returned
+			last := items[len(items)-1].Key
+			c.start = append(last[:len(last):len(last)], 0)
+		}
+	}
+	if len(c.items) == 0 {
+		return nil, nil
+	}
+	return &c.items[0], nil
+}
+
+func (c *diffCursor) advance() {
+	c.items = c.items[1:]
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// Change is one key written by Put, Delete, Batch or Txn, as a commit hook
+// sees it
+type Change struct {
+	Key []byte
+	// Value is the key's new value, nil if Delete is set
+	Value  []byte
+	Delete bool
+	// Old is the value the write replaced, if Existed
+	Old     []byte
+	Existed bool
+}
+
+// CommitHook runs inside the transaction of every write, once per key it
+// writes, after the write itself. Whatever it writes through tx commits
+// atomically with the data, and an error aborts the whole transaction and is
+// returned from the write unchanged.
+type CommitHook func(tx *Tx, ch Change) error
+
+// Tx is the write transaction a commit hook runs in. Writes through it do not
+// run hooks again. A Tx must not be used after the hook returns.
+type Tx struct {
+	tx *btree.Tx
+}
+
+// Get returns a copy of the value stored under key, and whether it exists,
+// seeing the transaction's own writes
+func (tx *Tx) Get(key []byte) ([]byte, bool, error) {
+	return tx.tx.Get(key)
+}
+
+// Put puts a key-value pair
+func (tx *Tx) Put(key, value []byte) error {
+	return tx.tx.Put(key, value)
+}
+
+// Delete deletes key, returning btree.ErrKeyNotFound if it does not exist
+func (tx *Tx) Delete(key []byte) error {
+	return tx.tx.Delete(key)
+}
+
+// OnCommit registers hook to run inside every later write to a key that is
+// not reserved, so another index can be kept in step with the data, e.g.
+// from value to key. Hooks run in the order registered, with the database
+// locked: a hook must write through its Tx, as calling the DB deadlocks.
+// Writes to buckets, ReplaceAll and restores do not run hooks. In a cluster
+// every node runs them as it applies a write, so they must be registered on
+// every node and give the same result everywhere.
+func (db *DB) OnCommit(hook CommitHook) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.hooks = append(db.hooks, hook)
+}
+
+// errCondFailed aborts a hooked Txn whose conditions do not hold
+var errCondFailed = errors.New("condition failed")
+
+// hooked reports whether writes must run commit hooks. db.mu must be held.
+func (db *DB) hooked() bool {
+	return len(db.hooks) > 0
+}
+
+// putTx puts key within tx and runs the commit hooks for it
+func (db *DB) putTx(tx *btree.Tx, key, value []byte) error {
+	if !db.hooked() || isReserved(key) {
+		return tx.Put(key, value)
+	}
+	old, existed, err := tx.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(key, value); err != nil {
+		return err
+	}
+	return db.runHooks(tx, Change{Key: key, Value: value, Old: old, Existed: existed})
+}
+
+// deleteTx deletes key within tx, failing with btree.ErrKeyNotFound if it is
+// missing, and runs the commit hooks for it
+func (db *DB) deleteTx(tx *btree.Tx, key []byte) error {
+	if !db.hooked() || isReserved(key) {
+		return tx.Delete(key)
+	}
+	old, existed, err := tx.Get(key)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return btree.ErrKeyNotFound
+	}
+	if err := tx.Delete(key); err != nil {
+		return err
+	}
+	return db.runHooks(tx, Change{Key: key, Delete: true, Old: old, Existed: true})
+}
+
+func (db *DB) runHooks(tx *btree.Tx, ch Change) error {
+	htx := &Tx{tx: tx}
+	for _, hook := range db.hooks {
+		if err := hook(htx, ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyOpsTx applies ops in order within tx as Batch does, running the
+// commit hooks for each
+func (db *DB) applyOpsTx(tx *btree.Tx, ops []btree.Op) error {
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			if err = db.deleteTx(tx, op.Key); errors.Is(err, btree.ErrKeyNotFound) {
+				err = nil
+			}
+		} else {
+			err = db.putTx(tx, op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txnHooked is Txn for a database with commit hooks. db.mu must be held.
+func (db *DB) txnHooked(conds []btree.Cond, ops []btree.Op) (bool, error) {
+	err := db.tree.Update(func(tx *btree.Tx) error {
+		for _, cond := range conds {
+			value, exists, err := tx.Get(cond.Key)
+			if err != nil {
+				return err
+			}
+			if cond.Absent == exists || exists && !bytes.Equal(value, cond.Value) {
+				return errCondFailed
+			}
+		}
+		return db.applyOpsTx(tx, ops)
+	})
+	if errors.Is(err, errCondFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isReserved reports whether key is in the space kept for metadata
+func isReserved(key []byte) bool {
+	return len(key) > 0 && key[0] == 0
+}
+
+// IsReservedKey reports whether key starts with a NUL byte, the space kept
+// for metadata such as buckets, versions, leases and shared values. Servers
+// refuse such keys from clients, who could otherwise forge or break it.
+func IsReservedKey(key []byte) bool {
+	return isReserved(key)
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+)
+
+const (
+	sketchDepth = 4
+	sketchWidth = 4096
+
+	// hotKeyCandidates bounds how many distinct keys are reported on
+	hotKeyCandidates = 256
+)
+
+// KeyAccess is an estimated access count for one key
+type KeyAccess struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// hotKeyTracker estimates per-key access counts in fixed memory with a
+// count-min sketch and remembers the heaviest keys seen so far. Estimates
+// never undercount, and overcount only on hash collisions.
+type hotKeyTracker struct {
+	mu         sync.Mutex
+	seed       maphash.Seed
+	sketch     [sketchDepth][sketchWidth]uint64
+	candidates map[string]uint64
+}
+
+func newHotKeyTracker() *hotKeyTracker {
+	return &hotKeyTracker{
+		seed:       maphash.MakeSeed(),
+		candidates: make(map[string]uint64, hotKeyCandidates),
+	}
+}
+
+// observe records one access to key
+func (h *hotKeyTracker) observe(key []byte) {
+	sum := maphash.Bytes(h.seed, key)
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	est := ^uint64(0)
+	for row := uint32(0); row < sketchDepth; row++ {
+		cell := &h.sketch[row][(h1+row*h2)%sketchWidth]
+		*cell++
+		if *cell < est {
+			est = *cell
+		}
+	}
+
+	k := string(key)
+	if _, ok := h.candidates[k]; ok || len(h.candidates) < hotKeyCandidates {
+		h.candidates[k] = est
+		return
+	}
+
+	// Replace the lightest candidate once this key outweighs it
+	var minKey string
+	minCount := ^uint64(0)
+	for ck, c := range h.candidates {
+		if c < minCount {
+			minKey, minCount = ck, c
+		}
+	}
+	if est > minCount {
+		delete(h.candidates, minKey)
+		h.candidates[k] = est
+	}
+}
+
+// top returns up to n keys by descending estimated count
+func (h *hotKeyTracker) top(n int) []KeyAccess {
+	h.mu.Lock()
+	out := make([]KeyAccess, 0, len(h.candidates))
+	for k, c := range h.candidates {
+		out = append(out, KeyAccess{Key: k, Count: c})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// Leases are named locks that lapse unless renewed. Each is one record under
+// a reserved name:
+//
+//	\x00lease:<name>            version byte, token and expiry as
+//	                            big-endian uint64s, then the holder
+//
+// A lease is free once its expiry has passed; the record stays until the
+// next acquire replaces it or its holder releases it. Every call takes the
+// time to judge expiry by rather than reading the clock, so replicas that
+// apply the same calls with the same times agree on who holds what.
+var leasePrefix = []byte("\x00lease:")
+
+const (
+	leaseRecordVersion = 1
+	leaseRecordHeader  = 1 + 8 + 8
+
+	// MaxLeaseNameSize bounds lease names, as bucket names are bounded
+	MaxLeaseNameSize = 64
+
+	// MaxLeaseHolderSize bounds the holder recorded with a lease
+	MaxLeaseHolderSize = 256
+)
+
+var (
+	ErrInvalidLease = errors.New("invalid lease name, holder or ttl")
+	ErrLeaseNotHeld = errors.New("lease not held")
+)
+
+// Lease is a named lease held by Holder until Expires. Token is set when the
+// lease is acquired and kept through renewals; a later acquire, by anyone,
+// gets a larger one, so a resource can refuse writes fenced with an older
+// token from a holder that lost the lease without noticing.
+type Lease struct {
+	Name    string    `json:"name"`
+	Holder  string    `json:"holder"`
+	Token   uint64    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// LeaseKey is the reserved key holding lease name
+func LeaseKey(name string) []byte {
+	return append(append([]byte(nil), leasePrefix...), name...)
+}
+
+// validateLease checks a lease name and holder
+func validateLease(name, holder string) error {
+	switch {
+	case name == "" || len(name) > MaxLeaseNameSize || bytes.IndexByte([]byte(name), 0) >= 0:
+		return ErrInvalidLease
+	case holder == "" || len(holder) > MaxLeaseHolderSize:
+		return ErrInvalidLease
+	}
+	return nil
+}
+
+func (l Lease) encode() []byte {
+	b := make([]byte, leaseRecordHeader, leaseRecordHeader+len(l.Holder))
+	b[0] = leaseRecordVersion
+	binary.BigEndian.PutUint64(b[1:], l.Token)
+	binary.BigEndian.PutUint64(b[9:], uint64(l.Expires.UnixNano()))
+	return append(b, l.Holder...)
+}
+
+func decodeLease(name string, b []byte) (Lease, error) {
+	if len(b) < leaseRecordHeader || b[0] != leaseRecordVersion {
+		return Lease{}, errors.New("invalid lease record")
+	}
+	return Lease{
+		Name:    name,
+		Holder:  string(b[leaseRecordHeader:]),
+		Token:   binary.BigEndian.Uint64(b[1:]),
+		Expires: time.Unix(0, int64(binary.BigEndian.Uint64(b[9:]))),
+	}, nil
+}
+
+// loadLease reads lease name within tx, reporting false if it has no record
+// or its record has expired by now
+func loadLease(tx *btree.Tx, name string, now time.Time) (Lease, bool, error) {
+	b, exists, err := tx.Get(LeaseKey(name))
+	if err != nil || !exists {
+		return Lease{}, false, err
+	}
+	l, err := decodeLease(name, b)
+	if err != nil {
+		return Lease{}, false, err
+	}
+	return l, now.Before(l.Expires), nil
+}
+
+// AcquireLease gives lease name to holder for ttl from now, unless someone
+// else holds it. It reports whether holder got it and returns the lease as
+// it stands: holder's, with token as its fencing token, or the other
+// holder's. Acquiring a lease holder already holds renews it, keeping its
+// token.
+func (db *DB) AcquireLease(name, holder string, ttl time.Duration, now time.Time, token uint64) (Lease, bool, error) {
+	return acquireLease(db.update, name, holder, ttl, now, token)
+}
+
+// AcquireLease works like DB.AcquireLease, recording the clock mark
+func (v *Versioned) AcquireLease(name, holder string, ttl time.Duration, now time.Time, token uint64) (Lease, bool, error) {
+	return acquireLease(v.update, name, holder, ttl, now, token)
+}
+
+func acquireLease(update updater, name, holder string, ttl time.Duration, now time.Time, token uint64) (Lease, bool, error) {
+	if err := validateLease(name, holder); err != nil || ttl <= 0 {
+		return Lease{}, false, ErrInvalidLease
+	}
+	var (
+		lease    Lease
+		acquired bool
+	)
+	err := update(func(tx *btree.Tx) error {
+		cur, held, err := loadLease(tx, name, now)
+		if err != nil {
+			return err
+		}
+		if held && cur.Holder != holder {
+			lease = cur
+			return nil
+		}
+		lease = Lease{Name: name, Holder: holder, Token: token, Expires: now.Add(ttl)}
+		if held {
+			lease.Token = cur.Token
+		}
+		acquired = true
+		return tx.Put(LeaseKey(name), lease.encode())
+	})
+	return lease, acquired, err
+}
+
+// RenewLease extends lease name to ttl from now. It fails with
+// ErrLeaseNotHeld unless holder holds it, acquired with token, and it has
+// not yet expired.
+func (db *DB) RenewLease(name, holder string, token uint64, ttl time.Duration, now time.Time) (Lease, error) {
+	return renewLease(db.update, name, holder, token, ttl, now)
+}
+
+// RenewLease works like DB.RenewLease, recording the clock mark
+func (v *Versioned) RenewLease(name, holder string, token uint64, ttl time.Duration, now time.Time) (Lease, error) {
+	return renewLease(v.update, name, holder, token, ttl, now)
+}
+
+func renewLease(update updater, name, holder string, token uint64, ttl time.Duration, now time.Time) (Lease, error) {
+	if err := validateLease(name, holder); err != nil || ttl <= 0 {
+		return Lease{}, ErrInvalidLease
+	}
+	var lease Lease
+	err := update(func(tx *btree.Tx) error {
+		cur, held, err := loadLease(tx, name, now)
+		if err != nil {
+			return err
+		}
+		if !held || cur.Holder != holder || cur.Token != token {
+			return ErrLeaseNotHeld
+		}
+		lease = cur
+		lease.Expires = now.Add(ttl)
+		return tx.Put(LeaseKey(name), lease.encode())
+	})
+	return lease, err
+}
+
+// ReleaseLease gives up lease name. It fails with ErrLeaseNotHeld unless the
+// record is holder's, acquired with token; releasing one that has expired
+// but not been taken since just removes it.
+func (db *DB) ReleaseLease(name, holder string, token uint64) error {
+	return releaseLease(db.update, name, holder, token)
+}
+
+// ReleaseLease works like DB.ReleaseLease, recording the clock mark
+func (v *Versioned) ReleaseLease(name, holder string, token uint64) error {
+	return releaseLease(v.update, name, holder, token)
+}
+
+func releaseLease(update updater, name, holder string, token uint64) error {
+	if err := validateLease(name, holder); err != nil {
+		return err
+	}
+	return update(func(tx *btree.Tx) error {
+		cur, _, err := loadLease(tx, name, time.Time{})
+		if err != nil {
+			return err
+		}
+		if cur.Holder != holder || cur.Token != token {
+			return ErrLeaseNotHeld
+		}
+		return tx.Delete(LeaseKey(name))
+	})
+}
+
+// GetLease returns lease name if it is held at now, or ErrLeaseNotHeld
+func (db *DB) GetLease(name string, now time.Time) (Lease, error) {
+	value, err := db.Get(LeaseKey(name))
+	if errors.Is(err, btree.ErrKeyNotFound) {
+		return Lease{}, ErrLeaseNotHeld
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+	l, err := decodeLease(name, value)
+	if err != nil {
+		return Lease{}, err
+	}
+	if !now.Before(l.Expires) {
+		return Lease{}, ErrLeaseNotHeld
+	}
+	return l, nil
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+var (
+	// ErrQuotaExceeded is returned by a bucket write that would take the
+	// bucket past its quota
+	ErrQuotaExceeded = errors.New("bucket quota exceeded")
+
+	// ErrInvalidQuota is returned for a quota whose value cap exceeds what a
+	// page can hold
+	ErrInvalidQuota = errors.New("invalid bucket quota")
+)
+
+// Quota limits what a bucket may hold. Zero fields are unlimited.
+// MaxValueSize caps each value below btree.MaxValueSize, which a larger
+// setting cannot raise.
+type Quota struct {
+	MaxKeys      uint64 `json:"max_keys,omitempty"`
+	MaxBytes     uint64 `json:"max_bytes,omitempty"`
+	MaxValueSize uint64 `json:"max_value_size,omitempty"`
+}
+
+// BucketUsage is what a bucket holds. Bytes counts the length of each key
+// (without the bucket prefix) plus its value.
+type BucketUsage struct {
+	Keys  uint64 `json:"keys"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// BucketInfo describes a bucket's quota and current usage
+type BucketInfo struct {
+	Name  string      `json:"name"`
+	Quota Quota       `json:"quota"`
+	Usage BucketUsage `json:"usage"`
+}
+
+// Validate checks q's value cap is one a page can hold
+func (q Quota) Validate() error {
+	if q.MaxValueSize > btree.MaxValueSize {
+		return fmt.Errorf("%w: max value size %d exceeds %d", ErrInvalidQuota, q.MaxValueSize, btree.MaxValueSize)
+	}
+	return nil
+}
+
+// allows reports whether a bucket may move from usage before to after. A
+// write that does not grow an exceeded dimension is allowed, so a bucket
+// whose quota was lowered below its usage can still be overwritten with
+// smaller values and emptied.
+func (q Quota) allows(before, after BucketUsage) bool {
+	if q.MaxKeys > 0 && after.Keys > q.MaxKeys && after.Keys > before.Keys {
+		return false
+	}
+	if q.MaxBytes > 0 && after.Bytes > q.MaxBytes && after.Bytes > before.Bytes {
+		return false
+	}
+	return true
+}
+
+// bucketRecordVersion tags the registry value layout: version, then
+// MaxKeys, MaxBytes, Keys, Bytes and MaxValueSize as big-endian uint64s.
+// Version 1 records lack MaxValueSize. Buckets created before quotas have an
+// empty registry value; their usage is counted on the first write that
+// needs it.
+const (
+	bucketRecordVersion = 2
+	bucketRecordSize    = 1 + 5*8
+	bucketRecordV1Size  = 1 + 4*8
+)
+
+type bucketRecord struct {
+	quota Quota
+	usage BucketUsage
+}
+
+func (r bucketRecord) encode() []byte {
+	b := make([]byte, bucketRecordSize)
+	b[0] = bucketRecordVersion
+	binary.BigEndian.PutUint64(b[1:], r.quota.MaxKeys)
+	binary.BigEndian.PutUint64(b[9:], r.quota.MaxBytes)
+	binary.BigEndian.PutUint64(b[17:], r.usage.Keys)
+	binary.BigEndian.PutUint64(b[25:], r.usage.Bytes)
+	binary.BigEndian.PutUint64(b[33:], r.quota.MaxValueSize)
+	return b
+}
+
+// decodeBucketRecord parses a registry value, reporting false for a legacy
+// empty one
+func decodeBucketRecord(b []byte) (bucketRecord, bool, error) {
+	if len(b) == 0 {
+		return bucketRecord{}, false, nil
+	}
+	v1 := len(b) == bucketRecordV1Size && b[0] == 1
+	if !v1 && (len(b) != bucketRecordSize || b[0] != bucketRecordVersion) {
+		return bucketRecord{}, false, errors.New("invalid bucket registry entry")
+	}
+	rec := bucketRecord{
+		quota: Quota{
+			MaxKeys:  binary.BigEndian.Uint64(b[1:]),
+			MaxBytes: binary.BigEndian.Uint64(b[9:]),
+		},
+		usage: BucketUsage{
+			Keys:  binary.BigEndian.Uint64(b[17:]),
+			Bytes: binary.BigEndian.Uint64(b[25:]),
+		},
+	}
+	if !v1 {
+		rec.quota.MaxValueSize = binary.BigEndian.Uint64(b[33:])
+	}
+	return rec, true, nil
+}
+
+// loadBucketRecord reads bucket name's registry entry inside tx, counting
+// its usage if the entry predates usage tracking
+func loadBucketRecord(tx *btree.Tx, name string) (bucketRecord, error) {
+	value, exists, err := tx.Get(BucketRegistryKey(name))
+	if err != nil {
+		return bucketRecord{}, err
+	}
+	if !exists {
+		return bucketRecord{}, ErrBucketNotFound
+	}
+	rec, tracked, err := decodeBucketRecord(value)
+	if err != nil || tracked {
+		return rec, err
+	}
+
+	rec.usage, err = countBucket(tx.Scan, name)
+	return rec, err
+}
+
+// countBucket totals bucket name's usage by walking its keys with scan
+func countBucket(scan func(start []byte, fn func(key, value []byte) bool) error, name string) (BucketUsage, error) {
+	prefix := BucketKey(name, nil)
+	var usage BucketUsage
+	err := scan(prefix, func(key, value []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		usage.Keys++
+		usage.Bytes += uint64(len(key) - len(prefix) + len(value))
+		return true
+	})
+	return usage, err
+}
+
+// updater runs fn in a write transaction, as DB.update and Versioned.update
+// do
+type updater func(fn func(tx *btree.Tx) error) error
+
+// update runs fn in a single tree transaction
+func (db *DB) update(fn func(tx *btree.Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+
+	return db.tree.Update(fn)
+}
+
+// SetBucketQuota replaces bucket name's quota. Lowering it below current
+// usage rejects further growth but keeps existing keys. A MaxValueSize above
+// btree.MaxValueSize fails with ErrInvalidQuota.
+func (db *DB) SetBucketQuota(name string, quota Quota) error {
+	return setBucketQuota(db.update, name, quota)
+}
+
+// SetBucketQuota works like DB.SetBucketQuota, recording the clock mark
+func (v *Versioned) SetBucketQuota(name string, quota Quota) error {
+	return setBucketQuota(v.update, name, quota)
+}
+
+func setBucketQuota(update updater, name string, quota Quota) error {
+	if err := ValidateBucketName(name); err != nil {
+		return err
+	}
+	if err := quota.Validate(); err != nil {
+		return err
+	}
+	return update(func(tx *btree.Tx) error {
+		rec, err := loadBucketRecord(tx, name)
+		if err != nil {
+			return err
+		}
+		rec.quota = quota
+		return tx.Put(BucketRegistryKey(name), rec.encode())
+	})
+}
+
+// BucketInfo returns bucket name's quota and usage
+func (db *DB) BucketInfo(name string) (BucketInfo, error) {
+	if err := ValidateBucketName(name); err != nil {
+		return BucketInfo{}, err
+	}
+	value, err := db.Get(BucketRegistryKey(name))
+	if errors.Is(err, btree.ErrKeyNotFound) {
+		return BucketInfo{}, ErrBucketNotFound
+	}
+	if err != nil {
+		return BucketInfo{}, err
+	}
+	rec, tracked, err := decodeBucketRecord(value)
+	if err != nil {
+		return BucketInfo{}, err
+	}
+	if !tracked {
+		if rec.usage, err = db.bucketUsage(name); err != nil {
+			return BucketInfo{}, err
+		}
+	}
+	return BucketInfo{Name: name, Quota: rec.quota, Usage: rec.usage}, nil
+}
+
+// bucketUsage counts bucket name's usage outside a transaction
+func (db *DB) bucketUsage(name string) (BucketUsage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return BucketUsage{}, errors.New("database closed")
+	}
+	return countBucket(db.tree.Scan, name)
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// replaceBatchSize is how many items ReplaceAll writes to the side file per
+// commit
+const replaceBatchSize = 1000
+
+// replaceFile swaps the side file in; tests replace it to fail the swap
+var replaceFile = btree.ReplaceFile
+
+// ReplaceAll replaces every key in the database, including buckets and
+// versions, with items. The new tree is built in a side file while reads
+// and writes carry on against the old one, then swapped in under the write
+// lock, as RestoreFrom does, so a reader sees either the whole old dataset
+// or the whole new one, never a mix. Writes that land while the side file is
+// built are discarded by the swap. Items may come in any order; of a key
+// given twice the last value wins, and items must not reuse the slices they
+// yield. Pins survive the swap. If the new file cannot be swapped in or
+// opened, the old one is put back and reopened.
+func (db *DB) ReplaceAll(items iter.Seq2[[]byte, []byte]) error {
+	db.replaceMu.Lock()
+	defer db.replaceMu.Unlock()
+
+	if err := db.checkReplaceable(); err != nil {
+		return err
+	}
+
+	tmpPath, oldPath := db.path+".replace.tmp", db.path+".replace.old"
+	for _, path := range []string{tmpPath, oldPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := db.buildReplacement(tmpPath, items); err != nil {
+		removeReplaceFile(tmpPath)
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.checkReplaceableLocked(); err != nil {
+		removeReplaceFile(tmpPath)
+		return err
+	}
+
+	// Keep a second link to the current file to put back if the swap fails
+	if err := os.Link(db.path, oldPath); err != nil {
+		removeReplaceFile(tmpPath)
+		return err
+	}
+	defer removeReplaceFile(oldPath)
+
+	pinned := db.tree.PinnedKeys()
+	if err := db.tree.Close(); err != nil {
+		removeReplaceFile(tmpPath)
+		return err
+	}
+	tree, err := db.swapIn(tmpPath)
+	if err != nil {
+		if tree, err = db.putBack(oldPath, err); tree == nil {
+			return err
+		}
+	}
+	db.tree = tree
+	if pinErr := tree.Pin(pinned); pinErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to pin keys after replace: %v\n", pinErr)
+	}
+	return err
+}
+
+// swapIn renames the side file at tmpPath over the database file and opens
+// the tree it holds
+func (db *DB) swapIn(tmpPath string) (*btree.BTree, error) {
+	if err := replaceFile(tmpPath, db.path); err != nil {
+		removeReplaceFile(tmpPath)
+		return nil, err
+	}
+	return btree.NewBTreeWithOptions(db.path, db.treeOptions())
+}
+
+// putBack renames the old file linked at oldPath back over the database
+// file after a failed swap and reopens it, returning the reopened tree and
+// cause. If the old file cannot be opened either, the database is closed
+// and the tree is nil.
+func (db *DB) putBack(oldPath string, cause error) (*btree.BTree, error) {
+	if err := btree.ReplaceFile(oldPath, db.path); err != nil {
+		cause = fmt.Errorf("%w (and failed to put the old file back: %v)", cause, err)
+	}
+	tree, err := btree.NewBTreeWithOptions(db.path, db.treeOptions())
+	if err != nil {
+		// Nothing may read the closed tree's cache of the old data
+		db.isClosed = true
+		return nil, errors.Join(cause, err)
+	}
+	return tree, cause
+}
+
+// removeReplaceFile removes a side file ReplaceAll no longer needs
+func removeReplaceFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove %s after replace: %v\n", path, err)
+	}
+}
+
+// checkReplaceable fails if the database cannot be written
+func (db *DB) checkReplaceable() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.checkReplaceableLocked()
+}
+
+func (db *DB) checkReplaceableLocked() error {
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+	if db.tree.Sealed() {
+		return btree.ErrSealed
+	}
+	if db.opts.ReadOnly {
+		return btree.ErrReadOnly
+	}
+	return nil
+}
+
+// buildReplacement writes items to a new tree at path, in the same format
+// and under the same encryption key as db, and syncs it
+func (db *DB) buildReplacement(path string, items iter.Seq2[[]byte, []byte]) (err error) {
+	opts := db.treeOptions()
+	opts.NoSync = true
+	opts.UseMmap = false
+	opts.YieldEvery = 0
+	opts.YieldLocker = nil
+	tree, err := btree.NewBTreeWithOptions(path, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := tree.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	ops := make([]btree.Op, 0, replaceBatchSize)
+	for key, value := range items {
+		ops = append(ops, btree.Op{Key: key, Value: value})
+		if len(ops) == replaceBatchSize {
+			if err := tree.Batch(ops, btree.BatchOptions{Sequential: true}); err != nil {
+				return err
+			}
+			ops = ops[:0]
+		}
+	}
+	if len(ops) > 0 {
+		if err := tree.Batch(ops, btree.BatchOptions{Sequential: true}); err != nil {
+			return err
+		}
+	}
+	return tree.Sync()
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// TestReplaceAllKeepsOldFileOnFailedSwap fails the swap, first before the
+// rename and then by leaving an unreadable file behind it, and checks the
+// database keeps serving and writing the old data without side files left
+func TestReplaceAllKeepsOldFileOnFailedSwap(t *testing.T) {
+	errInjected := errors.New("injected failure")
+	swaps := map[string]func(tmp, path string) error{
+		"rename fails": func(tmp, path string) error {
+			return errInjected
+		},
+		"reopen fails": func(tmp, path string) error {
+			if err := btree.ReplaceFile(tmp, path); err != nil {
+				return err
+			}
+			return os.WriteFile(path, []byte("not a tree"), 0o644)
+		},
+	}
+	defer func() { replaceFile = btree.ReplaceFile }()
+
+	for name, swap := range swaps {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "replace.db")
+			database, err := Open(path)
+			if err != nil {
+				t.Fatalf("Failed to open database: %v", err)
+			}
+			defer func() { _ = database.Close() }()
+			if err := database.Put([]byte("old"), []byte("v1")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+
+			replaceFile = swap
+			err = database.ReplaceAll(func(yield func(key, value []byte) bool) {
+				yield([]byte("new"), []byte("v2"))
+			})
+			replaceFile = btree.ReplaceFile
+			if err == nil {
+				t.Fatalf("Expected ReplaceAll to fail")
+			}
+
+			if v, err := database.Get([]byte("old")); err != nil || string(v) != "v1" {
+				t.Fatalf("Expected the old data kept, got %q, %v", v, err)
+			}
+			if _, err := database.Get([]byte("new")); !errors.Is(err, btree.ErrKeyNotFound) {
+				t.Fatalf("Expected no trace of the replacement, got %v", err)
+			}
+			if err := database.Put([]byte("after"), []byte("v3")); err != nil {
+				t.Fatalf("Put after the failed replace failed: %v", err)
+			}
+			for _, side := range []string{path + ".replace.tmp", path + ".replace.old"} {
+				if _, err := os.Stat(side); !os.IsNotExist(err) {
+					t.Fatalf("Expected %s removed, got %v", filepath.Base(side), err)
+				}
+			}
+
+			if err := database.ReplaceAll(func(yield func(key, value []byte) bool) {
+				for i := 0; i < 3; i++ {
+					if !yield([]byte(fmt.Sprintf("k%d", i)), []byte("v")) {
+						return
+					}
+				}
+			}); err != nil {
+				t.Fatalf("ReplaceAll after the failure failed: %v", err)
+			}
+			if v, err := database.Get([]byte("k2")); err != nil || string(v) != "v" {
+				t.Fatalf("Expected the replacement after the failure, got %q, %v", v, err)
+			}
+		})
+	}
+}
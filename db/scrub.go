@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// ScrubOptions configures the background scrubber started by StartScrubber
+type ScrubOptions struct {
+	// Interval is the pause between the end of one pass over the file and
+	// the start of the next
+	Interval time.Duration
+
+	// PagesPerSecond caps how fast a pass reads pages, so scrubbing a large
+	// file does not compete with foreground reads. Zero reads flat out.
+	PagesPerSecond int
+
+	// OnCorrupt, if set, is called with each corrupt page a pass finds. A
+	// page still corrupt on the next pass is reported again.
+	OnCorrupt func(btree.CorruptPage)
+
+	// OnError, if set, is called with each pass that could not finish
+	OnError func(error)
+}
+
+// ScrubStats counts the work of the background scrubber
+type ScrubStats struct {
+	Passes       uint64    `json:"passes"`
+	Pages        uint64    `json:"pages"`
+	CorruptPages uint64    `json:"corrupt_pages"`
+	LastPass     time.Time `json:"last_pass"`
+}
+
+// scrubCounters backs ScrubStats
+type scrubCounters struct {
+	passes   atomic.Uint64
+	pages    atomic.Uint64
+	corrupt  atomic.Uint64
+	lastPass atomic.Int64
+}
+
+// Scrub reads every page of the database back from disk and checks its
+// checksum, pacing itself to pagesPerSecond if above zero; see
+// btree.BTree.Scrub
+func (db *DB) Scrub(pagesPerSecond int, p btree.Progress) (btree.ScrubResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return btree.ScrubResult{}, errors.New("database closed")
+	}
+	return db.tree.Scrub(pagesPerSecond, p)
+}
+
+// StartScrubber scrubs the database in the background, one pass after
+// another with opts.Interval between them, until the returned function is
+// called. Corruption is only reported; repairing a page is left to the
+// operator, e.g. by restoring from a replica's snapshot.
+func (db *DB) StartScrubber(opts ScrubOptions) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			res, err := db.Scrub(opts.PagesPerSecond, btree.Progress{Context: ctx})
+			if ctx.Err() != nil {
+				return
+			}
+			db.scrub.pages.Add(uint64(res.Pages))
+			db.scrub.corrupt.Add(uint64(len(res.Corrupt)))
+			for _, page := range res.Corrupt {
+				if opts.OnCorrupt != nil {
+					opts.OnCorrupt(page)
+				}
+			}
+			if err != nil {
+				if opts.OnError != nil {
+					opts.OnError(err)
+				}
+			} else {
+				db.scrub.passes.Add(1)
+				db.scrub.lastPass.Store(time.Now().UnixNano())
+			}
+
+			timer := time.NewTimer(opts.Interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
+
+// ScrubStats reports what the background scrubber has done since the
+// database was opened
+func (db *DB) ScrubStats() ScrubStats {
+	stats := ScrubStats{
+		Passes:       db.scrub.passes.Load(),
+		Pages:        db.scrub.pages.Load(),
+		CorruptPages: db.scrub.corrupt.Load(),
+	}
+	if ns := db.scrub.lastPass.Load(); ns != 0 {
+		stats.LastPass = time.Unix(0, ns)
+	}
+	return stats
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// snapshotMagic marks the checksum trailer SnapshotTo appends after the file
+// contents: the magic followed by the SHA-256 of everything before it.
+var snapshotMagic = []byte("CONUSUM1")
+
+const snapshotTrailerSize = 8 + sha256.Size
+
+var (
+	ErrSnapshotChecksum   = errors.New("snapshot checksum mismatch")
+	ErrSnapshotNoChecksum = errors.New("snapshot has no checksum trailer")
+)
+
+// RestoreOptions tunes RestoreFromWithOptions
+type RestoreOptions struct {
+	// SkipVerify accepts snapshots without checking the checksum trailer, for
+	// transports such as raft that already checksum what they deliver. A
+	// trailer, if present, is still stripped.
+	SkipVerify bool
+
+	// InPlace writes the snapshot straight over the database file rather
+	// than staging a copy beside it, so a restore needs disk for the larger
+	// of the two files instead of both. The snapshot's header page is
+	// checked before anything is written; one in an older format is staged
+	// and migrated as usual. Past that point the old data is gone: if the
+	// stream is cut short or fails its checksum, the database is left empty
+	// and the error wraps ErrRestoreIncomplete. A crash mid-restore leaves a
+	// file that fails to open until it is removed.
+	InPlace bool
+}
+
+// ErrRestoreIncomplete is returned by an InPlace restore that failed after
+// it began overwriting the database, which it then left empty
+var ErrRestoreIncomplete = errors.New("in-place restore failed partway; database left empty")
+
+// SnapshotTo streams a durable snapshot of the database file to w, followed
+// by a checksum trailer that RestoreFrom verifies.
+// This acquires the DB lock for the duration for simplicity and consistency.
+func (db *DB) SnapshotTo(w io.Writer) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+
+	// Ensure latest state is on disk
+	if err := db.tree.Sync(); err != nil {
+		return err
+	}
+
+	f, err := os.Open(db.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close database file during snapshot: %v\n", closeErr)
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), f); err != nil {
+		return err
+	}
+	_, err = w.Write(append(append([]byte(nil), snapshotMagic...), h.Sum(nil)...))
+	return err
+}
+
+// RestoreFrom replaces the on-disk database with the provided snapshot stream,
+// rejecting it unless its checksum trailer matches.
+func (db *DB) RestoreFrom(r io.Reader) error {
+	return db.RestoreFromWithOptions(r, RestoreOptions{})
+}
+
+// RestoreFromWithOptions replaces the on-disk database with the provided
+// snapshot stream. Unless opts.InPlace is set, the snapshot is staged and
+// checked in a temp file first, then swapped in atomically via rename, with
+// the directory synced so the swap survives a crash, and the B-Tree reopened.
+func (db *DB) RestoreFromWithOptions(r io.Reader, opts RestoreOptions) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isClosed {
+		return errors.New("database closed")
+	}
+	if db.tree.Sealed() {
+		return btree.ErrSealed
+	}
+	if db.opts.ReadOnly {
+		return btree.ErrReadOnly
+	}
+
+	if opts.InPlace {
+		head := make([]byte, btree.HeaderSize)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return fmt.Errorf("read snapshot header: %w", err)
+		}
+		version, err := btree.HeaderVersion(head)
+		if err != nil {
+			return err
+		}
+		if version == btree.Version {
+			return db.restoreInPlace(head, r, opts)
+		}
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+
+	dir := filepath.Dir(db.path)
+	tmpPath := filepath.Join(dir, ".conure.restore.tmp")
+	if err := stageSnapshot(tmpPath, r, opts); err != nil {
+		if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove temp file after restore error: %v\n", removeErr)
+		}
+		return err
+	}
+
+	// A snapshot from a node running an older format is upgraded before it
+	// replaces ours
+	if err := btree.MigrateFile(tmpPath); err != nil {
+		return err
+	}
+
+	// Close the current tree to release file handles
+	pinned := db.tree.PinnedKeys()
+	if err := db.tree.Close(); err != nil {
+		return err
+	}
+
+	// Atomically and durably replace the db file
+	if err := btree.ReplaceFile(tmpPath, db.path); err != nil {
+		return err
+	}
+
+	// Reopen the tree
+	tree, err := btree.NewBTreeWithOptions(db.path, db.treeOptions())
+	if err != nil {
+		return err
+	}
+	db.tree = tree
+	if err := tree.Pin(pinned); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to pin keys after restore: %v\n", err)
+	}
+
+	return nil
+}
+
+// restoreInPlace overwrites the database file with the snapshot whose header
+// page, already read and checked, is head and whose remainder is r. The
+// header is written last, over a zeroed one, so the file never opens as a
+// mix of the old tree and the new.
+func (db *DB) restoreInPlace(head []byte, r io.Reader, opts RestoreOptions) error {
+	pinned := db.tree.PinnedKeys()
+	if err := db.tree.Close(); err != nil {
+		return err
+	}
+
+	err := writeInPlace(db.path, head, r, opts)
+	if err != nil {
+		// Start over from an empty file rather than serve a partial one
+		if truncErr := os.Truncate(db.path, 0); truncErr != nil {
+			return fmt.Errorf("%w: %w (and failed to empty it: %v)", ErrRestoreIncomplete, err, truncErr)
+		}
+		err = fmt.Errorf("%w: %w", ErrRestoreIncomplete, err)
+	}
+
+	tree, openErr := btree.NewBTreeWithOptions(db.path, db.treeOptions())
+	if openErr != nil {
+		// Nothing may read the closed tree's cache of the old data
+		db.isClosed = true
+		return errors.Join(err, openErr)
+	}
+	db.tree = tree
+	if err != nil {
+		return err
+	}
+	if err := tree.Pin(pinned); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to pin keys after restore: %v\n", err)
+	}
+	return nil
+}
+
+// writeInPlace writes the snapshot head+r over the file at path: a zeroed
+// header page first, then every later page as it arrives, then, once the
+// checksum trailer is verified and stripped and the pages synced, head
+func writeInPlace(path string, head []byte, r io.Reader, opts RestoreOptions) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close database file during restore: %v\n", closeErr)
+		}
+	}()
+
+	if _, err := f.WriteAt(make([]byte, len(head)), 0); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	// The trailer can only be told apart once the stream ends, so the last
+	// bytes seen are held back until then
+	h := sha256.New()
+	h.Write(head)
+	pages := io.NewOffsetWriter(f, int64(len(head)))
+	tail := &tailWriter{w: io.MultiWriter(pages, h), n: snapshotTrailerSize}
+	size, err := io.Copy(tail, r)
+	if err != nil {
+		return err
+	}
+	size += int64(len(head))
+
+	if len(tail.tail) == snapshotTrailerSize && bytes.Equal(tail.tail[:len(snapshotMagic)], snapshotMagic) {
+		if !opts.SkipVerify && !bytes.Equal(h.Sum(nil), tail.tail[len(snapshotMagic):]) {
+			return ErrSnapshotChecksum
+		}
+		size -= snapshotTrailerSize
+	} else if !opts.SkipVerify {
+		return ErrSnapshotNoChecksum
+	} else if _, err := pages.Write(tail.tail); err != nil {
+		return err
+	}
+
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(head, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// tailWriter writes through to w all but the last n bytes written to it,
+// which it keeps in tail
+type tailWriter struct {
+	w    io.Writer
+	n    int
+	tail []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.tail = append(t.tail, p...)
+	if over := len(t.tail) - t.n; over > 0 {
+		if _, err := t.w.Write(t.tail[:over]); err != nil {
+			return 0, err
+		}
+		t.tail = append(t.tail[:0], t.tail[over:]...)
+	}
+	return len(p), nil
+}
+
+// stageSnapshot writes r to path, verifies and strips the checksum trailer,
+// and syncs the result
+func stageSnapshot(path string, r io.Reader, opts RestoreOptions) error {
+	tmpFile, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := tmpFile.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close temp file during restore: %v\n", closeErr)
+		}
+	}()
+
+	size, err := io.Copy(tmpFile, r)
+	if err != nil {
+		return err
+	}
+
+	// The trailer can only be located once the whole stream has arrived
+
+	trailer := make([]byte, snapshotTrailerSize)
+	hasTrailer := false
+	if size >= snapshotTrailerSize {
+		if _, err := tmpFile.ReadAt(trailer, size-snapshotTrailerSize); err != nil {
+			return err
+		}
+		hasTrailer = bytes.Equal(trailer[:len(snapshotMagic)], snapshotMagic)
+	}
+
+	if hasTrailer {
+		if !opts.SkipVerify {
+			sum, err := hashPrefix(tmpFile, size-snapshotTrailerSize)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(sum, trailer[len(snapshotMagic):]) {
+				return ErrSnapshotChecksum
+			}
+		}
+		if err := tmpFile.Truncate(size - snapshotTrailerSize); err != nil {
+			return err
+		}
+	} else if !opts.SkipVerify {
+		return ErrSnapshotNoChecksum
+	}
+
+	return tmpFile.Sync()
+}
+
+// hashPrefix returns the SHA-256 of the first n bytes of f
+func hashPrefix(f *os.File, n int64) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, n)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"iter"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// Versions record when each key was last written, so a write can require
+// the key be unchanged since it was read without comparing whole values.
+// They live beside the keys under reserved names:
+//
+//	\x00v:<key>                 version of <key>, a big-endian uint64
+//	\x00v#<sha256(key)>         the same, for keys too long for the above
+//
+// Only writes through a Versioned handle maintain them; a key written
+// otherwise keeps whatever version it had.
+var (
+	versionPrefix       = []byte("\x00v:")
+	versionHashedPrefix = []byte("\x00v#")
+)
+
+// ErrVersionMismatch is returned by Versioned.PutIfVersion when the key's
+// version is not the expected one
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// KeyMeta describes a stored key
+type KeyMeta struct {
+	// Version is the version the key was last written at through a
+	// Versioned handle, or zero if it never was
+	Version uint64
+}
+
+// versionKey is the reserved key holding key's version
+func versionKey(key []byte) []byte {
+	if len(versionPrefix)+len(key) > btree.MaxKeySize {
+		sum := sha256.Sum256(key)
+		return append(append([]byte(nil), versionHashedPrefix...), sum[:]...)
+	}
+	return append(append([]byte(nil), versionPrefix...), key...)
+}
+
+func encodeVersion(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+// keyVersion reads key's version within tx, zero if it has none
+func keyVersion(tx *btree.Tx, key []byte) (uint64, error) {
+	b, exists, err := tx.Get(versionKey(key))
+	if err != nil || !exists || len(b) != 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// GetWithMeta gets a value along with its metadata
+func (db *DB) GetWithMeta(key []byte) ([]byte, KeyMeta, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.isClosed {
+		return nil, KeyMeta{}, errors.New("database closed")
+	}
+
+	if db.hotKeys != nil {
+		db.hotKeys.observe(key)
+	}
+	val, err := db.tree.Get(key)
+	if err != nil {
+		return nil, KeyMeta{}, err
+	}
+	var meta KeyMeta
+	if b, err := db.tree.Get(versionKey(key)); err == nil && len(b) == 8 {
+		meta.Version = binary.BigEndian.Uint64(b)
+	} else if err != nil && !errors.Is(err, btree.ErrKeyNotFound) {
+		return nil, KeyMeta{}, err
+	}
+	return val, meta, nil
+}
+
+// Versioned is a handle whose writes stamp every key they touch with one
+// version, such as the raft index of the command making them
+type Versioned struct {
+	db      *DB
+	version uint64
+
+	// clock is the encoded clock mark set by AtClock, and stamped whether a
+	// transaction recording it has committed
+	clock   []byte
+	stamped bool
+}
+
+// AtVersion returns a handle writing at version
+func (db *DB) AtVersion(version uint64) *Versioned {
+	return &Versioned{db: db, version: version}
+}
+
+// Put puts a key-value pair and records the handle's version for key
+func (v *Versioned) Put(key, value []byte) error {
+	return v.update(func(tx *btree.Tx) error {
+		return v.put(tx, key, value)
+	})
+}
+
+// PutIfVersion puts a key-value pair only if key is at version expect,
+// failing with ErrVersionMismatch otherwise. A missing key, or one never
+// written through a Versioned handle, is at version zero.
+func (v *Versioned) PutIfVersion(key, value []byte, expect uint64) error {
+	return v.update(func(tx *btree.Tx) error {
+		cur, err := keyVersion(tx, key)
+		if err != nil {
+			return err
+		}
+		if cur != expect {
+			return ErrVersionMismatch
+		}
+		return v.put(tx, key, value)
+	})
+}
+
+// PutReturningOld works like DB.PutReturningOld, recording the version
+func (v *Versioned) PutReturningOld(key, value []byte) (old []byte, existed bool, err error) {
+	err = v.update(func(tx *btree.Tx) error {
+		if old, existed, err = tx.Get(key); err != nil {
+			return err
+		}
+		return v.put(tx, key, value)
+	})
+	return old, existed, err
+}
+
+// Delete deletes key and its version
+func (v *Versioned) Delete(key []byte) error {
+	return v.update(func(tx *btree.Tx) error {
+		return v.db.deleteVersioned(tx, key)
+	})
+}
+
+// DeleteReturningOld works like DB.DeleteReturningOld, dropping the version
+func (v *Versioned) DeleteReturningOld(key []byte) (old []byte, existed bool, err error) {
+	err = v.update(func(tx *btree.Tx) error {
+		if old, existed, err = tx.Get(key); err != nil || !existed {
+			return err
+		}
+		return v.db.deleteVersioned(tx, key)
+	})
+	return old, existed, err
+}
+
+// Txn works like DB.Txn, recording the version for every key ops put and
+// dropping it for every key they delete
+func (v *Versioned) Txn(conds []btree.Cond, ops []btree.Op) (bool, error) {
+	stamped := make([]btree.Op, 0, 2*len(ops))
+	for _, op := range ops {
+		stamped = append(stamped, op)
+		if op.Delete {
+			stamped = append(stamped, btree.Op{Key: versionKey(op.Key), Delete: true})
+		} else {
+			stamped = append(stamped, btree.Op{Key: versionKey(op.Key), Value: encodeVersion(v.version)})
+		}
+	}
+	if v.clock != nil {
+		stamped = append(stamped, btree.Op{Key: clockKey, Value: v.clock})
+	}
+	ok, err := v.db.Txn(conds, stamped)
+	v.stamped = v.stamped || ok
+	return ok, err
+}
+
+func (v *Versioned) put(tx *btree.Tx, key, value []byte) error {
+	if err := v.db.putTx(tx, key, value); err != nil {
+		return err
+	}
+	return tx.Put(versionKey(key), encodeVersion(v.version))
+}
+
+// ReplaceAll works like DB.ReplaceAll, recording the handle's version for
+// every key in items, and its clock mark. Versions the old dataset held go
+// with it, so without this a key written back by the replace would read as
+// never written.
+func (v *Versioned) ReplaceAll(items iter.Seq2[[]byte, []byte]) error {
+	version := encodeVersion(v.version)
+	err := v.db.ReplaceAll(func(yield func(key, value []byte) bool) {
+		for key, value := range items {
+			if isReserved(key) {
+				// Metadata carries no version of its own
+				if !yield(key, value) {
+					return
+				}
+				continue
+			}
+			if !yield(key, value) || !yield(versionKey(key), version) {
+				return
+			}
+		}
+		if v.clock != nil {
+			yield(clockKey, v.clock)
+		}
+	})
+	if err == nil {
+		v.stamped = true
+	}
+	return err
+}
+
+// deleteVersioned deletes key, failing with btree.ErrKeyNotFound if it is
+// missing, and its version if it has one
+func (db *DB) deleteVersioned(tx *btree.Tx, key []byte) error {
+	if err := db.deleteTx(tx, key); err != nil {
+		return err
+	}
+	if err := tx.Delete(versionKey(key)); err != nil && !errors.Is(err, btree.ErrKeyNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// AccessLogOptions configures WithAccessLog
+type AccessLogOptions struct {
+	// Level is the level every request is logged at
+	Level slog.Level
+
+	// Redact replaces the key and prefix of each request with a placeholder.
+	// Values are never logged.
+	Redact bool
+}
+
+// redacted stands in for keys when AccessLogOptions.Redact is set
+const redacted = "[redacted]"
+
+// WithAccessLog logs one structured line per API request to logger: method,
+// path, request ID, key, client IP, status, duration and whether this node
+// was leader.
+// Requests slower than Settings.SlowRequest are logged at warn level with
+// slow=true, and lines below Settings.LogLevel are dropped.
+func (s *Server) WithAccessLog(logger *slog.Logger, opts AccessLogOptions) *Server {
+	s.accessLog = logger
+	s.accessLogOpts = opts
+	return s
+}
+
+// statusRecorder captures the status code and body size a handler writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush
+// streamed responses
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logged wraps an API handler with the access log, if one is configured
+func (s *Server) logged(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = withRequestID(w, r)
+		if s.accessLog == nil {
+			h(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+
+		settings := s.Settings()
+		level := s.accessLogOpts.Level
+		slow := settings.SlowRequest > 0 && elapsed >= settings.SlowRequest
+		if slow && level < slog.LevelWarn {
+			level = slog.LevelWarn
+		}
+		if level < settings.LogLevel {
+			return
+		}
+
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("request_id", requestID(r)),
+		}
+		q := r.URL.Query()
+		for _, field := range []string{"key", "prefix"} {
+			if v := q.Get(field); v != "" {
+				if s.accessLogOpts.Redact {
+					v = redacted
+				}
+				attrs = append(attrs, slog.String(field, v))
+			}
+		}
+		attrs = append(attrs,
+			slog.String("client", client),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Duration("duration", elapsed),
+			slog.Bool("leader", s.node.IsLeader()),
+		)
+		if slow {
+			attrs = append(attrs, slog.Bool("slow", true))
+		}
+		s.accessLog.LogAttrs(r.Context(), level, "request", attrs...)
+	}
+}
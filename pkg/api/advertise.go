@@ -0,0 +1,102 @@
+package api
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// peerHTTP holds the advertised HTTP API base URLs of peers, by raft
+// address. Each node keeps its own, filled in at join time.
+type peerHTTP struct {
+	mu    sync.Mutex
+	addrs map[raft.ServerAddress]string
+}
+
+func (p *peerHTTP) get(addr raft.ServerAddress) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.addrs[addr]
+	return u, ok
+}
+
+func (p *peerHTTP) set(addr raft.ServerAddress, u string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.addrs == nil {
+		p.addrs = make(map[raft.ServerAddress]string)
+	}
+	p.addrs[addr] = u
+}
+
+// WithAdvertiseHTTP sets the base URL clients reach this node's HTTP API at,
+// such as http://conure-1.conure-hs:8081 in Kubernetes, where it cannot be
+// told from the raft address. A bare host:port means http. It is reported
+// in /status and sent to the leader on /join, and nodes that know it send
+// clients there in leader redirects instead of guessing from the raft
+// address.
+func (s *Server) WithAdvertiseHTTP(addr string) *Server {
+	s.advertiseHTTP = httpBaseURL(addr)
+	return s
+}
+
+// AddPeerHTTP records the advertised HTTP API base URLs of peers, by raft
+// address, such as those the leader returns from /join
+func (s *Server) AddPeerHTTP(addrs map[string]string) {
+	for addr, u := range addrs {
+		if addr != "" && u != "" {
+			s.peers.set(raft.ServerAddress(addr), httpBaseURL(u))
+		}
+	}
+}
+
+// peerHTTPAddrs returns every advertised HTTP address this node knows, its
+// own included, by raft address
+func (s *Server) peerHTTPAddrs() map[string]string {
+	s.peers.mu.Lock()
+	out := make(map[string]string, len(s.peers.addrs)+1)
+	for addr, u := range s.peers.addrs {
+		out[string(addr)] = u
+	}
+	s.peers.mu.Unlock()
+	if s.advertiseHTTP != "" {
+		out[string(s.node.Addr())] = s.advertiseHTTP
+	}
+	return out
+}
+
+// advertisedHTTP returns the advertised HTTP API base URL of the server at
+// raft address addr, if this node knows it. A group's server learns no peers
+// of its own: it finds the server by ID in the parent's group, whose HTTP
+// API serves both.
+func (s *Server) advertisedHTTP(addr raft.ServerAddress) (string, bool) {
+	if addr == "" {
+		return "", false
+	}
+	if s.advertiseHTTP != "" && addr == s.node.Addr() {
+		return s.advertiseHTTP, true
+	}
+	if u, ok := s.peers.get(addr); ok || s.parent == nil {
+		return u, ok
+	}
+	id, ok := s.node.ServerID(addr)
+	if !ok {
+		return "", false
+	}
+	parentAddr, ok := s.parent.node.ServerAddr(id)
+	if !ok {
+		return "", false
+	}
+	return s.parent.advertisedHTTP(parentAddr)
+}
+
+// httpBaseURL turns addr, a URL or a bare host:port, into a base URL without
+// a trailing slash
+func httpBaseURL(addr string) string {
+	addr = strings.TrimSuffix(addr, "/")
+	if addr == "" || strings.Contains(addr, "://") {
+		return addr
+	}
+	return "http://" + addr
+}
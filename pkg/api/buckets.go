@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+type bucketInfo struct {
+	Name         string `json:"name"`
+	Keys         uint64 `json:"keys"`
+	Bytes        uint64 `json:"bytes"`
+	MaxKeys      uint64 `json:"max_keys,omitempty"`
+	MaxBytes     uint64 `json:"max_bytes,omitempty"`
+	MaxValueSize uint64 `json:"max_value_size,omitempty"`
+	// Group is the raft group serving the bucket, if not the default
+	Group string `json:"group,omitempty"`
+}
+
+// handleBuckets serves GET /buckets, listing this node's buckets, those of
+// its other raft groups included, with their usage and quotas, and POST /buckets?name=<name>, which creates a bucket
+// through raft and, if any of max_keys, max_bytes or max_value_size is given,
+// sets its quota. Omitted limits are unlimited.
+func (s *Server) handleBuckets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		resp, err := s.bucketInfos()
+		for _, g := range s.groupServers() {
+			if err != nil {
+				break
+			}
+			var more []bucketInfo
+			more, err = g.bucketInfos()
+			resp = append(resp, more...)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		name := r.URL.Query().Get("name")
+		if err := db.ValidateBucketName(name); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		quota, err := parseQuota(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		if !s.node.IsLeader() {
+			s.writeNotLeader(w)
+			return
+		}
+		if !s.admitWrite(w) {
+			return
+		}
+		cmd := raftnode.Command{Type: raftnode.CmdCreateBucket, Bucket: name, Quota: quota, RequestID: requestID(r)}
+		applied, err := s.node.Apply(cmd, s.Settings().ApplyTimeout)
+		if err != nil {
+			w.WriteHeader(applyStatus(err))
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		writeApplied(w, applied)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// bucketInfos lists the buckets in s's database
+func (s *Server) bucketInfos() ([]bucketInfo, error) {
+	_ = s.db.Reload()
+	names, err := s.db.Buckets()
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]bucketInfo, 0, len(names))
+	for _, name := range names {
+		info, err := s.db.BucketInfo(name)
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, bucketInfo{
+			Name:         name,
+			Keys:         info.Usage.Keys,
+			Bytes:        info.Usage.Bytes,
+			MaxKeys:      info.Quota.MaxKeys,
+			MaxBytes:     info.Quota.MaxBytes,
+			MaxValueSize: info.Quota.MaxValueSize,
+			Group:        s.node.GroupID(),
+		})
+	}
+	return resp, nil
+}
+
+// parseQuota reads ?max_keys, ?max_bytes and ?max_value_size, returning nil
+// if none is set
+func parseQuota(r *http.Request) (*db.Quota, error) {
+	q := r.URL.Query()
+	if !q.Has("max_keys") && !q.Has("max_bytes") && !q.Has("max_value_size") {
+		return nil, nil
+	}
+	var quota db.Quota
+	for _, f := range []struct {
+		name string
+		dst  *uint64
+	}{{"max_keys", &quota.MaxKeys}, {"max_bytes", &quota.MaxBytes}, {"max_value_size", &quota.MaxValueSize}} {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s", f.name)
+		}
+		*f.dst = n
+	}
+	if err := quota.Validate(); err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
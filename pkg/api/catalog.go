@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+type catalogItem struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+}
+
+type catalogResponse struct {
+	Items     []catalogItem `json:"items"`
+	Truncated bool          `json:"truncated"`
+	Next      string        `json:"next,omitempty"`
+}
+
+// handleCatalog serves GET /catalog?prefix=&start=&limit=&cursor=: the keys
+// under prefix with the size of each value, for storage analysis without
+// transferring the values. It pages like /scan.
+func (s *Server) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefix := []byte(r.URL.Query().Get("prefix"))
+	if !s.authorize(w, r, false, prefix) {
+		return
+	}
+	start, limit, ok := s.parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	// Refresh header to reflect external updates (e.g., local REPL)
+	_ = s.db.Reload()
+
+	if !s.readReady(w, r) {
+		return
+	}
+
+	// Fetch one extra key to learn whether the listing was cut short
+	op := s.StartOperation(r.Context(), "catalog")
+	keys, err := s.db.CatalogWithProgress(prefix, start, limit+1, op.Progress())
+	op.Finish()
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+
+	resp := catalogResponse{Items: make([]catalogItem, 0, len(keys))}
+	if len(keys) > limit {
+		resp.Truncated = true
+		resp.Next = base64.RawURLEncoding.EncodeToString(keys[limit].Key)
+		keys = keys[:limit]
+	}
+	for _, k := range keys {
+		resp.Items = append(resp.Items, catalogItem{Key: string(k.Key), Size: k.Size})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// clusterMember is one server in /cluster
+type clusterMember struct {
+	ID          string `json:"id"`
+	RaftAddress string `json:"raft_address"`
+	HTTPAddress string `json:"http_address"`
+	Suffrage    string `json:"suffrage"`
+	Leader      bool   `json:"leader"`
+}
+
+// handleCluster serves GET /cluster: every member of the raft configuration
+// with the base URL of its HTTP API, resolved as for the leader (see
+// WithLeaderHTTP). Clients use it to send writes and linearizable reads to
+// the leader and spread stale reads over the followers.
+func (s *Server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	f := s.node.Raft().GetConfiguration()
+	if err := f.Error(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	leader := s.node.Leader()
+	members := []clusterMember{}
+	for _, sv := range f.Configuration().Servers {
+		members = append(members, clusterMember{
+			ID:          string(sv.ID),
+			RaftAddress: string(sv.Address),
+			HTTPAddress: s.leaderBaseURL(sv.Address, r),
+			Suffrage:    suffrageToString(sv.Suffrage),
+			Leader:      sv.Address == leader,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"members": members})
+}
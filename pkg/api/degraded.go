@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// degradedRetryAfter is the Retry-After, in seconds, on writes refused while
+// the node serves degraded reads
+const degradedRetryAfter = 1
+
+// WithDegradedReads keeps the node serving reads when the cluster has no
+// leader, as after losing quorum: every read is answered from local data as
+// if stale=true, flagged with X-Conure-Degraded: true, and writes get 503
+// instead of a 409 naming a leader that does not exist.
+func (s *Server) WithDegradedReads() *Server {
+	s.degradedReads = true
+	return s
+}
+
+// Degraded reports whether the node is serving degraded reads: they are
+// enabled, the API is ready and no leader is known
+func (s *Server) Degraded() bool {
+	return s.degradedReads && !s.starting.Load() && s.node.Leader() == ""
+}
+
+// serveDegraded reports whether a read must be served locally because the
+// node is degraded, flagging the response if so
+func (s *Server) serveDegraded(w http.ResponseWriter) bool {
+	if !s.Degraded() {
+		return false
+	}
+	w.Header().Set("X-Conure-Degraded", "true")
+	return true
+}
+
+// whenWritable answers writes with 503 and Retry-After while the node is
+// degraded, passing reads through to h
+func (s *Server) whenWritable(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && s.Degraded() {
+			w.Header().Set("X-Conure-Degraded", "true")
+			w.Header().Set("Retry-After", strconv.Itoa(degradedRetryAfter))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("no leader: serving reads only\n"))
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// WithMinFreeDisk makes the leader refuse writes with 507 Insufficient
+// Storage while its disk has fewer than n bytes free. The check runs before
+// a write is proposed: once raft commits an entry every replica must apply
+// it, so the FSM itself never rejects for space.
+func (s *Server) WithMinFreeDisk(n uint64) *Server {
+	s.minFreeDisk = n
+	return s
+}
+
+// admitWrite answers 503 and returns false while the node is drained, and
+// 507 when the disk is below the configured minimum. Platforms that cannot
+// report free space admit writes.
+func (s *Server) admitWrite(w http.ResponseWriter) bool {
+	if !s.admitDrained(w) {
+		return false
+	}
+	if s.minFreeDisk == 0 {
+		return true
+	}
+	free, err := s.db.FreeSpace()
+	if err != nil || free >= s.minFreeDisk {
+		return true
+	}
+	w.WriteHeader(http.StatusInsufficientStorage)
+	_, _ = w.Write([]byte(fmt.Sprintf("%v: %d bytes free, %d required\n", btree.ErrDiskFull, free, s.minFreeDisk)))
+	return false
+}
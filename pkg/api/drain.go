@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// drainRetryAfter is the Retry-After, in seconds, on writes refused while
+// draining
+const drainRetryAfter = 5
+
+// Drained reports whether this node refuses writes for maintenance
+func (s *Server) Drained() bool {
+	if s.parent != nil {
+		return s.parent.Drained()
+	}
+	return s.drained.Load()
+}
+
+// handleDrain serves POST /admin/drain and /admin/undrain. A drained node
+// keeps serving reads but answers writes with 503 and Retry-After, so it can
+// be quiesced before maintenance. With ?transfer=true a drained leader also
+// hands leadership to another voter, so the cluster goes on taking writes.
+func (s *Server) handleDrain(drain bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.checkAdminToken(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.drained.Store(drain)
+
+		transfer := r.URL.Query().Get("transfer")
+		if drain && (strings.EqualFold(transfer, "true") || transfer == "1") && s.node.IsLeader() {
+			if err := s.node.Raft().LeadershipTransfer().Error(); err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte("drained, but leadership transfer failed: " + err.Error() + "\n"))
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]bool{"drained": drain})
+	}
+}
+
+// admitDrained answers 503 with Retry-After and returns false while the node
+// is drained
+func (s *Server) admitDrained(w http.ResponseWriter) bool {
+	if !s.Drained() {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(drainRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write([]byte("node is drained for maintenance\n"))
+	return false
+}
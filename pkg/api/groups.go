@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// WithGroup serves buckets from another raft group: /kv requests with
+// ?bucket= one of buckets, and POST /buckets?name= one of them, go to node
+// and database instead of this server's, so writes to them replicate and
+// commit independently of the rest. Other requests, and buckets not given
+// to a group, stay with this server's node. Each group's node must run with
+// its own Config.GroupID and database. The group serves with the options
+// this server has when Register is called, so every With call must come
+// before Register, whether before or after WithGroup; later ones reach only
+// this server. Settings are read from this server on every request.
+func (s *Server) WithGroup(node *raftnode.Node, database *db.DB, buckets ...string) *Server {
+	g := New(node, database)
+	g.parent = s
+	if s.groups == nil {
+		s.groups = make(map[string]*Server)
+	}
+	for _, bucket := range buckets {
+		s.groups[bucket] = g
+	}
+	return s
+}
+
+// groupServers returns the distinct servers of the other groups, in no
+// particular order
+func (s *Server) groupServers() []*Server {
+	seen := make(map[*Server]bool)
+	var out []*Server
+	for _, g := range s.groups {
+		if !seen[g] {
+			seen[g] = true
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// inherit copies the options of parent, the server g serves a group for.
+// It runs once, when parent's handlers are registered.
+func (g *Server) inherit(parent *Server) {
+	g.maxScanResults = parent.maxScanResults
+	g.minFreeDisk = parent.minFreeDisk
+	g.leaderHTTP = parent.leaderHTTP
+	g.acl = parent.acl
+	g.adminToken = parent.adminToken
+	g.maxTxnOps = parent.maxTxnOps
+	g.maxTxnBytes = parent.maxTxnBytes
+	g.degradedReads = parent.degradedReads
+	g.advertiseHTTP = parent.advertiseHTTP
+}
+
+// byBucket returns a handler serving a request whose query parameter param
+// names a bucket of another group with that group's server, and any other
+// with s. h builds the handler for each server.
+func (s *Server) byBucket(param string, h func(*Server) http.HandlerFunc) http.HandlerFunc {
+	own := h(s)
+	if len(s.groups) == 0 {
+		return own
+	}
+	handlers := make(map[*Server]http.HandlerFunc)
+	for _, g := range s.groupServers() {
+		g.inherit(s)
+		handlers[g] = h(g)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g, ok := s.groups[r.URL.Query().Get(param)]; ok {
+			handlers[g](w, r)
+			return
+		}
+		own(w, r)
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// importResponse is what POST /admin/import reports. An import that fails
+// after committing a batch reports Partial and the Error that stopped it.
+type importResponse struct {
+	Mode     string `json:"mode"`
+	Imported int    `json:"imported"`
+	Batches  int    `json:"batches"`
+	Index    uint64 `json:"index"`
+	Partial  bool   `json:"partial,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleImport serves POST /admin/import?mode=merge|replace, loading the
+// body's key,value CSV rows, as /export writes them, on every node. Rows are
+// shipped through raft in batches sized to fit a log entry, so followers
+// apply exactly what the leader does. mode=replace first drops every key
+// along with the first batch, in one entry; mode=merge keeps keys not in the
+// body. Add base64=true for base64 keys and values.
+//
+// The import is not atomic. One that fails before any batch commits changes
+// nothing and reports a plain error. One that fails later keeps the batches
+// committed before it, which after mode=replace leaves only those rows, so it
+// answers with the error status and the usual JSON marked partial, for the
+// client to retry the whole import.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode != "merge" && mode != "replace" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("mode must be merge or replace\n"))
+		return
+	}
+	if !s.node.IsLeader() {
+		s.writeNotLeader(w)
+		return
+	}
+	if !s.admitWrite(w) {
+		return
+	}
+
+	op := s.StartOperation(r.Context(), "import")
+	defer op.Finish()
+	resp := importResponse{Mode: mode}
+	opts := db.CSVOptions{
+		Base64: r.URL.Query().Get("base64") == "true",
+		// JSON encodes keys and values as base64, a third larger
+		BatchBytes: s.node.MaxCommandBytes() / 2,
+	}
+	var last raftnode.Applied
+	var applyErr error
+	err := db.ReadCSV(r.Body, opts, func(ops []btree.Op) error {
+		if err := op.Context().Err(); err != nil {
+			return err
+		}
+		for _, o := range ops {
+			if reservedKey(o.Key) != nil {
+				return errors.New(errReservedKey)
+			}
+		}
+		cmd := raftnode.Command{Type: raftnode.CmdTxn, Ops: ops, RequestID: requestID(r)}
+		if mode == "replace" && resp.Batches == 0 {
+			cmd.Type = raftnode.CmdReplaceAll
+		}
+		applied, err := s.node.Apply(cmd, s.Settings().ApplyTimeout)
+		if err != nil {
+			applyErr = err
+			return err
+		}
+		resp.Imported += len(ops)
+		resp.Batches++
+		last = applied
+		op.Report(resp.Imported, 0)
+		return nil
+	})
+	// An empty body still replaces the dataset with nothing
+	if err == nil && mode == "replace" && resp.Batches == 0 {
+		last, err = s.node.Apply(raftnode.Command{Type: raftnode.CmdReplaceAll, RequestID: requestID(r)}, s.Settings().ApplyTimeout)
+		applyErr = err
+		if err == nil {
+			resp.Batches = 1
+		}
+	}
+	status := http.StatusOK
+	if err != nil {
+		switch {
+		case applyErr != nil:
+			status = applyStatus(applyErr)
+		case op.Context().Err() != nil:
+			status = http.StatusServiceUnavailable
+		default:
+			status = http.StatusBadRequest
+		}
+		if resp.Batches == 0 {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(fmt.Sprintf("%v (nothing imported)\n", err)))
+			return
+		}
+		resp.Partial, resp.Error = true, err.Error()
+	}
+	resp.Index = last.Index
+	setAppliedHeaders(w, last)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// maxLeaseBody bounds a lease request body, which holds a name, a holder
+// and a few numbers
+const maxLeaseBody = 4 << 10
+
+type leaseRequest struct {
+	Name   string `json:"name"`
+	Holder string `json:"holder"`
+	TTL    string `json:"ttl"`
+	Token  uint64 `json:"token"`
+}
+
+type leaseResponse struct {
+	Acquired bool      `json:"acquired"`
+	Name     string    `json:"name"`
+	Holder   string    `json:"holder"`
+	Token    uint64    `json:"token"`
+	Expires  time.Time `json:"expires"`
+	Index    uint64    `json:"index,omitempty"`
+}
+
+// handleLease serves the named leases: POST /lease/acquire takes
+// {"name","holder","ttl"} and answers 200 with acquired=false and the
+// current holder when someone else holds it; POST /lease/renew and
+// /lease/release take {"name","holder","token"}, with a ttl for renew, and
+// answer 412 unless the caller still holds the lease with that token. GET
+// /lease?name= returns the lease if it is held. Leases lapse by the leader's
+// clock as stamped on each command, so expiry needs no sweeper. ACLs treat
+// the lease name as a key.
+func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/lease"), "/")
+	if action == "" {
+		s.handleGetLease(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cmd := raftnode.Command{RequestID: requestID(r)}
+	switch action {
+	case "acquire":
+		cmd.Type = raftnode.CmdLeaseAcquire
+	case "renew":
+		cmd.Type = raftnode.CmdLeaseRenew
+	case "release":
+		cmd.Type = raftnode.CmdLeaseRelease
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !s.node.IsLeader() {
+		s.writeNotLeader(w)
+		return
+	}
+	if !s.admitWrite(w) {
+		return
+	}
+
+	var req leaseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLeaseBody)).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if cmd.Type != raftnode.CmdLeaseRelease {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("ttl must be a positive duration, e.g. 10s\n"))
+			return
+		}
+		cmd.TTL = ttl
+	}
+	cmd.Key, cmd.Holder, cmd.Token = []byte(req.Name), req.Holder, req.Token
+	if !s.authorize(w, r, true, []byte(req.Name)) {
+		return
+	}
+
+	applied, err := s.node.Apply(cmd, s.Settings().ApplyTimeout)
+	if err != nil {
+		w.WriteHeader(applyStatus(err))
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	setAppliedHeaders(w, applied)
+	result, ok := applied.Response.(raftnode.LeaseResult)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK\n"))
+		return
+	}
+	l := result.Lease
+	resp := leaseResponse{Acquired: result.Acquired, Name: l.Name, Holder: l.Holder, Token: l.Token, Expires: l.Expires, Index: applied.Index}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleGetLease serves GET /lease?name=, answering 404 if the lease is
+// free. Expiry is judged by this node's clock.
+func (s *Server) handleGetLease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if !s.authorize(w, r, false, []byte(name)) {
+		return
+	}
+	_ = s.db.Reload()
+	if !s.readReady(w, r) {
+		return
+	}
+	lease, err := s.db.GetLease(name, time.Now())
+	if errors.Is(err, db.ErrLeaseNotHeld) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(lease)
+}
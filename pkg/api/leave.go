@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// leaveTimeout bounds each step of /leave: the leadership transfer, waiting
+// for another leader, and the removal request
+const leaveTimeout = 10 * time.Second
+
+// WithLeaderHTTP sets how /leave and read_index reads turn the leader's raft
+// address into the base URL of its HTTP API, and /cluster that of every
+// member. By default it keeps the raft address's host and uses the port this
+// node was reached on, as the REPL does.
+func (s *Server) WithLeaderHTTP(fn func(leader raft.ServerAddress) string) *Server {
+	s.leaderHTTP = fn
+	return s
+}
+
+// handleLeave serves POST /leave, removing this node from the cluster. A
+// leader first hands leadership to another voter; the node then asks the
+// leader to remove it and answers once the removal has committed, after
+// which it is safe to shut down. The last voter cannot leave.
+func (s *Server) handleLeave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	f := s.node.Raft().GetConfiguration()
+	if err := f.Error(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	self := s.node.RaftConfig().LocalID
+	var selfAddr raft.ServerAddress
+	member, otherVoters := false, 0
+	for _, sv := range f.Configuration().Servers {
+		switch {
+		case sv.ID == self:
+			member, selfAddr = true, sv.Address
+		case sv.Suffrage == raft.Voter:
+			otherVoters++
+		}
+	}
+	if !member {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK\n"))
+		return
+	}
+	if otherVoters == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("cannot leave: this node is the last voter\n"))
+		return
+	}
+
+	if s.node.IsLeader() {
+		if err := s.node.Raft().LeadershipTransfer().Error(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("leadership transfer failed: " + err.Error() + "\n"))
+			return
+		}
+	}
+
+	leader := s.waitOtherLeader(selfAddr)
+	if leader == "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("no other leader emerged\n"))
+		return
+	}
+
+	base := s.leaderBaseURL(leader, r)
+	if err := requestRemoval(base, string(self)); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte(fmt.Sprintf("leader %s did not remove this node: %v\n", leader, err)))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK\n"))
+}
+
+// waitOtherLeader waits up to leaveTimeout for a leader other than self and
+// returns its address, or "" if none emerged
+func (s *Server) waitOtherLeader(self raft.ServerAddress) raft.ServerAddress {
+	deadline := time.Now().Add(leaveTimeout)
+	for {
+		if leader := s.node.Leader(); leader != "" && leader != self && !s.node.IsLeader() {
+			return leader
+		}
+		if time.Now().After(deadline) {
+			return ""
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// leaderBaseURL resolves the HTTP API of the leader, or any member, from its
+// raft address: its advertised address if known (see WithAdvertiseHTTP),
+// else as WithLeaderHTTP says
+func (s *Server) leaderBaseURL(leader raft.ServerAddress, r *http.Request) string {
+	if u, ok := s.advertisedHTTP(leader); ok {
+		return u
+	}
+	if s.leaderHTTP != nil {
+		return s.leaderHTTP(leader)
+	}
+	host := string(leader)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	port := "80"
+	if _, p, err := net.SplitHostPort(r.Host); err == nil {
+		port = p
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// requestRemoval posts id to the leader's /remove, which answers once the
+// configuration change has committed
+func requestRemoval(base, id string) error {
+	body, err := json.Marshal(map[string]string{"ID": id})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: leaveTimeout}
+	resp, err := client.Post(strings.TrimRight(base, "/")+"/remove", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
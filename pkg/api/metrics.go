@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// writeGauge emits one gauge family in the Prometheus text exposition format.
+// Each sample is keyed by its label string, e.g. `peer="node2"` or "".
+func writeGauge(w io.Writer, name, help string, samples map[string]float64) {
+	writeFamily(w, "gauge", name, help, samples)
+}
+
+// writeCounter emits one counter family; see writeGauge
+func writeCounter(w io.Writer, name, help string, samples map[string]float64) {
+	writeFamily(w, "counter", name, help, samples)
+}
+
+func writeFamily(w io.Writer, kind, name, help string, samples map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	keys := make([]string, 0, len(samples))
+	for labels := range samples {
+		keys = append(keys, labels)
+	}
+	sort.Strings(keys)
+	for _, labels := range keys {
+		v := samples[labels]
+		if labels == "" {
+			fmt.Fprintf(w, "%s %g\n", name, v)
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s} %g\n", name, labels, v)
+	}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeGauge(w, "conure_raft_is_leader", "Whether this node is the raft leader.",
+		map[string]float64{"": boolGauge(s.node.IsLeader())})
+
+	lag := make(map[string]float64)
+	for _, p := range s.node.Replication() {
+		lag[fmt.Sprintf("peer=%q", p.ID)] = float64(p.Lag)
+	}
+	writeGauge(w, "conure_raft_replication_lag", "Log entries a follower trails the leader by (leader only).", lag)
+	writeGauge(w, "conure_raft_dead_peers", "Servers that have not answered the leader for dead_node_timeout (leader only).",
+		map[string]float64{"": float64(len(s.node.DeadPeers()))})
+
+	if stats, err := s.db.Stats(false); err == nil {
+		writeCounter(w, "conure_node_cache_hits_total", "Page reads served from the node cache.",
+			map[string]float64{"": float64(stats.CacheHits)})
+		writeCounter(w, "conure_node_cache_misses_total", "Page reads that went to the database file.",
+			map[string]float64{"": float64(stats.CacheMisses)})
+	}
+
+	dirty := s.db.DirtyStats()
+	writeGauge(w, "conure_dirty_pages", "Pages write transactions in progress hold in memory until they commit.",
+		map[string]float64{"": float64(dirty.Pages)})
+	writeCounter(w, "conure_dirty_spills_total", "Times a write transaction over the dirty page cap wrote its pages out before committing.",
+		map[string]float64{"": float64(dirty.Spills)})
+	writeCounter(w, "conure_dirty_spilled_pages_total", "Pages written out before their transaction committed.",
+		map[string]float64{"": float64(dirty.SpilledPages)})
+
+	scrub := s.db.ScrubStats()
+	writeCounter(w, "conure_scrub_passes_total", "Completed background scrub passes over the database file.",
+		map[string]float64{"": float64(scrub.Passes)})
+	writeCounter(w, "conure_scrub_pages_total", "Pages read back and checked by the background scrubber.",
+		map[string]float64{"": float64(scrub.Pages)})
+	writeCounter(w, "conure_scrub_corrupt_pages_total", "Corrupt pages found by the background scrubber, counted on every pass that finds them.",
+		map[string]float64{"": float64(scrub.CorruptPages)})
+}
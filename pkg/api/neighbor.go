@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// handleNeighbor serves GET /kv/next?key= and GET /kv/prev?key= with the key
+// just after (or before) key and its value, or 404 when there is none. The
+// key need not exist, so a client can step through the keys one at a time
+// from anywhere without a scan.
+func (s *Server) handleNeighbor(next bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		key := []byte(r.URL.Query().Get("key"))
+		if len(key) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("missing key\n"))
+			return
+		}
+		if !s.authorize(w, r, false, key) {
+			return
+		}
+
+		// Refresh header to reflect external updates (e.g., local REPL)
+		_ = s.db.Reload()
+
+		if !s.readReady(w, r) {
+			return
+		}
+
+		step := s.db.Prev
+		if next {
+			step = s.db.Next
+		}
+		item, err := step(key)
+		if errors.Is(err, btree.ErrKeyNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		if err != nil {
+			writeOpError(w, err)
+			return
+		}
+		// The neighbor may lie outside the prefixes the token may read
+		if !s.authorize(w, r, false, item.Key) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(scanItem{Key: string(item.Key), Value: string(item.Value)})
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// Operation is a long-running task listed under /admin/ops, where an
+// operator can follow its progress and cancel it
+type Operation struct {
+	seq       uint64
+	id        string
+	kind      string
+	requestID string
+	started   time.Time
+	done      atomic.Int64
+	total     atomic.Int64
+	ctx       context.Context
+	cancel    context.CancelFunc
+	registry  *operations
+}
+
+// operations is the registry of running operations
+type operations struct {
+	mu     sync.Mutex
+	nextID uint64
+	ops    map[string]*Operation
+}
+
+// opJSON is one operation in the /admin/ops listing
+type opJSON struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	RequestID string    `json:"request_id,omitempty"`
+	Started   time.Time `json:"started"`
+	Done      int64     `json:"done"`
+	Total     int64     `json:"total"`
+	Percent   *float64  `json:"percent,omitempty"`
+	Cancelled bool      `json:"cancelled"`
+}
+
+// StartOperation registers a long-running task of the given kind, e.g.
+// "compact", whose context is cancelled by ctx or through /admin/ops. The
+// caller must call Finish when it is done.
+func (s *Server) StartOperation(ctx context.Context, kind string) *Operation {
+	op := &Operation{kind: kind, started: time.Now(), registry: &s.ops}
+	op.ctx, op.cancel = context.WithCancel(ctx)
+	op.requestID, _ = ctx.Value(requestIDKey{}).(string)
+
+	s.ops.mu.Lock()
+	defer s.ops.mu.Unlock()
+	if s.ops.ops == nil {
+		s.ops.ops = make(map[string]*Operation)
+	}
+	s.ops.nextID++
+	op.seq = s.ops.nextID
+	op.id = strconv.FormatUint(op.seq, 10)
+	s.ops.ops[op.id] = op
+	return op
+}
+
+// Context is cancelled when the operation is
+func (op *Operation) Context() context.Context {
+	return op.ctx
+}
+
+// Report records how much of the operation is done; total is 0 if unknown
+func (op *Operation) Report(done, total int) {
+	op.done.Store(int64(done))
+	op.total.Store(int64(total))
+}
+
+// Progress returns a btree.Progress that reports to and is cancelled with
+// the operation
+func (op *Operation) Progress() btree.Progress {
+	return btree.Progress{Context: op.ctx, Report: op.Report}
+}
+
+// Finish removes the operation from the registry and releases its context
+func (op *Operation) Finish() {
+	op.registry.mu.Lock()
+	delete(op.registry.ops, op.id)
+	op.registry.mu.Unlock()
+	op.cancel()
+}
+
+func (op *Operation) json() opJSON {
+	out := opJSON{
+		ID:        op.id,
+		Kind:      op.kind,
+		RequestID: op.requestID,
+		Started:   op.started,
+		Done:      op.done.Load(),
+		Total:     op.total.Load(),
+		Cancelled: op.ctx.Err() != nil,
+	}
+	if out.Total > 0 {
+		percent := float64(out.Done) * 100 / float64(out.Total)
+		out.Percent = &percent
+	}
+	return out
+}
+
+// handleOps serves GET /admin/ops, listing running operations oldest first,
+// and POST /admin/ops/{id}/cancel
+func (s *Server) handleOps(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminToken(w, r) {
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/ops"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.ops.mu.Lock()
+		list := make([]*Operation, 0, len(s.ops.ops))
+		for _, op := range s.ops.ops {
+			list = append(list, op)
+		}
+		s.ops.mu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+
+		out := make([]opJSON, 0, len(list))
+		for _, op := range list {
+			out = append(out, op.json())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+		return
+	}
+
+	id, ok := strings.CutSuffix(rest, "/cancel")
+	if !ok || strings.Contains(id, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.ops.mu.Lock()
+	op := s.ops.ops[id]
+	s.ops.mu.Unlock()
+	if op == nil {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("no such operation\n"))
+		return
+	}
+	op.cancel()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(op.json())
+}
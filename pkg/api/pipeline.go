@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// pipelineWindow caps the writes one /kv/pipeline request has in flight
+const pipelineWindow = 256
+
+// pipelineFrame is one write in a /kv/pipeline request body
+type pipelineFrame struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Delete bool   `json:"delete"`
+	Bucket string `json:"bucket"`
+}
+
+// pipelineResult answers the frame at position Seq in the request
+type pipelineResult struct {
+	Seq    int    `json:"seq"`
+	Status int    `json:"status"`
+	Index  uint64 `json:"index,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handlePipeline serves POST /kv/pipeline: the body is a stream of JSON
+// write frames, and each is handed to raft as soon as it is read without
+// waiting for the ones before it to commit. A result line goes back for
+// every frame once its write is applied, so each write is still confirmed
+// individually. Results carry the frame's 0-based position, since a
+// rejected frame can be answered ahead of writes still in flight.
+func (s *Server) handlePipeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.node.IsLeader() {
+		s.writeNotLeader(w)
+		return
+	}
+	if !s.admitWrite(w) {
+		return
+	}
+	// Refuse an unknown token up front; prefixes are checked per frame
+	if len(s.acl) > 0 && s.aclRule(r) == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte("missing or unknown token\n"))
+		return
+	}
+
+	// Answer while the body is still being read
+	rc := http.NewResponseController(w)
+	_ = rc.EnableFullDuplex()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	var (
+		mu  sync.Mutex
+		enc = json.NewEncoder(w)
+		wg  sync.WaitGroup
+	)
+	respond := func(res pipelineResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(res)
+		_ = rc.Flush()
+	}
+	window := make(chan struct{}, pipelineWindow)
+	applyTimeout := s.Settings().ApplyTimeout
+
+	dec := json.NewDecoder(r.Body)
+	for seq := 0; ; seq++ {
+		var frame pipelineFrame
+		if err := dec.Decode(&frame); err != nil {
+			if !errors.Is(err, io.EOF) {
+				respond(pipelineResult{Seq: seq, Status: http.StatusBadRequest, Error: err.Error()})
+			}
+			break
+		}
+		cmd, code, msg := s.pipelineCommand(r, frame)
+		if code != 0 {
+			respond(pipelineResult{Seq: seq, Status: code, Error: msg})
+			continue
+		}
+
+		window <- struct{}{}
+		pending := s.node.ApplyAsync(cmd, applyTimeout)
+		wg.Add(1)
+		go func(seq int) {
+			defer wg.Done()
+			defer func() { <-window }()
+			applied, err := pending.Wait()
+			if err != nil {
+				respond(pipelineResult{Seq: seq, Status: applyStatus(err), Error: err.Error()})
+				return
+			}
+			respond(pipelineResult{Seq: seq, Status: http.StatusOK, Index: applied.Index})
+		}(seq)
+	}
+	wg.Wait()
+}
+
+// pipelineCommand turns frame into a raft command, or returns the status
+// code and message to reject it with
+func (s *Server) pipelineCommand(r *http.Request, frame pipelineFrame) (raftnode.Command, int, string) {
+	if frame.Key == "" {
+		return raftnode.Command{}, http.StatusBadRequest, "missing key"
+	}
+	if frame.Bucket != "" {
+		if err := db.ValidateBucketName(frame.Bucket); err != nil {
+			return raftnode.Command{}, http.StatusBadRequest, err.Error()
+		}
+	}
+	key := []byte(frame.Key)
+	if code, msg := s.checkACL(r, true, key); code != 0 {
+		return raftnode.Command{}, code, msg
+	}
+	cmd := raftnode.Command{Type: raftnode.CmdPut, Key: key, Value: []byte(frame.Value), Bucket: frame.Bucket, RequestID: requestID(r)}
+	if frame.Delete {
+		cmd.Type = raftnode.CmdDelete
+		cmd.Value = nil
+	}
+	return cmd, 0, ""
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/raft"
+)
+
+// handleReadIndex serves GET /raft/readindex: the leader confirms with a
+// quorum that it still leads and returns the index a follower must apply
+// before serving a linearizable read
+func (s *Server) handleReadIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	index, err := s.node.ReadIndex()
+	if errors.Is(err, raft.ErrNotLeader) {
+		s.writeNotLeader(w)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]uint64{"index": index})
+}
+
+// readIndexRequested reports whether a follower read asked for ?read_index=true
+func readIndexRequested(r *http.Request) bool {
+	v := r.URL.Query().Get("read_index")
+	return strings.EqualFold(v, "true") || v == "1"
+}
+
+// waitReadIndex makes a follower read linearizable: it asks the leader for
+// its read index and waits until this node has applied it. It answers 503
+// and returns false if there is no leader, the leader cannot confirm its
+// lease, or this node does not catch up within the barrier timeout.
+func (s *Server) waitReadIndex(w http.ResponseWriter, r *http.Request) bool {
+	leader := s.node.Leader()
+	if leader == "" {
+		w.Header().Set("Retry-After", strconv.Itoa(noLeaderRetryAfter))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("no leader known: retry later\n"))
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.Settings().BarrierTimeout)
+	defer cancel()
+
+	index, err := fetchReadIndex(ctx, s.leaderBaseURL(leader, r))
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("read index: " + err.Error() + "\n"))
+		return false
+	}
+	if err := s.node.WaitForApplied(ctx, index); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(fmt.Sprintf("applied index %d is behind read index %d\n", s.node.Raft().AppliedIndex(), index)))
+		return false
+	}
+	return true
+}
+
+// fetchReadIndex asks the leader at base for its read index
+func fetchReadIndex(ctx context.Context, base string) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(base, "/")+"/raft/readindex", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var body struct {
+		Index uint64 `json:"index"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.Index, nil
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// startingRetryAfter is the Retry-After, in seconds, on requests refused
+// while the node waits for a leader
+const startingRetryAfter = 1
+
+// noLeaderRetryAfter is the Retry-After, in seconds, on requests for the
+// leader made while no leader is known
+const noLeaderRetryAfter = 1
+
+// WithWaitForLeader holds the API back while the node starts: until it knows
+// a leader, every endpoint but /healthz, /status, /version and /metrics
+// answers 503 with Retry-After, rather than the errors of a node with no
+// leader. Once a leader has been seen, or timeout has passed since this call,
+// the API serves normally for good. A zero timeout waits as long as it takes.
+func (s *Server) WithWaitForLeader(timeout time.Duration) *Server {
+	s.starting.Store(true)
+	s.startDeadline = time.Time{}
+	if timeout > 0 {
+		s.startDeadline = time.Now().Add(timeout)
+	}
+	return s
+}
+
+// Ready reports whether the API serves normally: the node has known a
+// leader, or was not asked to wait for one
+func (s *Server) Ready() bool {
+	if !s.starting.Load() {
+		return true
+	}
+	if s.node.Leader() != "" || (!s.startDeadline.IsZero() && time.Now().After(s.startDeadline)) {
+		s.starting.Store(false)
+		return true
+	}
+	return false
+}
+
+// whenReady answers 503 with Retry-After instead of calling h until Ready
+func (s *Server) whenReady(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.Ready() {
+			w.Header().Set("Retry-After", strconv.Itoa(startingRetryAfter))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("node is starting: no leader yet\n"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writeNotLeader answers a request only the leader may serve: 409 naming the
+// leader to retry against, by raft address and, if this node knows it, by
+// advertised HTTP address as leader_http, or 503 with Retry-After while no leader is known,
+// as during an election, so clients wait rather than follow an empty hint
+func (s *Server) writeNotLeader(w http.ResponseWriter) {
+	leader := s.node.Leader()
+	if leader == "" {
+		w.Header().Set("Retry-After", strconv.Itoa(noLeaderRetryAfter))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("no leader known: retry later\n"))
+		return
+	}
+	hint := map[string]string{"leader": string(leader)}
+	if u, ok := s.advertisedHTTP(leader); ok {
+		hint["leader_http"] = u
+	}
+	w.WriteHeader(http.StatusConflict)
+	_ = json.NewEncoder(w).Encode(hint)
+}
+
+// handleHealthz serves GET /healthz, for readiness probes: 200 once the API
+// serves normally, 503 while it waits for a leader. A degraded node stays
+// ready, as it still serves reads, and says so.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ready := s.Ready()
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]bool{"ready": ready, "degraded": s.Degraded()})
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+type replaceItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type replaceRequest struct {
+	Items []replaceItem `json:"items"`
+}
+
+// handleReplace serves POST /admin/replace: the body's items replace every
+// key on every node, through one raft entry, so readers see the old dataset
+// or the new one but never a mix. The /txn limits apply to the item count
+// and body size.
+func (s *Server) handleReplace(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.node.IsLeader() {
+		s.writeNotLeader(w)
+		return
+	}
+	if !s.admitWrite(w) {
+		return
+	}
+
+	var req replaceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxTxnBytes)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write([]byte(fmt.Sprintf("replace body exceeds %d bytes\n", s.maxTxnBytes)))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if len(req.Items) > s.maxTxnOps {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = w.Write([]byte(fmt.Sprintf("replace has %d items, limit is %d\n", len(req.Items), s.maxTxnOps)))
+		return
+	}
+
+	cmd := raftnode.Command{Type: raftnode.CmdReplaceAll, RequestID: requestID(r)}
+	for _, item := range req.Items {
+		if item.Key == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("missing key in item\n"))
+			return
+		}
+		if reservedKey([]byte(item.Key)) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(errReservedKey + "\n"))
+			return
+		}
+		cmd.Ops = append(cmd.Ops, btree.Op{Key: []byte(item.Key), Value: []byte(item.Value)})
+	}
+
+	applied, err := s.node.Apply(cmd, s.Settings().ApplyTimeout)
+	if err != nil {
+		w.WriteHeader(applyStatus(err))
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	setAppliedHeaders(w, applied)
+	_, _ = w.Write([]byte("OK\n"))
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type scanItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type scanResponse struct {
+	Items     []scanItem `json:"items"`
+	Truncated bool       `json:"truncated"`
+	Next      string     `json:"next,omitempty"`
+}
+
+// handleScan serves GET /scan?prefix=&start=&limit=&cursor=. Results are capped
+// at maxScanResults; when more keys remain the response is marked truncated and
+// carries a cursor the client passes back to fetch the next page.
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefix := []byte(r.URL.Query().Get("prefix"))
+	if !s.authorize(w, r, false, prefix) {
+		return
+	}
+	start, limit, ok := s.parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	// Refresh header to reflect external updates (e.g., local REPL)
+	_ = s.db.Reload()
+
+	if !s.readReady(w, r) {
+		return
+	}
+
+	// Fetch one extra item to learn whether the scan was cut short
+	op := s.StartOperation(r.Context(), "scan")
+	items, err := s.db.ScanWithProgress(prefix, start, limit+1, op.Progress())
+	op.Finish()
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+
+	resp := scanResponse{Items: make([]scanItem, 0, len(items))}
+	if len(items) > limit {
+		resp.Truncated = true
+		resp.Next = base64.RawURLEncoding.EncodeToString(items[limit].Key)
+		items = items[:limit]
+	}
+	for _, it := range items {
+		resp.Items = append(resp.Items, scanItem{Key: string(it.Key), Value: string(it.Value)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// parsePage reads where a paged listing starts, from start= or a cursor= it
+// handed out, and how many items it returns: limit=, capped at
+// maxScanResults. It answers 400 and returns false if either is malformed.
+func (s *Server) parsePage(w http.ResponseWriter, r *http.Request) ([]byte, int, bool) {
+	q := r.URL.Query()
+	start := []byte(q.Get("start"))
+	if cursor := q.Get("cursor"); cursor != "" {
+		next, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("invalid cursor\n"))
+			return nil, 0, false
+		}
+		start = next
+	}
+
+	limit := s.maxScanResults
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("invalid limit\n"))
+			return nil, 0, false
+		}
+		if n > 0 && n < limit {
+			limit = n
+		}
+	}
+	return start, limit, true
+}
+
+// readReady waits until this node may serve a read of r: on the leader after
+// a read barrier, on a follower only for a stale or read_index read, or any
+// read while degraded, else it answers with the leader. It reports whether
+// the read can go ahead.
+func (s *Server) readReady(w http.ResponseWriter, r *http.Request) bool {
+	if s.serveDegraded(w) {
+		return s.waitMinIndex(w, r)
+	}
+	q := r.URL.Query()
+	stale := strings.EqualFold(q.Get("stale"), "true") || q.Get("stale") == "1"
+	if s.node.IsLeader() {
+		if !s.waitMinIndex(w, r) {
+			return false
+		}
+		barrier := s.node.Raft().Barrier(s.Settings().BarrierTimeout)
+		if err := barrier.Error(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return false
+		}
+		return true
+	} else if readIndex := readIndexRequested(r); !stale && !readIndex {
+		s.writeNotLeader(w)
+		return false
+	} else if readIndex && !s.waitReadIndex(w, r) {
+		return false
+	}
+	return s.waitMinIndex(w, r)
+}
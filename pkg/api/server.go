@@ -1,13 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/conuredb/conuredb/btree"
 	"github.com/conuredb/conuredb/db"
 	"github.com/conuredb/conuredb/pkg/raftnode"
 	"github.com/hashicorp/raft"
@@ -16,38 +23,236 @@ import (
 type Server struct {
 	node           *raftnode.Node
 	db             *db.DB
-	barrierTimeout time.Duration
+	maxScanResults int
+	accessLog      *slog.Logger
+	accessLogOpts  AccessLogOptions
+	minFreeDisk    uint64
+	leaderHTTP     func(raft.ServerAddress) string
+	acl            []ACLRule
+	settings       atomic.Pointer[Settings]
+	adminToken     string
+	ops            operations
+	drained        atomic.Bool
+	maxTxnOps      int
+	maxTxnBytes    int64
+	// starting is set by WithWaitForLeader until the API is ready
+	starting      atomic.Bool
+	startDeadline time.Time
+	// degradedReads keeps reads served while there is no leader
+	degradedReads bool
+	// groups maps the buckets served by other raft groups to their
+	// servers, whose parent is this one; see WithGroup
+	groups map[string]*Server
+	parent *Server
+	// advertiseHTTP is this node's HTTP API base URL, and peers those of
+	// the others it has learned; see WithAdvertiseHTTP
+	advertiseHTTP string
+	peers         peerHTTP
 }
 
 func New(node *raftnode.Node, db *db.DB) *Server {
-	return &Server{node: node, db: db, barrierTimeout: 3 * time.Second}
+	s := &Server{node: node, db: db, maxScanResults: 1000, maxTxnOps: defaultMaxTxnOps, maxTxnBytes: defaultMaxTxnBytes}
+	s.SetSettings(defaultSettings())
+	return s
 }
 
 func (s *Server) WithBarrierTimeout(d time.Duration) *Server {
 	if d > 0 {
-		s.barrierTimeout = d
+		settings := s.Settings()
+		settings.BarrierTimeout = d
+		s.SetSettings(settings)
+	}
+	return s
+}
+
+// WithMaxScanResults caps the number of items a single /scan request returns,
+// regardless of the limit the client asks for.
+func (s *Server) WithMaxScanResults(n int) *Server {
+	if n > 0 {
+		s.maxScanResults = n
 	}
 	return s
 }
 
 func (s *Server) Register(mux *http.ServeMux) {
-	mux.HandleFunc("/kv", s.handleKV)
-	mux.HandleFunc("/join", s.handleJoin)
-	mux.HandleFunc("/remove", s.handleRemove)
-	mux.HandleFunc("/status", s.handleStatus)
-	mux.HandleFunc("/raft/config", s.handleRaftConfig)
-	mux.HandleFunc("/raft/stats", s.handleRaftStats)
+	// Until the node is ready only /healthz, /status, /version and /metrics answer
+	handle := func(path string, h http.HandlerFunc) {
+		mux.HandleFunc(path, s.logged(s.whenReady(h)))
+	}
+	mux.HandleFunc("/healthz", s.logged(s.handleHealthz))
+	mux.HandleFunc("/status", s.logged(s.handleStatus))
+	mux.HandleFunc("/version", s.logged(s.handleVersion))
+	mux.HandleFunc("/metrics", s.logged(s.handleMetrics))
+	handle("/kv", s.byBucket("bucket", func(g *Server) http.HandlerFunc { return g.whenWritable(g.handleKV) }))
+	handle("/kv/pipeline", s.whenWritable(s.handlePipeline))
+	handle("/kv/next", s.handleNeighbor(true))
+	handle("/kv/prev", s.handleNeighbor(false))
+	handle("/scan", s.handleScan)
+	handle("/catalog", s.handleCatalog)
+	handle("/buckets", s.byBucket("name", func(g *Server) http.HandlerFunc { return g.whenWritable(g.handleBuckets) }))
+	handle("/txn", s.whenWritable(s.handleTxn))
+	handle("/lease", s.whenWritable(s.handleLease))
+	handle("/lease/", s.whenWritable(s.handleLease))
+	handle("/join", s.handleJoin)
+	handle("/remove", s.handleRemove)
+	handle("/leave", s.handleLeave)
+	handle("/stats", s.handleStats)
+	handle("/compact", s.handleCompact)
+	handle("/verify", s.handleVerify)
+	handle("/cluster", s.handleCluster)
+	handle("/raft/config", s.handleRaftConfig)
+	handle("/raft/stats", s.handleRaftStats)
+	handle("/raft/readindex", s.handleReadIndex)
+	handle("/debug/hotkeys", s.handleHotKeys)
+	handle("/admin/config", s.handleAdminConfig)
+	handle("/admin/ops", s.handleOps)
+	handle("/admin/ops/", s.handleOps)
+	handle("/admin/dropcache", s.handleDropCache)
+	handle("/admin/replace", s.whenWritable(s.handleReplace))
+	handle("/admin/import", s.whenWritable(s.handleImport))
+	handle("/admin/drain", s.handleDrain(true))
+	handle("/admin/undrain", s.handleDrain(false))
 }
 
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	resp := map[string]any{
 		"is_leader": s.node.IsLeader(),
 		"leader":    string(s.node.Leader()),
+		"drained":   s.Drained(),
+		"degraded":  s.Degraded(),
+		// How far this node's clock was ahead of the leader's stamp on the
+		// last command it applied, replication delay included
+		"clock_skew_ms":       s.node.ClockSkew().Milliseconds(),
+		"version":             s.versionInfo(),
+		"advertise_http_addr": s.advertiseHTTP,
+	}
+	if u, ok := s.advertisedHTTP(s.node.Leader()); ok {
+		resp["leader_http"] = u
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// handleStats serves GET /stats. Pass full=true to walk the whole tree for key
+// counts and size histograms; this reads every page.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	_ = s.db.Reload()
+	full := strings.EqualFold(r.URL.Query().Get("full"), "true") || r.URL.Query().Get("full") == "1"
+	stats, err := s.db.Stats(full)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if s.minFreeDisk > 0 {
+		stats.MinDiskFree = s.minFreeDisk
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// handleCompact serves POST /compact. It compacts this node's database file
+// only; every replica holds the same data and is compacted separately. It
+// runs to the end even if the client goes away, unless cancelled through
+// /admin/ops.
+func (s *Server) handleCompact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	op := s.StartOperation(context.WithoutCancel(r.Context()), "compact")
+	defer op.Finish()
+	stats, err := s.db.CompactWithProgress(op.Progress())
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// handleVerify serves POST /verify, checking the structure of this node's
+// database file. A corrupt file is reported with 500 and the first problem
+// found.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	_ = s.db.Reload()
+	op := s.StartOperation(r.Context(), "verify")
+	defer op.Finish()
+	if err := s.db.VerifyWithProgress(op.Progress()); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+// handleDropCache serves POST /admin/dropcache, emptying this node's cache of
+// decoded pages so later reads go back to disk
+func (s *Server) handleDropCache(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	dropped, err := s.db.DropCache()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"nodes_dropped": dropped})
+}
+
+// writeOpError reports an operation that failed, or 503 if it was cancelled
+// or too many readers were open
+func writeOpError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, btree.ErrTooManyReaders) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	_, _ = w.Write([]byte(err.Error() + "\n"))
+}
+
+// handleHotKeys serves GET /debug/hotkeys?top=N with the most accessed keys on
+// this node. Writes are counted as they are applied, so every replica sees
+// them; reads only where they are served.
+func (s *Server) handleHotKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	top := 10
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("invalid top\n"))
+			return
+		}
+		top = n
+	}
+	keys, ok := s.db.HotKeys(top)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("hot key tracking is disabled\n"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(keys)
+}
+
 func (s *Server) handleRaftConfig(w http.ResponseWriter, r *http.Request) {
 	f := s.node.Raft().GetConfiguration()
 	if err := f.Error(); err != nil {
@@ -60,16 +265,22 @@ func (s *Server) handleRaftConfig(w http.ResponseWriter, r *http.Request) {
 		ID       string `json:"id"`
 		Address  string `json:"address"`
 		Suffrage string `json:"suffrage"`
+		// HTTPAddress is the server's advertised HTTP API, if known
+		HTTPAddress string `json:"http_address,omitempty"`
 	}
 	resp := struct {
-		Leader  string       `json:"leader"`
-		Servers []serverInfo `json:"servers"`
+		Leader     string       `json:"leader"`
+		LeaderHTTP string       `json:"leader_http,omitempty"`
+		Servers    []serverInfo `json:"servers"`
 	}{Leader: string(s.node.Leader())}
+	resp.LeaderHTTP, _ = s.advertisedHTTP(s.node.Leader())
 	for _, sv := range cfg.Servers {
+		httpAddr, _ := s.advertisedHTTP(sv.Address)
 		resp.Servers = append(resp.Servers, serverInfo{
-			ID:       string(sv.ID),
-			Address:  string(sv.Address),
-			Suffrage: suffrageToString(sv.Suffrage),
+			ID:          string(sv.ID),
+			Address:     string(sv.Address),
+			Suffrage:    suffrageToString(sv.Suffrage),
+			HTTPAddress: httpAddr,
 		})
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -90,7 +301,18 @@ func suffrageToString(s raft.ServerSuffrage) string {
 }
 
 func (s *Server) handleRaftStats(w http.ResponseWriter, r *http.Request) {
-	stats := s.node.Raft().Stats()
+	stats := make(map[string]any)
+	for k, v := range s.node.Raft().Stats() {
+		stats[k] = v
+	}
+	// Per-follower replication progress is only known on the leader
+	if peers := s.node.Replication(); peers != nil {
+		stats["peers"] = peers
+	}
+	if dead := s.node.DeadPeers(); dead != nil {
+		stats["dead_peers"] = dead
+	}
+	stats["max_command_bytes"] = s.node.MaxCommandBytes()
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(stats)
 }
@@ -100,7 +322,7 @@ func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	type req struct{ ID, RaftAddr string }
+	type req struct{ ID, RaftAddr, HTTPAddr string }
 	var body req
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -108,8 +330,7 @@ func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !s.node.IsLeader() {
-		w.WriteHeader(http.StatusConflict)
-		_ = json.NewEncoder(w).Encode(map[string]string{"leader": string(s.node.Leader())})
+		s.writeNotLeader(w)
 		return
 	}
 	if err := s.node.AddVoter(body.ID, body.RaftAddr); err != nil {
@@ -117,8 +338,13 @@ func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(err.Error() + "\n"))
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("OK\n"))
+	if body.HTTPAddr != "" {
+		s.AddPeerHTTP(map[string]string{body.RaftAddr: body.HTTPAddr})
+	}
+	// Hand the new member the HTTP addresses known here, so it can
+	// redirect clients to whichever of them leads
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"http_addrs": s.peerHTTPAddrs()})
 }
 
 func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
@@ -134,8 +360,7 @@ func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !s.node.IsLeader() {
-		w.WriteHeader(http.StatusConflict)
-		_ = json.NewEncoder(w).Encode(map[string]string{"leader": string(s.node.Leader())})
+		s.writeNotLeader(w)
 		return
 	}
 	f := s.node.Raft().RemoveServer(raft.ServerID(body.ID), 0, 0)
@@ -155,50 +380,50 @@ func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("missing key\n"))
 		return
 	}
+	bucket := r.URL.Query().Get("bucket")
+	readKey := key
+	if bucket != "" {
+		if err := db.ValidateBucketName(bucket); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		if wantOld(r) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("return=old is not supported with bucket\n"))
+			return
+		}
+		if r.Header.Get("If-Version") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("If-Version is not supported with bucket\n"))
+			return
+		}
+		readKey = db.BucketKey(bucket, key)
+	}
+	write := r.Method == http.MethodPut || r.Method == http.MethodDelete
+	if !s.authorize(w, r, write, key) {
+		return
+	}
+	// return=old reveals the previous value, so it needs read access too
+	if write && wantOld(r) && !s.authorize(w, r, false, key) {
+		return
+	}
 
 	// Refresh header to reflect external updates (e.g., local REPL)
 	_ = s.db.Reload()
 
 	switch r.Method {
 	case http.MethodGet:
-		stale := strings.EqualFold(r.URL.Query().Get("stale"), "true") || r.URL.Query().Get("stale") == "1"
-		if s.node.IsLeader() {
-			// linearizable read via barrier
-			barrier := s.node.Raft().Barrier(s.barrierTimeout)
-			if err := barrier.Error(); err != nil {
-				w.WriteHeader(http.StatusServiceUnavailable)
-				_, _ = w.Write([]byte(err.Error() + "\n"))
-				return
-			}
-			val, err := s.db.Get(key)
-			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
-				_, _ = w.Write([]byte(err.Error() + "\n"))
-				return
-			}
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(append(val, '\n'))
-			return
-		}
-		// follower: serve stale read if requested; else indicate leader
-		if stale {
-			val, err := s.db.Get(key)
-			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
-				_, _ = w.Write([]byte(err.Error() + "\n"))
-				return
-			}
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(append(val, '\n'))
-			return
+		if s.readReady(w, r) {
+			s.writeValue(w, readKey)
 		}
-		w.WriteHeader(http.StatusConflict)
-		_ = json.NewEncoder(w).Encode(map[string]string{"leader": string(s.node.Leader())})
 
 	case http.MethodPut:
 		if !s.node.IsLeader() {
-			w.WriteHeader(http.StatusConflict)
-			_ = json.NewEncoder(w).Encode(map[string]string{"leader": string(s.node.Leader())})
+			s.writeNotLeader(w)
+			return
+		}
+		if !s.admitWrite(w) {
 			return
 		}
 
@@ -220,32 +445,153 @@ func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		cmd := raftnode.Command{Type: raftnode.CmdPut, Key: key, Value: value}
-		if err := s.node.Apply(cmd, 5*time.Second); err != nil {
-			log.Printf("apply error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		ifVersion, err := parseIfVersion(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
 			_, _ = w.Write([]byte(err.Error() + "\n"))
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK\n"))
+		cmd := raftnode.Command{Type: raftnode.CmdPut, Key: key, Value: value, ReturnOld: wantOld(r), IfVersion: ifVersion, Bucket: bucket, RequestID: requestID(r)}
+		resp, err := s.node.Apply(cmd, s.Settings().ApplyTimeout)
+		if err != nil {
+			log.Printf("apply error (request %s): %v", requestID(r), err)
+			w.WriteHeader(applyStatus(err))
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		writeApplied(w, resp)
 
 	case http.MethodDelete:
 		if !s.node.IsLeader() {
-			w.WriteHeader(http.StatusConflict)
-			_ = json.NewEncoder(w).Encode(map[string]string{"leader": string(s.node.Leader())})
+			s.writeNotLeader(w)
 			return
 		}
-		cmd := raftnode.Command{Type: raftnode.CmdDelete, Key: key}
-		if err := s.node.Apply(cmd, 5*time.Second); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if !s.admitWrite(w) {
+			return
+		}
+		cmd := raftnode.Command{Type: raftnode.CmdDelete, Key: key, ReturnOld: wantOld(r), Bucket: bucket, RequestID: requestID(r)}
+		resp, err := s.node.Apply(cmd, s.Settings().ApplyTimeout)
+		if err != nil {
+			w.WriteHeader(applyStatus(err))
 			_, _ = w.Write([]byte(err.Error() + "\n"))
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK\n"))
+		writeApplied(w, resp)
 
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+// writeValue answers a read of key with its value, and its version in
+// X-Conure-Version
+func (s *Server) writeValue(w http.ResponseWriter, key []byte) {
+	val, meta, err := s.db.GetWithMeta(key)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	w.Header().Set("X-Conure-Version", strconv.FormatUint(meta.Version, 10))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(append(val, '\n'))
+}
+
+// parseIfVersion reads the version a put requires the key to be at from
+// the If-Version header, nil if there is none
+func parseIfVersion(r *http.Request) (*uint64, error) {
+	v := r.Header.Get("If-Version")
+	if v == "" {
+		return nil, nil
+	}
+	if wantOld(r) {
+		return nil, errors.New("If-Version is not supported with return=old")
+	}
+	version, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid If-Version")
+	}
+	return &version, nil
+}
+
+// wantOld reports whether a write asked for the previous value via ?return=old
+func wantOld(r *http.Request) bool {
+	return r.URL.Query().Get("return") == "old"
+}
+
+// waitMinIndex holds a read until this node has applied ?min_index, so a
+// client can read its own write from a follower, or read on the leader at
+// least as fresh as an index it learned elsewhere, even one not yet
+// committed. It answers 503 and returns false if the node is still behind
+// after the barrier timeout.
+func (s *Server) waitMinIndex(w http.ResponseWriter, r *http.Request) bool {
+	v := r.URL.Query().Get("min_index")
+	if v == "" {
+		return true
+	}
+	index, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("invalid min_index\n"))
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.Settings().BarrierTimeout)
+	defer cancel()
+	if err := s.node.WaitForApplied(ctx, index); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(fmt.Sprintf("applied index %d is behind min_index %d\n", s.node.Raft().AppliedIndex(), index)))
+		return false
+	}
+	return true
+}
+
+// applyStatus maps an error from replicating a write to a status code
+func applyStatus(err error) int {
+	switch {
+	case errors.Is(err, btree.ErrDiskFull), errors.Is(err, db.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, db.ErrBucketNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, raftnode.ErrCommandTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, db.ErrVersionMismatch), errors.Is(err, db.ErrLeaseNotHeld):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, btree.ErrEmptyKey), errors.Is(err, db.ErrInvalidQuota), errors.Is(err, db.ErrInvalidLease):
+		return http.StatusBadRequest
+	case errors.Is(err, btree.ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// setAppliedHeaders reports the raft index a write committed at and how
+// many servers held it when it was applied
+func setAppliedHeaders(w http.ResponseWriter, applied raftnode.Applied) {
+	index := strconv.FormatUint(applied.Index, 10)
+	w.Header().Set("X-Raft-Index", index)
+	w.Header().Set("X-Conure-Commit-Index", index)
+	w.Header().Set("X-Conure-Replicas", strconv.Itoa(applied.Replicas))
+}
+
+// writeApplied acknowledges a write with the raft index it committed at,
+// including the previous value when the command asked for it
+func writeApplied(w http.ResponseWriter, applied raftnode.Applied) {
+	setAppliedHeaders(w, applied)
+	old, ok := applied.Response.(raftnode.OldValue)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK\n"))
+		return
+	}
+	body := struct {
+		Index   uint64  `json:"index"`
+		Existed bool    `json:"existed"`
+		Old     *string `json:"old,omitempty"`
+	}{Index: applied.Index, Existed: old.Existed}
+	if old.Existed {
+		v := string(old.Value)
+		body.Old = &v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Settings are the parameters an operator can change on a running node via
+// /admin/config. Each node keeps its own; nothing is replicated.
+type Settings struct {
+	// BarrierTimeout bounds the raft barrier of a linearizable read and the
+	// wait for min_index
+	BarrierTimeout time.Duration
+
+	// ApplyTimeout bounds how long a write waits to be replicated
+	ApplyTimeout time.Duration
+
+	// SlowRequest, if positive, logs requests taking at least this long at
+	// warn level on the access log
+	SlowRequest time.Duration
+
+	// LogLevel drops access log lines below this level
+	LogLevel slog.Level
+}
+
+// settingsJSON is Settings as /admin/config reads and writes it. Fields left
+// out of a POST keep their current value.
+type settingsJSON struct {
+	BarrierTimeout *string     `json:"barrier_timeout,omitempty"`
+	ApplyTimeout   *string     `json:"apply_timeout,omitempty"`
+	SlowRequest    *string     `json:"slow_request,omitempty"`
+	LogLevel       *slog.Level `json:"log_level,omitempty"`
+}
+
+func defaultSettings() Settings {
+	return Settings{BarrierTimeout: 3 * time.Second, ApplyTimeout: 5 * time.Second}
+}
+
+// Settings returns the settings currently in effect
+func (s *Server) Settings() Settings {
+	if s.parent != nil {
+		return s.parent.Settings()
+	}
+	return *s.settings.Load()
+}
+
+// SetSettings replaces the settings in effect. Requests already running
+// keep the values they started with.
+func (s *Server) SetSettings(settings Settings) {
+	s.settings.Store(&settings)
+}
+
+// WithAdminToken requires "Authorization: Bearer <token>" on /admin
+// endpoints. Without one they are open, like the other cluster endpoints.
+func (s *Server) WithAdminToken(token string) *Server {
+	s.adminToken = token
+	return s
+}
+
+// checkAdminToken rejects r with 401 unless it carries the admin token, if
+// one is set
+func (s *Server) checkAdminToken(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		return true
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte("missing or wrong admin token\n"))
+		return false
+	}
+	return true
+}
+
+// handleAdminConfig serves /admin/config: GET returns this node's settings
+// and POST changes the ones given in the body, returning the result
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminToken(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req settingsJSON
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		updated, err := req.apply(s.Settings())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		s.SetSettings(updated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cur := s.Settings()
+	barrier, apply, slow := cur.BarrierTimeout.String(), cur.ApplyTimeout.String(), cur.SlowRequest.String()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(settingsJSON{
+		BarrierTimeout: &barrier,
+		ApplyTimeout:   &apply,
+		SlowRequest:    &slow,
+		LogLevel:       &cur.LogLevel,
+	})
+}
+
+// apply returns cur with the fields set in req changed
+func (req settingsJSON) apply(cur Settings) (Settings, error) {
+	for _, f := range []struct {
+		name     string
+		value    *string
+		dst      *time.Duration
+		positive bool
+	}{
+		{"barrier_timeout", req.BarrierTimeout, &cur.BarrierTimeout, true},
+		{"apply_timeout", req.ApplyTimeout, &cur.ApplyTimeout, true},
+		{"slow_request", req.SlowRequest, &cur.SlowRequest, false},
+	} {
+		if f.value == nil {
+			continue
+		}
+		d, err := time.ParseDuration(*f.value)
+		if err != nil || d < 0 || (f.positive && d == 0) {
+			return cur, fmt.Errorf("invalid %s %q", f.name, *f.value)
+		}
+		*f.dst = d
+	}
+	if req.LogLevel != nil {
+		cur.LogLevel = *req.LogLevel
+	}
+	return cur, nil
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// Default limits on one /txn request, which raft carries as a single log
+// entry
+const (
+	defaultMaxTxnOps   = 10000
+	defaultMaxTxnBytes = 4 << 20
+)
+
+// WithTxnLimits caps the conditions plus ops, and the body bytes, of one
+// /txn request. Larger requests are refused with 413 before they reach the
+// raft log. Zero keeps the default.
+func (s *Server) WithTxnLimits(maxOps int, maxBytes int64) *Server {
+	if maxOps > 0 {
+		s.maxTxnOps = maxOps
+	}
+	if maxBytes > 0 {
+		s.maxTxnBytes = maxBytes
+	}
+	return s
+}
+
+type txnCond struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Absent bool   `json:"absent"`
+}
+
+type txnOp struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Delete bool   `json:"delete"`
+}
+
+type txnRequest struct {
+	Conds []txnCond `json:"conds"`
+	Ops   []txnOp   `json:"ops"`
+}
+
+type txnResponse struct {
+	Succeeded bool   `json:"succeeded"`
+	Index     uint64 `json:"index"`
+}
+
+// handleTxn serves POST /txn: the ops are applied atomically through raft if
+// every condition holds on committed state. A failed condition is answered
+// with 200 and succeeded=false.
+func (s *Server) handleTxn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.node.IsLeader() {
+		s.writeNotLeader(w)
+		return
+	}
+	if !s.admitWrite(w) {
+		return
+	}
+
+	var req txnRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxTxnBytes)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write([]byte(fmt.Sprintf("txn body exceeds %d bytes\n", s.maxTxnBytes)))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if n := len(req.Conds) + len(req.Ops); n > s.maxTxnOps {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = w.Write([]byte(fmt.Sprintf("txn has %d conditions and ops, limit is %d\n", n, s.maxTxnOps)))
+		return
+	}
+
+	cmd := raftnode.Command{Type: raftnode.CmdTxn, RequestID: requestID(r)}
+	for _, c := range req.Conds {
+		if c.Key == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("missing key in condition\n"))
+			return
+		}
+		cmd.Conds = append(cmd.Conds, btree.Cond{Key: []byte(c.Key), Value: []byte(c.Value), Absent: c.Absent})
+	}
+	for _, op := range req.Ops {
+		if op.Key == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("missing key in op\n"))
+			return
+		}
+		cmd.Ops = append(cmd.Ops, btree.Op{Key: []byte(op.Key), Value: []byte(op.Value), Delete: op.Delete})
+	}
+
+	reads := make([][]byte, 0, len(cmd.Conds))
+	for _, c := range cmd.Conds {
+		reads = append(reads, c.Key)
+	}
+	writes := make([][]byte, 0, len(cmd.Ops))
+	for _, op := range cmd.Ops {
+		writes = append(writes, op.Key)
+	}
+	if !s.authorize(w, r, false, reads...) || !s.authorize(w, r, true, writes...) {
+		return
+	}
+
+	applied, err := s.node.Apply(cmd, s.Settings().ApplyTimeout)
+	if err != nil {
+		w.WriteHeader(applyStatus(err))
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	result, _ := applied.Response.(raftnode.TxnResult)
+	setAppliedHeaders(w, applied)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(txnResponse{Succeeded: result.Succeeded, Index: applied.Index})
+}
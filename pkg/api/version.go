@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/pkg/version"
+)
+
+// versionInfo is the body of /version: the build of this node's binary and
+// the formats it writes, so a rolling upgrade can spot nodes left behind
+type versionInfo struct {
+	version.Info
+	StorageFormat uint32 `json:"storage_format"`
+	RaftProtocol  int    `json:"raft_protocol"`
+}
+
+func (s *Server) versionInfo() versionInfo {
+	return versionInfo{
+		Info:          version.Get(),
+		StorageFormat: btree.Version,
+		RaftProtocol:  int(s.node.RaftConfig().ProtocolVersion),
+	}
+}
+
+// handleVersion serves GET /version
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.versionInfo())
+}
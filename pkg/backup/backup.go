@@ -0,0 +1,184 @@
+// Package backup periodically uploads snapshots of a database to a Store,
+// such as a local directory or an S3-compatible bucket, keeping the newest
+// few.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+)
+
+// Store is a destination for backups, addressed by name
+type Store interface {
+	// Put stores the contents of r under name, replacing any existing one
+	Put(ctx context.Context, name string, r io.ReadSeeker) error
+
+	// List returns the names of every stored backup, in any order
+	List(ctx context.Context) ([]string, error)
+
+	// Delete removes the backup called name
+	Delete(ctx context.Context, name string) error
+}
+
+// StoreOptions holds what NewStore needs beyond the destination URL
+type StoreOptions struct {
+	// S3Endpoint is the base URL of the S3-compatible service. Empty means
+	// https://s3.<region>.amazonaws.com.
+	S3Endpoint string
+
+	// S3Region signs requests; empty means us-east-1
+	S3Region string
+
+	// S3AccessKey and S3SecretKey sign requests, and S3SessionToken is sent
+	// with them for temporary credentials. Empty keys fall back to
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+	S3AccessKey    string
+	S3SecretKey    string
+	S3SessionToken string
+}
+
+// NewStore opens the store at destination: "s3://bucket/prefix" for an
+// S3-compatible bucket, or a directory path, optionally as a file:// URL.
+func NewStore(destination string, opts StoreOptions) (Store, error) {
+	if !strings.Contains(destination, "://") {
+		return NewDirStore(destination)
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return NewDirStore(u.Path)
+	case "s3":
+		return NewS3Store(u.Host, strings.Trim(u.Path, "/"), opts)
+	}
+	return nil, fmt.Errorf("unsupported backup destination %q", destination)
+}
+
+const (
+	namePrefix = "conure-"
+	nameSuffix = ".snapshot"
+
+	// nameTime orders backup names by when they were taken
+	nameTime = "20060102T150405.000000000Z"
+)
+
+// Options configures Start
+type Options struct {
+	// Interval is how often a backup is taken
+	Interval time.Duration
+
+	// Retain is how many of the newest backups to keep; older ones are
+	// deleted after each successful backup. Zero keeps every backup.
+	Retain int
+
+	// ShouldRun, if set, is asked before each backup, which is skipped
+	// unless it returns true. A cluster passes its leader check so only one
+	// node uploads.
+	ShouldRun func() bool
+
+	// OnError, if set, is called with each failed backup or prune
+	OnError func(error)
+}
+
+// Start takes a backup of database to store every opts.Interval until the
+// returned function is called
+func Start(database *db.DB, store Store, opts Options) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if opts.ShouldRun != nil && !opts.ShouldRun() {
+					continue
+				}
+				if _, err := Backup(ctx, database, store, opts.Retain); err != nil && opts.OnError != nil && ctx.Err() == nil {
+					opts.OnError(err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
+
+// Backup uploads a snapshot of database to store, then deletes all but the
+// newest retain backups, and returns the new backup's name. The snapshot is
+// the format SnapshotTo writes, so db.RestoreFrom verifies and loads it.
+func Backup(ctx context.Context, database *db.DB, store Store, retain int) (string, error) {
+	// Stage the snapshot locally: uploads need its size up front, and the
+	// database should not stay locked for the duration of a slow upload
+	tmp, err := os.CreateTemp("", "conure-backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := tmp.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close backup temp file: %v\n", closeErr)
+		}
+		if removeErr := os.Remove(tmp.Name()); removeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove backup temp file: %v\n", removeErr)
+		}
+	}()
+	if err := database.SnapshotTo(tmp); err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	name := namePrefix + time.Now().UTC().Format(nameTime) + nameSuffix
+	if err := store.Put(ctx, name, tmp); err != nil {
+		return "", fmt.Errorf("upload %s: %w", name, err)
+	}
+	if err := prune(ctx, store, retain); err != nil {
+		return name, fmt.Errorf("prune: %w", err)
+	}
+	return name, nil
+}
+
+// prune deletes all but the newest retain backups in store
+func prune(ctx context.Context, store Store, retain int) error {
+	if retain <= 0 {
+		return nil
+	}
+	names, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	backups := names[:0]
+	for _, name := range names {
+		if strings.HasPrefix(name, namePrefix) && strings.HasSuffix(name, nameSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > retain {
+		if err := store.Delete(ctx, backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// DirStore keeps backups as files in a local directory, which may be a
+// mounted network file system
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a store in dir, creating it if needed
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// Put writes r to a hidden temp file and renames it into place once synced,
+// so a partial backup never appears under its name
+func (s *DirStore) Put(ctx context.Context, name string, r io.ReadSeeker) error {
+	tmp, err := os.CreateTemp(s.dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = btree.ReplaceFile(tmp.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		if removeErr := os.Remove(tmp.Name()); removeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove backup temp file: %v\n", removeErr)
+		}
+	}
+	return err
+}
+
+// List returns the files in the directory, skipping hidden temp files
+func (s *DirStore) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// Delete removes the file called name
+func (s *DirStore) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(s.dir, name))
+}
@@ -0,0 +1,400 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Store keeps backups as objects under a prefix in an S3-compatible
+// bucket. Requests use path-style addressing and Signature Version 4, which
+// AWS S3, MinIO and most compatible services accept.
+type S3Store struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	partSize     int64
+	client       *http.Client
+}
+
+const (
+	// s3PartSize is the size of each part of a multipart upload, and the
+	// largest object Put sends in one request. A single PUT is capped at
+	// 5 GB, and a multipart upload at 10,000 parts, so larger objects get
+	// larger parts.
+	s3PartSize = 64 << 20
+	s3MaxParts = 10000
+)
+
+// NewS3Store returns a store for the objects under prefix in bucket
+func NewS3Store(bucket, prefix string, opts StoreOptions) (*S3Store, error) {
+	if bucket == "" {
+		return nil, errors.New("missing S3 bucket")
+	}
+	region := opts.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := opts.S3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	accessKey, secretKey, sessionToken := opts.S3AccessKey, opts.S3SecretKey, opts.S3SessionToken
+	if accessKey == "" && secretKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("missing S3 credentials")
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	// No client timeout: an upload takes as long as the object needs, and
+	// the caller's context bounds it
+	return &S3Store{
+		endpoint:     u,
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		partSize:     s3PartSize,
+		client:       &http.Client{},
+	}, nil
+}
+
+// Put uploads r as one object, in a single request up to the part size and
+// as a multipart upload above it
+func (s *S3Store) Put(ctx context.Context, name string, r io.ReadSeeker) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size <= s.partSize {
+		_, err := s.putRange(ctx, s.prefix+name, nil, r, 0, size)
+		return err
+	}
+	return s.putMultipart(ctx, s.prefix+name, r, size)
+}
+
+// putMultipart uploads the size bytes of r to key in parts. If a part or
+// the completion fails, the upload is aborted so the service does not keep
+// the parts already sent.
+func (s *S3Store) putMultipart(ctx context.Context, key string, r io.ReadSeeker, size int64) error {
+	req, err := s.request(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	body, _, err := s.do(req, emptySHA256)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadId string
+	}
+	if err := xml.Unmarshal(body, &initiated); err != nil {
+		return fmt.Errorf("decode multipart upload: %w", err)
+	}
+	if initiated.UploadId == "" {
+		return errors.New("S3 returned no multipart upload ID")
+	}
+	uploadID := initiated.UploadId
+
+	partSize := s.partSize
+	if parts := (size + partSize - 1) / partSize; parts > s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	var complete struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		header, err := s.putRange(ctx, key, query, r, offset, min(partSize, size-offset))
+		if err != nil {
+			return s.abortMultipart(key, uploadID, fmt.Errorf("part %d: %w", number, err))
+		}
+		complete.Parts = append(complete.Parts, part{PartNumber: number, ETag: header.Get("ETag")})
+	}
+
+	doc, err := xml.Marshal(complete)
+	if err != nil {
+		return s.abortMultipart(key, uploadID, err)
+	}
+	sum := sha256.Sum256(doc)
+	req, err = s.request(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, io.NopCloser(bytes.NewReader(doc)))
+	if err != nil {
+		return s.abortMultipart(key, uploadID, err)
+	}
+	req.ContentLength = int64(len(doc))
+	body, _, err = s.do(req, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return s.abortMultipart(key, uploadID, err)
+	}
+	// Completion can fail after the 200 status has been sent, in which case
+	// the body is an error document
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.Unmarshal(body, &result); err == nil && result.XMLName.Local == "Error" {
+		return s.abortMultipart(key, uploadID, fmt.Errorf("S3 complete multipart upload %s: %s: %s", key, result.Code, result.Message))
+	}
+	return nil
+}
+
+// abortMultipart discards the parts of a failed upload and returns cause.
+// It runs on a context of its own, since cause is often the cancellation
+// of the upload's.
+func (s *S3Store) abortMultipart(key, uploadID string, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := s.request(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err == nil {
+		_, _, err = s.do(req, emptySHA256)
+	}
+	if err != nil {
+		return fmt.Errorf("%w (abort multipart upload: %v)", cause, err)
+	}
+	return cause
+}
+
+// putRange sends the n bytes of r from offset as the body of a PUT to key,
+// returning the response headers
+func (s *S3Store) putRange(ctx context.Context, key string, query url.Values, r io.ReadSeeker, offset, n int64) (http.Header, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, r, n); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	req, err := s.request(ctx, http.MethodPut, key, query, io.NopCloser(io.LimitReader(r, n)))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = n
+	_, header, err := s.do(req, hex.EncodeToString(h.Sum(nil)))
+	return header, err
+}
+
+// List pages through the objects under the prefix
+func (s *S3Store) List(ctx context.Context) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := s.request(ctx, http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, _, err := s.do(req, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("decode object list: %w", err)
+		}
+		for _, c := range page.Contents {
+			if name := strings.TrimPrefix(c.Key, s.prefix); !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Delete removes the object called name
+func (s *S3Store) Delete(ctx context.Context, name string) error {
+	req, err := s.request(ctx, http.MethodDelete, s.prefix+name, nil, nil)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.do(req, emptySHA256)
+	return err
+}
+
+// request builds a request for key in the bucket, or the bucket itself if
+// key is empty
+func (s *S3Store) request(ctx context.Context, method, key string, query url.Values, body io.ReadCloser) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// Sign the path exactly as it will be sent
+	req.URL.RawPath = awsEscape(u.Path, false)
+	return req, nil
+}
+
+// do signs and sends req, returning the response body and headers or an
+// error for any status other than 2xx
+func (s *S3Store) do(req *http.Request, payloadHash string) ([]byte, http.Header, error) {
+	// Temporary credentials carry a session token, which is signed with the
+	// other headers
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	signV4(req, payloadHash, s.region, s.accessKey, s.secretKey, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close S3 response body: %v\n", closeErr)
+		}
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, fmt.Errorf("S3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, resp.Header, nil
+}
+
+// emptySHA256 is the payload hash of a request without a body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 adds AWS Signature Version 4 headers for the s3 service to req,
+// signing the host and every header already set on it
+func signV4(req *http.Request, payloadHash, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// canonicalQuery encodes q sorted by key with AWS escaping
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes every byte outside the RFC 3986 unreserved set,
+// and also '/' when escapeSlash is set
+func awsEscape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
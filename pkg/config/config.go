@@ -11,12 +11,73 @@ import (
 
 // Config defines runtime configuration loaded from YAML and/or flags.
 type Config struct {
-	NodeID         string        `yaml:"node_id"`
-	DataDir        string        `yaml:"data_dir"`
-	RaftAddr       string        `yaml:"raft_addr"`
-	HTTPAddr       string        `yaml:"http_addr"`
-	Bootstrap      bool          `yaml:"bootstrap"`
-	BarrierTimeout time.Duration `yaml:"barrier_timeout"`
+	NodeID             string        `yaml:"node_id"`
+	DataDir            string        `yaml:"data_dir"`
+	RaftAddr           string        `yaml:"raft_addr"`
+	HTTPAddr           string        `yaml:"http_addr"`
+	AdvertiseHTTP      string        `yaml:"advertise_http_addr"`
+	RESPAddr           string        `yaml:"resp_addr"`
+	Bootstrap          bool          `yaml:"bootstrap"`
+	BarrierTimeout     time.Duration `yaml:"barrier_timeout"`
+	MaxScanResults     int           `yaml:"max_scan_results"`
+	MaxTxnOps          int           `yaml:"max_txn_ops"`
+	MaxTxnBytes        int64         `yaml:"max_txn_bytes"`
+	LagAlertThreshold  uint64        `yaml:"lag_alert_threshold"`
+	RaftAdvertise      string        `yaml:"raft_advertise"`
+	RaftMaxPool        int           `yaml:"raft_max_pool"`
+	RaftTimeout        time.Duration `yaml:"raft_timeout"`
+	TrackHotKeys       bool          `yaml:"track_hot_keys"`
+	HTTPReadTimeout    time.Duration `yaml:"http_read_timeout"`
+	HTTPWriteTimeout   time.Duration `yaml:"http_write_timeout"`
+	HTTPIdleTimeout    time.Duration `yaml:"http_idle_timeout"`
+	HTTPMaxHeaderBytes int           `yaml:"http_max_header_bytes"`
+	HeartbeatTimeout   time.Duration `yaml:"heartbeat_timeout"`
+	ElectionTimeout    time.Duration `yaml:"election_timeout"`
+	LeaderLeaseTimeout time.Duration `yaml:"leader_lease_timeout"`
+	CommitTimeout      time.Duration `yaml:"commit_timeout"`
+	RaftMaxAppend      int           `yaml:"raft_max_append_entries"`
+	RaftBatchApply     bool          `yaml:"raft_batch_apply"`
+	MaxCommandBytes    int           `yaml:"max_command_bytes"`
+	AccessLog          bool          `yaml:"access_log"`
+	AccessLogRedact    bool          `yaml:"access_log_redact"`
+	MinFreeDiskBytes   uint64        `yaml:"min_free_disk_bytes"`
+	SnapshotCompress   string        `yaml:"snapshot_compression"`
+	ACL                []ACLRule     `yaml:"acl"`
+	AdminToken         string        `yaml:"admin_token"`
+	AllowMigration     bool          `yaml:"allow_migration"`
+	EncryptionKeyFile  string        `yaml:"encryption_key_file"`
+	Backup             BackupConfig  `yaml:"backup"`
+	WaitForLeader      bool          `yaml:"wait_for_leader"`
+	StartupTimeout     time.Duration `yaml:"startup_timeout"`
+	RejoinInterval     time.Duration `yaml:"rejoin_interval"`
+	DegradedReads      bool          `yaml:"degraded_reads"`
+	ScrubInterval      time.Duration `yaml:"scrub_interval"`
+	ScrubPagesPerSec   int           `yaml:"scrub_pages_per_second"`
+	MaxDirtyPages      int           `yaml:"max_dirty_pages"`
+	DeadNodeTimeout    time.Duration `yaml:"dead_node_timeout"`
+	RemoveDeadNodes    bool          `yaml:"remove_dead_nodes"`
+	RestoreInPlace     bool          `yaml:"restore_in_place"`
+}
+
+// BackupConfig schedules uploads of database snapshots from the leader.
+// S3 credentials are only read from the config file or the environment.
+type BackupConfig struct {
+	Interval       time.Duration `yaml:"interval"`
+	Destination    string        `yaml:"destination"`
+	Retain         int           `yaml:"retain"`
+	S3Endpoint     string        `yaml:"s3_endpoint"`
+	S3Region       string        `yaml:"s3_region"`
+	S3AccessKey    string        `yaml:"s3_access_key"`
+	S3SecretKey    string        `yaml:"s3_secret_key"`
+	S3SessionToken string        `yaml:"s3_session_token"`
+}
+
+// ACLRule maps an API token to the key prefixes it may read and write.
+// Tokens are only read from the config file, never from flags.
+type ACLRule struct {
+	Token string   `yaml:"token"`
+	Read  []string `yaml:"read"`
+	Write []string `yaml:"write"`
 }
 
 // Load reads a YAML config file from path. If path is empty or the file
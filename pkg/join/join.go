@@ -0,0 +1,287 @@
+// Package join adds a node to a running cluster through the HTTP API of
+// seed nodes, following leader redirects.
+package join
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type joinRequest struct {
+	ID       string `json:"ID"`
+	RaftAddr string `json:"RaftAddr"`
+	HTTPAddr string `json:"HTTPAddr,omitempty"`
+}
+
+type leaderHintResp struct {
+	Leader     string `json:"leader"`
+	LeaderHTTP string `json:"leader_http"`
+}
+
+// joinResponse is the leader's answer to a join: the advertised HTTP
+// addresses it knows, by raft address
+type joinResponse struct {
+	HTTPAddrs map[string]string `json:"http_addrs"`
+}
+
+// Advertise is what a joining node tells the leader about itself besides
+// its raft address
+type Advertise struct {
+	// HTTPAddr is the base URL of the node's HTTP API as clients reach it
+	HTTPAddr string
+
+	// OnPeers, if set, is given the advertised HTTP addresses, by raft
+	// address, the leader knows when it accepts the node
+	OnPeers func(httpAddrs map[string]string)
+}
+
+// Cluster attempts to join the cluster by posting to seeds and following leader redirects.
+func Cluster(nodeID, raftAddr string, seeds []string, backoff time.Duration, maxRetries int, adv Advertise) {
+	logger := newLogger(nodeID)
+	client := &http.Client{Timeout: 10 * time.Second} // Increased timeout for k8s
+	if backoff <= 0 {
+		backoff = 2 * time.Second
+	}
+	if maxRetries <= 0 {
+		maxRetries = 0 // 0 = infinite
+	}
+
+	logger.Printf("Starting cluster join process with seeds: %v", seeds)
+
+	// Check if already part of cluster before attempting to join
+	if isAlreadyInCluster(client, seeds, nodeID, logger) {
+		logger.Printf("Node %s is already part of the cluster, skipping join", nodeID)
+		return
+	}
+
+	attempt := 0
+	currentBackoff := backoff
+	jr := joinRequest{ID: nodeID, RaftAddr: raftAddr, HTTPAddr: adv.HTTPAddr}
+
+	for {
+		if joinRound(client, seeds, jr, adv.OnPeers, &attempt, logger) {
+			return
+		}
+
+		if maxRetries > 0 && attempt >= maxRetries {
+			logger.Printf("Exhausted all join attempts (%d), giving up", attempt)
+			return
+		}
+
+		logger.Printf("Join round failed, sleeping for %v before retrying", currentBackoff)
+		time.Sleep(currentBackoff)
+
+		// Exponential backoff with jitter, max 30 seconds
+		currentBackoff = time.Duration(float64(currentBackoff) * 1.5)
+		if currentBackoff > 30*time.Second {
+			currentBackoff = 30 * time.Second
+		}
+	}
+}
+
+func newLogger(nodeID string) *log.Logger {
+	return log.New(os.Stdout, fmt.Sprintf("[JOIN %s] ", nodeID), log.LstdFlags)
+}
+
+// joinRound posts jr to each seed in turn, following leader hints, until one
+// accepts it, and hands what the leader answers to onPeers. attempt counts
+// the seeds tried across rounds.
+func joinRound(client *http.Client, seeds []string, jr joinRequest, onPeers func(map[string]string), attempt *int, logger *log.Logger) bool {
+	for _, seed := range seeds {
+		*attempt++
+		logger.Printf("Join attempt %d to seed %s", *attempt, seed)
+
+		// First check if seed is healthy
+		if !isSeedHealthy(client, seed, logger) {
+			logger.Printf("Seed %s is not healthy, trying next", seed)
+			continue
+		}
+
+		// Validate URL
+		u, err := url.Parse(seed)
+		if err != nil {
+			logger.Printf("Invalid seed URL %s: %v", seed, err)
+			continue
+		}
+		u.Path = "/join"
+
+		bodyBytes, err := json.Marshal(jr)
+		if err != nil {
+			logger.Printf("Failed to marshal join request: %v", err)
+			continue
+		}
+
+		req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(bodyBytes))
+		if err != nil {
+			logger.Printf("Failed to create request: %v", err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.Printf("Failed to contact seed %s: %v", seed, err)
+			continue
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			logger.Printf("Successfully joined cluster via %s", seed)
+			readPeers(resp, onPeers)
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				logger.Printf("Warning: failed to close response body: %v", closeErr)
+			}
+			return true
+
+		case http.StatusConflict:
+			// Follow leader hint
+			var h leaderHintResp
+			if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+				logger.Printf("Failed to decode leader hint: %v", err)
+				if closeErr := resp.Body.Close(); closeErr != nil {
+					logger.Printf("Warning: failed to close response body after decode error: %v", closeErr)
+				}
+				continue
+			}
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				logger.Printf("Warning: failed to close response body: %v", closeErr)
+			}
+
+			if h.Leader != "" {
+				leader := h.LeaderHTTP
+				if leader == "" {
+					leader = "http://" + h.Leader
+				}
+				logger.Printf("Redirecting to leader: %s", leader)
+				if tryJoinLeader(client, leader, jr, onPeers, logger) {
+					logger.Printf("Successfully joined cluster via leader %s", leader)
+					return true
+				}
+			}
+
+		case http.StatusServiceUnavailable, http.StatusInternalServerError:
+			logger.Printf("Seed %s is temporarily unavailable (status %d)", seed, resp.StatusCode)
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				logger.Printf("Warning: failed to close response body: %v", closeErr)
+			}
+
+		default:
+			logger.Printf("Unexpected response from %s: status %d", seed, resp.StatusCode)
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				logger.Printf("Warning: failed to close response body: %v", closeErr)
+			}
+		}
+	}
+	return false
+}
+
+// isAlreadyInCluster checks if this node is already part of the cluster
+func isAlreadyInCluster(client *http.Client, seeds []string, nodeID string, logger *log.Logger) bool {
+	for _, seed := range seeds {
+		u, err := url.Parse(seed)
+		if err != nil {
+			continue
+		}
+		u.Path = "/raft/config"
+
+		resp, err := client.Get(u.String())
+		if err != nil {
+			continue
+		}
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				logger.Printf("Warning: failed to close response body: %v", closeErr)
+			}
+		}()
+
+		if resp.StatusCode == http.StatusOK {
+			var config struct {
+				Servers []struct {
+					ID string `json:"id"`
+				} `json:"servers"`
+			}
+
+			if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+				continue
+			}
+
+			for _, server := range config.Servers {
+				if server.ID == nodeID {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isSeedHealthy checks if a seed is responding to health checks
+func isSeedHealthy(client *http.Client, seed string, logger *log.Logger) bool {
+	u, err := url.Parse(seed)
+	if err != nil {
+		return false
+	}
+	u.Path = "/status"
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return false
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Printf("Warning: failed to close response body in health check: %v", closeErr)
+		}
+	}()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// readPeers hands the HTTP addresses in a join response to onPeers, if set
+func readPeers(resp *http.Response, onPeers func(map[string]string)) {
+	var jresp joinResponse
+	if onPeers == nil || json.NewDecoder(resp.Body).Decode(&jresp) != nil || len(jresp.HTTPAddrs) == 0 {
+		return
+	}
+	onPeers(jresp.HTTPAddrs)
+}
+
+// tryJoinLeader attempts to join via the leader directly, at base URL leader
+func tryJoinLeader(client *http.Client, leader string, jr joinRequest, onPeers func(map[string]string), logger *log.Logger) bool {
+	leaderURL := strings.TrimSuffix(leader, "/") + "/join"
+	bodyBytes, err := json.Marshal(jr)
+	if err != nil {
+		logger.Printf("Failed to marshal join request for leader: %v", err)
+		return false
+	}
+
+	req, err := http.NewRequest(http.MethodPost, leaderURL, bytes.NewReader(bodyBytes))
+	if err != nil {
+		logger.Printf("Failed to create leader request: %v", err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Printf("Failed to contact leader %s: %v", leader, err)
+		return false
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Printf("Warning: failed to close response body in leader join: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	readPeers(resp, onPeers)
+	return true
+}
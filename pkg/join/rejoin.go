@@ -0,0 +1,119 @@
+package join
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RejoinOptions configures StartRejoin
+type RejoinOptions struct {
+	// Interval is how often the seeds are asked whether the node is still
+	// in the cluster configuration
+	Interval time.Duration
+
+	// MaxBackoff caps the wait between failed rejoins, which starts at
+	// Interval and doubles after each failure. Zero means 30s.
+	MaxBackoff time.Duration
+
+	// Advertise is sent with each rejoin, as with Cluster
+	Advertise Advertise
+}
+
+// StartRejoin checks every opts.Interval that nodeID is still in the
+// cluster configuration and, when it has been removed, joins again through
+// seeds, until the returned function is called. Only seeds that know a
+// leader are believed, so a partition that hides every seed, or a seed that
+// is itself cut off, does not trigger a join.
+func StartRejoin(nodeID, raftAddr string, seeds []string, opts RejoinOptions) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	maxBackoff := opts.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	go func() {
+		defer close(done)
+		logger := newLogger(nodeID)
+		client := &http.Client{Timeout: 10 * time.Second}
+		jr := joinRequest{ID: nodeID, RaftAddr: raftAddr, HTTPAddr: opts.Advertise.HTTPAddr}
+		attempt := 0
+		wait := opts.Interval
+		for {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			wait = opts.Interval
+			member, known := membership(ctx, client, seeds, nodeID, logger)
+			if !known || member {
+				continue
+			}
+			logger.Printf("Node %s is no longer in the cluster configuration, rejoining", nodeID)
+			if joinRound(client, seeds, jr, opts.Advertise.OnPeers, &attempt, logger) {
+				attempt = 0
+				continue
+			}
+			// Back off from the interval so a cluster that keeps refusing the
+			// node is not asked again on every check
+			wait = opts.Interval << min(attempt, 16)
+			if wait > maxBackoff || wait <= 0 {
+				wait = maxBackoff
+			}
+			logger.Printf("Rejoin failed, retrying in %v", wait)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
+
+// membership asks seeds for the cluster configuration. known reports that
+// some seed answered while knowing a leader, and member that one of those
+// listed nodeID.
+func membership(ctx context.Context, client *http.Client, seeds []string, nodeID string, logger *log.Logger) (member, known bool) {
+	for _, seed := range seeds {
+		u, err := url.Parse(seed)
+		if err != nil {
+			continue
+		}
+		u.Path = "/raft/config"
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		var config struct {
+			Leader  string `json:"leader"`
+			Servers []struct {
+				ID string `json:"id"`
+			} `json:"servers"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&config)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Printf("Warning: failed to close response body: %v", closeErr)
+		}
+		if resp.StatusCode != http.StatusOK || err != nil || config.Leader == "" {
+			continue
+		}
+		known = true
+		for _, server := range config.Servers {
+			if server.ID == nodeID {
+				return true, true
+			}
+		}
+	}
+	return false, known
+}
@@ -0,0 +1,108 @@
+package raftnode
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/hashicorp/raft"
+)
+
+// logFsync is the latency slowLogStore adds to every log write, standing in
+// for an fsync on a slow disk
+const logFsync = 5 * time.Millisecond
+
+// slowLogStore delays each write to the raft log by logFsync
+type slowLogStore struct {
+	raft.LogStore
+}
+
+func (s slowLogStore) StoreLog(l *raft.Log) error {
+	time.Sleep(logFsync)
+	return s.LogStore.StoreLog(l)
+}
+
+func (s slowLogStore) StoreLogs(logs []*raft.Log) error {
+	time.Sleep(logFsync)
+	return s.LogStore.StoreLogs(logs)
+}
+
+// BenchmarkLogBatching applies writes from many goroutines to a
+// single-node cluster whose raft log takes logFsync per write, with each
+// entry written alone and with writes batched
+func BenchmarkLogBatching(b *testing.B) {
+	wrapLogStore = func(s raft.LogStore) raft.LogStore { return slowLogStore{s} }
+	b.Cleanup(func() { wrapLogStore = func(s raft.LogStore) raft.LogStore { return s } })
+
+	for _, bc := range []struct {
+		name       string
+		maxAppend  int
+		batchApply bool
+	}{
+		{"unbatched", 1, false},
+		{"batched", 256, true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			node := startBenchNode(b, Config{MaxAppendEntries: bc.maxAppend, BatchApply: bc.batchApply})
+			var seq atomic.Int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := []byte(fmt.Sprintf("k%08d", seq.Add(1)))
+					if _, err := node.Apply(Command{Type: CmdPut, Key: key, Value: []byte("v")}, 10*time.Second); err != nil {
+						b.Errorf("Apply failed: %v", err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "applies/s")
+		})
+	}
+}
+
+// startBenchNode bootstraps a single-node cluster configured by cfg and
+// waits for it to lead
+func startBenchNode(b *testing.B, cfg Config) *Node {
+	b.Helper()
+	dir := b.TempDir()
+	database, err := db.OpenWithOptions(filepath.Join(dir, "bench.db"), db.Options{NoSync: true})
+	if err != nil {
+		b.Fatalf("Failed to open database: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("Failed to find free port: %v", err)
+	}
+	addr := l.Addr().String()
+	if err := l.Close(); err != nil {
+		b.Fatalf("Failed to release port: %v", err)
+	}
+
+	cfg.NodeID, cfg.RaftAddr, cfg.DataDir, cfg.Bootstrap = "bench", addr, dir, true
+	node, err := StartNode(cfg, &FSM{DB: database})
+	if err != nil {
+		b.Fatalf("Failed to start node: %v", err)
+	}
+	b.Cleanup(func() {
+		if err := node.Shutdown(); err != nil {
+			b.Logf("Warning: failed to shut down node: %v", err)
+		}
+		if err := database.Close(); err != nil {
+			b.Logf("Warning: failed to close database: %v", err)
+		}
+	})
+	deadline := time.Now().Add(10 * time.Second)
+	for !node.IsLeader() {
+		if time.Now().After(deadline) {
+			b.Fatal("Node did not become leader")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return node
+}
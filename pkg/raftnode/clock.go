@@ -0,0 +1,67 @@
+package raftnode
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// clampTimestamp keeps the timestamps commands are applied with from going
+// backward. A command stamped before one already applied, as happens when
+// leadership moves to a node whose clock is behind, takes the highest
+// timestamp applied so far instead, with a warning. Every node applies the
+// same entries in the same order, and the mark is kept in the database with
+// the writes it stamped, so every node clamps alike, including one that
+// restarted or restored a snapshot. It reports whether the mark moved.
+func (f *FSM) clampTimestamp(cmd *Command, index uint64) bool {
+	if cmd.Timestamp == 0 {
+		return false
+	}
+	f.skew.Store(time.Now().UnixNano() - cmd.Timestamp)
+	last := f.lastTimestamp.Load()
+	if cmd.Timestamp >= last {
+		f.lastTimestamp.Store(cmd.Timestamp)
+		return cmd.Timestamp > last
+	}
+	f.logger().LogAttrs(context.Background(), slog.LevelWarn, "command timestamp went backward, clamped",
+		slog.Uint64("index", index),
+		slog.Time("timestamp", time.Unix(0, cmd.Timestamp)),
+		slog.Time("clamped_to", time.Unix(0, last)),
+		slog.Duration("behind", time.Duration(last-cmd.Timestamp)),
+	)
+	cmd.Timestamp = last
+	return false
+}
+
+// loadClock resets the mark clampTimestamp keeps to the one the database
+// records, as on start or after a restore replaced the data
+func (f *FSM) loadClock() error {
+	mark, err := f.DB.ClockMark()
+	if err != nil {
+		return err
+	}
+	var ts int64
+	if !mark.IsZero() {
+		ts = mark.UnixNano()
+	}
+	f.lastTimestamp.Store(ts)
+	return nil
+}
+
+// LastTimestamp returns the highest timestamp a command has been applied
+// with, or the zero time if none carried one
+func (f *FSM) LastTimestamp() time.Time {
+	ts := f.lastTimestamp.Load()
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ts)
+}
+
+// ClockSkew returns how far this node's clock was ahead of the timestamp of
+// the last command it applied, as stamped by the leader. On a follower that
+// is its skew from the leader plus the replication delay; a large negative
+// value means this node's clock is behind.
+func (f *FSM) ClockSkew() time.Duration {
+	return time.Duration(f.skew.Load())
+}
@@ -1,18 +1,101 @@
 package raftnode
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
 
 type CommandType uint8
 
 const (
 	CmdPut CommandType = iota
 	CmdDelete
+	CmdTxn
+	CmdCreateBucket
+	CmdReplaceAll
+	CmdLeaseAcquire
+	CmdLeaseRenew
+	CmdLeaseRelease
 )
 
+func (t CommandType) String() string {
+	switch t {
+	case CmdPut:
+		return "put"
+	case CmdDelete:
+		return "delete"
+	case CmdTxn:
+		return "txn"
+	case CmdCreateBucket:
+		return "create_bucket"
+	case CmdReplaceAll:
+		return "replace_all"
+	case CmdLeaseAcquire:
+		return "lease_acquire"
+	case CmdLeaseRenew:
+		return "lease_renew"
+	case CmdLeaseRelease:
+		return "lease_release"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(t))
+}
+
 type Command struct {
 	Type  CommandType `json:"type"`
 	Key   []byte      `json:"key"`
 	Value []byte      `json:"value,omitempty"`
+	// ReturnOld makes a put or delete respond with the value it replaced
+	ReturnOld bool `json:"return_old,omitempty"`
+	// IfVersion, if set, makes a put fail with db.ErrVersionMismatch unless
+	// the key is at this version
+	IfVersion *uint64 `json:"if_version,omitempty"`
+	// Conds and Ops make up a CmdTxn, evaluated against committed state. A
+	// CmdReplaceAll carries the whole new dataset as Ops.
+	Conds []btree.Cond `json:"conds,omitempty"`
+	Ops   []btree.Op   `json:"ops,omitempty"`
+	// Bucket scopes a put or delete to a bucket, enforcing its quota, and
+	// names the bucket a CmdCreateBucket creates
+	Bucket string `json:"bucket,omitempty"`
+	// Quota, if set, replaces the quota of the bucket a CmdCreateBucket
+	// creates or already exists
+	Quota *db.Quota `json:"quota,omitempty"`
+	// Holder, TTL and Token describe a lease command on the lease named by
+	// Key. Expiry is judged by Timestamp, so every node agrees on it.
+	Holder string        `json:"holder,omitempty"`
+	TTL    time.Duration `json:"ttl,omitempty"`
+	Token  uint64        `json:"token,omitempty"`
+	// Group is the raft group the command was proposed to, stamped by
+	// Apply; a group's FSM refuses commands for another
+	Group string `json:"group,omitempty"`
+	// RequestID is the ID of the API request that issued the command, logged
+	// when it is applied to trace a write across nodes
+	RequestID string `json:"request_id,omitempty"`
+	// Timestamp is the leader's clock, in Unix nanoseconds, when it proposed
+	// the command. The FSM never applies a command with a timestamp earlier
+	// than one it has applied already.
+	Timestamp int64 `json:"ts,omitempty"`
+}
+
+// TxnResult is the FSM response to a CmdTxn
+type TxnResult struct {
+	Succeeded bool
+}
+
+// LeaseResult is the FSM response to a lease acquire or renew: the lease as
+// it stands, and for an acquire whether the caller got it
+type LeaseResult struct {
+	Lease    db.Lease
+	Acquired bool
+}
+
+// OldValue is the FSM response to a command with ReturnOld set
+type OldValue struct {
+	Value   []byte
+	Existed bool
 }
 
 func EncodeCommand(cmd Command) ([]byte, error) {
@@ -0,0 +1,55 @@
+package raftnode
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// SnapshotCompression selects how Persist encodes raft snapshots. Restore
+// accepts every encoding regardless of the setting.
+type SnapshotCompression string
+
+const (
+	SnapshotCompressionNone SnapshotCompression = "none"
+	SnapshotCompressionGzip SnapshotCompression = "gzip"
+)
+
+// snapshotGzipTag prefixes a gzip-compressed snapshot. An uncompressed
+// snapshot starts with the database file's magic number, whose first byte
+// (little-endian) is 'U', so the two cannot be confused.
+const snapshotGzipTag byte = 0xC1
+
+// ParseSnapshotCompression validates a configured compression name; "" means
+// none
+func ParseSnapshotCompression(s string) (SnapshotCompression, error) {
+	switch c := SnapshotCompression(s); c {
+	case "", SnapshotCompressionNone:
+		return SnapshotCompressionNone, nil
+	case SnapshotCompressionGzip:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown snapshot compression %q (want none or gzip)", s)
+	}
+}
+
+// snapshotReader returns a reader over the database snapshot in r,
+// decompressing it if it was written compressed
+func snapshotReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return br, nil
+		}
+		return nil, err
+	}
+	if head[0] != snapshotGzipTag {
+		return br, nil
+	}
+	if _, err := br.Discard(1); err != nil {
+		return nil, err
+	}
+	return gzip.NewReader(br)
+}
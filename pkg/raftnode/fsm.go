@@ -1,9 +1,15 @@
 package raftnode
 
 import (
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/conuredb/conuredb/db"
 	"github.com/hashicorp/raft"
@@ -11,25 +17,162 @@ import (
 
 type FSM struct {
 	DB *db.DB
+
+	// SnapshotCompression compresses snapshots as they are persisted. Zero
+	// means none.
+	SnapshotCompression SnapshotCompression
+
+	// RestoreInPlace restores snapshots from the leader straight over the
+	// database file instead of staging a copy; see db.RestoreOptions.InPlace
+	RestoreInPlace bool
+
+	// Logger, if set, logs each applied command that carries a request ID,
+	// so a write can be followed from the API to every node that applies it
+	Logger *slog.Logger
+
+	// applied is the index of the last entry applied to DB. Raft counts an
+	// entry applied once it is handed to the FSM, slightly before DB holds
+	// it, so reads waiting for an index check both.
+	applied atomic.Uint64
+
+	// lastTimestamp is the highest timestamp a command has been applied
+	// with, as recorded in DB, and skew how far the local clock was ahead of
+	// the last one, both in nanoseconds; see clampTimestamp
+	lastTimestamp atomic.Int64
+	skew          atomic.Int64
+
+	// group is the raft group of the node applying to the FSM, set by
+	// StartNode
+	group string
+}
+
+// restoredIndex marks applied after a snapshot restore, whose index the FSM
+// is not told; raft's applied index is then exact
+const restoredIndex = math.MaxUint64
+
+// hasApplied reports whether DB reflects every entry up to index, given
+// raft has dispatched them
+func (f *FSM) hasApplied(index uint64) bool {
+	return f.applied.Load() >= index
 }
 
 func (f *FSM) Apply(l *raft.Log) interface{} {
+	defer f.applied.Store(l.Index)
 	cmd, err := DecodeCommand(l.Data)
 	if err != nil {
 		return err
 	}
-	switch cmd.Type {
-	case CmdPut:
-		return f.DB.Put(cmd.Key, cmd.Value)
-	case CmdDelete:
-		return f.DB.Delete(cmd.Key)
+	raised := f.clampTimestamp(&cmd, l.Index)
+	versioned := f.DB.AtVersion(l.Index).AtClock(f.LastTimestamp())
+	var resp interface{}
+	if cmd.Group != f.group {
+		resp = ErrWrongGroup
+	} else {
+		resp = f.apply(cmd, versioned, l.Index)
+	}
+	// An entry that wrote nothing must still keep the mark it raised
+	if raised {
+		if err := versioned.SaveClock(); err != nil {
+			f.logger().LogAttrs(context.Background(), slog.LevelWarn, "failed to save clock mark",
+				slog.Uint64("index", l.Index),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+	if f.Logger != nil && cmd.RequestID != "" {
+		attrs := []slog.Attr{
+			slog.String("request_id", cmd.RequestID),
+			slog.Uint64("index", l.Index),
+			slog.String("type", cmd.Type.String()),
+		}
+		if err, ok := resp.(error); ok {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		f.Logger.LogAttrs(context.Background(), slog.LevelInfo, "applied", attrs...)
+	}
+	return resp
+}
+
+// apply executes cmd, the entry at index, through versioned and returns the
+// FSM response. Keys outside buckets are versioned by the index that last
+// wrote them, and every write records the clock mark.
+func (f *FSM) apply(cmd Command, versioned *db.Versioned, index uint64) interface{} {
+	switch {
+	case cmd.Type == CmdPut && cmd.Bucket != "":
+		b, err := versioned.Bucket(cmd.Bucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(cmd.Key, cmd.Value)
+	case cmd.Type == CmdDelete && cmd.Bucket != "":
+		b, err := versioned.Bucket(cmd.Bucket)
+		if err != nil {
+			return err
+		}
+		return b.Delete(cmd.Key)
+	case cmd.Type == CmdPut && cmd.IfVersion != nil:
+		return versioned.PutIfVersion(cmd.Key, cmd.Value, *cmd.IfVersion)
+	case cmd.Type == CmdPut && cmd.ReturnOld:
+		old, existed, err := versioned.PutReturningOld(cmd.Key, cmd.Value)
+		if err != nil {
+			return err
+		}
+		return OldValue{Value: old, Existed: existed}
+	case cmd.Type == CmdPut:
+		return versioned.Put(cmd.Key, cmd.Value)
+	case cmd.Type == CmdDelete && cmd.ReturnOld:
+		old, existed, err := versioned.DeleteReturningOld(cmd.Key)
+		if err != nil {
+			return err
+		}
+		return OldValue{Value: old, Existed: existed}
+	case cmd.Type == CmdDelete:
+		return versioned.Delete(cmd.Key)
+	case cmd.Type == CmdTxn:
+		ok, err := versioned.Txn(cmd.Conds, cmd.Ops)
+		if err != nil {
+			return err
+		}
+		return TxnResult{Succeeded: ok}
+	case cmd.Type == CmdReplaceAll:
+		return versioned.ReplaceAll(func(yield func(key, value []byte) bool) {
+			for _, op := range cmd.Ops {
+				if !yield(op.Key, op.Value) {
+					return
+				}
+			}
+		})
+	case cmd.Type == CmdLeaseAcquire:
+		// The entry's index is the fencing token, larger for every later
+		// acquire
+		lease, acquired, err := versioned.AcquireLease(string(cmd.Key), cmd.Holder, cmd.TTL, time.Unix(0, cmd.Timestamp), index)
+		if err != nil {
+			return err
+		}
+		return LeaseResult{Lease: lease, Acquired: acquired}
+	case cmd.Type == CmdLeaseRenew:
+		lease, err := versioned.RenewLease(string(cmd.Key), cmd.Holder, cmd.Token, cmd.TTL, time.Unix(0, cmd.Timestamp))
+		if err != nil {
+			return err
+		}
+		return LeaseResult{Lease: lease, Acquired: true}
+	case cmd.Type == CmdLeaseRelease:
+		return versioned.ReleaseLease(string(cmd.Key), cmd.Holder, cmd.Token)
+	case cmd.Type == CmdCreateBucket:
+		if err := versioned.CreateBucket(cmd.Bucket); err != nil {
+			return err
+		}
+		if cmd.Quota != nil {
+			return versioned.SetBucketQuota(cmd.Bucket, *cmd.Quota)
+		}
+		return nil
 	default:
 		return nil
 	}
 }
 
 func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
-	return &dbSnapshot{db: f.DB}, nil
+	return &dbSnapshot{db: f.DB, compression: f.SnapshotCompression}, nil
 }
 
 func (f *FSM) Restore(rc io.ReadCloser) error {
@@ -38,11 +181,29 @@ func (f *FSM) Restore(rc io.ReadCloser) error {
 			fmt.Fprintf(os.Stderr, "Warning: failed to close ReadCloser during restore: %v\n", closeErr)
 		}
 	}()
-	return f.DB.RestoreFrom(rc)
+	r, err := snapshotReader(rc)
+	if err != nil {
+		return err
+	}
+	// Raft checksums snapshots itself; skip the redundant pass over the file
+	if err := f.DB.RestoreFromWithOptions(r, db.RestoreOptions{SkipVerify: true, InPlace: f.RestoreInPlace}); err != nil {
+		return err
+	}
+	f.applied.Store(restoredIndex)
+	return f.loadClock()
+}
+
+// logger returns Logger, or the default logger if it is unset
+func (f *FSM) logger() *slog.Logger {
+	if f.Logger != nil {
+		return f.Logger
+	}
+	return slog.Default()
 }
 
 type dbSnapshot struct {
-	db *db.DB
+	db          *db.DB
+	compression SnapshotCompression
 }
 
 func (s *dbSnapshot) Persist(sink raft.SnapshotSink) error {
@@ -50,11 +211,30 @@ func (s *dbSnapshot) Persist(sink raft.SnapshotSink) error {
 		// Ensure sink is closed on any path
 		_ = sink.Close()
 	}()
-	if err := s.db.SnapshotTo(sink); err != nil {
+	if err := s.write(sink); err != nil {
 		_ = sink.Cancel()
 		return err
 	}
 	return nil
 }
 
+// write streams the database to w, encoded as configured
+func (s *dbSnapshot) write(w io.Writer) error {
+	if s.compression != SnapshotCompressionGzip {
+		return s.db.SnapshotTo(w)
+	}
+	if _, err := w.Write([]byte{snapshotGzipTag}); err != nil {
+		return err
+	}
+	// Zero-padded pages compress well even at the fastest level
+	zw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	if err := s.db.SnapshotTo(zw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
 func (s *dbSnapshot) Release() {}
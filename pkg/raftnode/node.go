@@ -1,9 +1,14 @@
 package raftnode
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
@@ -15,11 +20,59 @@ type Config struct {
 	RaftAddr  string
 	DataDir   string
 	Bootstrap bool
+
+	// AdvertiseAddr is the address peers dial to reach this node, e.g. a
+	// service DNS name when RaftAddr binds 0.0.0.0. Defaults to RaftAddr.
+	AdvertiseAddr string
+	// MaxPool is the number of idle connections kept per peer (default 3)
+	MaxPool int
+	// TransportTimeout bounds raft RPC I/O (default 10s)
+	TransportTimeout time.Duration
+
+	// Raft timing; zero values keep the hashicorp/raft defaults, which are
+	// tuned for a LAN. LeaderLeaseTimeout must not exceed HeartbeatTimeout.
+	HeartbeatTimeout   time.Duration
+	ElectionTimeout    time.Duration
+	LeaderLeaseTimeout time.Duration
+	CommitTimeout      time.Duration
+
+	// MaxAppendEntries caps how many log entries the leader writes, and
+	// fsyncs, to its log store as one batch and sends a follower per RPC
+	// (default 64, at most 1024; 1 writes each entry on its own).
+	// BatchApply buffers applies so entries arriving while a batch is being
+	// written queue for the next one rather than block; with a slow raft
+	// disk the two set how many writes share an fsync.
+	MaxAppendEntries int
+	BatchApply       bool
+
+	// MaxCommandBytes caps an encoded command; Apply rejects a larger one
+	// with ErrCommandTooLarge before it reaches the log. Zero means
+	// MaxCommandBytes, the package default.
+	MaxCommandBytes int
+
+	// GroupID names the raft group this node replicates, for a process
+	// running several independent groups, each with its own RaftAddr,
+	// FSM and database. A group's raft state is kept under
+	// DataDir/raft-<GroupID>, so groups may share a DataDir. Empty is the
+	// default group, kept under DataDir/raft.
+	GroupID string
 }
 
 type Node struct {
-	raft *raft.Raft
-	fsm  *FSM
+	raft      *raft.Raft
+	fsm       *FSM
+	transport *trackingTransport
+	stores    []*raftboltdb.BoltStore
+	config    raft.Config
+	joinMu    sync.Mutex
+	// maxCommandBytes is the effective Config.MaxCommandBytes
+	maxCommandBytes int
+	// dead is what WatchDeadPeers last found
+	dead deadPeers
+	// group is Config.GroupID
+	group string
+	// stopTracking stops trackPeers
+	stopTracking func()
 }
 
 func (n *Node) Raft() *raft.Raft {
@@ -34,22 +87,245 @@ func (n *Node) Leader() raft.ServerAddress {
 	return n.raft.Leader()
 }
 
+// AddVoter adds id as a voter at addr. It is idempotent: a voter already
+// registered at addr is left alone, and a known ID with a new address is moved
+// in a single reconfiguration.
 func (n *Node) AddVoter(id, addr string) error {
-	future := n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	// Serialize joins so two retries for the same ID cannot both miss the check
+	n.joinMu.Lock()
+	defer n.joinMu.Unlock()
+
+	f := n.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return err
+	}
+	for _, sv := range f.Configuration().Servers {
+		if sv.ID == raft.ServerID(id) && sv.Address == raft.ServerAddress(addr) && sv.Suffrage == raft.Voter {
+			return nil
+		}
+	}
+
+	// AddVoter on an existing ID updates its address in place; passing the
+	// index we checked against fails the change if membership moved meanwhile
+	future := n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), f.Index(), 0)
 	return future.Error()
 }
 
-func (n *Node) Apply(cmd Command, timeout time.Duration) error {
+// Addr returns the raft address peers reach this node at, its advertise
+// address if one is set
+func (n *Node) Addr() raft.ServerAddress {
+	return n.transport.LocalAddr()
+}
+
+// GroupID returns the raft group the node replicates; see Config.GroupID
+func (n *Node) GroupID() string {
+	return n.group
+}
+
+// ServerID returns the ID of the server at addr in the group's membership
+func (n *Node) ServerID(addr raft.ServerAddress) (raft.ServerID, bool) {
+	f := n.raft.GetConfiguration()
+	if f.Error() != nil {
+		return "", false
+	}
+	for _, sv := range f.Configuration().Servers {
+		if sv.Address == addr {
+			return sv.ID, true
+		}
+	}
+	return "", false
+}
+
+// ServerAddr returns the raft address of server id in the group's membership
+func (n *Node) ServerAddr(id raft.ServerID) (raft.ServerAddress, bool) {
+	f := n.raft.GetConfiguration()
+	if f.Error() != nil {
+		return "", false
+	}
+	for _, sv := range f.Configuration().Servers {
+		if sv.ID == id {
+			return sv.Address, true
+		}
+	}
+	return "", false
+}
+
+// RaftConfig returns the raft configuration the node was started with.
+func (n *Node) RaftConfig() raft.Config {
+	return n.config
+}
+
+// Shutdown stops raft and closes the log and stable stores.
+func (n *Node) Shutdown() error {
+	n.stopTracking()
+	if err := n.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	for _, st := range n.stores {
+		if err := st.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applied is the outcome of a command replicated by Apply
+type Applied struct {
+	// Index is the raft log index the command committed at. A follower
+	// whose applied index has reached it reflects the command.
+	Index uint64
+
+	// Response is what the FSM returned, such as an OldValue
+	Response any
+
+	// Replicas is how many servers, the leader included, held the entry
+	// when it was applied, as far as the leader had heard. It is at least a
+	// quorum; a follower whose acknowledgement was still in flight is missed.
+	Replicas int
+}
+
+// Apply replicates cmd and returns the FSM's response. An error returned by
+// the FSM is reported as the error rather than the response.
+func (n *Node) Apply(cmd Command, timeout time.Duration) (Applied, error) {
+	return n.ApplyAsync(cmd, timeout).Wait()
+}
+
+// MaxCommandBytes is the default cap on an encoded command. Raft ships each
+// log entry whole, to every follower and into the log store, so a larger one
+// would hold up replication and heartbeats behind it.
+const MaxCommandBytes = 8 << 20
+
+// MaxCommandBytes returns the largest encoded command Apply accepts
+func (n *Node) MaxCommandBytes() int {
+	return n.maxCommandBytes
+}
+
+// ErrCommandTooLarge is returned by Apply for a command that encodes to more
+// than the node's MaxCommandBytes
+var ErrCommandTooLarge = errors.New("command too large for one raft log entry")
+
+// ErrWrongGroup is returned by Apply for a command addressed to another raft
+// group, and by the FSM for one that reached the wrong group's log
+var ErrWrongGroup = errors.New("command for another raft group")
+
+// PendingApply is a command handed to raft by ApplyAsync
+type PendingApply struct {
+	node   *Node
+	future raft.ApplyFuture
+	err    error
+}
+
+// ApplyAsync hands cmd to raft without waiting for it to commit, so several
+// commands can be in flight at once. They commit in the order they were
+// handed over.
+func (n *Node) ApplyAsync(cmd Command, timeout time.Duration) *PendingApply {
+	if cmd.Group == "" {
+		cmd.Group = n.group
+	}
+	if cmd.Group != n.group {
+		return &PendingApply{err: fmt.Errorf("%w: %q proposed to %q", ErrWrongGroup, cmd.Group, n.group)}
+	}
+	if cmd.Timestamp == 0 {
+		cmd.Timestamp = time.Now().UnixNano()
+	}
 	b, err := EncodeCommand(cmd)
 	if err != nil {
-		return err
+		return &PendingApply{err: err}
+	}
+	if len(b) > n.maxCommandBytes {
+		return &PendingApply{err: fmt.Errorf("%w: %d bytes encoded, limit is %d", ErrCommandTooLarge, len(b), n.maxCommandBytes)}
+	}
+	return &PendingApply{node: n, future: n.raft.Apply(b, timeout)}
+}
+
+// Wait blocks until the command is applied and returns what Apply would
+func (p *PendingApply) Wait() (Applied, error) {
+	if p.err != nil {
+		return Applied{}, p.err
+	}
+	if err := p.future.Error(); err != nil {
+		return Applied{}, err
+	}
+	if err, ok := p.future.Response().(error); ok {
+		return Applied{}, err
+	}
+	index := p.future.Index()
+	return Applied{Index: index, Response: p.future.Response(), Replicas: p.node.replicas(index)}, nil
+}
+
+// ClockSkew returns how far this node's clock was ahead of the leader's when
+// it applied the last command; see FSM.ClockSkew
+func (n *Node) ClockSkew() time.Duration {
+	return n.fsm.ClockSkew()
+}
+
+// ReadIndex returns an index at which a linearizable read may be served:
+// once a node has applied it, its data reflects every write acknowledged
+// before the call. Only the leader can answer, after confirming with a
+// quorum that it still leads. It uses the last log index rather than the
+// commit index, which may lag just after an election; entries past the
+// commit index only make the reader wait a little longer.
+func (n *Node) ReadIndex() (uint64, error) {
+	if !n.IsLeader() {
+		return 0, raft.ErrNotLeader
+	}
+	index := n.raft.LastIndex()
+	if err := n.raft.VerifyLeader().Error(); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// WaitApplied waits up to timeout for the FSM to apply the log entry at
+// index, and reports whether it did
+func (n *Node) WaitApplied(index uint64, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return n.WaitForApplied(ctx, index) == nil
+}
+
+// WaitForApplied blocks until the FSM has applied the log entry at index,
+// returning ctx's error if it is done first
+func (n *Node) WaitForApplied(ctx context.Context, index uint64) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for n.raft.AppliedIndex() < index || !n.fsm.hasApplied(index) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// groupRaftDir returns where group's raft state is kept under dataDir; see
+// Config.GroupID
+func groupRaftDir(dataDir, group string) (string, error) {
+	if group == "." || group == ".." || strings.ContainsAny(group, `/\`) {
+		return "", fmt.Errorf("invalid group ID %q", group)
+	}
+	if group == "" {
+		return filepath.Join(dataDir, "raft"), nil
 	}
-	f := n.raft.Apply(b, timeout)
-	return f.Error()
+	return filepath.Join(dataDir, "raft-"+group), nil
 }
 
+// wrapLogStore lets tests stand a slower disk in for the raft log
+var wrapLogStore = func(s raft.LogStore) raft.LogStore { return s }
+
 func StartNode(cfg Config, fsm *FSM) (*Node, error) {
-	raftDir := filepath.Join(cfg.DataDir, "raft")
+	if cfg.MaxCommandBytes < 0 {
+		return nil, fmt.Errorf("max command bytes must not be negative, got %d", cfg.MaxCommandBytes)
+	}
+	maxCommandBytes := cfg.MaxCommandBytes
+	if maxCommandBytes == 0 {
+		maxCommandBytes = MaxCommandBytes
+	}
+	raftDir, err := groupRaftDir(cfg.DataDir, cfg.GroupID)
+	if err != nil {
+		return nil, err
+	}
 	if err := os.MkdirAll(raftDir, 0o755); err != nil {
 		return nil, err
 	}
@@ -58,6 +334,13 @@ func StartNode(cfg Config, fsm *FSM) (*Node, error) {
 	rcfg.LocalID = raft.ServerID(cfg.NodeID)
 	rcfg.SnapshotInterval = 30 * time.Second
 	rcfg.SnapshotThreshold = 8192
+	if err := applyTimeouts(rcfg, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := fsm.loadClock(); err != nil {
+		return nil, err
+	}
 
 	// Stores
 	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "stable.bolt"))
@@ -74,17 +357,20 @@ func StartNode(cfg Config, fsm *FSM) (*Node, error) {
 	}
 
 	// Transport
-	transport, err := raft.NewTCPTransport(cfg.RaftAddr, nil, 3, 10*time.Second, os.Stderr)
+	tcp, err := newTransport(cfg)
 	if err != nil {
 		return nil, err
 	}
+	transport := newTrackingTransport(tcp)
 
-	r, err := raft.NewRaft(rcfg, fsm, logStore, stableStore, snaps, transport)
+	fsm.group = cfg.GroupID
+	r, err := raft.NewRaft(rcfg, fsm, wrapLogStore(logStore), stableStore, snaps, transport)
 	if err != nil {
 		return nil, err
 	}
 
-	n := &Node{raft: r, fsm: fsm}
+	n := &Node{raft: r, fsm: fsm, transport: transport, stores: []*raftboltdb.BoltStore{logStore, stableStore}, config: *rcfg, maxCommandBytes: maxCommandBytes, group: cfg.GroupID}
+	n.stopTracking = n.trackPeers()
 
 	// Bootstrap if requested and no existing state
 	if cfg.Bootstrap {
@@ -96,7 +382,7 @@ func StartNode(cfg Config, fsm *FSM) (*Node, error) {
 			configuration := raft.Configuration{
 				Servers: []raft.Server{{
 					ID:      raft.ServerID(cfg.NodeID),
-					Address: raft.ServerAddress(cfg.RaftAddr),
+					Address: raft.ServerAddress(cfg.advertise()),
 				}},
 			}
 			if err := r.BootstrapCluster(configuration).Error(); err != nil {
@@ -108,3 +394,32 @@ func StartNode(cfg Config, fsm *FSM) (*Node, error) {
 
 	return n, nil
 }
+
+// applyTimeouts copies the non-zero timeouts from cfg into rcfg and checks
+// they are consistent
+func applyTimeouts(rcfg *raft.Config, cfg Config) error {
+	if cfg.HeartbeatTimeout > 0 {
+		rcfg.HeartbeatTimeout = cfg.HeartbeatTimeout
+	}
+	if cfg.ElectionTimeout > 0 {
+		rcfg.ElectionTimeout = cfg.ElectionTimeout
+	}
+	if cfg.LeaderLeaseTimeout > 0 {
+		rcfg.LeaderLeaseTimeout = cfg.LeaderLeaseTimeout
+	}
+	if cfg.CommitTimeout > 0 {
+		rcfg.CommitTimeout = cfg.CommitTimeout
+	}
+	if cfg.MaxAppendEntries > 0 {
+		rcfg.MaxAppendEntries = cfg.MaxAppendEntries
+	}
+	rcfg.BatchApplyCh = cfg.BatchApply
+
+	if rcfg.LeaderLeaseTimeout > rcfg.HeartbeatTimeout {
+		return fmt.Errorf("leader lease timeout %v must not exceed heartbeat timeout %v", rcfg.LeaderLeaseTimeout, rcfg.HeartbeatTimeout)
+	}
+	if rcfg.ElectionTimeout < rcfg.HeartbeatTimeout {
+		return fmt.Errorf("election timeout %v must be at least heartbeat timeout %v", rcfg.ElectionTimeout, rcfg.HeartbeatTimeout)
+	}
+	return raft.ValidateConfig(rcfg)
+}
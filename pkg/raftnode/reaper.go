@@ -0,0 +1,186 @@
+package raftnode
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// DeadPeer is a server in the configuration that has not answered the
+// leader for longer than DeadPeerOptions.After
+type DeadPeer struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Voter   bool   `json:"voter"`
+
+	// LastContact is when the peer last answered this node, or zero if it
+	// has not since this node became leader
+	LastContact time.Time `json:"last_contact"`
+}
+
+// DeadPeerOptions configures WatchDeadPeers
+type DeadPeerOptions struct {
+	// After is how long a peer must go without answering the leader to be
+	// flagged dead. Keep it far above any partition the cluster is expected
+	// to ride out; hours, not minutes.
+	After time.Duration
+
+	// Interval is how often to check (default After/10)
+	Interval time.Duration
+
+	// Remove takes a dead peer out of the raft configuration, one per
+	// check, as long as the voters left are still a quorum of the current
+	// configuration and a quorum of them are answering. Otherwise dead
+	// peers are only reported.
+	Remove bool
+
+	// OnDead is called once when a peer is flagged, and again only if it
+	// answers and later goes silent again
+	OnDead func(DeadPeer)
+
+	// OnRemove is called after each attempted removal with its outcome
+	OnRemove func(DeadPeer, error)
+}
+
+// deadPeers is what WatchDeadPeers last found, for DeadPeers
+type deadPeers struct {
+	mu    sync.Mutex
+	peers []DeadPeer
+}
+
+// DeadPeers returns the peers WatchDeadPeers last flagged as dead, sorted by
+// ID. It is nil on a follower or when nothing is watching.
+func (n *Node) DeadPeers() []DeadPeer {
+	n.dead.mu.Lock()
+	defer n.dead.mu.Unlock()
+	return n.dead.peers
+}
+
+// WatchDeadPeers checks every interval, while this node leads, for peers
+// that have not answered it for opts.After, and reports or removes them.
+// Silence is measured from no earlier than when this node was first seen
+// leading, since a new leader has not yet heard from anyone. Call the
+// returned function to stop watching.
+func (n *Node) WatchDeadPeers(opts DeadPeerOptions) (stop func()) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = opts.After / 10
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var leaderSince time.Time
+		flagged := make(map[raft.ServerID]bool)
+		for {
+			select {
+			case <-ticker.C:
+				if !n.IsLeader() {
+					leaderSince = time.Time{}
+					clear(flagged)
+					n.setDeadPeers(nil)
+					continue
+				}
+				if leaderSince.IsZero() {
+					leaderSince = time.Now()
+				}
+				n.checkDeadPeers(opts, leaderSince, flagged)
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (n *Node) setDeadPeers(peers []DeadPeer) {
+	n.dead.mu.Lock()
+	defer n.dead.mu.Unlock()
+	n.dead.peers = peers
+}
+
+// checkDeadPeers flags the peers silent since before opts.After ago, or
+// since leaderSince if later, and removes at most one if opts.Remove allows
+func (n *Node) checkDeadPeers(opts DeadPeerOptions, leaderSince time.Time, flagged map[raft.ServerID]bool) {
+	f := n.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return
+	}
+	now := time.Now()
+	voters, live := 0, 0
+	var dead []DeadPeer
+	seen := make(map[raft.ServerID]bool)
+	for _, sv := range f.Configuration().Servers {
+		voter := sv.Suffrage == raft.Voter
+		if voter {
+			voters++
+		}
+		if sv.ID == n.config.LocalID {
+			if voter {
+				live++
+			}
+			continue
+		}
+		seen[sv.ID] = true
+		contact := n.transport.lastContact(sv.ID)
+		silent := now.Sub(leaderSince)
+		if contact.After(leaderSince) {
+			silent = now.Sub(contact)
+		} else {
+			contact = time.Time{}
+		}
+		if silent < opts.After {
+			delete(flagged, sv.ID)
+			if voter {
+				live++
+			}
+			continue
+		}
+		peer := DeadPeer{ID: string(sv.ID), Address: string(sv.Address), Voter: voter, LastContact: contact}
+		dead = append(dead, peer)
+		if !flagged[sv.ID] {
+			flagged[sv.ID] = true
+			if opts.OnDead != nil {
+				opts.OnDead(peer)
+			}
+		}
+	}
+	// Forget peers no longer in the configuration
+	for id := range flagged {
+		if !seen[id] {
+			delete(flagged, id)
+		}
+	}
+	sort.Slice(dead, func(i, j int) bool { return dead[i].ID < dead[j].ID })
+	n.setDeadPeers(dead)
+
+	if !opts.Remove || len(dead) == 0 {
+		return
+	}
+	// Remove non-voters first, which cannot change the quorum
+	peer := dead[0]
+	for _, p := range dead {
+		if !p.Voter {
+			peer = p
+			break
+		}
+	}
+	if peer.Voter {
+		left := voters - 1
+		if left < voters/2+1 || live < left/2+1 {
+			return
+		}
+	}
+	// Passing the index checked against fails the removal if the
+	// configuration changed meanwhile
+	err := n.raft.RemoveServer(raft.ServerID(peer.ID), f.Index(), 0).Error()
+	if err == nil {
+		delete(flagged, raft.ServerID(peer.ID))
+	}
+	if opts.OnRemove != nil {
+		opts.OnRemove(peer, err)
+	}
+}
@@ -0,0 +1,260 @@
+package raftnode
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// PeerReplication describes how far a follower trails the leader's log.
+type PeerReplication struct {
+	ID         string `json:"id"`
+	MatchIndex uint64 `json:"match_index"`
+	Lag        uint64 `json:"lag"`
+
+	// LastContact is when the follower last answered an AppendEntries or
+	// heartbeat from this node, or zero if it never has
+	LastContact time.Time `json:"last_contact"`
+}
+
+// trackingTransport records, per peer, the highest log index acknowledged by a
+// successful AppendEntries and when the peer last answered one. hashicorp/raft
+// keeps the leader's matchIndex and lastContact private, so we observe them on
+// the wire instead.
+type trackingTransport struct {
+	*raft.NetworkTransport
+
+	mu    sync.Mutex
+	peers map[raft.ServerID]*peerProgress
+}
+
+// peerProgress is what trackingTransport knows of one peer. match is only
+// valid for the leader term it was learned in: a new leader, or this node
+// leading again, replicates afresh and may find the peer further behind.
+type peerProgress struct {
+	term    uint64
+	match   uint64
+	contact time.Time
+}
+
+func newTrackingTransport(t *raft.NetworkTransport) *trackingTransport {
+	return &trackingTransport{NetworkTransport: t, peers: make(map[raft.ServerID]*peerProgress)}
+}
+
+func (t *trackingTransport) observe(id raft.ServerID, req *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.peers[id]
+	if p == nil {
+		p = &peerProgress{}
+		t.peers[id] = p
+	}
+	// Any answer, even a rejection, shows the peer is alive
+	p.contact = time.Now()
+	if req.Term != p.term {
+		p.term, p.match = req.Term, 0
+	}
+
+	// Heartbeats carry neither entries nor a previous index and prove nothing
+	if !resp.Success || (req.PrevLogEntry == 0 && len(req.Entries) == 0) {
+		return
+	}
+	idx := req.PrevLogEntry + uint64(len(req.Entries))
+	if n := len(req.Entries); n > 0 {
+		idx = req.Entries[n-1].Index
+	}
+	if idx > p.match {
+		p.match = idx
+	}
+}
+
+// forget drops what is known of id, for a peer whose replication raft has
+// just started or stopped: a server removed and added back starts over
+func (t *trackingTransport) forget(id raft.ServerID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.peers, id)
+}
+
+func (t *trackingTransport) matchIndex(id raft.ServerID) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p := t.peers[id]; p != nil {
+		return p.match
+	}
+	return 0
+}
+
+// lastContact returns when id last answered, or zero if it never has
+func (t *trackingTransport) lastContact(id raft.ServerID) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p := t.peers[id]; p != nil {
+		return p.contact
+	}
+	return time.Time{}
+}
+
+// trackPeers forgets a peer's progress whenever the leader starts or stops
+// replicating to it, which raft reports as a PeerObservation. Call the
+// returned function to stop.
+func (n *Node) trackPeers() (stop func()) {
+	ch := make(chan raft.Observation, 16)
+	obs := raft.NewObserver(ch, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.PeerObservation)
+		return ok
+	})
+	n.raft.RegisterObserver(obs)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case o := <-ch:
+				n.transport.forget(o.Data.(raft.PeerObservation).Peer.ID)
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			n.raft.DeregisterObserver(obs)
+			close(done)
+		})
+	}
+}
+
+func (t *trackingTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	if err := t.NetworkTransport.AppendEntries(id, target, args, resp); err != nil {
+		return err
+	}
+	t.observe(id, args, resp)
+	return nil
+}
+
+func (t *trackingTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	p, err := t.NetworkTransport.AppendEntriesPipeline(id, target)
+	if err != nil {
+		return nil, err
+	}
+	tp := &trackingPipeline{
+		AppendPipeline: p,
+		id:             id,
+		transport:      t,
+		out:            make(chan raft.AppendFuture, cap(p.Consumer())),
+		done:           make(chan struct{}),
+	}
+	go tp.forward()
+	return tp, nil
+}
+
+// trackingPipeline relays pipelined responses to raft after recording them.
+type trackingPipeline struct {
+	raft.AppendPipeline
+
+	id        raft.ServerID
+	transport *trackingTransport
+	out       chan raft.AppendFuture
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (p *trackingPipeline) forward() {
+	for {
+		select {
+		case f := <-p.AppendPipeline.Consumer():
+			if f.Error() == nil {
+				p.transport.observe(p.id, f.Request(), f.Response())
+			}
+			select {
+			case p.out <- f:
+			case <-p.done:
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *trackingPipeline) Consumer() <-chan raft.AppendFuture {
+	return p.out
+}
+
+func (p *trackingPipeline) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	return p.AppendPipeline.Close()
+}
+
+// Replication reports the match index and lag of every other server in the
+// configuration. It is only meaningful on the leader and returns nil elsewhere.
+func (n *Node) Replication() []PeerReplication {
+	if !n.IsLeader() {
+		return nil
+	}
+	f := n.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return nil
+	}
+
+	last := n.raft.LastIndex()
+	_, self := n.raft.LeaderWithID()
+	peers := make([]PeerReplication, 0, len(f.Configuration().Servers))
+	for _, sv := range f.Configuration().Servers {
+		if sv.ID == self {
+			continue
+		}
+		match := n.transport.matchIndex(sv.ID)
+		var lag uint64
+		if last > match {
+			lag = last - match
+		}
+		peers = append(peers, PeerReplication{ID: string(sv.ID), MatchIndex: match, Lag: lag, LastContact: n.transport.lastContact(sv.ID)})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+// replicas counts the servers known to hold the entry at index: this node,
+// which appended it, and every peer that has acknowledged it
+func (n *Node) replicas(index uint64) int {
+	f := n.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return 1
+	}
+	count := 1
+	for _, sv := range f.Configuration().Servers {
+		if sv.ID != n.config.LocalID && n.transport.matchIndex(sv.ID) >= index {
+			count++
+		}
+	}
+	return count
+}
+
+// WatchReplicationLag polls replication progress every interval and calls fn
+// for each follower whose lag exceeds threshold. Call the returned function to
+// stop watching.
+func (n *Node) WatchReplicationLag(interval time.Duration, threshold uint64, fn func(PeerReplication)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, p := range n.Replication() {
+					if p.Lag > threshold {
+						fn(p)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
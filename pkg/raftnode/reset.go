@@ -0,0 +1,57 @@
+package raftnode
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrRaftStateInUse is returned by ResetState while a running node holds the
+// raft stores open
+var ErrRaftStateInUse = errors.New("raft state is in use by a running node")
+
+// ResetState clears the raft log, stable store and snapshots of group under
+// dataDir, leaving the database file alone, so a node whose raft state
+// conflicts with its cluster's, as after a forced removal, can join again as
+// a fresh member. group is a Config.GroupID, with empty for the default
+// group, and only that group's state is touched. Its raft directory is moved
+// aside to <dir>.reset-<UTC time> rather than deleted, and the new path
+// returned. It fails with ErrRaftStateInUse if a node is running on the
+// group's state, and with os.ErrNotExist if there is none to reset.
+func ResetState(dataDir, group string) (string, error) {
+	raftDir, err := groupRaftDir(dataDir, group)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(raftDir); err != nil {
+		return "", err
+	}
+
+	// A running node holds an exclusive lock on each store
+	for _, name := range []string{"stable.bolt", "log.bolt"} {
+		path := filepath.Join(raftDir, name)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		store, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+		if errors.Is(err, bolt.ErrTimeout) {
+			return "", fmt.Errorf("%w: %s", ErrRaftStateInUse, path)
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := store.Close(); err != nil {
+			return "", err
+		}
+	}
+
+	aside := raftDir + ".reset-" + time.Now().UTC().Format("20060102T150405Z")
+	if err := os.Rename(raftDir, aside); err != nil {
+		return "", err
+	}
+	return aside, nil
+}
@@ -0,0 +1,75 @@
+package raftnode
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	defaultMaxPool          = 3
+	defaultTransportTimeout = 10 * time.Second
+)
+
+// advertiseAddr is a host:port other servers dial to reach this node. Unlike
+// a *net.TCPAddr it may carry a DNS name, which survives pod IP changes.
+type advertiseAddr string
+
+func (a advertiseAddr) Network() string { return "tcp" }
+func (a advertiseAddr) String() string  { return string(a) }
+
+// streamLayer listens on the bind address but reports the advertise address,
+// so raft records the name peers should use rather than what we bound.
+type streamLayer struct {
+	net.Listener
+	advertise net.Addr
+}
+
+func (s *streamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", string(address), timeout)
+}
+
+func (s *streamLayer) Addr() net.Addr {
+	return s.advertise
+}
+
+// newTransport binds cfg.RaftAddr and advertises cfg.AdvertiseAddr, falling
+// back to the bind address when none is set.
+func newTransport(cfg Config) (*raft.NetworkTransport, error) {
+	advertise := cfg.advertise()
+	host, _, err := net.SplitHostPort(advertise)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raft advertise address %q: %w", advertise, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return nil, errors.New("raft address is not advertisable; set an advertise address when binding to all interfaces")
+	}
+
+	maxPool := cfg.MaxPool
+	if maxPool <= 0 {
+		maxPool = defaultMaxPool
+	}
+	timeout := cfg.TransportTimeout
+	if timeout <= 0 {
+		timeout = defaultTransportTimeout
+	}
+
+	list, err := net.Listen("tcp", cfg.RaftAddr)
+	if err != nil {
+		return nil, err
+	}
+	stream := &streamLayer{Listener: list, advertise: advertiseAddr(advertise)}
+	return raft.NewNetworkTransport(stream, maxPool, timeout, os.Stderr), nil
+}
+
+// advertise returns the address other servers should use to reach this node
+func (c Config) advertise() string {
+	if c.AdvertiseAddr != "" {
+		return c.AdvertiseAddr
+	}
+	return c.RaftAddr
+}
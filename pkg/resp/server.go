@@ -0,0 +1,468 @@
+// Package resp serves a subset of the Redis protocol (RESP2) so Redis
+// clients can read and write ConureDB: GET, SET, DEL and SCAN, plus PING,
+// ECHO, QUIT, READONLY and READWRITE. Writes go through raft like the HTTP
+// API's; a follower answers writes, and reads not marked READONLY, with a
+// Redis Cluster style "-MOVED 0 <leader>" redirect.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// defaultScanCount is how many keys SCAN returns without COUNT
+	defaultScanCount = 10
+
+	// maxCursors bounds the SCAN cursors one connection keeps open
+	maxCursors = 1024
+)
+
+// Server answers RESP commands against a node's database
+type Server struct {
+	node           *raftnode.Node
+	db             *db.DB
+	applyTimeout   time.Duration
+	barrierTimeout time.Duration
+	maxScanCount   int
+	leaderAddr     func(raft.ServerAddress) string
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// New returns a Server for node's database; start it with ListenAndServe
+func New(node *raftnode.Node, db *db.DB) *Server {
+	return &Server{
+		node:           node,
+		db:             db,
+		applyTimeout:   5 * time.Second,
+		barrierTimeout: 3 * time.Second,
+		maxScanCount:   1000,
+		conns:          make(map[net.Conn]struct{}),
+	}
+}
+
+// WithApplyTimeout bounds how long a write waits to replicate
+func (s *Server) WithApplyTimeout(d time.Duration) *Server {
+	if d > 0 {
+		s.applyTimeout = d
+	}
+	return s
+}
+
+// WithBarrierTimeout bounds the read barrier a leader runs before GET and SCAN
+func (s *Server) WithBarrierTimeout(d time.Duration) *Server {
+	if d > 0 {
+		s.barrierTimeout = d
+	}
+	return s
+}
+
+// WithMaxScanCount caps the keys one SCAN returns, whatever COUNT asks for
+func (s *Server) WithMaxScanCount(n int) *Server {
+	if n > 0 {
+		s.maxScanCount = n
+	}
+	return s
+}
+
+// WithLeaderAddr sets how a MOVED redirect turns the leader's raft address
+// into the host:port of its RESP listener. By default it keeps the leader's
+// host and uses the port this node listens on.
+func (s *Server) WithLeaderAddr(fn func(leader raft.ServerAddress) string) *Server {
+	s.leaderAddr = fn
+	return s
+}
+
+// ListenAndServe listens on addr and serves connections until Close
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until Close, and then returns nil
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections and closes the open ones
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+// conn is the state of one client connection
+type conn struct {
+	net.Conn
+	w writer
+
+	// readOnly allows reads on a follower, which may be stale
+	readOnly bool
+
+	// cursors maps SCAN cursors handed out to the key to resume at
+	cursors    map[uint64][]byte
+	nextCursor uint64
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		_ = nc.Close()
+	}()
+
+	c := &conn{Conn: nc, w: writer{bufio.NewWriter(nc)}, cursors: make(map[uint64][]byte)}
+	r := bufio.NewReader(nc)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.w.error("ERR " + err.Error())
+				_ = c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.dispatch(c, args)
+		// Pipelined commands are answered together
+		if r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// dispatch runs one command and writes its reply, reporting whether the
+// client asked to close the connection
+func (s *Server) dispatch(c *conn, args [][]byte) bool {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	switch name {
+	case "PING":
+		switch len(args) {
+		case 0:
+			c.w.simple("PONG")
+		case 1:
+			c.w.bulk(args[0])
+		default:
+			wrongArgs(c, name)
+		}
+	case "ECHO":
+		if len(args) != 1 {
+			wrongArgs(c, name)
+			return false
+		}
+		c.w.bulk(args[0])
+	case "QUIT":
+		c.w.simple("OK")
+		return true
+	case "READONLY":
+		c.readOnly = true
+		c.w.simple("OK")
+	case "READWRITE":
+		c.readOnly = false
+		c.w.simple("OK")
+	case "COMMAND":
+		// Clients such as redis-cli ask for command docs on connect
+		c.w.array(0)
+	case "GET":
+		if len(args) != 1 {
+			wrongArgs(c, name)
+			return false
+		}
+		if reserved(c, args[0]) {
+			return false
+		}
+		s.get(c, args[0])
+	case "SET":
+		if len(args) != 2 {
+			// Expiry and conditional options are not supported
+			if len(args) > 2 {
+				c.w.error("ERR syntax error")
+			} else {
+				wrongArgs(c, name)
+			}
+			return false
+		}
+		if reserved(c, args[0]) {
+			return false
+		}
+		s.set(c, args[0], args[1])
+	case "DEL":
+		if len(args) == 0 {
+			wrongArgs(c, name)
+			return false
+		}
+		if reserved(c, args...) {
+			return false
+		}
+		s.del(c, args)
+	case "SCAN":
+		s.scan(c, args)
+	default:
+		c.w.error(fmt.Sprintf("ERR unknown command '%s'", truncate(name)))
+	}
+	return false
+}
+
+// reserved refuses the command if any of keys is in the space kept for
+// metadata, which clients may neither read nor write
+func reserved(c *conn, keys ...[]byte) bool {
+	for _, key := range keys {
+		if db.IsReservedKey(key) {
+			c.w.error("ERR keys starting with a NUL byte are reserved")
+			return true
+		}
+	}
+	return false
+}
+
+// truncate shortens a client-supplied name before echoing it back
+func truncate(name string) string {
+	if len(name) > 64 {
+		return name[:64] + "..."
+	}
+	return name
+}
+
+func wrongArgs(c *conn, name string) {
+	c.w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// redirect answers a command this node cannot serve with the leader's
+// address, or CLUSTERDOWN while there is none
+func (s *Server) redirect(c *conn) {
+	leader := s.node.Leader()
+	if leader == "" {
+		c.w.error("CLUSTERDOWN no leader elected")
+		return
+	}
+	c.w.error("MOVED 0 " + s.leaderRESPAddr(leader, c))
+}
+
+// leaderRESPAddr resolves the leader's RESP listener, see WithLeaderAddr
+func (s *Server) leaderRESPAddr(leader raft.ServerAddress, c *conn) string {
+	if s.leaderAddr != nil {
+		return s.leaderAddr(leader)
+	}
+	host := string(leader)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	_, port, _ := net.SplitHostPort(c.LocalAddr().String())
+	return net.JoinHostPort(host, port)
+}
+
+// readable reports whether this node may serve a read now, running the
+// leader's read barrier, and replies with the reason if not
+func (s *Server) readable(c *conn) bool {
+	// Refresh header to reflect external updates (e.g., local REPL)
+	_ = s.db.Reload()
+	if !s.node.IsLeader() {
+		if c.readOnly {
+			return true
+		}
+		s.redirect(c)
+		return false
+	}
+	if err := s.node.Raft().Barrier(s.barrierTimeout).Error(); err != nil {
+		c.w.error("TRYAGAIN " + err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) get(c *conn, key []byte) {
+	if !s.readable(c) {
+		return
+	}
+	val, err := s.db.Get(key)
+	switch {
+	case errors.Is(err, btree.ErrKeyNotFound):
+		c.w.null()
+	case err != nil:
+		c.w.error("ERR " + err.Error())
+	default:
+		c.w.bulk(val)
+	}
+}
+
+func (s *Server) set(c *conn, key, value []byte) {
+	if !s.node.IsLeader() {
+		s.redirect(c)
+		return
+	}
+	cmd := raftnode.Command{Type: raftnode.CmdPut, Key: key, Value: value}
+	if _, err := s.node.Apply(cmd, s.applyTimeout); err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+	c.w.simple("OK")
+}
+
+// del deletes each key and replies with how many existed
+func (s *Server) del(c *conn, keys [][]byte) {
+	if !s.node.IsLeader() {
+		s.redirect(c)
+		return
+	}
+	deleted := 0
+	for _, key := range keys {
+		cmd := raftnode.Command{Type: raftnode.CmdDelete, Key: key, ReturnOld: true}
+		applied, err := s.node.Apply(cmd, s.applyTimeout)
+		if err != nil {
+			c.w.error("ERR " + err.Error())
+			return
+		}
+		if old, ok := applied.Response.(raftnode.OldValue); ok && old.Existed {
+			deleted++
+		}
+	}
+	c.w.integer(deleted)
+}
+
+// scan serves SCAN cursor [MATCH prefix*] [COUNT n]. Cursors are numbers
+// local to the connection, as Redis clients expect, each standing for the
+// key to resume at; 0 starts a scan and is returned when it is complete.
+func (s *Server) scan(c *conn, args [][]byte) {
+	if len(args) == 0 {
+		wrongArgs(c, "SCAN")
+		return
+	}
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		c.w.error("ERR invalid cursor")
+		return
+	}
+	var start []byte
+	if cursor != 0 {
+		next, ok := c.cursors[cursor]
+		if !ok {
+			c.w.error("ERR invalid cursor")
+			return
+		}
+		delete(c.cursors, cursor)
+		start = next
+	}
+
+	var prefix []byte
+	count := defaultScanCount
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.w.error("ERR syntax error")
+			return
+		}
+		switch opt, val := strings.ToUpper(string(args[i])), args[i+1]; opt {
+		case "MATCH":
+			p, ok := bytes.CutSuffix(val, []byte("*"))
+			if !ok || bytes.ContainsAny(p, "*?[\\") {
+				c.w.error("ERR only prefix patterns such as 'user:*' are supported")
+				return
+			}
+			if reserved(c, p) {
+				return
+			}
+			prefix = p
+		case "COUNT":
+			n, err := strconv.Atoi(string(val))
+			if err != nil || n < 1 {
+				c.w.error("ERR value is not an integer or out of range")
+				return
+			}
+			count = n
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+	count = min(count, s.maxScanCount)
+
+	if !s.readable(c) {
+		return
+	}
+	// Fetch one extra item to learn whether the scan is complete
+	items, err := s.db.Scan(prefix, start, count+1)
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+	next := "0"
+	if len(items) > count {
+		if len(c.cursors) >= maxCursors {
+			// Abandoned scans would otherwise pile up
+			clear(c.cursors)
+		}
+		c.nextCursor++
+		c.cursors[c.nextCursor] = items[count].Key
+		next = strconv.FormatUint(c.nextCursor, 10)
+		items = items[:count]
+	}
+
+	c.w.array(2)
+	c.w.bulk([]byte(next))
+	c.w.array(len(items))
+	for _, it := range items {
+		c.w.bulk(it.Key)
+	}
+}
@@ -0,0 +1,59 @@
+// Package version describes the build of the running binary. Release
+// builds set Version, Commit and Date with -ldflags, for example:
+//
+//	go build -ldflags "-X github.com/conuredb/conuredb/pkg/version.Version=v1.4.0 \
+//	  -X github.com/conuredb/conuredb/pkg/version.Commit=$(git rev-parse HEAD)" ./cmd/conure-db
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X in release builds
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info. A build without Commit or Date set falls back
+// to the revision and time the Go toolchain recorded from version control,
+// when it did; a revision with uncommitted changes ends in "-dirty".
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	var revision, date string
+	dirty := false
+	for _, s := range build.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.time":
+			date = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if info.Commit == "" && revision != "" {
+		info.Commit = revision
+		if dirty {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.Date == "" {
+		info.Date = date
+	}
+	return info
+}
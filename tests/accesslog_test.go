@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// syncBuffer is a bytes.Buffer safe to write from server goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestAccessLogRecordsRequest sends a write and a redacted read and checks
+// each produces one structured entry with the expected fields
+func TestAccessLogRecordsRequest(t *testing.T) {
+	for _, redact := range []bool{false, true} {
+		var out syncBuffer
+		logger := slog.New(slog.NewJSONHandler(&out, nil))
+		ts, _ := startTestServer(t, func(s *api.Server) {
+			s.WithAccessLog(logger, api.AccessLogOptions{Redact: redact})
+		})
+
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key=secret-key", strings.NewReader("secret-value"))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT failed: %v", err)
+		}
+		_ = resp.Body.Close()
+
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(out.String())), &entry); err != nil {
+			t.Fatalf("Expected one JSON log line, got %q: %v", out.String(), err)
+		}
+		want := map[string]any{
+			"msg":    "request",
+			"level":  "INFO",
+			"method": "PUT",
+			"path":   "/kv",
+			"client": "127.0.0.1",
+			"status": float64(http.StatusOK),
+			"leader": true,
+			"key":    "secret-key",
+		}
+		if redact {
+			want["key"] = "[redacted]"
+		}
+		for field, v := range want {
+			if entry[field] != v {
+				t.Errorf("redact=%v: field %q = %v, want %v", redact, field, entry[field], v)
+			}
+		}
+		if _, ok := entry["duration"]; !ok {
+			t.Errorf("redact=%v: missing duration in %v", redact, entry)
+		}
+		if strings.Contains(out.String(), "secret-value") {
+			t.Errorf("redact=%v: value leaked into the access log: %s", redact, out.String())
+		}
+	}
+}
+
+// TestAccessLogSlowRequestsAndLevel drops info lines below a raised log
+// level while slow requests still get through at warn
+func TestAccessLogSlowRequestsAndLevel(t *testing.T) {
+	var out syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	ts, _ := startTestServer(t, func(s *api.Server) {
+		s.WithAccessLog(logger, api.AccessLogOptions{}).WithBarrierTimeout(200 * time.Millisecond)
+		settings := s.Settings()
+		settings.SlowRequest = 100 * time.Millisecond
+		settings.LogLevel = slog.LevelWarn
+		s.SetSettings(settings)
+	})
+
+	for _, path := range []string{"/status", "/kv?key=k&min_index=1000000"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out.String())), &entry); err != nil {
+		t.Fatalf("Expected only the slow request logged, got %q: %v", out.String(), err)
+	}
+	if entry["level"] != "WARN" || entry["slow"] != true || entry["path"] != "/kv" {
+		t.Fatalf("Unexpected slow request entry: %v", entry)
+	}
+}
+
+// TestRequestIDReachesFSMLog sends a write with an X-Request-ID and checks
+// the same ID is echoed and appears in both the access log and the FSM's
+// apply log, and that a request without one is given an ID
+func TestRequestIDReachesFSMLog(t *testing.T) {
+	var out syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	node, database := startTestNodeWith(t, func(f *raftnode.FSM) { f.Logger = logger })
+	mux := http.NewServeMux()
+	api.New(node, database).WithAccessLog(logger, api.AccessLogOptions{}).Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key=traced&value=v", nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("X-Request-ID", "trace-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if got := resp.Header.Get("X-Request-ID"); got != "trace-123" {
+		t.Fatalf("Expected the request ID echoed, got %q", got)
+	}
+
+	msgs := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Bad log line %q: %v", line, err)
+		}
+		if entry["request_id"] == "trace-123" {
+			msgs[entry["msg"].(string)] = true
+		}
+	}
+	if !msgs["request"] || !msgs["applied"] {
+		t.Fatalf("Expected trace-123 in the access log and the apply log, got %s", out.String())
+	}
+
+	resp, err = http.Get(ts.URL + "/kv?key=traced")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Fatalf("Expected a generated request ID")
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// TestAdminConfigChangesBarrierTimeout changes the barrier timeout on a
+// running server and checks a read waiting on an unreachable min_index gives
+// up after the new timeout rather than the old one
+func TestAdminConfigChangesBarrierTimeout(t *testing.T) {
+	ts, _ := startTestServer(t, func(s *api.Server) {
+		s.WithBarrierTimeout(100 * time.Millisecond).WithAdminToken("ops")
+	})
+
+	post := func(token, body string) (*http.Response, map[string]string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/admin/config", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Config request failed: %v", err)
+		}
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close response body: %v", closeErr)
+			}
+		}()
+		var out map[string]string
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+				t.Fatalf("Failed to decode settings: %v", err)
+			}
+		}
+		return resp, out
+	}
+	timedRead := func() time.Duration {
+		t.Helper()
+		start := time.Now()
+		resp, err := http.Get(ts.URL + "/kv?key=k&min_index=1000000")
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("Expected 503 for an unreachable min_index, got %d", resp.StatusCode)
+		}
+		return time.Since(start)
+	}
+
+	if took := timedRead(); took > time.Second {
+		t.Fatalf("Expected the configured 100ms timeout, read took %v", took)
+	}
+
+	if resp, _ := post("", `{"barrier_timeout":"1s"}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without the admin token, got %d", resp.StatusCode)
+	}
+	if resp, _ := post("ops", `{"barrier_timeout":"-1s"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a negative timeout, got %d", resp.StatusCode)
+	}
+	resp, settings := post("ops", `{"barrier_timeout":"1500ms","log_level":"WARN"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to update settings: %d", resp.StatusCode)
+	}
+	if settings["barrier_timeout"] != "1.5s" || settings["apply_timeout"] != "5s" || settings["log_level"] != "WARN" {
+		t.Fatalf("Unexpected settings after update: %v", settings)
+	}
+
+	if took := timedRead(); took < 1500*time.Millisecond {
+		t.Fatalf("Expected the read to wait the new 1.5s timeout, took %v", took)
+	}
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// TestRedirectsUseAdvertisedHTTP gives each node a Kubernetes-style HTTP
+// address, unrelated to its raft address, and checks that once a follower
+// has joined through the leader its redirects, /status and /cluster name
+// the leader's advertised address rather than one guessed from raft
+func TestRedirectsUseAdvertisedHTTP(t *testing.T) {
+	c := startTestCluster(t, 3)
+	leader := c.leader(t)
+	follower := (leader + 1) % len(c.nodes)
+	other := (leader + 2) % len(c.nodes)
+	advertised := func(i int) string { return fmt.Sprintf("http://conure-%d.conure-hs:8081", i) }
+
+	servers := make([]*api.Server, len(c.nodes))
+	urls := make([]string, len(c.nodes))
+	for i := range c.nodes {
+		servers[i] = api.New(c.nodes[i], c.dbs[i]).WithAdvertiseHTTP(fmt.Sprintf("conure-%d.conure-hs:8081", i))
+		mux := http.NewServeMux()
+		servers[i].Register(mux)
+		ts := httptest.NewServer(mux)
+		t.Cleanup(ts.Close)
+		urls[i] = ts.URL
+	}
+
+	// the follower announces itself, as join.Cluster does, and learns the
+	// leader's address from the answer
+	body, _ := json.Marshal(map[string]string{"ID": c.ids[follower], "RaftAddr": c.addrs[follower], "HTTPAddr": advertised(follower)})
+	resp, err := http.Post(urls[leader]+"/join", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Join request failed: %v", err)
+	}
+	var joined struct {
+		HTTPAddrs map[string]string `json:"http_addrs"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&joined)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("Join: status %d, decode error %v", resp.StatusCode, err)
+	}
+	if got := joined.HTTPAddrs[c.addrs[leader]]; got != advertised(leader) {
+		t.Fatalf("Join answer lists the leader at %q, want %q", got, advertised(leader))
+	}
+	servers[follower].AddPeerHTTP(joined.HTTPAddrs)
+
+	hint := func(i int) map[string]string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, urls[i]+"/kv?key=k&value=v", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT on node %d failed: %v", i, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("PUT on follower %d: expected 409, got %d", i, resp.StatusCode)
+		}
+		var h map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+			t.Fatalf("Failed to decode leader hint: %v", err)
+		}
+		return h
+	}
+	if h := hint(follower); h["leader_http"] != advertised(leader) || h["leader"] != c.addrs[leader] {
+		t.Fatalf("Unexpected redirect from the follower: %v", h)
+	}
+	// a node that has not learned the leader's address sends only the raft one
+	if h := hint(other); h["leader_http"] != "" || h["leader"] != c.addrs[leader] {
+		t.Fatalf("Unexpected redirect from a node without the address: %v", h)
+	}
+
+	var status map[string]any
+	getJSON(t, urls[follower]+"/status", &status)
+	if status["advertise_http_addr"] != advertised(follower) || status["leader_http"] != advertised(leader) {
+		t.Fatalf("Unexpected /status: %v", status)
+	}
+
+	var config struct {
+		LeaderHTTP string `json:"leader_http"`
+		Servers    []struct {
+			ID          string `json:"id"`
+			HTTPAddress string `json:"http_address"`
+		} `json:"servers"`
+	}
+	getJSON(t, urls[leader]+"/raft/config", &config)
+	if config.LeaderHTTP != advertised(leader) {
+		t.Fatalf("/raft/config on the leader: leader_http %q, want %q", config.LeaderHTTP, advertised(leader))
+	}
+	for _, sv := range config.Servers {
+		want := ""
+		switch sv.ID {
+		case c.ids[leader]:
+			want = advertised(leader)
+		case c.ids[follower]:
+			want = advertised(follower)
+		}
+		if sv.HTTPAddress != want {
+			t.Fatalf("/raft/config lists %s at %q, want %q", sv.ID, sv.HTTPAddress, want)
+		}
+	}
+
+	var cluster struct {
+		Members []struct {
+			HTTPAddress string `json:"http_address"`
+			Leader      bool   `json:"leader"`
+		} `json:"members"`
+	}
+	getJSON(t, urls[follower]+"/cluster", &cluster)
+	for _, m := range cluster.Members {
+		if m.Leader && m.HTTPAddress != advertised(leader) {
+			t.Fatalf("/cluster lists the leader at %q, want %q", m.HTTPAddress, advertised(leader))
+		}
+	}
+}
+
+// getJSON decodes the JSON body of a GET of url into v
+func getJSON(t *testing.T, url string, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: expected 200, got %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode %s: %v", url, err)
+	}
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// freeRaftAddr returns a loopback address with a currently unused port
+func freeRaftAddr(t testing.TB) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find free port: %v", err)
+	}
+	addr := l.Addr().String()
+	if err := l.Close(); err != nil {
+		t.Fatalf("Failed to release port: %v", err)
+	}
+	return addr
+}
+
+// startTestNode bootstraps a single-node cluster and waits until it is leader
+func startTestNode(t testing.TB) (*raftnode.Node, *db.DB) {
+	t.Helper()
+	return startTestNodeWith(t, nil)
+}
+
+// startTestNodeWith is startTestNode with configure applied to the FSM
+// before the node starts
+func startTestNodeWith(t testing.TB, configure func(*raftnode.FSM)) (*raftnode.Node, *db.DB) {
+	t.Helper()
+	dir := t.TempDir()
+
+	database, err := db.Open(filepath.Join(dir, "conure.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	fsm := &raftnode.FSM{DB: database}
+	if configure != nil {
+		configure(fsm)
+	}
+	node, err := raftnode.StartNode(raftnode.Config{
+		NodeID:    "node1",
+		RaftAddr:  freeRaftAddr(t),
+		DataDir:   dir,
+		Bootstrap: true,
+	}, fsm)
+	if err != nil {
+		t.Fatalf("Failed to start raft node: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := node.Shutdown(); err != nil {
+			t.Logf("Warning: failed to shut down raft node: %v", err)
+		}
+		if err := database.Close(); err != nil {
+			t.Logf("Warning: failed to close database: %v", err)
+		}
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !node.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("Node did not become leader in time")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return node, database
+}
+
+// startTestServer wraps a leader node in an HTTP test server
+func startTestServer(t testing.TB, configure func(*api.Server)) (*httptest.Server, *db.DB) {
+	t.Helper()
+	node, database := startTestNode(t)
+
+	srv := api.New(node, database)
+	if configure != nil {
+		configure(srv)
+	}
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts, database
+}
+
+// TestScanTruncatesAtServerCap requests more items than the server allows and
+// pages through the rest with the continuation cursor
+func TestScanTruncatesAtServerCap(t *testing.T) {
+	ts, database := startTestServer(t, func(s *api.Server) { s.WithMaxScanResults(10) })
+
+	const numKeys = 25
+	for i := 0; i < numKeys; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("scan-%03d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatalf("Failed to put entry %d: %v", i, err)
+		}
+	}
+
+	type scanResp struct {
+		Items []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"items"`
+		Truncated bool   `json:"truncated"`
+		Next      string `json:"next"`
+	}
+	scan := func(q url.Values) scanResp {
+		resp, err := http.Get(ts.URL + "/scan?" + q.Encode())
+		if err != nil {
+			t.Fatalf("Scan request failed: %v", err)
+		}
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close response body: %v", closeErr)
+			}
+		}()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected scan status: %d", resp.StatusCode)
+		}
+		var out scanResp
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("Failed to decode scan response: %v", err)
+		}
+		return out
+	}
+
+	first := scan(url.Values{"prefix": {"scan-"}, "limit": {"1000"}})
+	if len(first.Items) != 10 {
+		t.Fatalf("Expected scan to be capped at 10 items, got %d", len(first.Items))
+	}
+	if !first.Truncated || first.Next == "" {
+		t.Fatalf("Expected truncated scan with a cursor, got truncated=%v next=%q", first.Truncated, first.Next)
+	}
+
+	// Follow the cursor until the scan is complete
+	seen := len(first.Items)
+	next := first.Next
+	for next != "" {
+		page := scan(url.Values{"prefix": {"scan-"}, "cursor": {next}})
+		for i, it := range page.Items {
+			want := fmt.Sprintf("scan-%03d", seen+i)
+			if it.Key != want {
+				t.Fatalf("Expected key %s, got %s", want, it.Key)
+			}
+		}
+		seen += len(page.Items)
+		next = page.Next
+		if page.Truncated != (next != "") {
+			t.Fatalf("Truncated flag and cursor disagree: truncated=%v next=%q", page.Truncated, next)
+		}
+	}
+	if seen != numKeys {
+		t.Fatalf("Expected to page through %d keys, got %d", numKeys, seen)
+	}
+}
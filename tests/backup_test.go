@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/backup"
+)
+
+// TestScheduledBackupsToDir runs the scheduler against a local directory
+// and checks backups appear on schedule, only while allowed to run, that
+// old ones are pruned, and that the newest restores
+func TestScheduledBackupsToDir(t *testing.T) {
+	source := openTestDB(t, "source.db")
+	for i := 0; i < 200; i++ {
+		if err := source.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("backed up")); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+
+	dir := t.TempDir()
+	store, err := backup.NewStore("file://"+dir, backup.StoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	var leader, runs atomic.Int64
+	stop := backup.Start(source, store, backup.Options{
+		Interval: 20 * time.Millisecond,
+		Retain:   2,
+		ShouldRun: func() bool {
+			if leader.Load() == 0 {
+				return false
+			}
+			runs.Add(1)
+			return true
+		},
+		OnError: func(err error) { t.Errorf("Backup failed: %v", err) },
+	})
+	defer stop()
+
+	list := func() []string {
+		t.Helper()
+		names, err := store.List(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to list backups: %v", err)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if names := list(); len(names) != 0 {
+		t.Fatalf("Expected no backups while not leader, got %v", names)
+	}
+
+	leader.Store(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for runs.Load() < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Only %d backups ran in time", runs.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+
+	names := list()
+	if len(names) != 2 {
+		t.Fatalf("Expected the 2 newest of %d backups retained, got %v", runs.Load(), names)
+	}
+
+	f, err := os.Open(filepath.Join(dir, names[1]))
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	target := openTestDB(t, "target.db")
+	if err := target.RestoreFrom(f); err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+	if v, err := target.Get([]byte("key-199")); err != nil || string(v) != "backed up" {
+		t.Fatalf("Get after restore: %q, %v", v, err)
+	}
+}
+
+// TestBackupToS3 backs up to a minimal fake S3 service and checks objects
+// are uploaded signed, listed under the prefix and pruned
+func TestBackupToS3(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		key, ok := strings.CutPrefix(r.URL.Path, "/backups/")
+		switch {
+		case r.Method == http.MethodPut && ok:
+			body, _ := io.ReadAll(r.Body)
+			objects[key] = body
+		case r.Method == http.MethodDelete && ok:
+			delete(objects, key)
+		case r.Method == http.MethodGet && r.URL.Path == "/backups" && r.URL.Query().Get("list-type") == "2":
+			type content struct{ Key string }
+			var out struct {
+				XMLName  xml.Name `xml:"ListBucketResult"`
+				Contents []content
+			}
+			for k := range objects {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					out.Contents = append(out.Contents, content{k})
+				}
+			}
+			_ = xml.NewEncoder(w).Encode(out)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	source := openTestDB(t, "source.db")
+	if err := source.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	store, err := backup.NewStore("s3://backups/cluster-a", backup.StoreOptions{
+		S3Endpoint:  srv.URL,
+		S3AccessKey: "test-key",
+		S3SecretKey: "test-secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+
+	var last string
+	for i := 0; i < 3; i++ {
+		if last, err = backup.Backup(context.Background(), source, store, 2); err != nil {
+			t.Fatalf("Backup %d failed: %v", i, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(objects) != 2 {
+		t.Fatalf("Expected 2 objects retained, got %d", len(objects))
+	}
+	if _, ok := objects["cluster-a/"+last]; !ok {
+		t.Fatalf("Expected the newest backup %s under the prefix, have %v", last, objects)
+	}
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestBatchAppliesAtomically applies a shuffled batch of puts and deletes and
+// checks a batch with an invalid op leaves the database untouched
+func TestBatchAppliesAtomically(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "batch.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close test database: %v", closeErr)
+		}
+	}()
+
+	const numKeys = 2000
+	ops := make([]btree.Op, 0, numKeys)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(numKeys) {
+		ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("b%05d", i)), Value: []byte(fmt.Sprintf("v%d", i))})
+	}
+	if err := database.Batch(ops, btree.BatchOptions{Sequential: true}); err != nil {
+		t.Fatalf("Failed to apply batch: %v", err)
+	}
+
+	// Delete the even keys, including one that never existed
+	var deletes []btree.Op
+	for i := 0; i < numKeys; i += 2 {
+		deletes = append(deletes, btree.Op{Key: []byte(fmt.Sprintf("b%05d", i)), Delete: true})
+	}
+	deletes = append(deletes, btree.Op{Key: []byte("missing"), Delete: true})
+	if err := database.Batch(deletes, btree.BatchOptions{}); err != nil {
+		t.Fatalf("Failed to apply delete batch: %v", err)
+	}
+
+	// A batch with an oversized value must not apply any of its ops
+	bad := []btree.Op{
+		{Key: []byte("b00000"), Value: []byte("resurrected")},
+		{Key: []byte("huge"), Value: bytes.Repeat([]byte("x"), btree.MaxValueSize+1)},
+	}
+	if err := database.Batch(bad, btree.BatchOptions{}); !errors.Is(err, btree.ErrValueTooLarge) {
+		t.Fatalf("Expected ErrValueTooLarge, got %v", err)
+	}
+
+	for i := 0; i < numKeys; i++ {
+		value, err := database.Get([]byte(fmt.Sprintf("b%05d", i)))
+		if i%2 == 0 {
+			if err == nil {
+				t.Fatalf("Expected key %d to be deleted, got %s", i, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Failed to get key %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("v%d", i); string(value) != want {
+			t.Fatalf("Value mismatch for key %d: expected %s, got %s", i, want, value)
+		}
+	}
+}
+
+// BenchmarkBatchWrite compares bulk batch writes with and without the
+// sequential allocation hint
+func BenchmarkBatchWrite(b *testing.B) {
+	for _, sequential := range []bool{false, true} {
+		b.Run(fmt.Sprintf("sequential=%v", sequential), func(b *testing.B) {
+			database, err := db.Open(filepath.Join(b.TempDir(), "bench.db"))
+			if err != nil {
+				b.Fatalf("Failed to open database: %v", err)
+			}
+			defer func() {
+				if closeErr := database.Close(); closeErr != nil {
+					b.Logf("Warning: failed to close benchmark database: %v", closeErr)
+				}
+			}()
+
+			const batchSize = 500
+			value := bytes.Repeat([]byte("v"), 64)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				ops := make([]btree.Op, batchSize)
+				for i := range ops {
+					ops[i] = btree.Op{Key: []byte(fmt.Sprintf("k%08d-%04d", n, i)), Value: value}
+				}
+				if err := database.Batch(ops, btree.BatchOptions{Sequential: sequential}); err != nil {
+					b.Fatalf("Failed to apply batch: %v", err)
+				}
+			}
+			b.SetBytes(int64(batchSize * (len(value) + 14)))
+		})
+	}
+}
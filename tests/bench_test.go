@@ -0,0 +1,279 @@
+package tests
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// Benchmark dataset settings, passed after -args, e.g.
+//
+//	go test ./tests -run '^$' -bench . -args -bench.keys=100000 -bench.value=512
+var (
+	benchKeys  = flag.Int("bench.keys", 10000, "keys loaded before each storage and HTTP benchmark")
+	benchValue = flag.Int("bench.value", 100, "value size in bytes")
+	benchSync  = flag.Bool("bench.sync", false, "fsync every commit, as a node does by default")
+	benchURL   = flag.String("bench.url", "", "drive the HTTP benchmarks against this running node instead of an in-process one")
+)
+
+// benchKey is the key for index i; keys sort in index order
+func benchKey(i int) []byte {
+	return []byte(fmt.Sprintf("bench-%09d", i))
+}
+
+// openBenchDB opens an empty database for a benchmark, closed when it ends
+func openBenchDB(b *testing.B) *db.DB {
+	b.Helper()
+	database, err := db.OpenWithOptions(filepath.Join(b.TempDir(), "bench.db"), db.Options{NoSync: !*benchSync})
+	if err != nil {
+		b.Fatalf("Failed to open database: %v", err)
+	}
+	b.Cleanup(func() {
+		if closeErr := database.Close(); closeErr != nil {
+			b.Logf("Warning: failed to close benchmark database: %v", closeErr)
+		}
+	})
+	return database
+}
+
+// loadBench writes keys [from, to) holding value in batches
+func loadBench(b *testing.B, database *db.DB, from, to int, value []byte) {
+	b.Helper()
+	ops := make([]btree.Op, 0, 1000)
+	for i := from; i < to; i++ {
+		ops = append(ops, btree.Op{Key: benchKey(i), Value: value})
+		if len(ops) == cap(ops) || i == to-1 {
+			if err := database.Batch(ops, btree.BatchOptions{}); err != nil {
+				b.Fatalf("Failed to load keys: %v", err)
+			}
+			ops = ops[:0]
+		}
+	}
+}
+
+// benchDataset opens a database holding -bench.keys keys of -bench.value
+// bytes and returns it with the value
+func benchDataset(b *testing.B) (*db.DB, []byte) {
+	b.Helper()
+	database := openBenchDB(b)
+	value := bytes.Repeat([]byte("v"), *benchValue)
+	loadBench(b, database, 0, *benchKeys, value)
+	return database, value
+}
+
+// BenchmarkPut overwrites random keys of the dataset, then inserts new ones
+// between them
+func BenchmarkPut(b *testing.B) {
+	b.Run("overwrite", func(b *testing.B) {
+		database, value := benchDataset(b)
+		rng := rand.New(rand.NewSource(1))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := database.Put(benchKey(rng.Intn(*benchKeys)), value); err != nil {
+				b.Fatalf("Put failed: %v", err)
+			}
+		}
+	})
+	b.Run("insert", func(b *testing.B) {
+		database, value := benchDataset(b)
+		rng := rand.New(rand.NewSource(1))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			key := append(benchKey(rng.Intn(*benchKeys)), fmt.Sprintf("/%d", i)...)
+			if err := database.Put(key, value); err != nil {
+				b.Fatalf("Put failed: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkGet reads random keys of the dataset
+func BenchmarkGet(b *testing.B) {
+	database, _ := benchDataset(b)
+	rng := rand.New(rand.NewSource(1))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := database.Get(benchKey(rng.Intn(*benchKeys))); err != nil {
+			b.Fatalf("Get failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkScan reads runs of 10 and 100 keys from random starts
+func BenchmarkScan(b *testing.B) {
+	database, _ := benchDataset(b)
+	for _, limit := range []int{10, 100} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := benchKey(rng.Intn(max(*benchKeys-limit, 1)))
+				if _, err := database.Scan(nil, start, limit); err != nil {
+					b.Fatalf("Scan failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMixed runs reads and writes over the dataset in the ratios of a
+// read-mostly and a write-heavy load: gets, puts, and short scans
+func BenchmarkMixed(b *testing.B) {
+	for _, mix := range []struct {
+		name        string
+		puts, scans int // per 100 operations; the rest are gets
+	}{
+		{"read-mostly", 5, 5},
+		{"write-heavy", 50, 5},
+	} {
+		b.Run(mix.name, func(b *testing.B) {
+			database, value := benchDataset(b)
+			rng := rand.New(rand.NewSource(1))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := benchKey(rng.Intn(*benchKeys))
+				var err error
+				switch r := rng.Intn(100); {
+				case r < mix.puts:
+					err = database.Put(key, value)
+				case r < mix.puts+mix.scans:
+					_, err = database.Scan(nil, key, 10)
+				default:
+					_, err = database.Get(key)
+				}
+				if err != nil {
+					b.Fatalf("Operation %d failed: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSplits inserts keys that fill leaves and split them: ascending
+// past the end of the tree, where appends split, and at random points
+// within it
+func BenchmarkSplits(b *testing.B) {
+	b.Run("append", func(b *testing.B) {
+		database, value := benchDataset(b)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := database.Put(benchKey(*benchKeys+i), value); err != nil {
+				b.Fatalf("Put failed: %v", err)
+			}
+		}
+	})
+	b.Run("random", func(b *testing.B) {
+		database := openBenchDB(b)
+		value := bytes.Repeat([]byte("v"), *benchValue)
+		order := rand.New(rand.NewSource(1)).Perm(b.N)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for _, i := range order {
+			if err := database.Put(benchKey(i), value); err != nil {
+				b.Fatalf("Put failed: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkMerges deletes keys in order, emptying leaves so they merge with
+// or borrow from their siblings, reloading the dataset untimed whenever it
+// runs out
+func BenchmarkMerges(b *testing.B) {
+	database, value := benchDataset(b)
+	next := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if next == *benchKeys {
+			b.StopTimer()
+			loadBench(b, database, 0, *benchKeys, value)
+			next = 0
+			b.StartTimer()
+		}
+		if err := database.Delete(benchKey(next)); err != nil {
+			b.Fatalf("Delete failed: %v", err)
+		}
+		next++
+	}
+}
+
+// benchServer returns the base URL the HTTP benchmarks drive: -bench.url if
+// set, or an in-process single-node server loaded with the dataset
+func benchServer(b *testing.B) string {
+	b.Helper()
+	if *benchURL != "" {
+		return strings.TrimSuffix(*benchURL, "/")
+	}
+	ts, database := startTestServer(b, nil)
+	loadBench(b, database, 0, *benchKeys, bytes.Repeat([]byte("v"), *benchValue))
+	return ts.URL
+}
+
+// benchRequest sends one request and returns an error unless it gets 200
+func benchRequest(client *http.Client, method, url string, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: unexpected status %d", method, url, resp.StatusCode)
+	}
+	return nil
+}
+
+// BenchmarkHTTP measures whole requests through the API: each write is a
+// raft round trip and each read a leader check, on top of the storage work
+// the other benchmarks time. Requests run from GOMAXPROCS goroutines, or
+// -cpu of them; the keys written are the dataset's, so a node given with
+// -bench.url must hold bench-000000000 and up for the gets to succeed.
+func BenchmarkHTTP(b *testing.B) {
+	base := benchServer(b)
+	value := bytes.Repeat([]byte("v"), *benchValue)
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+
+	for _, op := range []struct {
+		name   string
+		method string
+		url    func(key []byte) string
+		body   []byte
+	}{
+		{"put", http.MethodPut, func(key []byte) string { return base + "/kv?key=" + string(key) }, value},
+		{"get", http.MethodGet, func(key []byte) string { return base + "/kv?key=" + string(key) }, nil},
+		{"scan", http.MethodGet, func(key []byte) string { return base + "/scan?limit=10&start=" + string(key) }, nil},
+	} {
+		b.Run(op.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					if err := benchRequest(client, op.method, op.url(benchKey(rng.Intn(*benchKeys))), op.body); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestBucketsAreListed creates several buckets and checks they are listed
+// with their own keys and nothing leaks between them
+func TestBucketsAreListed(t *testing.T) {
+	database := openTestDB(t, "buckets.db")
+
+	want := map[string]int{"orders": 3, "users": 5, "audit-log": 0}
+	for name, n := range want {
+		if err := database.CreateBucket(name); err != nil {
+			t.Fatalf("Failed to create bucket %s: %v", name, err)
+		}
+		b, err := database.Bucket(name)
+		if err != nil {
+			t.Fatalf("Failed to open bucket %s: %v", name, err)
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("k%d", i)), []byte(name)); err != nil {
+				t.Fatalf("Failed to put into %s: %v", name, err)
+			}
+		}
+	}
+	// Plain keys stay outside every bucket
+	if err := database.Put([]byte("orders"), []byte("not a bucket")); err != nil {
+		t.Fatalf("Failed to put plain key: %v", err)
+	}
+
+	names, err := database.Buckets()
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %v", err)
+	}
+	if fmt.Sprint(names) != "[audit-log orders users]" {
+		t.Fatalf("Unexpected bucket list: %v", names)
+	}
+	for name, n := range want {
+		count, err := database.BucketKeyCount(name)
+		if err != nil || count != n {
+			t.Fatalf("Bucket %s: expected %d keys, got %d (%v)", name, n, count, err)
+		}
+	}
+
+	users, _ := database.Bucket("users")
+	items, err := users.Scan(nil, nil, 0)
+	if err != nil || len(items) != 5 || string(items[0].Key) != "k0" {
+		t.Fatalf("Unexpected bucket scan: %v, %v", items, err)
+	}
+	if _, err := database.Bucket("missing"); !errors.Is(err, db.ErrBucketNotFound) {
+		t.Fatalf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+// TestBucketsEndpoint creates buckets over HTTP and lists them with counts
+func TestBucketsEndpoint(t *testing.T) {
+	ts, database := startTestServer(t, nil)
+
+	for _, name := range []string{"b", "a"} {
+		resp, err := http.Post(ts.URL+"/buckets?name="+name, "", nil)
+		if err != nil {
+			t.Fatalf("Create bucket request failed: %v", err)
+		}
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close response body: %v", closeErr)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected create status: %d", resp.StatusCode)
+		}
+	}
+	a, err := database.Bucket("a")
+	if err != nil {
+		t.Fatalf("Bucket created over HTTP not found: %v", err)
+	}
+	if err := a.Put([]byte("x"), []byte("1")); err != nil {
+		t.Fatalf("Failed to put into bucket: %v", err)
+	}
+
+	resp, err := http.Get(ts.URL + "/buckets?counts=true")
+	if err != nil {
+		t.Fatalf("List buckets request failed: %v", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close response body: %v", closeErr)
+		}
+	}()
+	var got []struct {
+		Name string `json:"name"`
+		Keys int    `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode bucket list: %v", err)
+	}
+	if len(got) != 2 || got[0].Name != "a" || got[0].Keys != 1 || got[1].Name != "b" || got[1].Keys != 0 {
+		t.Fatalf("Unexpected bucket list: %+v", got)
+	}
+}
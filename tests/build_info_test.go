@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/pkg/version"
+)
+
+// TestVersionEndpoint stamps the build info as -ldflags -X does in a release
+// build and checks /version and /status report it with the storage format
+// and raft protocol
+func TestVersionEndpoint(t *testing.T) {
+	oldVersion, oldCommit, oldDate := version.Version, version.Commit, version.Date
+	version.Version, version.Commit, version.Date = "v1.4.0", "0123abcd", "2026-10-16T07:00:00Z"
+	t.Cleanup(func() { version.Version, version.Commit, version.Date = oldVersion, oldCommit, oldDate })
+
+	ts, _ := startTestServer(t, nil)
+
+	type info struct {
+		Version       string `json:"version"`
+		Commit        string `json:"commit"`
+		Date          string `json:"build_date"`
+		GoVersion     string `json:"go_version"`
+		StorageFormat uint32 `json:"storage_format"`
+		RaftProtocol  int    `json:"raft_protocol"`
+	}
+	get := func(path string, v any) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d", path, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: bad body: %v", path, err)
+		}
+	}
+
+	var got info
+	get("/version", &got)
+	want := info{Version: "v1.4.0", Commit: "0123abcd", Date: "2026-10-16T07:00:00Z", StorageFormat: btree.Version, RaftProtocol: 3}
+	if got.GoVersion == "" {
+		t.Fatalf("Expected a Go version, got %+v", got)
+	}
+	got.GoVersion = ""
+	if got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+
+	var status struct {
+		Version info `json:"version"`
+	}
+	get("/status", &status)
+	status.Version.GoVersion = ""
+	if status.Version != want {
+		t.Fatalf("Expected /status to carry %+v, got %+v", want, status.Version)
+	}
+
+	resp, err := http.Post(ts.URL+"/version", "", nil)
+	if err != nil {
+		t.Fatalf("POST /version failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405 for POST /version, got %d", resp.StatusCode)
+	}
+}
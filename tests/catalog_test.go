@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// TestCatalogSizesMatchValues writes values of many sizes and checks the
+// catalog lists each key with the length of the value stored under it, and
+// leaves out the reserved keys a bucket adds
+func TestCatalogSizesMatchValues(t *testing.T) {
+	database := openTestDB(t, "catalog.db")
+
+	want := make(map[string]int)
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("item-%03d", i)
+		value := strings.Repeat("x", i%97)
+		if err := database.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+		want[key] = len(value)
+	}
+	if err := database.Put([]byte("other"), []byte("value")); err != nil {
+		t.Fatalf("Failed to put other: %v", err)
+	}
+	if err := database.CreateBucket("b"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	keys, err := database.Catalog([]byte("item-"))
+	if err != nil {
+		t.Fatalf("Catalog failed: %v", err)
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("Expected %d keys, got %d", len(want), len(keys))
+	}
+	for i, k := range keys {
+		if i > 0 && string(keys[i-1].Key) >= string(k.Key) {
+			t.Fatalf("Expected keys in order, got %q before %q", keys[i-1].Key, k.Key)
+		}
+		value, err := database.Get(k.Key)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", k.Key, err)
+		}
+		if k.Size != len(value) || k.Size != want[string(k.Key)] {
+			t.Fatalf("Expected %s to have size %d, got %d", k.Key, len(value), k.Size)
+		}
+	}
+
+	all, err := database.Catalog(nil)
+	if err != nil {
+		t.Fatalf("Catalog failed: %v", err)
+	}
+	if len(all) != len(want)+1 {
+		t.Fatalf("Expected %d keys without reserved ones, got %d", len(want)+1, len(all))
+	}
+}
+
+// TestCatalogEndpoint pages through /catalog and checks every key comes back
+// once with its size
+func TestCatalogEndpoint(t *testing.T) {
+	ts, database := startTestServer(t, func(s *api.Server) { s.WithMaxScanResults(10) })
+	for i := 0; i < 25; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("c-%02d", i)), []byte(strings.Repeat("v", i))); err != nil {
+			t.Fatalf("Failed to put entry %d: %v", i, err)
+		}
+	}
+
+	var sizes []int
+	next := ""
+	for page := 0; ; page++ {
+		if page > 3 {
+			t.Fatal("Expected the catalog to end within 3 pages")
+		}
+		u := ts.URL + "/catalog?prefix=c-"
+		if next != "" {
+			u += "&cursor=" + next
+		}
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("GET /catalog failed: %v", err)
+		}
+		var out struct {
+			Items []struct {
+				Key  string `json:"key"`
+				Size int    `json:"size"`
+			} `json:"items"`
+			Truncated bool   `json:"truncated"`
+			Next      string `json:"next"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		_ = resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected a catalog page, got %d: %v", resp.StatusCode, err)
+		}
+		for _, it := range out.Items {
+			if want := fmt.Sprintf("c-%02d", len(sizes)); it.Key != want {
+				t.Fatalf("Expected %s, got %s", want, it.Key)
+			}
+			sizes = append(sizes, it.Size)
+		}
+		if !out.Truncated {
+			break
+		}
+		next = out.Next
+	}
+	if len(sizes) != 25 {
+		t.Fatalf("Expected 25 keys, got %d", len(sizes))
+	}
+	for i, size := range sizes {
+		if size != i {
+			t.Fatalf("Expected c-%02d to have size %d, got %d", i, i, size)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// runCheck runs the conure-db binary at bin with check and args, returning
+// its exit code and output
+func runCheck(t *testing.T, bin string, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(bin, append([]string{"check"}, args...)...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode(), out.String()
+	}
+	if err != nil {
+		t.Fatalf("Failed to run check: %v", err)
+	}
+	return 0, out.String()
+}
+
+// TestCheckCommand builds conure-db and runs check against a healthy file,
+// which passes, and the same file with a flipped byte, which fails naming
+// the corrupt page, in both output formats
+func TestCheckCommand(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "conure-db")
+	if out, err := exec.Command("go", "build", "-o", bin, "github.com/conuredb/conuredb/cmd/conure-db").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build conure-db: %v\n%s", err, out)
+	}
+
+	path := filepath.Join(dir, "check.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for i := 0; i < 2000; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("value")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := database.Put([]byte("key-1000"), []byte("needle")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	if code, out := runCheck(t, bin, path); code != 0 || !strings.HasSuffix(out, "ok\n") || !strings.Contains(out, "2000 keys") {
+		t.Fatalf("Check of a healthy file: exit %d\n%s", code, out)
+	}
+	if code, _ := runCheck(t, bin); code != 2 {
+		t.Fatalf("Check without a path: expected exit 2, got %d", code)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	i := bytes.Index(data, []byte("needle"))
+	if i < 0 {
+		t.Fatal("Expected the value on disk")
+	}
+	data[i] ^= 0xFF
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write corrupted file: %v", err)
+	}
+	page := i / btree.NodeSize
+
+	code, out := runCheck(t, bin, path)
+	if code != 1 || !strings.Contains(out, fmt.Sprintf("page %d:", page)) || !strings.Contains(out, "FAILED") {
+		t.Fatalf("Check of a corrupt file: exit %d\n%s", code, out)
+	}
+
+	code, out = runCheck(t, bin, "-json", path)
+	var report struct {
+		OK           bool `json:"ok"`
+		CorruptPages []struct {
+			ID    int    `json:"id"`
+			Error string `json:"error"`
+		} `json:"corrupt_pages"`
+	}
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("Failed to decode the JSON report: %v\n%s", err, out)
+	}
+	if code != 1 || report.OK || len(report.CorruptPages) != 1 || report.CorruptPages[0].ID != page {
+		t.Fatalf("Unexpected JSON report, exit %d: %+v", code, report)
+	}
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/raftnode"
+	"github.com/hashicorp/raft"
+)
+
+// TestBackwardTimestampClamped applies a command stamped before one already
+// applied, as a new leader with a slow clock would, and checks it is clamped
+// to the earlier command's timestamp with a warning
+func TestBackwardTimestampClamped(t *testing.T) {
+	var logs bytes.Buffer
+	fsm := &raftnode.FSM{
+		DB:     openTestDB(t, "skew.db"),
+		Logger: slog.New(slog.NewTextHandler(&logs, nil)),
+	}
+
+	apply := func(index uint64, key string, ts time.Time) {
+		t.Helper()
+		data, err := raftnode.EncodeCommand(raftnode.Command{Type: raftnode.CmdPut, Key: []byte(key), Value: []byte("v"), Timestamp: ts.UnixNano()})
+		if err != nil {
+			t.Fatalf("Failed to encode command: %v", err)
+		}
+		if resp := fsm.Apply(&raft.Log{Index: index, Data: data}); resp != nil {
+			t.Fatalf("Failed to apply command %d: %v", index, resp)
+		}
+	}
+
+	now := time.Now()
+	apply(1, "a", now)
+	apply(2, "b", now.Add(-5*time.Second))
+
+	if got := fsm.LastTimestamp(); !got.Equal(time.Unix(0, now.UnixNano())) {
+		t.Fatalf("Expected the timestamp to stay at %v, got %v", now, got)
+	}
+	if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "clamped") {
+		t.Fatalf("Expected a warning about the clamped timestamp, got %q", logs.String())
+	}
+	if skew := fsm.ClockSkew(); skew < 5*time.Second {
+		t.Fatalf("Expected a skew of at least 5s from the late timestamp, got %v", skew)
+	}
+
+	// A later timestamp moves forward again without a warning
+	logs.Reset()
+	apply(3, "c", now.Add(time.Second))
+	if got := fsm.LastTimestamp(); !got.Equal(time.Unix(0, now.Add(time.Second).UnixNano())) {
+		t.Fatalf("Expected the timestamp to advance, got %v", got)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("Expected no warning for a later timestamp, got %q", logs.String())
+	}
+}
+
+// TestClockMarkSurvivesRestore checks the clamp mark is kept in the
+// database, even by an entry that wrote nothing, so a node restoring a
+// snapshot clamps a late timestamp as the node that took it does
+func TestClockMarkSurvivesRestore(t *testing.T) {
+	source := &raftnode.FSM{DB: openTestDB(t, "source.db")}
+	apply := func(fsm *raftnode.FSM, index uint64, cmd raftnode.Command) interface{} {
+		t.Helper()
+		data, err := raftnode.EncodeCommand(cmd)
+		if err != nil {
+			t.Fatalf("Failed to encode command: %v", err)
+		}
+		return fsm.Apply(&raft.Log{Index: index, Data: data})
+	}
+
+	now := time.Now()
+	if resp := apply(source, 1, raftnode.Command{Type: raftnode.CmdPut, Key: []byte("a"), Value: []byte("v"), Timestamp: now.UnixNano()}); resp != nil {
+		t.Fatalf("Failed to apply put: %v", resp)
+	}
+	// A write whose condition fails still moves the mark
+	later := now.Add(10 * time.Second)
+	stale := uint64(99)
+	if resp := apply(source, 2, raftnode.Command{Type: raftnode.CmdPut, Key: []byte("a"), Value: []byte("w"), IfVersion: &stale, Timestamp: later.UnixNano()}); resp == nil {
+		t.Fatalf("Expected the conditional put to fail")
+	}
+	if mark, err := source.DB.ClockMark(); err != nil || !mark.Equal(time.Unix(0, later.UnixNano())) {
+		t.Fatalf("Expected the database to record %v, got %v (%v)", later, mark, err)
+	}
+
+	target := &raftnode.FSM{DB: openTestDB(t, "target.db")}
+	if err := target.Restore(io.NopCloser(bytes.NewReader(persistSnapshot(t, source)))); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if got := target.LastTimestamp(); !got.Equal(time.Unix(0, later.UnixNano())) {
+		t.Fatalf("Expected the restored node to resume from %v, got %v", later, got)
+	}
+	for _, fsm := range []*raftnode.FSM{source, target} {
+		if resp := apply(fsm, 3, raftnode.Command{Type: raftnode.CmdPut, Key: []byte("b"), Value: []byte("v"), Timestamp: now.Add(time.Second).UnixNano()}); resp != nil {
+			t.Fatalf("Failed to apply put: %v", resp)
+		}
+		if got := fsm.LastTimestamp(); !got.Equal(time.Unix(0, later.UnixNano())) {
+			t.Fatalf("Expected the late timestamp clamped to %v, got %v", later, got)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/hashicorp/raft"
+)
+
+// TestClusterListsMembersWithHTTPAddresses asks every node of a cluster for
+// /cluster and checks it lists all members, one of them the leader, each
+// with an HTTP address that answers
+func TestClusterListsMembersWithHTTPAddresses(t *testing.T) {
+	c := startTestCluster(t, 3)
+
+	urls := make(map[raft.ServerAddress]string)
+	servers := make([]*httptest.Server, len(c.nodes))
+	for i := range c.nodes {
+		mux := http.NewServeMux()
+		api.New(c.nodes[i], c.dbs[i]).
+			WithLeaderHTTP(func(addr raft.ServerAddress) string { return urls[addr] }).
+			Register(mux)
+		servers[i] = httptest.NewServer(mux)
+		t.Cleanup(servers[i].Close)
+		urls[raft.ServerAddress(c.addrs[i])] = servers[i].URL
+	}
+	leader := c.leader(t)
+	for i, node := range c.nodes {
+		waitFor(t, 5*time.Second, c.ids[i]+" to learn every member", func() bool {
+			f := node.Raft().GetConfiguration()
+			return f.Error() == nil && len(f.Configuration().Servers) == len(c.nodes)
+		})
+	}
+
+	for _, ts := range servers {
+		resp, err := http.Get(ts.URL + "/cluster")
+		if err != nil {
+			t.Fatalf("GET /cluster failed: %v", err)
+		}
+		var body struct {
+			Members []struct {
+				ID          string `json:"id"`
+				RaftAddress string `json:"raft_address"`
+				HTTPAddress string `json:"http_address"`
+				Suffrage    string `json:"suffrage"`
+				Leader      bool   `json:"leader"`
+			} `json:"members"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode /cluster: %v", err)
+		}
+		if len(body.Members) != len(c.nodes) {
+			t.Fatalf("Expected %d members, got %+v", len(c.nodes), body.Members)
+		}
+
+		for _, m := range body.Members {
+			if m.HTTPAddress != urls[raft.ServerAddress(m.RaftAddress)] {
+				t.Fatalf("Member %s: expected HTTP address %s, got %s", m.ID, urls[raft.ServerAddress(m.RaftAddress)], m.HTTPAddress)
+			}
+			if m.Leader != (m.RaftAddress == c.addrs[leader]) {
+				t.Fatalf("Member %s: leader=%v, but the leader is %s", m.ID, m.Leader, c.addrs[leader])
+			}
+			status, err := http.Get(m.HTTPAddress + "/status")
+			if err != nil {
+				t.Fatalf("Member %s is not reachable at %s: %v", m.ID, m.HTTPAddress, err)
+			}
+			_ = status.Body.Close()
+			if status.StatusCode != http.StatusOK {
+				t.Fatalf("Member %s answered /status with %d", m.ID, status.StatusCode)
+			}
+		}
+	}
+}
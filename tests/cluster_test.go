@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// testCluster is a set of raft nodes running in-process on loopback addresses
+type testCluster struct {
+	nodes []*raftnode.Node
+	dbs   []*db.DB
+	ids   []string
+	addrs []string
+}
+
+// startTestCluster bootstraps node1 and joins the remaining nodes as voters
+func startTestCluster(t *testing.T, size int) *testCluster {
+	t.Helper()
+	return startTestClusterWith(t, size, db.Options{})
+}
+
+// startTestClusterWith is startTestCluster with every node's database
+// opened with opts
+func startTestClusterWith(t *testing.T, size int, opts db.Options) *testCluster {
+	t.Helper()
+	c := &testCluster{}
+
+	for i := 0; i < size; i++ {
+		dir := t.TempDir()
+		database, err := db.OpenWithOptions(filepath.Join(dir, "conure.db"), opts)
+		if err != nil {
+			t.Fatalf("Failed to open database for node %d: %v", i+1, err)
+		}
+		id := fmt.Sprintf("node%d", i+1)
+		addr := freeRaftAddr(t)
+		node, err := raftnode.StartNode(raftnode.Config{
+			NodeID:    id,
+			RaftAddr:  addr,
+			DataDir:   dir,
+			Bootstrap: i == 0,
+		}, &raftnode.FSM{DB: database})
+		if err != nil {
+			t.Fatalf("Failed to start node %d: %v", i+1, err)
+		}
+		t.Cleanup(func() {
+			if err := node.Shutdown(); err != nil {
+				t.Logf("Warning: failed to shut down %s: %v", id, err)
+			}
+			if err := database.Close(); err != nil {
+				t.Logf("Warning: failed to close database for %s: %v", id, err)
+			}
+		})
+
+		c.nodes = append(c.nodes, node)
+		c.dbs = append(c.dbs, database)
+		c.ids = append(c.ids, id)
+		c.addrs = append(c.addrs, addr)
+
+		if i == 0 {
+			waitFor(t, 10*time.Second, "node1 to become leader", node.IsLeader)
+			continue
+		}
+		if err := c.nodes[0].AddVoter(id, addr); err != nil {
+			t.Fatalf("Failed to add %s as voter: %v", id, err)
+		}
+	}
+
+	return c
+}
+
+// leader returns the index of the current leader, waiting for one to emerge
+func (c *testCluster) leader(t *testing.T) int {
+	t.Helper()
+	idx := -1
+	waitFor(t, 10*time.Second, "a leader", func() bool {
+		for i, n := range c.nodes {
+			if n.IsLeader() {
+				idx = i
+				return true
+			}
+		}
+		return false
+	})
+	return idx
+}
+
+// put replicates a write through the current leader
+func (c *testCluster) put(t *testing.T, key, value string) {
+	t.Helper()
+	cmd := raftnode.Command{Type: raftnode.CmdPut, Key: []byte(key), Value: []byte(value)}
+	if _, err := c.nodes[c.leader(t)].Apply(cmd, 5*time.Second); err != nil {
+		t.Fatalf("Failed to apply put %s: %v", key, err)
+	}
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestReplicationLagGrowsForStalledFollower stops one follower and asserts the
+// leader reports its lag growing while the healthy follower keeps up
+func TestReplicationLagGrowsForStalledFollower(t *testing.T) {
+	c := startTestCluster(t, 3)
+	leader := c.nodes[c.leader(t)]
+
+	lagOf := func(id string) uint64 {
+		for _, p := range leader.Replication() {
+			if p.ID == id {
+				return p.Lag
+			}
+		}
+		t.Fatalf("Peer %s missing from replication report", id)
+		return 0
+	}
+
+	for i := 0; i < 5; i++ {
+		c.put(t, fmt.Sprintf("warm-%d", i), "v")
+	}
+	waitFor(t, 5*time.Second, "followers to catch up", func() bool {
+		return lagOf("node2") == 0 && lagOf("node3") == 0
+	})
+
+	// Stall node3; the remaining two nodes still form a quorum
+	if err := c.nodes[2].Shutdown(); err != nil {
+		t.Fatalf("Failed to stop node3: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		c.put(t, fmt.Sprintf("lag-%d", i), "v")
+	}
+	before := lagOf("node3")
+	for i := 0; i < 10; i++ {
+		c.put(t, fmt.Sprintf("lag-more-%d", i), "v")
+	}
+	after := lagOf("node3")
+
+	if before < 10 || after < before+10 {
+		t.Fatalf("Expected stalled follower lag to grow, got %d then %d", before, after)
+	}
+	waitFor(t, 5*time.Second, "healthy follower to catch up", func() bool {
+		return lagOf("node2") == 0
+	})
+	t.Logf("Stalled follower lag grew from %d to %d", before, after)
+}
+
+// TestReplicationForgetsRemovedPeer removes a caught-up follower and adds it
+// back at an address nothing answers on, and checks the leader reports it
+// with no match rather than the index it had acknowledged before removal
+func TestReplicationForgetsRemovedPeer(t *testing.T) {
+	c := startTestCluster(t, 3)
+	leader := c.nodes[c.leader(t)]
+	matchOf := func(id string) (uint64, bool) {
+		for _, p := range leader.Replication() {
+			if p.ID == id {
+				return p.MatchIndex, true
+			}
+		}
+		return 0, false
+	}
+
+	for i := 0; i < 5; i++ {
+		c.put(t, fmt.Sprintf("warm-%d", i), "v")
+	}
+	waitFor(t, 5*time.Second, "node3 to catch up", func() bool {
+		match, _ := matchOf("node3")
+		return match >= 5
+	})
+
+	if err := c.nodes[2].Shutdown(); err != nil {
+		t.Fatalf("Failed to stop node3: %v", err)
+	}
+	if err := leader.Raft().RemoveServer("node3", 0, 0).Error(); err != nil {
+		t.Fatalf("Failed to remove node3: %v", err)
+	}
+	if err := leader.AddVoter("node3", freeRaftAddr(t)); err != nil {
+		t.Fatalf("Failed to add node3 back: %v", err)
+	}
+	c.put(t, "after", "v")
+
+	match, ok := matchOf("node3")
+	if !ok || match != 0 {
+		t.Fatalf("Expected re-added node3 with no match, got %d (listed %v)", match, ok)
+	}
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// TestCommandSizeLimit checks a node refuses a command that encodes past its
+// configured MaxCommandBytes with ErrCommandTooLarge before it reaches the
+// raft log, while smaller commands still apply
+func TestCommandSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	database := openTestDB(t, "size.db")
+	if _, err := raftnode.StartNode(raftnode.Config{NodeID: "bad", RaftAddr: freeRaftAddr(t), DataDir: filepath.Join(dir, "bad"), MaxCommandBytes: -1}, &raftnode.FSM{DB: database}); err == nil {
+		t.Fatal("Expected a negative MaxCommandBytes to be rejected")
+	}
+
+	const limit = 4096
+	node, err := raftnode.StartNode(raftnode.Config{
+		NodeID:          "node1",
+		RaftAddr:        freeRaftAddr(t),
+		DataDir:         dir,
+		Bootstrap:       true,
+		MaxCommandBytes: limit,
+	}, &raftnode.FSM{DB: database})
+	if err != nil {
+		t.Fatalf("Failed to start raft node: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := node.Shutdown(); err != nil {
+			t.Logf("Warning: failed to shut down raft node: %v", err)
+		}
+	})
+	waitFor(t, 10*time.Second, "node1 to become leader", node.IsLeader)
+
+	if got := node.MaxCommandBytes(); got != limit {
+		t.Fatalf("Expected the effective limit to be %d, got %d", limit, got)
+	}
+
+	var ops []btree.Op
+	for i := 0; i < 64; i++ {
+		ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("key-%02d", i)), Value: make([]byte, 100)})
+	}
+	before := node.Raft().LastIndex()
+	_, err = node.Apply(raftnode.Command{Type: raftnode.CmdTxn, Ops: ops}, 5*time.Second)
+	if !errors.Is(err, raftnode.ErrCommandTooLarge) {
+		t.Fatalf("Expected ErrCommandTooLarge, got %v", err)
+	}
+	if after := node.Raft().LastIndex(); after != before {
+		t.Fatalf("Expected the rejected command to stay out of the log, last index moved from %d to %d", before, after)
+	}
+
+	if _, err := node.Apply(raftnode.Command{Type: raftnode.CmdTxn, Ops: ops[:8]}, 5*time.Second); err != nil {
+		t.Fatalf("Failed to apply a command under the limit: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestCommitHookIndex keeps a value→key index with a commit hook and checks
+// it matches the data after inserts, overwrites and deletes through every
+// write path, and that a failing hook aborts the write it runs in
+func TestCommitHookIndex(t *testing.T) {
+	database := openTestDB(t, "hook.db")
+
+	errRejected := errors.New("value rejected")
+	indexKey := func(value, key []byte) []byte {
+		return []byte(fmt.Sprintf("idx/%s/%s", value, key))
+	}
+	database.OnCommit(func(tx *db.Tx, ch db.Change) error {
+		if bytes.HasPrefix(ch.Key, []byte("idx/")) {
+			return nil
+		}
+		if bytes.Equal(ch.Value, []byte("bad")) {
+			return errRejected
+		}
+		if ch.Existed {
+			if err := tx.Delete(indexKey(ch.Old, ch.Key)); err != nil {
+				return err
+			}
+		}
+		if ch.Delete {
+			return nil
+		}
+		return tx.Put(indexKey(ch.Value, ch.Key), nil)
+	})
+
+	// checkIndex compares the index with an index rebuilt from the data
+	checkIndex := func(step string) {
+		t.Helper()
+		data, err := database.Scan([]byte("k"), nil, 0)
+		if err != nil {
+			t.Fatalf("%s: failed to scan data: %v", step, err)
+		}
+		index, err := database.Scan([]byte("idx/"), nil, 0)
+		if err != nil {
+			t.Fatalf("%s: failed to scan index: %v", step, err)
+		}
+		var want, got []string
+		for _, it := range data {
+			want = append(want, string(indexKey(it.Value, it.Key)))
+		}
+		for _, it := range index {
+			got = append(got, string(it.Key))
+		}
+		slices.Sort(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("%s: index out of step with data:\nexpected %v\ngot      %v", step, want, got)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", i%3))); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	checkIndex("insert")
+
+	if err := database.Put([]byte("k01"), []byte("v9")); err != nil {
+		t.Fatalf("Failed to overwrite: %v", err)
+	}
+	if _, _, err := database.PutReturningOld([]byte("k02"), []byte("v9")); err != nil {
+		t.Fatalf("Failed to overwrite returning old: %v", err)
+	}
+	checkIndex("overwrite")
+
+	if err := database.Delete([]byte("k03")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, _, err := database.DeleteReturningOld([]byte("k04")); err != nil {
+		t.Fatalf("Failed to delete returning old: %v", err)
+	}
+	if err := database.Delete([]byte("missing")); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound deleting a missing key, got %v", err)
+	}
+	checkIndex("delete")
+
+	ops := []btree.Op{
+		{Key: []byte("k05"), Value: []byte("v7")},
+		{Key: []byte("k06"), Delete: true},
+		{Key: []byte("k50"), Value: []byte("v7")},
+		{Key: []byte("missing"), Delete: true},
+	}
+	if err := database.Batch(ops, btree.BatchOptions{}); err != nil {
+		t.Fatalf("Failed to apply batch: %v", err)
+	}
+	applied, err := database.Txn([]btree.Cond{{Key: []byte("k07"), Value: []byte("v1")}}, []btree.Op{{Key: []byte("k07"), Value: []byte("v8")}})
+	if err != nil || !applied {
+		t.Fatalf("Expected txn to apply, got %v, %v", applied, err)
+	}
+	applied, err = database.Txn([]btree.Cond{{Key: []byte("k07"), Value: []byte("v1")}}, []btree.Op{{Key: []byte("k07"), Value: []byte("v6")}})
+	if err != nil || applied {
+		t.Fatalf("Expected txn with a failed condition not to apply, got %v, %v", applied, err)
+	}
+	versioned := database.AtVersion(42)
+	if err := versioned.Put([]byte("k08"), []byte("v5")); err != nil {
+		t.Fatalf("Failed to put at version: %v", err)
+	}
+	if err := versioned.Delete([]byte("k09")); err != nil {
+		t.Fatalf("Failed to delete at version: %v", err)
+	}
+	checkIndex("batch, txn and versioned")
+
+	// A hook error aborts the whole write, data and index alike
+	if err := database.Put([]byte("k10"), []byte("bad")); !errors.Is(err, errRejected) {
+		t.Fatalf("Expected the hook's error, got %v", err)
+	}
+	err = database.Batch([]btree.Op{{Key: []byte("k11"), Value: []byte("v4")}, {Key: []byte("k12"), Value: []byte("bad")}}, btree.BatchOptions{})
+	if !errors.Is(err, errRejected) {
+		t.Fatalf("Expected the hook's error from the batch, got %v", err)
+	}
+	if v, err := database.Get([]byte("k11")); err != nil || string(v) != "v2" {
+		t.Fatalf("Expected the rejected batch not to apply, got %q, %v", v, err)
+	}
+	checkIndex("rejected writes")
+}
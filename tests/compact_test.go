@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestCompactShrinksFileAfterDeletes deletes most keys and checks Compact
+// returns the space to the filesystem without losing the survivors
+func TestCompactShrinksFileAfterDeletes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compact.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close test database: %v", closeErr)
+		}
+	}()
+
+	const numKeys = 2000
+	value := bytes.Repeat([]byte("v"), 200)
+	for i := 0; i < numKeys; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%05d", i)), value); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	for i := 0; i < numKeys; i++ {
+		if i%20 == 0 {
+			continue
+		}
+		if err := database.Delete([]byte(fmt.Sprintf("key-%05d", i))); err != nil {
+			t.Fatalf("Failed to delete key %d: %v", i, err)
+		}
+	}
+
+	fileSize := func() int64 {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat database file: %v", err)
+		}
+		return info.Size()
+	}
+	before := fileSize()
+
+	stats, err := database.Compact()
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	after := fileSize()
+	t.Logf("Compacted %d -> %d bytes (%+v)", before, after, stats)
+
+	if after >= before/4 {
+		t.Fatalf("Expected file to shrink well below %d bytes, got %d", before, after)
+	}
+	if after != stats.BytesAfter {
+		t.Fatalf("Reported size %d does not match file size %d", stats.BytesAfter, after)
+	}
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < numKeys; i++ {
+			got, err := database.Get([]byte(fmt.Sprintf("key-%05d", i)))
+			if i%20 != 0 {
+				if err == nil {
+					t.Fatalf("Expected key %d to stay deleted", i)
+				}
+				continue
+			}
+			if err != nil || !bytes.Equal(got, value) {
+				t.Fatalf("Key %d lost after compaction: %v", i, err)
+			}
+		}
+	}
+	check()
+
+	// The compacted file must stay writable and survive a reopen
+	for i := numKeys; i < numKeys+100; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%05d", i)), value); err != nil {
+			t.Fatalf("Failed to put after compaction: %v", err)
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	database, err = db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	check()
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// TestFollowerScanIsPointInTime streams transactions that rewrite every key
+// of a prefix to the same generation while a follower serves yielding scans
+// of it and compacts. Each scan must return one generation for every key.
+func TestFollowerScanIsPointInTime(t *testing.T) {
+	c := startTestClusterWith(t, 2, db.Options{NoSync: true, YieldEvery: 3})
+	leader := c.leader(t)
+	follower := 1 - leader
+
+	mux := http.NewServeMux()
+	api.New(c.nodes[follower], c.dbs[follower]).Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	const keys = 200
+	writeGen := func(gen int) error {
+		ops := make([]btree.Op, keys)
+		for i := range ops {
+			ops[i] = btree.Op{Key: []byte(fmt.Sprintf("row:%03d", i)), Value: []byte(fmt.Sprintf("gen-%d", gen))}
+		}
+		_, err := c.nodes[leader].Apply(raftnode.Command{Type: raftnode.CmdTxn, Ops: ops}, 5*time.Second)
+		return err
+	}
+	if err := writeGen(0); err != nil {
+		t.Fatalf("Initial write failed: %v", err)
+	}
+	waitFor(t, 5*time.Second, "the follower to apply generation 0", func() bool {
+		_, err := c.dbs[follower].Get([]byte(fmt.Sprintf("row:%03d", keys-1)))
+		return err == nil
+	})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for gen := 1; ; gen++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := writeGen(gen); err != nil {
+				t.Errorf("Write of generation %d failed: %v", gen, err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			if _, err := c.dbs[follower].Compact(); err != nil {
+				t.Errorf("Compact on the follower failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	seen := make(map[string]bool)
+	deadline := time.Now().Add(3 * time.Second)
+	for scans := 0; time.Now().Before(deadline) || scans < 10; scans++ {
+		resp, err := http.Get(ts.URL + fmt.Sprintf("/scan?prefix=row:&limit=%d&stale=true", keys))
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		var body struct {
+			Items []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"items"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close response body: %v", closeErr)
+		}
+		if resp.StatusCode != http.StatusOK || err != nil {
+			t.Fatalf("Scan returned %d: %v", resp.StatusCode, err)
+		}
+		if len(body.Items) != keys {
+			t.Fatalf("Expected %d keys, got %d", keys, len(body.Items))
+		}
+		gen := body.Items[0].Value
+		for _, it := range body.Items {
+			if it.Value != gen {
+				t.Fatalf("Scan mixed generations: %s is %s but %s is %s", body.Items[0].Key, gen, it.Key, it.Value)
+			}
+		}
+		seen[gen] = true
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("Expected scans to observe several generations, saw %v", seen)
+	}
+}
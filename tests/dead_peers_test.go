@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// TestDeadFollowerFlaggedThenRemoved stops one follower for good and checks
+// the leader flags it once it has been silent for the timeout, leaves it in
+// the configuration while removal is off, and removes it once it is on
+func TestDeadFollowerFlaggedThenRemoved(t *testing.T) {
+	c := startTestCluster(t, 3)
+	li := c.leader(t)
+	leader := c.nodes[li]
+	deadIdx := (li + 1) % len(c.nodes)
+	dead := c.ids[deadIdx]
+
+	flagged := make(chan raftnode.DeadPeer, 4)
+	stop := leader.WatchDeadPeers(raftnode.DeadPeerOptions{
+		After:    2 * time.Second,
+		Interval: 100 * time.Millisecond,
+		OnDead:   func(p raftnode.DeadPeer) { flagged <- p },
+	})
+
+	// Healthy followers are never flagged
+	time.Sleep(2500 * time.Millisecond)
+	if peers := leader.DeadPeers(); len(peers) != 0 {
+		t.Fatalf("Expected no dead peers with every node up, got %+v", peers)
+	}
+
+	if err := c.nodes[deadIdx].Shutdown(); err != nil {
+		t.Fatalf("Failed to stop %s: %v", dead, err)
+	}
+	select {
+	case p := <-flagged:
+		if p.ID != dead || !p.Voter || p.LastContact.IsZero() {
+			t.Fatalf("Expected %s flagged as a voter with a last contact, got %+v", dead, p)
+		}
+		if silent := time.Since(p.LastContact); silent < 2*time.Second {
+			t.Fatalf("Expected %s flagged only after 2s of silence, got %v", dead, silent)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected %s to be flagged dead", dead)
+	}
+	if peers := leader.DeadPeers(); len(peers) != 1 || peers[0].ID != dead {
+		t.Fatalf("Expected DeadPeers to list only %s, got %+v", dead, peers)
+	}
+
+	// Flagged once, and left in the configuration
+	time.Sleep(500 * time.Millisecond)
+	select {
+	case p := <-flagged:
+		t.Fatalf("Expected one warning per dead peer, got another for %s", p.ID)
+	default:
+	}
+	if n := len(leader.Raft().GetConfiguration().Configuration().Servers); n != 3 {
+		t.Fatalf("Expected 3 servers with removal off, got %d", n)
+	}
+	stop()
+
+	removed := make(chan error, 1)
+	stop = leader.WatchDeadPeers(raftnode.DeadPeerOptions{
+		After:    2 * time.Second,
+		Interval: 100 * time.Millisecond,
+		Remove:   true,
+		OnRemove: func(p raftnode.DeadPeer, err error) {
+			if p.ID == dead {
+				removed <- err
+			}
+		},
+	})
+	defer stop()
+	select {
+	case err := <-removed:
+		if err != nil {
+			t.Fatalf("Failed to remove %s: %v", dead, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected %s to be removed", dead)
+	}
+	for _, sv := range leader.Raft().GetConfiguration().Configuration().Servers {
+		if string(sv.ID) == dead {
+			t.Fatalf("Expected %s gone from the configuration", dead)
+		}
+	}
+	c.put(t, "after", "removal")
+}
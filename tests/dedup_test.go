@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestCompactDedupsRepeatedValues fills two databases with many keys
+// holding one config blob, compacts both, and checks the one with
+// DedupMinValueSize shrinks to a fraction of the other while every read
+// still returns the full value, and the shared copy goes once no key refers
+// to it
+func TestCompactDedupsRepeatedValues(t *testing.T) {
+	const n = 2000
+	blob := bytes.Repeat([]byte("default-config;"), 60)[:900]
+	key := func(i int) []byte { return []byte(fmt.Sprintf("cfg-%05d", i)) }
+
+	fill := func(name string, opts db.Options) (*db.DB, string) {
+		path := filepath.Join(t.TempDir(), name)
+		database, err := db.OpenWithOptions(path, opts)
+		if err != nil {
+			t.Fatalf("Failed to open database: %v", err)
+		}
+		t.Cleanup(func() {
+			if closeErr := database.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close test database: %v", closeErr)
+			}
+		})
+		for start := 0; start < n; start += 200 {
+			var ops []btree.Op
+			for i := start; i < start+200; i++ {
+				ops = append(ops, btree.Op{Key: key(i), Value: blob})
+			}
+			if err := database.Batch(ops, btree.BatchOptions{}); err != nil {
+				t.Fatalf("Failed to load batch at %d: %v", start, err)
+			}
+		}
+		if err := database.Put([]byte("unique"), []byte("not shared")); err != nil {
+			t.Fatalf("Failed to put unique key: %v", err)
+		}
+		return database, path
+	}
+	fileSize := func(path string) int64 {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		return info.Size()
+	}
+
+	plain, plainPath := fill("plain.db", db.Options{NoSync: true})
+	if _, err := plain.Compact(); err != nil {
+		t.Fatalf("Failed to compact plain database: %v", err)
+	}
+	deduped, dedupPath := fill("dedup.db", db.Options{NoSync: true, DedupMinValueSize: 64})
+	stats, err := deduped.Compact()
+	if err != nil {
+		t.Fatalf("Failed to compact deduped database: %v", err)
+	}
+	if stats.ValuesShared != n {
+		t.Fatalf("Expected %d values shared, got %d", n, stats.ValuesShared)
+	}
+
+	plainSize, dedupSize := fileSize(plainPath), fileSize(dedupPath)
+	t.Logf("%d keys of a %d-byte value: %d bytes plain, %d deduplicated (%.1f%%)", n, len(blob), plainSize, dedupSize, 100*float64(dedupSize)/float64(plainSize))
+	if dedupSize*4 > plainSize {
+		t.Fatalf("Expected deduplicated file under a quarter of %d bytes, got %d", plainSize, dedupSize)
+	}
+
+	// Reads resolve references, through Get, Scan and Txn conditions alike
+	for _, i := range []int{0, n / 2, n - 1} {
+		if got, err := deduped.Get(key(i)); err != nil || !bytes.Equal(got, blob) {
+			t.Fatalf("Get %s returned %d bytes, %v", key(i), len(got), err)
+		}
+	}
+	items, err := deduped.Scan([]byte("cfg-"), nil, 0)
+	if err != nil || len(items) != n {
+		t.Fatalf("Expected %d scanned keys, got %d: %v", n, len(items), err)
+	}
+	for _, it := range items {
+		if !bytes.Equal(it.Value, blob) {
+			t.Fatalf("Scan returned %d bytes for %s", len(it.Value), it.Key)
+		}
+	}
+	ok, err := deduped.Txn([]btree.Cond{{Key: key(1), Value: blob}}, []btree.Op{{Key: key(1), Value: []byte("changed")}})
+	if err != nil || !ok {
+		t.Fatalf("Expected txn conditioned on the shared value to apply, got %v, %v", ok, err)
+	}
+	if err := deduped.Verify(); err != nil {
+		t.Fatalf("Tree failed verification: %v", err)
+	}
+
+	// The shared copies stay out of scans and exports, and out of reach of
+	// writes
+	all, err := deduped.Scan(nil, nil, 0)
+	if err != nil || len(all) != n+1 {
+		t.Fatalf("Expected %d keys from a full scan, got %d: %v", n+1, len(all), err)
+	}
+	var csv bytes.Buffer
+	if err := deduped.ExportCSV(&csv); err != nil || bytes.Count(csv.Bytes(), []byte("\n")) != n+1 {
+		t.Fatalf("Expected %d exported rows, got %d: %v", n+1, bytes.Count(csv.Bytes(), []byte("\n")), err)
+	}
+	shared, err := deduped.Scan([]byte("\x00d:"), nil, 0)
+	if err != nil || len(shared) != 1 {
+		t.Fatalf("Expected one shared value, got %d: %v", len(shared), err)
+	}
+	if err := deduped.Put(shared[0].Key, []byte("forged")); !errors.Is(err, btree.ErrReservedKey) {
+		t.Fatalf("Expected ErrReservedKey overwriting a shared value, got %v", err)
+	}
+	if err := deduped.Delete(shared[0].Key); !errors.Is(err, btree.ErrReservedKey) {
+		t.Fatalf("Expected ErrReservedKey deleting a shared value, got %v", err)
+	}
+	if _, _, err := deduped.PutReturningOld(shared[0].Key, []byte("forged")); !errors.Is(err, btree.ErrReservedKey) {
+		t.Fatalf("Expected ErrReservedKey swapping a shared value, got %v", err)
+	}
+	if _, _, err := deduped.DeleteReturningOld(shared[0].Key); !errors.Is(err, btree.ErrReservedKey) {
+		t.Fatalf("Expected ErrReservedKey taking a shared value, got %v", err)
+	}
+
+	// Releases that cannot read references refuse the file by its flags
+	headerFlags := func(path string) uint32 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		return binary.LittleEndian.Uint32(data[btree.HeaderSize-4 : btree.HeaderSize])
+	}
+	if plainFlags, dedupFlags := headerFlags(plainPath), headerFlags(dedupPath); plainFlags != 0 || dedupFlags == 0 {
+		t.Fatalf("Expected header flags only on the deduplicated file, got %#x and %#x", plainFlags, dedupFlags)
+	}
+
+	reader, err := db.OpenWithOptions(dedupPath, db.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to reopen deduplicated file: %v", err)
+	}
+	got, err := reader.Get(key(n - 1))
+	if closeErr := reader.Close(); closeErr != nil {
+		t.Logf("Warning: failed to close reader: %v", closeErr)
+	}
+	if err != nil || !bytes.Equal(got, blob) {
+		t.Fatalf("Reopened file returned %d bytes, %v", len(got), err)
+	}
+
+	// Overwriting or deleting every key drops the shared copy
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			err = deduped.Delete(key(i))
+		} else {
+			err = deduped.Put(key(i), []byte("own"))
+		}
+		if err != nil {
+			t.Fatalf("Failed to release key %d: %v", i, err)
+		}
+	}
+	shared, err = deduped.Scan([]byte("\x00d:"), nil, 0)
+	if err != nil || len(shared) != 0 {
+		t.Fatalf("Expected no shared values left, got %d: %v", len(shared), err)
+	}
+	if err := deduped.Verify(); err != nil {
+		t.Fatalf("Tree failed verification after releasing every reference: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// TestDegradedReadsOnQuorumLoss stops two nodes of three and checks the
+// survivor, started with degraded reads, keeps answering plain reads from its
+// data, flagged as degraded, while refusing writes with 503
+func TestDegradedReadsOnQuorumLoss(t *testing.T) {
+	c := startTestCluster(t, 3)
+	c.put(t, "k", "v")
+
+	survivor := (c.leader(t) + 1) % 3
+	waitFor(t, 5*time.Second, "the write to replicate", func() bool {
+		v, err := c.dbs[survivor].Get([]byte("k"))
+		return err == nil && string(v) == "v"
+	})
+	mux := http.NewServeMux()
+	api.New(c.nodes[survivor], c.dbs[survivor]).WithDegradedReads().Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	get := func(path string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, strings.TrimSpace(string(body))
+	}
+
+	// With a leader, a follower still sends plain reads there
+	if resp, _ := get("/kv?key=k"); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected 409 from a follower with a leader, got %d", resp.StatusCode)
+	}
+
+	for i := range c.nodes {
+		if i == survivor {
+			continue
+		}
+		if err := c.nodes[i].Shutdown(); err != nil {
+			t.Fatalf("Failed to stop %s: %v", c.ids[i], err)
+		}
+	}
+	waitFor(t, 15*time.Second, "the survivor to lose its leader", func() bool {
+		return c.nodes[survivor].Leader() == ""
+	})
+
+	resp, body := get("/kv?key=k")
+	if resp.StatusCode != http.StatusOK || body != "v" {
+		t.Fatalf("Expected a degraded read of v, got %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Conure-Degraded") != "true" {
+		t.Fatalf("Expected the read to be flagged as degraded")
+	}
+	if resp, _ := get("/scan?prefix=k"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a degraded scan to succeed, got %d", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key=k", strings.NewReader("w"))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	putResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	_ = putResp.Body.Close()
+	if putResp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 for a write without quorum, got %d", putResp.StatusCode)
+	}
+
+	for _, path := range []string{"/status", "/healthz"} {
+		resp, body := get(path)
+		var status struct {
+			Degraded bool `json:"degraded"`
+		}
+		if err := json.Unmarshal([]byte(body), &status); err != nil || resp.StatusCode != http.StatusOK || !status.Degraded {
+			t.Fatalf("Expected %s to report degraded with 200, got %d %s", path, resp.StatusCode, body)
+		}
+	}
+}
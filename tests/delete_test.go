@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestDeletesCascadeMergesToRoot grows a three-level tree, then deletes keys
+// so that leaves, then internal pages, underflow and merge until the root
+// collapses into a single leaf. The tree must verify after every phase and
+// after reopening, and hold exactly the surviving keys.
+func TestDeletesCascadeMergesToRoot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "merge.db")
+	database, err := db.OpenWithOptions(path, db.Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	const n = 6000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("del-%05d", i)) }
+	value := make([]byte, 200)
+	for start := 0; start < n; start += 200 {
+		var ops []btree.Op
+		for i := start; i < start+200; i++ {
+			ops = append(ops, btree.Op{Key: key(i), Value: value})
+		}
+		if err := database.Batch(ops, btree.BatchOptions{}); err != nil {
+			t.Fatalf("Failed to load batch at %d: %v", start, err)
+		}
+	}
+
+	depth := func() int {
+		stats, err := database.Stats(true)
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		return stats.Depth
+	}
+	verify := func(phase string) {
+		if err := database.Verify(); err != nil {
+			t.Fatalf("Verify failed after %s: %v", phase, err)
+		}
+	}
+	verify("load")
+	if d := depth(); d < 3 {
+		t.Fatalf("Expected at least three levels before deleting, got %d", d)
+	}
+
+	// Survivors are spread across the key space so merges happen everywhere
+	survives := func(i int) bool { return i%1500 == 7 }
+
+	// Phase 1: single deletes of every odd key thin every leaf
+	for i := 1; i < n; i += 2 {
+		if survives(i) {
+			continue
+		}
+		if err := database.Delete(key(i)); err != nil {
+			t.Fatalf("Failed to delete key %d: %v", i, err)
+		}
+		if i%1001 == 0 {
+			verify(fmt.Sprintf("deleting key %d", i))
+		}
+	}
+	verify("odd deletes")
+
+	// Phase 2: the remaining keys in descending batches, so many merges land
+	// in one transaction
+	for end := n; end > 0; end -= 150 {
+		var ops []btree.Op
+		for i := end - 1; i >= end-150 && i >= 0; i-- {
+			if i%2 == 0 && !survives(i) {
+				ops = append(ops, btree.Op{Key: key(i), Delete: true})
+			}
+		}
+		if err := database.Batch(ops, btree.BatchOptions{}); err != nil {
+			t.Fatalf("Failed to delete batch ending at %d: %v", end, err)
+		}
+		verify(fmt.Sprintf("batch ending at %d", end))
+	}
+	if d := depth(); d != 1 {
+		t.Fatalf("Expected merges to collapse the tree to a single leaf, got depth %d", d)
+	}
+
+	check := func() {
+		items, err := database.Scan(nil, nil, 0)
+		if err != nil {
+			t.Fatalf("Failed to scan: %v", err)
+		}
+		var want []string
+		for i := 0; i < n; i++ {
+			if survives(i) {
+				want = append(want, string(key(i)))
+			}
+		}
+		if len(items) != len(want) {
+			t.Fatalf("Expected %d surviving keys, got %d", len(want), len(items))
+		}
+		for i, it := range items {
+			if string(it.Key) != want[i] {
+				t.Fatalf("Item %d: got key %q, want %q", i, it.Key, want[i])
+			}
+		}
+	}
+	check()
+
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	database, err = db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+	verify("reopen")
+	check()
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestDiffReportsDifferences diffs two databases that share most keys,
+// spread over several scan pages, and checks every difference is reported
+// in key order
+func TestDiffReportsDifferences(t *testing.T) {
+	dir := t.TempDir()
+	pathA, pathB := filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db")
+	fill := func(path string, edit func(i int) (string, bool)) {
+		t.Helper()
+		database, err := db.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open database: %v", err)
+		}
+		for i := 0; i < 2500; i++ {
+			value, ok := edit(i)
+			if !ok {
+				continue
+			}
+			if err := database.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+	fill(pathA, func(i int) (string, bool) { return "v", i != 2499 })
+	fill(pathB, func(i int) (string, bool) {
+		switch i {
+		case 0, 1200:
+			return "", false
+		case 5, 1999:
+			return "changed", true
+		}
+		return "v", true
+	})
+
+	result, err := db.Diff(pathA, pathB)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	keys := func(ks [][]byte) []string {
+		out := []string{}
+		for _, k := range ks {
+			out = append(out, string(k))
+		}
+		return out
+	}
+	if got, want := keys(result.Missing), []string{"key-00000", "key-01200"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Missing = %v, want %v", got, want)
+	}
+	if got, want := keys(result.Extra), []string{"key-02499"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Extra = %v, want %v", got, want)
+	}
+	if got, want := keys(result.Changed), []string{"key-00005", "key-01999"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Changed = %v, want %v", got, want)
+	}
+	if result.Same != 2495 || result.Equal() {
+		t.Fatalf("Expected 2495 matching keys and a difference, got %+v", result.Same)
+	}
+
+	if result, err := db.Diff(pathA, pathA); err != nil || !result.Equal() || result.Same != 2499 {
+		t.Fatalf("Expected a file to match itself, got %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// TestMaxDirtyPagesBoundsLargeTransaction writes far more pages than
+// MaxDirtyPages in one transaction and checks the pages held in memory stay
+// near the cap, while the transaction still aborts and commits as a whole and
+// a copy of the file taken mid-transaction, as a crash would leave it, holds
+// only the committed data
+func TestMaxDirtyPagesBoundsLargeTransaction(t *testing.T) {
+	const (
+		maxDirty = 64
+		n        = 20000
+	)
+	// One operation copies a path and may split every page on it
+	const slack = 16
+
+	path := filepath.Join(t.TempDir(), "dirty.db")
+	tree, err := btree.NewBTreeWithOptions(path, btree.Options{NoSync: true, MaxDirtyPages: maxDirty})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+	if err := tree.Put([]byte("base"), []byte("committed")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	crashCopy := filepath.Join(t.TempDir(), "crash.db")
+	errAbort := errors.New("abort")
+	var peak int64
+	write := func(abort bool) error {
+		return tree.Update(func(tx *btree.Tx) error {
+			for i := 0; i < n; i++ {
+				if err := tx.Put([]byte(fmt.Sprintf("key-%06d", i)), make([]byte, 100)); err != nil {
+					return err
+				}
+				peak = max(peak, tree.DirtyStats().Pages)
+				if i == n/2 && abort {
+					data, err := os.ReadFile(path)
+					if err != nil {
+						return err
+					}
+					if err := os.WriteFile(crashCopy, data, 0o644); err != nil {
+						return err
+					}
+				}
+			}
+			if err := tx.Delete([]byte("base")); err != nil {
+				return err
+			}
+			// The transaction reads back what it spilled
+			if v, ok, err := tx.Get([]byte("key-000000")); err != nil || !ok || len(v) != 100 {
+				return fmt.Errorf("reading a spilled page back: %q, %v, %v", v, ok, err)
+			}
+			if abort {
+				return errAbort
+			}
+			return nil
+		})
+	}
+
+	if err := write(true); !errors.Is(err, errAbort) {
+		t.Fatalf("Expected the transaction to abort, got %v", err)
+	}
+	stats := tree.DirtyStats()
+	if stats.Spills == 0 || stats.SpilledPages < n/100 {
+		t.Fatalf("Expected the transaction to spill, got %+v", stats)
+	}
+	if peak > maxDirty+slack {
+		t.Fatalf("Expected at most %d dirty pages, peaked at %d", maxDirty+slack, peak)
+	}
+	if stats.Pages != 0 {
+		t.Fatalf("Expected no dirty pages after the abort, got %d", stats.Pages)
+	}
+	if v, err := tree.Get([]byte("base")); err != nil || string(v) != "committed" {
+		t.Fatalf("Expected the aborted transaction to leave base, got %q, %v", v, err)
+	}
+	if _, err := tree.Get([]byte("key-000000")); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Fatalf("Expected the aborted transaction's keys to be gone, got %v", err)
+	}
+
+	// A crash mid-transaction leaves the last commit
+	crashed, err := btree.NewBTreeWithOptions(crashCopy, btree.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open the crash copy: %v", err)
+	}
+	if v, err := crashed.Get([]byte("base")); err != nil || string(v) != "committed" {
+		t.Fatalf("Expected the crash copy to hold base, got %q, %v", v, err)
+	}
+	if _, err := crashed.Get([]byte("key-000000")); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Fatalf("Expected the crash copy to hold none of the transaction, got %v", err)
+	}
+	if err := crashed.Verify(); err != nil {
+		t.Fatalf("Crash copy failed to verify: %v", err)
+	}
+	if err := crashed.Close(); err != nil {
+		t.Fatalf("Failed to close the crash copy: %v", err)
+	}
+
+	peak = 0
+	if err := write(false); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if peak > maxDirty+slack {
+		t.Fatalf("Expected at most %d dirty pages, peaked at %d", maxDirty+slack, peak)
+	}
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Failed to close tree: %v", err)
+	}
+
+	tree, err = btree.NewBTreeWithOptions(path, btree.Options{})
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	for _, i := range []int{0, n / 2, n - 1} {
+		if v, err := tree.Get([]byte(fmt.Sprintf("key-%06d", i))); err != nil || len(v) != 100 {
+			t.Fatalf("Expected key %d after reopening, got %q, %v", i, v, err)
+		}
+	}
+	if _, err := tree.Get([]byte("base")); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Fatalf("Expected base to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// impossibleFree is more free space than any test machine has, so a
+// threshold of it makes every disk look full
+const impossibleFree = 1 << 62
+
+// TestWritesRefusedWhenDiskLow opens a database whose free-space threshold
+// cannot be met and checks writes fail with ErrDiskFull before touching the
+// file while reads keep working
+func TestWritesRefusedWhenDiskLow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "full.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := database.FreeSpace(); err != nil {
+		t.Skipf("Free space is not reported here: %v", err)
+	}
+	if err := database.Put([]byte("existing"), []byte("v1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	database, err = db.OpenWithOptions(path, db.Options{MinFreeBytes: impossibleFree})
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+
+	statsBefore, err := database.Stats(false)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if statsBefore.DiskFree == 0 || statsBefore.MinDiskFree != impossibleFree {
+		t.Fatalf("Expected stats to report free space and the threshold, got %+v", statsBefore)
+	}
+
+	if err := database.Put([]byte("new"), []byte("v")); !errors.Is(err, btree.ErrDiskFull) {
+		t.Fatalf("Expected ErrDiskFull from Put, got %v", err)
+	}
+	if err := database.Delete([]byte("existing")); !errors.Is(err, btree.ErrDiskFull) {
+		t.Fatalf("Expected ErrDiskFull from Delete, got %v", err)
+	}
+	if _, err := database.Txn(nil, []btree.Op{{Key: []byte("new"), Value: []byte("v")}}); !errors.Is(err, btree.ErrDiskFull) {
+		t.Fatalf("Expected ErrDiskFull from Txn, got %v", err)
+	}
+
+	got, err := database.Get([]byte("existing"))
+	if err != nil || string(got) != "v1" {
+		t.Fatalf("Expected reads to continue, got %q, %v", got, err)
+	}
+	statsAfter, err := database.Stats(false)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if statsAfter.PageCount != statsBefore.PageCount {
+		t.Fatalf("Refused writes allocated pages: %d -> %d", statsBefore.PageCount, statsAfter.PageCount)
+	}
+}
+
+// TestHTTPWritesRefusedWhenDiskLow checks the leader answers 507 to writes
+// below its free-space threshold and still serves reads
+func TestHTTPWritesRefusedWhenDiskLow(t *testing.T) {
+	ts, database := startTestServer(t, func(s *api.Server) { s.WithMinFreeDisk(impossibleFree) })
+	if _, err := database.FreeSpace(); err != nil {
+		t.Skipf("Free space is not reported here: %v", err)
+	}
+	if err := database.Put([]byte("existing"), []byte("v1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key=new&value=v", nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Fatalf("Expected 507 for a write, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(ts.URL+"/txn", "application/json", strings.NewReader(`{"ops":[{"key":"new","value":"v"}]}`))
+	if err != nil {
+		t.Fatalf("POST /txn failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Fatalf("Expected 507 for a txn, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/kv?key=existing")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected reads to continue, got %d", resp.StatusCode)
+	}
+}
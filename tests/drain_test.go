@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestDrainRejectsWritesServesReads drains a node and checks writes are
+// refused with 503 and Retry-After while reads still succeed, then undrains
+// it and writes again
+func TestDrainRejectsWritesServesReads(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+	httpPut(t, ts, "k", "v")
+
+	send := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		return resp
+	}
+	drained := func() bool {
+		t.Helper()
+		resp := send(http.MethodGet, "/status", "")
+		defer func() { _ = resp.Body.Close() }()
+		var status struct {
+			Drained bool `json:"drained"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode status: %v", err)
+		}
+		return status.Drained
+	}
+
+	if drained() {
+		t.Fatal("Expected a new node not to be drained")
+	}
+	resp := send(http.MethodPost, "/admin/drain", "")
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Drain failed with %d", resp.StatusCode)
+	}
+	if !drained() {
+		t.Fatal("Expected /status to report the node drained")
+	}
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		resp := send(method, "/kv?key=k", "new")
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("Expected %s while drained to get 503, got %d", method, resp.StatusCode)
+		}
+		if resp.Header.Get("Retry-After") == "" {
+			t.Fatalf("Expected %s while drained to carry Retry-After", method)
+		}
+	}
+	resp = send(http.MethodGet, "/kv?key=k", "")
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "v" {
+		t.Fatalf("Expected the read to return v while drained, got %d %q", resp.StatusCode, body)
+	}
+
+	resp = send(http.MethodPost, "/admin/undrain", "")
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Undrain failed with %d", resp.StatusCode)
+	}
+	httpPut(t, ts, "k", "after")
+	if drained() {
+		t.Fatal("Expected /status to report the node undrained")
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestDropCacheRereadsFromDisk warms the node cache, drops it and checks
+// every key still reads back correctly, now through cache misses
+func TestDropCacheRereadsFromDisk(t *testing.T) {
+	database := openTestDB(t, "dropcache.db")
+
+	const n = 2000
+	for i := 0; i < n; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	readAll := func() {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			value, err := database.Get([]byte(fmt.Sprintf("key-%05d", i)))
+			if err != nil {
+				t.Fatalf("Get %d failed: %v", i, err)
+			}
+			if want := fmt.Sprintf("value-%d", i); string(value) != want {
+				t.Fatalf("Expected %q, got %q", want, value)
+			}
+		}
+	}
+	misses := func() uint64 {
+		t.Helper()
+		stats, err := database.Stats(false)
+		if err != nil {
+			t.Fatalf("Stats failed: %v", err)
+		}
+		return stats.CacheMisses
+	}
+
+	readAll()
+	warm := misses()
+	readAll()
+	if got := misses(); got != warm {
+		t.Fatalf("Expected a warm cache to serve every read, got %d new misses", got-warm)
+	}
+
+	dropped, err := database.DropCache()
+	if err != nil {
+		t.Fatalf("DropCache failed: %v", err)
+	}
+	if dropped == 0 {
+		t.Fatal("Expected DropCache to drop cached nodes")
+	}
+	readAll()
+	// The cache also held pages of superseded trees, so only the live ones
+	// are read back
+	if got := misses() - warm; got == 0 || got > uint64(dropped) {
+		t.Fatalf("Expected reads after DropCache to miss the cache, got %d misses for %d dropped nodes", got, dropped)
+	}
+	if err := database.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+}
+
+// TestAdminDropCacheEndpoint drops the cache through POST /admin/dropcache
+func TestAdminDropCacheEndpoint(t *testing.T) {
+	ts, database := startTestServer(t, nil)
+	if err := database.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	resp, err := http.Get(ts.URL + "/admin/dropcache")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405 for GET, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(ts.URL+"/admin/dropcache", "", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close response body: %v", closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var out struct {
+		NodesDropped int `json:"nodes_dropped"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if out.NodesDropped == 0 {
+		t.Fatal("Expected the endpoint to report dropped nodes")
+	}
+	if value, err := database.Get([]byte("k")); err != nil || string(value) != "v" {
+		t.Fatalf("Expected k to read back after dropping the cache, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// Example_embedded uses ConureDB as a plain embedded library, with no raft
+// node or HTTP server involved
+func Example_embedded() {
+	dir, err := os.MkdirTemp("", "conure-embedded")
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "app.db")
+
+	store, err := db.OpenWithOptions(path, db.Options{NoSync: true})
+	if err != nil {
+		panic(err)
+	}
+	err = store.Batch([]btree.Op{
+		{Key: []byte("user:1"), Value: []byte("alice")},
+		{Key: []byte("user:2"), Value: []byte("bob")},
+		{Key: []byte("order:1"), Value: []byte("book")},
+	}, btree.BatchOptions{})
+	if err != nil {
+		panic(err)
+	}
+	if err := store.Sync(); err != nil {
+		panic(err)
+	}
+	if err := store.Close(); err != nil {
+		panic(err)
+	}
+
+	// Reopen read-only, e.g. from a reporting process
+	reader, err := db.OpenWithOptions(path, db.Options{ReadOnly: true})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	users, err := reader.Scan([]byte("user:"), nil, 0)
+	if err != nil {
+		panic(err)
+	}
+	for _, item := range users {
+		fmt.Printf("%s=%s\n", item.Key, item.Value)
+	}
+	fmt.Println(errors.Is(reader.Put([]byte("user:3"), []byte("carol")), btree.ErrReadOnly))
+
+	// Output:
+	// user:1=alice
+	// user:2=bob
+	// true
+}
+
+// TestEmbeddedPackagesAvoidServerDeps guards the embedded library against
+// picking up the raft or HTTP layers as dependencies
+func TestEmbeddedPackagesAvoidServerDeps(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping dependency check in short mode")
+	}
+	out, err := exec.Command("go", "list", "-deps", "github.com/conuredb/conuredb/db").Output()
+	if err != nil {
+		t.Skipf("go list unavailable: %v", err)
+	}
+	for _, dep := range strings.Fields(string(out)) {
+		if strings.HasPrefix(dep, "github.com/hashicorp/") || dep == "net/http" || strings.HasPrefix(dep, "github.com/conuredb/conuredb/pkg/") {
+			t.Fatalf("Embedded package db depends on %s", dep)
+		}
+	}
+}
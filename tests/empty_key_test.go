@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// TestEmptyKeyRejected checks every embedded write and read refuses the
+// empty key with ErrEmptyKey, leaving the tree and scans untouched, and the
+// API answers 400 for it
+func TestEmptyKeyRejected(t *testing.T) {
+	database := openTestDB(t, "empty.db")
+
+	// Enough keys for internal pages, so separators are exercised too
+	const n = 500
+	for i := 0; i < n; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("k%04d", i)), make([]byte, 64)); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+
+	empty := []byte{}
+	checks := map[string]error{
+		"Put":     database.Put(empty, []byte("v")),
+		"Put nil": database.Put(nil, []byte("v")),
+		"Delete":  database.Delete(empty),
+		"Batch":   database.Batch([]btree.Op{{Key: []byte("ok"), Value: []byte("v")}, {Key: empty, Value: []byte("v")}}, btree.BatchOptions{}),
+	}
+	_, checks["Get"] = database.Get(empty)
+	_, _, checks["PutReturningOld"] = database.PutReturningOld(empty, []byte("v"))
+	_, _, checks["DeleteReturningOld"] = database.DeleteReturningOld(empty)
+	_, checks["Txn op"] = database.Txn(nil, []btree.Op{{Key: empty, Value: []byte("v")}})
+	_, checks["Txn cond"] = database.Txn([]btree.Cond{{Key: empty, Absent: true}}, []btree.Op{{Key: []byte("ok"), Value: []byte("v")}})
+	for name, err := range checks {
+		if !errors.Is(err, btree.ErrEmptyKey) {
+			t.Errorf("%s: expected ErrEmptyKey, got %v", name, err)
+		}
+	}
+
+	// A rejected batch or txn must not have applied its valid ops
+	if _, err := database.Get([]byte("ok")); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Fatalf("Expected rejected ops not to apply, got %v", err)
+	}
+
+	// An empty start or prefix still means "from the beginning"
+	items, err := database.Scan(nil, empty, 0)
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if len(items) != n || string(items[0].Key) != "k0000" {
+		t.Fatalf("Expected %d keys from k0000, got %d", n, len(items))
+	}
+	if err := database.Verify(); err != nil {
+		t.Fatalf("Tree failed verification: %v", err)
+	}
+
+	ts, _ := startTestServer(t, nil)
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key=", strings.NewReader("v"))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an empty key, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestEncryptedFileNeedsItsKey writes an encrypted database, checks no value
+// appears in the file, and reopens it with no key, the wrong key and the
+// right one
+func TestEncryptedFileNeedsItsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encrypted.db")
+	key := bytes.Repeat([]byte{0x42}, btree.EncryptionKeySize)
+
+	database, err := db.OpenWithOptions(path, db.Options{NoSync: true, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("Failed to create encrypted database: %v", err)
+	}
+	// Values of every size up to the limit make pages split against the
+	// smaller room an encrypted page leaves
+	const n = 2000
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf("secret-%05d-%s", i, bytes.Repeat([]byte{'x'}, i*7%(btree.MaxValueSize-16))))
+	}
+	for i := 0; i < n; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%05d", i)), value(i)); err != nil {
+			t.Fatalf("Put %d failed: %v", i, err)
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret-")) || bytes.Contains(data, []byte("key-00")) {
+		t.Fatal("Found plaintext keys or values in the encrypted file")
+	}
+
+	if _, err := db.OpenWithOptions(path, db.Options{}); !errors.Is(err, btree.ErrEncryptionKeyRequired) {
+		t.Fatalf("Expected ErrEncryptionKeyRequired without a key, got %v", err)
+	}
+	wrong := bytes.Repeat([]byte{0x24}, btree.EncryptionKeySize)
+	if _, err := db.OpenWithOptions(path, db.Options{EncryptionKey: wrong}); !errors.Is(err, btree.ErrWrongEncryptionKey) {
+		t.Fatalf("Expected ErrWrongEncryptionKey with the wrong key, got %v", err)
+	}
+
+	database, err = db.OpenWithOptions(path, db.Options{ReadOnly: true, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("Failed to reopen with the right key: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+	for i := 0; i < n; i += 37 {
+		got, err := database.Get([]byte(fmt.Sprintf("key-%05d", i)))
+		if err != nil || !bytes.Equal(got, value(i)) {
+			t.Fatalf("Get key-%05d after reopening: %q, %v", i, got, err)
+		}
+	}
+	if err := database.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+}
+
+// TestEncryptionKeyRejectsPlainFile checks a key does not silently apply to
+// an existing unencrypted file
+func TestEncryptionKeyRejectsPlainFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	key := bytes.Repeat([]byte{0x42}, btree.EncryptionKeySize)
+	if _, err := db.OpenWithOptions(path, db.Options{EncryptionKey: key}); !errors.Is(err, btree.ErrNotEncrypted) {
+		t.Fatalf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+// TestEncryptedHeaderIsAuthenticated checks an encrypted file's header
+// fails to open once its sealed fields or its clear flags are altered, so
+// the root and free list can be neither read nor swapped and a sealed file
+// cannot be unsealed by clearing its flag
+func TestEncryptedHeaderIsAuthenticated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "header.db")
+	key := bytes.Repeat([]byte{0x42}, btree.EncryptionKeySize)
+	database, err := db.OpenWithOptions(path, db.Options{NoSync: true, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("Failed to create encrypted database: %v", err)
+	}
+	if err := database.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := database.Seal(); err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	for name, offset := range map[string]int{
+		"root ID":     8,
+		"sealed flag": btree.HeaderSize - 4,
+	} {
+		altered := bytes.Clone(data)
+		altered[offset] ^= 1
+		if err := os.WriteFile(path, altered, 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if database, err := db.OpenWithOptions(path, db.Options{EncryptionKey: key}); !errors.Is(err, btree.ErrPageChecksum) {
+			if err == nil {
+				_ = database.Close()
+			}
+			t.Fatalf("Altered %s: expected ErrPageChecksum, got %v", name, err)
+		}
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	database, err = db.OpenWithOptions(path, db.Options{ReadOnly: true, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("Failed to reopen the intact file: %v", err)
+	}
+	defer func() { _ = database.Close() }()
+	if v, err := database.Get([]byte("k")); err != nil || string(v) != "v" || !database.Sealed() {
+		t.Fatalf("Unexpected intact file: %q, %v, sealed %v", v, err, database.Sealed())
+	}
+}
@@ -0,0 +1,258 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// startGroupNode bootstraps a single-node raft group in dir with its own
+// database and waits until it leads
+func startGroupNode(t *testing.T, dir, group string) (*raftnode.Node, *db.DB) {
+	t.Helper()
+	database, err := db.Open(filepath.Join(dir, group+".db"))
+	if err != nil {
+		t.Fatalf("Failed to open database for group %s: %v", group, err)
+	}
+	node, err := raftnode.StartNode(raftnode.Config{
+		NodeID:    "node1",
+		RaftAddr:  freeRaftAddr(t),
+		DataDir:   dir,
+		Bootstrap: true,
+		GroupID:   group,
+	}, &raftnode.FSM{DB: database})
+	if err != nil {
+		t.Fatalf("Failed to start group %s: %v", group, err)
+	}
+	t.Cleanup(func() {
+		if err := node.Shutdown(); err != nil {
+			t.Logf("Warning: failed to shut down group %s: %v", group, err)
+		}
+		if err := database.Close(); err != nil {
+			t.Logf("Warning: failed to close database for group %s: %v", group, err)
+		}
+	})
+	waitFor(t, 10*time.Second, "group "+group+" to elect a leader", node.IsLeader)
+	return node, database
+}
+
+// groupPut writes key in bucket through ts and returns an error unless it
+// gets 200
+func groupPut(ts *httptest.Server, bucket, key string) error {
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?bucket="+bucket+"&key="+key, strings.NewReader("v"))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PUT %s/%s: unexpected status %d", bucket, key, resp.StatusCode)
+	}
+	return nil
+}
+
+// TestBucketsOnRaftGroups serves two buckets from two raft groups of one
+// process and checks writes to each commit in its own group, in parallel,
+// and go on when the other group stops
+func TestBucketsOnRaftGroups(t *testing.T) {
+	dir := t.TempDir()
+	node, database := startTestNode(t)
+	nodeA, dbA := startGroupNode(t, dir, "a")
+	nodeB, dbB := startGroupNode(t, dir, "b")
+
+	mux := http.NewServeMux()
+	api.New(node, database).WithGroup(nodeA, dbA, "alpha").WithGroup(nodeB, dbB, "beta").Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	for _, name := range []string{"alpha", "beta"} {
+		resp, err := http.Post(ts.URL+"/buckets?name="+name, "", nil)
+		if err != nil {
+			t.Fatalf("POST /buckets failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Creating bucket %s: expected 200, got %d", name, resp.StatusCode)
+		}
+	}
+	if _, err := dbA.Bucket("alpha"); err != nil {
+		t.Fatalf("Expected alpha in group a's database: %v", err)
+	}
+	if _, err := dbB.Bucket("beta"); err != nil {
+		t.Fatalf("Expected beta in group b's database: %v", err)
+	}
+	if names, _ := database.Buckets(); len(names) != 0 {
+		t.Fatalf("Expected no buckets in the default group, got %v", names)
+	}
+
+	const writes = 50
+	startA, startB := nodeA.Raft().LastIndex(), nodeB.Raft().LastIndex()
+	startDefault := node.Raft().LastIndex()
+	var wg sync.WaitGroup
+	for _, bucket := range []string{"alpha", "beta"} {
+		wg.Add(1)
+		go func(bucket string) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if err := groupPut(ts, bucket, fmt.Sprintf("k%03d", i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(bucket)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	if got := nodeA.Raft().LastIndex() - startA; got != writes {
+		t.Fatalf("Group a committed %d entries, want %d", got, writes)
+	}
+	if got := nodeB.Raft().LastIndex() - startB; got != writes {
+		t.Fatalf("Group b committed %d entries, want %d", got, writes)
+	}
+	if got := node.Raft().LastIndex(); got != startDefault {
+		t.Fatalf("Default group log moved from %d to %d", startDefault, got)
+	}
+	for _, c := range []struct {
+		database *db.DB
+		bucket   string
+	}{{dbA, "alpha"}, {dbB, "beta"}} {
+		if n, err := c.database.BucketKeyCount(c.bucket); err != nil || n != writes {
+			t.Fatalf("Bucket %s holds %d keys, want %d (%v)", c.bucket, n, writes, err)
+		}
+	}
+
+	_, err := nodeA.Apply(raftnode.Command{Type: raftnode.CmdPut, Key: []byte("k"), Value: []byte("v"), Group: "b"}, time.Second)
+	if !errors.Is(err, raftnode.ErrWrongGroup) {
+		t.Fatalf("Expected ErrWrongGroup proposing to the wrong group, got %v", err)
+	}
+
+	// with group a stopped, beta keeps taking writes
+	if err := nodeA.Shutdown(); err != nil {
+		t.Fatalf("Failed to stop group a: %v", err)
+	}
+	if err := groupPut(ts, "beta", "after"); err != nil {
+		t.Fatalf("PUT to beta with group a stopped: %v", err)
+	}
+	if err := groupPut(ts, "alpha", "after"); err == nil {
+		t.Fatal("Expected PUT to alpha to fail with group a stopped")
+	}
+}
+
+// TestGroupInheritsOptionsSetAfterWithGroup sets an ACL after WithGroup
+// and checks a request routed to the group is held to it
+func TestGroupInheritsOptionsSetAfterWithGroup(t *testing.T) {
+	node, database := startTestNode(t)
+	nodeA, dbA := startGroupNode(t, t.TempDir(), "a")
+
+	mux := http.NewServeMux()
+	api.New(node, database).WithGroup(nodeA, dbA, "alpha").
+		WithACL([]api.ACLRule{{Token: "writer", Write: []string{""}}}).
+		Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Post(ts.URL+"/buckets?name=alpha", "", nil)
+	if err != nil {
+		t.Fatalf("POST /buckets failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Creating bucket alpha: expected 200, got %d", resp.StatusCode)
+	}
+
+	put := func(token string) int {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?bucket=alpha&key=k", strings.NewReader("v"))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := put(""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without a token in the group, got %d", code)
+	}
+	if code := put("writer"); code != http.StatusOK {
+		t.Fatalf("Expected 200 with the token in the group, got %d", code)
+	}
+}
+
+// TestGroupNotLeaderNamesLeaderHTTP sends a bucket write to a node that
+// follows in the bucket's group and checks the redirect names the group
+// leader's HTTP API, which the node knows by the leader's server ID in the
+// default group
+func TestGroupNotLeaderNamesLeaderHTTP(t *testing.T) {
+	c := startTestCluster(t, 2)
+	leaderNode, _ := startGroupNode(t, t.TempDir(), "g")
+
+	dir := t.TempDir()
+	groupDB, err := db.Open(filepath.Join(dir, "g.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	addr := freeRaftAddr(t)
+	follower, err := raftnode.StartNode(raftnode.Config{NodeID: "node2", RaftAddr: addr, DataDir: dir, GroupID: "g"}, &raftnode.FSM{DB: groupDB})
+	if err != nil {
+		t.Fatalf("Failed to start group follower: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := follower.Shutdown(); err != nil {
+			t.Logf("Warning: failed to shut down group follower: %v", err)
+		}
+		if err := groupDB.Close(); err != nil {
+			t.Logf("Warning: failed to close database: %v", err)
+		}
+	})
+	if err := leaderNode.AddVoter("node2", addr); err != nil {
+		t.Fatalf("Failed to add group follower: %v", err)
+	}
+	waitFor(t, 10*time.Second, "the group follower to learn its leader", func() bool { return follower.Leader() != "" })
+
+	srv := api.New(c.nodes[1], c.dbs[1]).WithAdvertiseHTTP("http://node2:8081").WithGroup(follower, groupDB, "orders")
+	srv.AddPeerHTTP(map[string]string{c.addrs[0]: "http://node1:8081"})
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?bucket=orders&key=k", strings.NewReader("v"))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var hint map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&hint); err != nil || resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected 409 with a leader hint, got %d (%v)", resp.StatusCode, err)
+	}
+	if hint["leader"] != string(leaderNode.Addr()) || hint["leader_http"] != "http://node1:8081" {
+		t.Fatalf("Expected the group leader and node1's HTTP API, got %v", hint)
+	}
+}
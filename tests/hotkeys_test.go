@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestHotKeysSurfaceAtTop hammers a few keys amid a long tail of cold ones and
+// checks the hot keys lead the report
+func TestHotKeysSurfaceAtTop(t *testing.T) {
+	database, err := db.OpenWithOptions(filepath.Join(t.TempDir(), "hot.db"), db.Options{TrackHotKeys: true, NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close test database: %v", closeErr)
+		}
+	}()
+
+	hot := []string{"hot-a", "hot-b", "hot-c"}
+	for _, k := range hot {
+		if err := database.Put([]byte(k), []byte("v")); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+
+	// Far more cold keys than the tracker keeps candidates for
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		if i%5 == 0 {
+			if err := database.Put([]byte(fmt.Sprintf("cold-%d", rng.Intn(2000))), []byte("v")); err != nil {
+				t.Fatalf("Failed to put cold key: %v", err)
+			}
+			continue
+		}
+		if _, err := database.Get([]byte(hot[i%len(hot)])); err != nil {
+			t.Fatalf("Failed to get hot key: %v", err)
+		}
+	}
+
+	top, ok := database.HotKeys(3)
+	if !ok {
+		t.Fatal("Expected hot key tracking to be enabled")
+	}
+	if len(top) != 3 {
+		t.Fatalf("Expected 3 hot keys, got %+v", top)
+	}
+	for i, k := range top {
+		if k.Key[:4] != "hot-" || k.Count < 1000 {
+			t.Fatalf("Unexpected entry %d in hot key report: %+v", i, top)
+		}
+	}
+}
+
+// TestHotKeysEndpointDisabledByDefault checks tracking is opt-in
+func TestHotKeysEndpointDisabledByDefault(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+	resp, err := http.Get(ts.URL + "/debug/hotkeys?top=5")
+	if err != nil {
+		t.Fatalf("Hot keys request failed: %v", err)
+	}
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		t.Logf("Warning: failed to close response body: %v", closeErr)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 with tracking disabled, got %d", resp.StatusCode)
+	}
+}
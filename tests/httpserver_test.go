@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// TestStalledClientIsDisconnected sends half a request and stops; the server
+// must drop the connection once the read timeout passes
+func TestStalledClientIsDisconnected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := api.NewHTTPServer("", http.NotFoundHandler(), api.HTTPOptions{ReadTimeout: 200 * time.Millisecond})
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Logf("Warning: failed to close HTTP server: %v", err)
+		}
+	})
+
+	for _, partial := range []string{
+		"PUT /kv?key=a HTTP/1.1\r\nHost: test\r\n",                                     // stalls in the headers
+		"PUT /kv?key=a HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\nonly-part", // stalls in the body
+	} {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		if _, err := conn.Write([]byte(partial)); err != nil {
+			t.Fatalf("Failed to write partial request: %v", err)
+		}
+
+		start := time.Now()
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatalf("Failed to set deadline: %v", err)
+		}
+		_, err = io.ReadAll(conn)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Server kept a stalled connection open for %v", time.Since(start))
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("Server took %v to drop a stalled connection", elapsed)
+		}
+		if err := conn.Close(); err != nil {
+			t.Logf("Warning: failed to close connection: %v", err)
+		}
+	}
+}
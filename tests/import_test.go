@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// postImport posts body to ts's /admin/import with query and the ops token,
+// returning the status and response body
+func postImport(t *testing.T, ts *httptest.Server, query, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/admin/import?"+query, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer ops")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /admin/import failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	out, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(out)
+}
+
+// TestImportReplicates imports a CSV through the leader's /admin/import and
+// checks a follower ends up with the same keyspace
+func TestImportReplicates(t *testing.T) {
+	c := startTestCluster(t, 3)
+	c.put(t, "stale", "x")
+	leader := c.leader(t)
+	follower := (leader + 1) % len(c.nodes)
+
+	servers := make([]*httptest.Server, len(c.nodes))
+	for i := range c.nodes {
+		mux := http.NewServeMux()
+		api.New(c.nodes[i], c.dbs[i]).WithAdminToken("ops").Register(mux)
+		servers[i] = httptest.NewServer(mux)
+		t.Cleanup(servers[i].Close)
+	}
+
+	var csv strings.Builder
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&csv, "key-%05d,value-%d\n", i, i)
+	}
+
+	if status, _ := postImport(t, servers[leader], "", csv.String()); status != http.StatusBadRequest {
+		t.Fatalf("Import without a mode: expected 400, got %d", status)
+	}
+	if status, _ := postImport(t, servers[follower], "mode=replace", csv.String()); status != http.StatusConflict {
+		t.Fatalf("Import on a follower: expected 409, got %d", status)
+	}
+	status, body := postImport(t, servers[leader], "mode=replace", csv.String())
+	if status != http.StatusOK {
+		t.Fatalf("Import failed: %d %s", status, body)
+	}
+	if !strings.Contains(body, `"imported":2500`) || !strings.Contains(body, `"batches":3`) {
+		t.Fatalf("Unexpected import response: %s", body)
+	}
+
+	want, err := c.dbs[leader].Scan(nil, nil, 0)
+	if err != nil {
+		t.Fatalf("Scan on the leader failed: %v", err)
+	}
+	if len(want) != 2500 {
+		t.Fatalf("Leader holds %d keys, want 2500", len(want))
+	}
+	waitFor(t, 10*time.Second, "the follower to apply the import", func() bool {
+		got, err := c.dbs[follower].Scan(nil, nil, 0)
+		if err != nil || len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if string(got[i].Key) != string(want[i].Key) || string(got[i].Value) != string(want[i].Value) {
+				return false
+			}
+		}
+		return true
+	})
+	if _, err := c.dbs[follower].Get([]byte("stale")); err == nil {
+		t.Fatal("Expected replace to drop keys not in the import")
+	}
+
+	// merge keeps what is already there
+	status, body = postImport(t, servers[leader], "mode=merge", "extra,1\nkey-00000,changed\n")
+	if status != http.StatusOK {
+		t.Fatalf("Merge import failed: %d %s", status, body)
+	}
+	waitFor(t, 10*time.Second, "the follower to apply the merge", func() bool {
+		v, err := c.dbs[follower].Get([]byte("key-00000"))
+		return err == nil && string(v) == "changed"
+	})
+	if items, err := c.dbs[follower].Scan(nil, nil, 0); err != nil || len(items) != 2501 {
+		t.Fatalf("Follower holds %d keys after the merge, want 2501 (%v)", len(items), err)
+	}
+}
+
+// TestImportReportsPartialReplace breaks a replace import after its first
+// batch and checks the response says the dataset now holds only that batch,
+// while one failing before any batch commits leaves the data alone
+func TestImportReportsPartialReplace(t *testing.T) {
+	ts, database := startTestServer(t, func(s *api.Server) { s.WithAdminToken("ops") })
+	if err := database.Put([]byte("stale"), []byte("x")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	if status, body := postImport(t, ts, "mode=replace", "bad,row,here\n"); status != http.StatusBadRequest || strings.Contains(body, "partial") {
+		t.Fatalf("Expected a plain 400 for a first row that fails, got %d %s", status, body)
+	}
+	if _, err := database.Get([]byte("stale")); err != nil {
+		t.Fatalf("Import that committed nothing dropped a key: %v", err)
+	}
+
+	var csv strings.Builder
+	for i := 0; i < 1500; i++ {
+		fmt.Fprintf(&csv, "key-%05d,value-%d\n", i, i)
+	}
+	csv.WriteString("bad,row,here\n")
+	status, body := postImport(t, ts, "mode=replace", csv.String())
+	if status != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d %s", status, body)
+	}
+	if !strings.Contains(body, `"partial":true`) || !strings.Contains(body, `"imported":1000`) || !strings.Contains(body, `"error":`) {
+		t.Fatalf("Expected a partial response after one batch, got %s", body)
+	}
+	items, err := database.Scan(nil, nil, 0)
+	if err != nil || len(items) != 1000 {
+		t.Fatalf("Expected the first batch alone to remain, got %d keys (%v)", len(items), err)
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/hashicorp/raft"
+)
+
+// TestDuplicateJoinKeepsConfigurationStable re-posts /join for a member and
+// checks membership is neither duplicated nor reconfigured, then moves it
+func TestDuplicateJoinKeepsConfigurationStable(t *testing.T) {
+	c := startTestCluster(t, 3)
+	leader := c.nodes[c.leader(t)]
+
+	mux := http.NewServeMux()
+	api.New(leader, c.dbs[c.leader(t)]).Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	join := func(id, addr string) {
+		body, _ := json.Marshal(map[string]string{"ID": id, "RaftAddr": addr})
+		resp, err := http.Post(ts.URL+"/join", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Errorf("Join request failed: %v", err)
+			return
+		}
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close response body: %v", closeErr)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Unexpected join status: %d", resp.StatusCode)
+		}
+	}
+	configuration := func() (raft.Configuration, uint64) {
+		f := leader.Raft().GetConfiguration()
+		if err := f.Error(); err != nil {
+			t.Fatalf("Failed to get configuration: %v", err)
+		}
+		return f.Configuration(), f.Index()
+	}
+	entriesFor := func(cfg raft.Configuration, id string) []raft.Server {
+		var out []raft.Server
+		for _, sv := range cfg.Servers {
+			if string(sv.ID) == id {
+				out = append(out, sv)
+			}
+		}
+		return out
+	}
+
+	_, before := configuration()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			join("node3", c.addrs[2])
+		}()
+	}
+	wg.Wait()
+
+	cfg, after := configuration()
+	if after != before {
+		t.Fatalf("Duplicate joins reconfigured the cluster: index %d -> %d", before, after)
+	}
+	if len(cfg.Servers) != 3 || len(entriesFor(cfg, "node3")) != 1 {
+		t.Fatalf("Expected three servers with one node3 entry, got %+v", cfg.Servers)
+	}
+
+	// A restarted node announcing a new address is moved, not duplicated
+	moved := freeRaftAddr(t)
+	join("node3", moved)
+	join("node3", moved)
+	cfg, _ = configuration()
+	entries := entriesFor(cfg, "node3")
+	if len(cfg.Servers) != 3 || len(entries) != 1 || string(entries[0].Address) != moved {
+		t.Fatalf("Expected node3 moved to %s, got %+v", moved, cfg.Servers)
+	}
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/raftnode"
+	"github.com/hashicorp/raft"
+)
+
+type leaseReply struct {
+	Acquired bool   `json:"acquired"`
+	Holder   string `json:"holder"`
+	Token    uint64 `json:"token"`
+}
+
+// postLease posts body to /lease/<action> and decodes a JSON reply, if any
+func postLease(t *testing.T, ts *httptest.Server, action, body string) (int, leaseReply) {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/lease/"+action, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /lease/%s failed: %v", action, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var reply leaseReply
+	if resp.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			t.Fatalf("Failed to decode lease reply: %v", err)
+		}
+	} else {
+		_, _ = io.Copy(io.Discard, resp.Body)
+	}
+	return resp.StatusCode, reply
+}
+
+// TestLeaseContention has two clients contend for a lease: one holds it and
+// renews it, it lapses, and the other then acquires it with a larger token
+func TestLeaseContention(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+
+	status, a := postLease(t, ts, "acquire", `{"name":"jobs","holder":"a","ttl":"400ms"}`)
+	if status != http.StatusOK || !a.Acquired || a.Holder != "a" || a.Token == 0 {
+		t.Fatalf("Expected a to acquire the lease, got %d %+v", status, a)
+	}
+	status, b := postLease(t, ts, "acquire", `{"name":"jobs","holder":"b","ttl":"400ms"}`)
+	if status != http.StatusOK || b.Acquired || b.Holder != "a" {
+		t.Fatalf("Expected b to be refused while a holds the lease, got %d %+v", status, b)
+	}
+	if status, _ := postLease(t, ts, "renew", `{"name":"jobs","holder":"b","token":1,"ttl":"400ms"}`); status != http.StatusPreconditionFailed {
+		t.Fatalf("Expected b's renew to fail with 412, got %d", status)
+	}
+	renew := `{"name":"jobs","holder":"a","token":` + jsonUint(a.Token) + `,"ttl":"400ms"}`
+	if status, r := postLease(t, ts, "renew", renew); status != http.StatusOK || r.Token != a.Token {
+		t.Fatalf("Expected a to renew the lease, got %d %+v", status, r)
+	}
+
+	resp, err := http.Get(ts.URL + "/lease?name=jobs")
+	if err != nil {
+		t.Fatalf("GET /lease failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the held lease to be found, got %d", resp.StatusCode)
+	}
+
+	// a stops renewing; once the lease lapses b takes it over
+	time.Sleep(500 * time.Millisecond)
+	status, b = postLease(t, ts, "acquire", `{"name":"jobs","holder":"b","ttl":"10s"}`)
+	if status != http.StatusOK || !b.Acquired || b.Holder != "b" {
+		t.Fatalf("Expected b to acquire the lapsed lease, got %d %+v", status, b)
+	}
+	if b.Token <= a.Token {
+		t.Fatalf("Expected b's token %d to exceed a's %d", b.Token, a.Token)
+	}
+	if status, _ := postLease(t, ts, "renew", renew); status != http.StatusPreconditionFailed {
+		t.Fatalf("Expected a's renew after losing the lease to fail with 412, got %d", status)
+	}
+	release := `{"name":"jobs","holder":"a","token":` + jsonUint(a.Token) + `}`
+	if status, _ := postLease(t, ts, "release", release); status != http.StatusPreconditionFailed {
+		t.Fatalf("Expected a's release of b's lease to fail with 412, got %d", status)
+	}
+
+	release = `{"name":"jobs","holder":"b","token":` + jsonUint(b.Token) + `}`
+	if status, _ := postLease(t, ts, "release", release); status != http.StatusOK {
+		t.Fatalf("Expected b to release the lease, got %d", status)
+	}
+	if status, a = postLease(t, ts, "acquire", `{"name":"jobs","holder":"a","ttl":"10s"}`); status != http.StatusOK || !a.Acquired {
+		t.Fatalf("Expected a to acquire the released lease, got %d %+v", status, a)
+	}
+}
+
+func jsonUint(v uint64) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// TestLeaseExpiryAgreesAfterRestore lets a lease lapse on one node, restores
+// its snapshot on another, and applies an acquire stamped by a leader whose
+// clock is behind to both: each must judge expiry by the same clamped time
+// and hand the lease over
+func TestLeaseExpiryAgreesAfterRestore(t *testing.T) {
+	apply := func(fsm *raftnode.FSM, index uint64, cmd raftnode.Command) interface{} {
+		t.Helper()
+		data, err := raftnode.EncodeCommand(cmd)
+		if err != nil {
+			t.Fatalf("Failed to encode command: %v", err)
+		}
+		return fsm.Apply(&raft.Log{Index: index, Data: data})
+	}
+	acquire := func(holder string, at time.Time) raftnode.Command {
+		return raftnode.Command{Type: raftnode.CmdLeaseAcquire, Key: []byte("jobs"), Holder: holder, TTL: 5 * time.Second, Timestamp: at.UnixNano()}
+	}
+
+	source := &raftnode.FSM{DB: openTestDB(t, "source.db")}
+	now := time.Now()
+	if res, ok := apply(source, 1, acquire("worker-1", now)).(raftnode.LeaseResult); !ok || !res.Acquired {
+		t.Fatalf("Expected worker-1 to acquire the lease, got %+v", res)
+	}
+	// A renew after the lease lapsed writes nothing but moves the clock
+	renew := raftnode.Command{Type: raftnode.CmdLeaseRenew, Key: []byte("jobs"), Holder: "worker-1", Token: 1, TTL: 5 * time.Second, Timestamp: now.Add(10 * time.Second).UnixNano()}
+	if err, _ := apply(source, 2, renew).(error); err == nil {
+		t.Fatalf("Expected the late renew to fail")
+	}
+
+	target := &raftnode.FSM{DB: openTestDB(t, "target.db")}
+	if err := target.Restore(io.NopCloser(bytes.NewReader(persistSnapshot(t, source)))); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	for _, fsm := range []*raftnode.FSM{source, target} {
+		res, ok := apply(fsm, 3, acquire("worker-2", now.Add(time.Second))).(raftnode.LeaseResult)
+		if !ok || !res.Acquired || res.Lease.Holder != "worker-2" {
+			t.Fatalf("Expected worker-2 to take over the lapsed lease, got %+v", res)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/hashicorp/raft"
+)
+
+// TestLeaderLeavesCluster has the leader call /leave on itself and checks
+// leadership moves and the remaining nodes drop it from their configuration
+func TestLeaderLeavesCluster(t *testing.T) {
+	c := startTestCluster(t, 3)
+	c.put(t, "before", "leave")
+
+	urls := make(map[raft.ServerAddress]string)
+	servers := make([]*httptest.Server, len(c.nodes))
+	for i := range c.nodes {
+		mux := http.NewServeMux()
+		api.New(c.nodes[i], c.dbs[i]).
+			WithLeaderHTTP(func(leader raft.ServerAddress) string { return urls[leader] }).
+			Register(mux)
+		servers[i] = httptest.NewServer(mux)
+		t.Cleanup(servers[i].Close)
+		urls[raft.ServerAddress(c.addrs[i])] = servers[i].URL
+	}
+
+	leaving := c.leader(t)
+	resp, err := http.Post(servers[leaving].URL+"/leave", "", nil)
+	if err != nil {
+		t.Fatalf("POST /leave failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from /leave, got %d", resp.StatusCode)
+	}
+
+	for i, node := range c.nodes {
+		if i == leaving {
+			continue
+		}
+		waitFor(t, 5*time.Second, c.ids[i]+" to drop the leaving node", func() bool {
+			f := node.Raft().GetConfiguration()
+			if f.Error() != nil {
+				return false
+			}
+			servers := f.Configuration().Servers
+			for _, sv := range servers {
+				if string(sv.ID) == c.ids[leaving] {
+					return false
+				}
+			}
+			return len(servers) == 2
+		})
+	}
+	if c.nodes[leaving].IsLeader() {
+		t.Fatalf("Leaving node is still the leader")
+	}
+	c.put(t, "after", "leave")
+}
+
+// TestLastNodeCannotLeave checks a single-node cluster refuses /leave
+func TestLastNodeCannotLeave(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+
+	resp, err := http.Post(ts.URL+"/leave", "", nil)
+	if err != nil {
+		t.Fatalf("POST /leave failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for the last node, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// writeV1File writes n keys to a new database at path and rewrites it as
+// format version 1 wrote it: the same pages without checksums
+func writeV1File(t *testing.T, path string, n int) {
+	t.Helper()
+	database, err := db.OpenWithOptions(path, db.Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	binary.LittleEndian.PutUint32(data[4:], 1)
+	for off := btree.HeaderSize; off+btree.NodeSize <= len(data); off += btree.NodeSize {
+		copy(data[off+btree.MaxNodeBytes:off+btree.NodeSize], make([]byte, btree.PageChecksumSize))
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write version 1 file: %v", err)
+	}
+}
+
+// fileFormatVersion reads the version word from a file's header
+func fileFormatVersion(t *testing.T, path string) uint32 {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	return binary.LittleEndian.Uint32(data[4:])
+}
+
+// TestMigrateVersion1File opens a version 1 file, which only succeeds
+// read-only until migration is allowed, then checks the migrated file holds
+// every key and is at the current version
+func TestMigrateVersion1File(t *testing.T) {
+	const n = 3000
+	path := filepath.Join(t.TempDir(), "old.db")
+	writeV1File(t, path, n)
+
+	if _, err := db.Open(path); !errors.Is(err, btree.ErrNeedsMigration) {
+		t.Fatalf("Expected ErrNeedsMigration opening a version 1 file, got %v", err)
+	}
+
+	reader, err := db.OpenWithOptions(path, db.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open version 1 file read-only: %v", err)
+	}
+	if v, err := reader.Get([]byte("key-00042")); err != nil || string(v) != "value-42" {
+		t.Fatalf("Read-only get from version 1 file: %q, %v", v, err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Failed to close reader: %v", err)
+	}
+	if got := fileFormatVersion(t, path); got != 1 {
+		t.Fatalf("Read-only open changed the version to %d", got)
+	}
+
+	database, err := db.OpenWithOptions(path, db.Options{AllowMigration: true})
+	if err != nil {
+		t.Fatalf("Failed to open with migration: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+	if got := fileFormatVersion(t, path); got != btree.Version {
+		t.Fatalf("Expected version %d after migration, got %d", btree.Version, got)
+	}
+	if _, err := os.Stat(path + ".migrate"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the migration temp file to be gone, got %v", err)
+	}
+	if err := database.Verify(); err != nil {
+		t.Fatalf("Migrated tree fails verification: %v", err)
+	}
+	items, err := database.Scan([]byte("key-"), nil, 0)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(items) != n {
+		t.Fatalf("Expected %d keys after migration, got %d", n, len(items))
+	}
+	for i, it := range items {
+		if want := fmt.Sprintf("key-%05d", i); string(it.Key) != want {
+			t.Fatalf("Expected %s at position %d, got %s", want, i, it.Key)
+		}
+		if want := fmt.Sprintf("value-%d", i); string(it.Value) != want {
+			t.Fatalf("Expected %s for %s, got %s", want, it.Key, it.Value)
+		}
+	}
+	if err := database.Put([]byte("after"), []byte("migration")); err != nil {
+		t.Fatalf("Failed to write to migrated file: %v", err)
+	}
+}
+
+// TestRestoreMigratesVersion1Snapshot restores a snapshot taken by a node
+// still on version 1 and checks it is upgraded on the way in
+func TestRestoreMigratesVersion1Snapshot(t *testing.T) {
+	old := filepath.Join(t.TempDir(), "old.db")
+	writeV1File(t, old, 500)
+	snap, err := os.ReadFile(old)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+
+	target := openTestDB(t, "target.db")
+	if err := target.RestoreFromWithOptions(bytes.NewReader(snap), db.RestoreOptions{SkipVerify: true}); err != nil {
+		t.Fatalf("Failed to restore version 1 snapshot: %v", err)
+	}
+	if v, err := target.Get([]byte("key-00499")); err != nil || string(v) != "value-499" {
+		t.Fatalf("Get after restore: %q, %v", v, err)
+	}
+	if err := target.Put([]byte("after"), []byte("restore")); err != nil {
+		t.Fatalf("Failed to write after restore: %v", err)
+	}
+}
+
+// TestCorruptPageFailsChecksum flips a byte inside a page and checks
+// reading it reports the checksum mismatch instead of returning bad data
+func TestCorruptPageFailsChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := database.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	// Every page holding "value" is a copy of the one live leaf
+	for i := bytes.Index(data, []byte("value")); i >= 0; {
+		data[i] ^= 0xFF
+		next := bytes.Index(data[i+1:], []byte("value"))
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write corrupted file: %v", err)
+	}
+
+	database, err = db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+	if _, err := database.Get([]byte("key")); !errors.Is(err, btree.ErrPageChecksum) {
+		t.Fatalf("Expected ErrPageChecksum reading a corrupted page, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// serveNode wraps cluster node i in an HTTP test server
+func (c *testCluster) serveNode(t *testing.T, i int, barrier time.Duration) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	api.New(c.nodes[i], c.dbs[i]).WithBarrierTimeout(barrier).Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// httpPut writes key through ts and returns the raft index it committed at
+func httpPut(t *testing.T, ts *httptest.Server, key, value string) uint64 {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key="+url.QueryEscape(key), strings.NewReader(value))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT %s failed: %v", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT %s: expected 200, got %d", key, resp.StatusCode)
+	}
+	index, err := strconv.ParseUint(resp.Header.Get("X-Raft-Index"), 10, 64)
+	if err != nil || index == 0 {
+		t.Fatalf("PUT %s: bad X-Raft-Index %q", key, resp.Header.Get("X-Raft-Index"))
+	}
+	return index
+}
+
+// staleGet reads key from ts as a stale read bounded by minIndex
+func staleGet(t *testing.T, ts *httptest.Server, key string, minIndex uint64) (int, string) {
+	t.Helper()
+	q := url.Values{"key": {key}, "stale": {"true"}, "min_index": {strconv.FormatUint(minIndex, 10)}}
+	resp, err := http.Get(ts.URL + "/kv?" + q.Encode())
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(body))
+}
+
+// TestFollowerReadWaitsForMinIndex writes through the leader and reads from a
+// follower with min_index: the read waits for the follower to apply the
+// write and then returns it, and gives up with 503 past the barrier timeout
+func TestFollowerReadWaitsForMinIndex(t *testing.T) {
+	c := startTestCluster(t, 3)
+	li := c.leader(t)
+	fi := (li + 1) % len(c.nodes)
+	leader := c.serveNode(t, li, 3*time.Second)
+	follower := c.serveNode(t, fi, 3*time.Second)
+
+	index := httpPut(t, leader, "ryw", "first")
+	if code, body := staleGet(t, follower, "ryw", index); code != http.StatusOK || body != "first" {
+		t.Fatalf("Expected follower to return the write at index %d, got %d %q", index, code, body)
+	}
+
+	// Ask for the next index before it exists; the read must block until the
+	// leader commits it and the follower applies it
+	const delay = 300 * time.Millisecond
+	go func() {
+		time.Sleep(delay)
+		cmd := raftnode.Command{Type: raftnode.CmdPut, Key: []byte("ryw"), Value: []byte("second")}
+		if _, err := c.nodes[li].Apply(cmd, 5*time.Second); err != nil {
+			t.Errorf("Failed to apply second write: %v", err)
+		}
+	}()
+	start := time.Now()
+	code, body := staleGet(t, follower, "ryw", index+1)
+	if code != http.StatusOK || body != "second" {
+		t.Fatalf("Expected the waiting read to return the new value, got %d %q", code, body)
+	}
+	if waited := time.Since(start); waited < delay {
+		t.Fatalf("Expected the read to wait for the write, returned after %v", waited)
+	}
+
+	impatient := c.serveNode(t, fi, 200*time.Millisecond)
+	if code, body := staleGet(t, impatient, "ryw", index+1000); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 for an unreachable min_index, got %d %q", code, body)
+	}
+}
+
+// TestLeaderReadWaitsForMinIndex reads from the leader with a min_index
+// beyond its last write: the read blocks until writes reach that index, then
+// returns the latest value
+func TestLeaderReadWaitsForMinIndex(t *testing.T) {
+	ts, _ := startTestServer(t, func(s *api.Server) { s.WithBarrierTimeout(5 * time.Second) })
+	index := httpPut(t, ts, "fresh", "v1")
+
+	type result struct {
+		code int
+		body string
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + "/kv?key=fresh&min_index=" + strconv.FormatUint(index+2, 10))
+		if err != nil {
+			done <- result{body: err.Error()}
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		done <- result{resp.StatusCode, strings.TrimSpace(string(body))}
+	}()
+
+	for _, value := range []string{"v2", "v3"} {
+		select {
+		case res := <-done:
+			t.Fatalf("Read returned before min_index was reached: %d %q", res.code, res.body)
+		case <-time.After(200 * time.Millisecond):
+		}
+		httpPut(t, ts, "fresh", value)
+	}
+
+	select {
+	case res := <-done:
+		if res.code != http.StatusOK || res.body != "v3" {
+			t.Fatalf("Expected the read to return v3 once min_index was applied, got %d %q", res.code, res.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Read did not return after min_index was reached")
+	}
+}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// mmapValue returns a value big enough that a few thousand keys grow the file
+// through several mapping sizes
+func mmapValue(i int) []byte {
+	return bytes.Repeat([]byte{byte('a' + i%26)}, 1000)
+}
+
+// putMmapKeys writes keys [from, to) in batches
+func putMmapKeys(t *testing.T, database *db.DB, from, to int) {
+	t.Helper()
+	for start := from; start < to; start += 100 {
+		var ops []btree.Op
+		for i := start; i < to && i < start+100; i++ {
+			ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("key-%05d", i)), Value: mmapValue(i)})
+		}
+		if err := database.Batch(ops, btree.BatchOptions{}); err != nil {
+			t.Fatalf("Failed to write batch at %d: %v", start, err)
+		}
+	}
+}
+
+// checkMmapKeys reads back keys [0, n)
+func checkMmapKeys(t *testing.T, database *db.DB, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		got, err := database.Get([]byte(fmt.Sprintf("key-%05d", i)))
+		if err != nil {
+			t.Fatalf("Failed to get key %d: %v", i, err)
+		}
+		if !bytes.Equal(got, mmapValue(i)) {
+			t.Fatalf("Key %d: got %d bytes starting %q", i, len(got), got[:min(len(got), 8)])
+		}
+	}
+}
+
+// TestMmapReadsAfterGrowth grows a file well past its initial mapping and
+// checks every key reads back, both in the writing process and in a
+// read-only mmap reader that was opened while the file was still small
+func TestMmapReadsAfterGrowth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mmap.db")
+	writer, err := db.OpenWithOptions(path, db.Options{UseMmap: true, NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := writer.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close writer: %v", closeErr)
+		}
+	}()
+
+	putMmapKeys(t, writer, 0, 100)
+	reader, err := db.OpenWithOptions(path, db.Options{ReadOnly: true, UseMmap: true})
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close reader: %v", closeErr)
+		}
+	}()
+	checkMmapKeys(t, reader, 100)
+
+	const total = 4000
+	putMmapKeys(t, writer, 100, total)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Size() <= 2<<20 {
+		t.Fatalf("Expected the file to outgrow the initial mapping, size is %d", info.Size())
+	}
+	checkMmapKeys(t, writer, total)
+
+	// The reader sees pages appended by the writer before and after it
+	// remaps on Reload
+	if err := reader.Reload(); err != nil {
+		t.Fatalf("Failed to reload reader: %v", err)
+	}
+	checkMmapKeys(t, reader, total)
+
+	// Compact shrinks the file under the writer's mapping
+	if _, err := writer.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	putMmapKeys(t, writer, total, total+200)
+	checkMmapKeys(t, writer, total+200)
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// TestNextPrev steps through a tree of several levels from keys that exist,
+// keys between them and keys past either end, and checks a walk in each
+// direction visits every key once
+func TestNextPrev(t *testing.T) {
+	database := openTestDB(t, "neighbor.db")
+
+	// Even keys only, enough for internal pages, so stepping crosses leaves
+	const n = 2000
+	key := func(i int) string { return fmt.Sprintf("k%04d", i) }
+	for i := 0; i < n; i += 2 {
+		if err := database.Put([]byte(key(i)), []byte("v"+key(i))); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	// A bucket adds reserved keys, which sort before every other key
+	if err := database.CreateBucket("b"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	cases := []struct {
+		from, next, prev string
+	}{
+		{"k0000", "k0002", ""},
+		{"a", "k0000", ""},
+		{"k0001", "k0002", "k0000"},
+		{"k0100", "k0102", "k0098"},
+		{"k0101", "k0102", "k0100"},
+		{"k1998", "", "k1996"},
+		{"k1999", "", "k1998"},
+		{"z", "", "k1998"},
+	}
+	for _, c := range cases {
+		item, err := database.Next([]byte(c.from))
+		if c.next == "" {
+			if !errors.Is(err, btree.ErrKeyNotFound) {
+				t.Errorf("Next(%q): expected ErrKeyNotFound, got %q, %v", c.from, item.Key, err)
+			}
+		} else if err != nil || string(item.Key) != c.next || string(item.Value) != "v"+c.next {
+			t.Errorf("Next(%q): expected %q, got %q=%q, %v", c.from, c.next, item.Key, item.Value, err)
+		}
+
+		item, err = database.Prev([]byte(c.from))
+		if c.prev == "" {
+			if !errors.Is(err, btree.ErrKeyNotFound) {
+				t.Errorf("Prev(%q): expected ErrKeyNotFound, got %q, %v", c.from, item.Key, err)
+			}
+		} else if err != nil || string(item.Key) != c.prev || string(item.Value) != "v"+c.prev {
+			t.Errorf("Prev(%q): expected %q, got %q=%q, %v", c.from, c.prev, item.Key, item.Value, err)
+		}
+	}
+
+	if _, err := database.Next(nil); !errors.Is(err, btree.ErrEmptyKey) {
+		t.Errorf("Expected ErrEmptyKey for Next of the empty key, got %v", err)
+	}
+
+	// Walk the whole tree both ways
+	count := 0
+	for cur := []byte(key(0)); ; count++ {
+		item, err := database.Next(cur)
+		if errors.Is(err, btree.ErrKeyNotFound) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to step forward from %q: %v", cur, err)
+		}
+		if want := key(2 * (count + 1)); string(item.Key) != want {
+			t.Fatalf("Expected %q after %q, got %q", want, cur, item.Key)
+		}
+		cur = item.Key
+	}
+	if count != n/2-1 {
+		t.Fatalf("Expected %d steps forward, got %d", n/2-1, count)
+	}
+	count = 0
+	for cur := []byte(key(n - 2)); ; count++ {
+		item, err := database.Prev(cur)
+		if errors.Is(err, btree.ErrKeyNotFound) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to step back from %q: %v", cur, err)
+		}
+		cur = item.Key
+	}
+	if count != n/2-1 {
+		t.Fatalf("Expected %d steps back, got %d", n/2-1, count)
+	}
+
+	// A deleted run wider than a leaf is stepped over
+	for i := 400; i <= 1200; i += 2 {
+		if err := database.Delete([]byte(key(i))); err != nil {
+			t.Fatalf("Failed to delete key %d: %v", i, err)
+		}
+	}
+	if item, err := database.Next([]byte(key(398))); err != nil || string(item.Key) != key(1202) {
+		t.Fatalf("Expected %q after the deleted range, got %q, %v", key(1202), item.Key, err)
+	}
+	if item, err := database.Prev([]byte(key(1202))); err != nil || string(item.Key) != key(398) {
+		t.Fatalf("Expected %q before the deleted range, got %q, %v", key(398), item.Key, err)
+	}
+}
+
+// TestNeighborEndpoints checks /kv/next and /kv/prev return the neighbor as
+// JSON, 404 past either end and 400 without a key
+func TestNeighborEndpoints(t *testing.T) {
+	ts, database := startTestServer(t, nil)
+
+	for _, k := range []string{"a", "c", "e"} {
+		if err := database.Put([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatalf("Failed to put %q: %v", k, err)
+		}
+	}
+
+	get := func(path string, q url.Values) (int, map[string]string) {
+		resp, err := http.Get(ts.URL + path + "?" + q.Encode())
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close response body: %v", closeErr)
+			}
+		}()
+		var out map[string]string
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+				t.Fatalf("Failed to decode %s response: %v", path, err)
+			}
+		}
+		return resp.StatusCode, out
+	}
+
+	if code, out := get("/kv/next", url.Values{"key": {"b"}}); code != http.StatusOK || out["key"] != "c" || out["value"] != "vc" {
+		t.Fatalf("Expected c=vc after b, got %d %v", code, out)
+	}
+	if code, out := get("/kv/prev", url.Values{"key": {"c"}}); code != http.StatusOK || out["key"] != "a" || out["value"] != "va" {
+		t.Fatalf("Expected a=va before c, got %d %v", code, out)
+	}
+	if code, _ := get("/kv/next", url.Values{"key": {"e"}}); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 after the last key, got %d", code)
+	}
+	if code, _ := get("/kv/prev", url.Values{"key": {"a"}}); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 before the first key, got %d", code)
+	}
+	if code, _ := get("/kv/next", nil); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 without a key, got %d", code)
+	}
+}
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// TestAddItemKeepsOrder inserts keys in random order, including a
+// duplicate, and checks the leaf stays sorted with an accurate count
+func TestAddItemKeepsOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	node := btree.NewLeafNode(1)
+	const n = 1000
+	for _, i := range rng.Perm(n) {
+		node.AddItem(btree.Item{Key: []byte(fmt.Sprintf("key-%04d", i)), Value: []byte("v")})
+	}
+	node.AddItem(btree.Item{Key: []byte("key-0500"), Value: []byte("dup")})
+
+	items := node.Items()
+	if int(node.Count()) != n+1 || len(items) != n+1 {
+		t.Fatalf("Expected %d items, count %d, len %d", n+1, node.Count(), len(items))
+	}
+	for i := 1; i < len(items); i++ {
+		if bytes.Compare(items[i-1].Key, items[i].Key) > 0 {
+			t.Fatalf("Items out of order at %d: %s > %s", i, items[i-1].Key, items[i].Key)
+		}
+	}
+	// A duplicate goes before the key it equals
+	if string(items[500].Key) != "key-0500" || string(items[500].Value) != "dup" {
+		t.Fatalf("Expected the duplicate at 500, got %s=%s", items[500].Key, items[500].Value)
+	}
+}
+
+// TestDeserializeRejectsMismatchedChildCount raises the item count of an
+// internal page so its items and children no longer match what it holds,
+// and checks the page fails to decode as corrupt rather than misreading
+func TestDeserializeRejectsMismatchedChildCount(t *testing.T) {
+	node := btree.NewInternalNode(7)
+	node.AddItem(btree.Item{Key: []byte("m")})
+	for i, child := range []btree.NodeID{3, 4} {
+		if err := node.AddChild(i, child); err != nil {
+			t.Fatalf("AddChild failed: %v", err)
+		}
+	}
+	page, err := node.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	if _, err := btree.DeserializeNode(page); err != nil {
+		t.Fatalf("Expected the intact page to decode, got %v", err)
+	}
+
+	// The count follows the 8-byte ID and the type byte
+	binary.LittleEndian.PutUint16(page[9:], 2)
+	if _, err := btree.DeserializeNode(page); !errors.Is(err, btree.ErrCorrupt) {
+		t.Fatalf("Expected ErrCorrupt for a mismatched child count, got %v", err)
+	}
+}
+
+// TestChildPastAllocatedPagesIsCorrupt points the root of a file at a page
+// that was never allocated and checks reads through it fail as corrupt
+func TestChildPastAllocatedPagesIsCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "child.db")
+	tree, err := btree.NewBTreeWithOptions(path, btree.Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("value")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	// The root ID follows the magic number and version in the header
+	rootID := binary.LittleEndian.Uint64(data[8:])
+	off := rootID * btree.NodeSize
+	root, err := btree.DeserializeNode(data[off : off+btree.NodeSize])
+	if err != nil {
+		t.Fatalf("Failed to decode root: %v", err)
+	}
+	if root.Type() != btree.InternalNode {
+		t.Fatal("Expected an internal root")
+	}
+	children := root.Children()
+	children[len(children)-1] = 1 << 40
+	page, err := root.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	copy(data[off:], page)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tree, err = btree.NewBTreeWithOptions(path, btree.Options{})
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+	if _, err := tree.Get([]byte("key-0999")); !errors.Is(err, btree.ErrCorrupt) {
+		t.Fatalf("Expected ErrCorrupt reading through the bad child, got %v", err)
+	}
+}
+
+// TestMinimalItemsSplitBeforeCountOverflow fills a tree with the smallest
+// items possible, which pack a page most densely, and checks every page
+// splits at MaxItems, far below what its uint16 count can hold
+func TestMinimalItemsSplitBeforeCountOverflow(t *testing.T) {
+	tree, err := btree.NewBTreeWithOptions(filepath.Join(t.TempDir(), "tiny.db"), btree.Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+
+	const n = 1 << 15
+	ops := make([]btree.Op, 0, n)
+	for i := 0; i < n; i++ {
+		ops = append(ops, btree.Op{Key: binary.BigEndian.AppendUint16(nil, uint16(i))})
+	}
+	if err := tree.Batch(ops, btree.BatchOptions{}); err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	// Verify rejects any page holding more than MaxItems
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	stats, err := tree.Stats(true)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	// the first 256 keys start with a zero byte and count as reserved
+	if keys := stats.Keys + stats.ReservedKeys; keys != n || stats.LeafPages < n/btree.MaxItems {
+		t.Fatalf("Expected %d keys in at least %d leaves, got %d in %d", n, n/btree.MaxItems, keys, stats.LeafPages)
+	}
+
+	// A node counting past uint16 is refused rather than written wrapped
+	node := btree.NewLeafNode(1)
+	for i := 0; i <= 1<<16; i++ {
+		node.AddItem(btree.Item{Key: binary.BigEndian.AppendUint32(nil, uint32(i))})
+	}
+	if _, err := node.Serialize(); err == nil {
+		t.Fatal("Expected a node with a wrapped count to fail to serialize")
+	}
+}
+
+// BenchmarkAddItemLargeLeaf fills a leaf with keys in random order, at
+// the current item cap and at a larger fanout
+func BenchmarkAddItemLargeLeaf(b *testing.B) {
+	for _, size := range []int{255, 4096} {
+		b.Run(fmt.Sprintf("items=%d", size), func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+			items := make([]btree.Item, size)
+			for i, k := range rng.Perm(size) {
+				items[i] = btree.Item{Key: []byte(fmt.Sprintf("key-%08d", k))}
+			}
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				node := btree.NewLeafNode(1)
+				for _, it := range items {
+					node.AddItem(it)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*size), "ns/insert")
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+type opEntry struct {
+	ID        string   `json:"id"`
+	Kind      string   `json:"kind"`
+	Done      int64    `json:"done"`
+	Total     int64    `json:"total"`
+	Percent   *float64 `json:"percent"`
+	Cancelled bool     `json:"cancelled"`
+}
+
+// TestAdminOpsListsAndCancels starts an operation, finds it with its
+// progress in /admin/ops and cancels it from there
+func TestAdminOpsListsAndCancels(t *testing.T) {
+	var srv *api.Server
+	ts, _ := startTestServer(t, func(s *api.Server) { srv = s.WithAdminToken("ops") })
+
+	do := func(method, path string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer ops")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		t.Cleanup(func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close response body: %v", closeErr)
+			}
+		})
+		return resp
+	}
+	list := func() []opEntry {
+		t.Helper()
+		resp := do(http.MethodGet, "/admin/ops")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 listing operations, got %d", resp.StatusCode)
+		}
+		var ops []opEntry
+		if err := json.NewDecoder(resp.Body).Decode(&ops); err != nil {
+			t.Fatalf("Failed to decode operations: %v", err)
+		}
+		return ops
+	}
+
+	resp, err := http.Get(ts.URL + "/admin/ops")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without the admin token, got %d", resp.StatusCode)
+	}
+
+	op := srv.StartOperation(context.Background(), "test")
+	op.Report(40, 100)
+
+	ops := list()
+	if len(ops) != 1 || ops[0].Kind != "test" || ops[0].Percent == nil || *ops[0].Percent != 40 || ops[0].Cancelled {
+		t.Fatalf("Expected one running test operation at 40%%, got %+v", ops)
+	}
+
+	if resp := do(http.MethodPost, "/admin/ops/999/cancel"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 cancelling an unknown operation, got %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodPost, "/admin/ops/"+ops[0].ID+"/cancel"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 cancelling the operation, got %d", resp.StatusCode)
+	}
+	select {
+	case <-op.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected cancelling to cancel the operation's context")
+	}
+	if ops := list(); len(ops) != 1 || !ops[0].Cancelled {
+		t.Fatalf("Expected the operation listed as cancelled until it finishes, got %+v", ops)
+	}
+
+	op.Finish()
+	if ops := list(); len(ops) != 0 {
+		t.Fatalf("Expected no operations after it finished, got %+v", ops)
+	}
+}
+
+// TestCompactStopsWhenCancelled cancels a compaction part way through and
+// checks the tree is left intact and a later compaction completes
+func TestCompactStopsWhenCancelled(t *testing.T) {
+	tree, err := btree.NewBTreeWithOptions(filepath.Join(t.TempDir(), "cancel.db"), btree.Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+
+	const n = 20000
+	for i := 0; i < n; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("value")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var reports int
+	_, err = tree.CompactWithProgress(btree.Progress{Context: ctx, Report: func(done, total int) {
+		reports++
+		if done > total {
+			t.Errorf("Reported %d of %d pages done", done, total)
+		}
+		cancel()
+	}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the compaction to stop with context.Canceled, got %v", err)
+	}
+	if reports != 1 {
+		t.Fatalf("Expected it to stop at the first report, got %d reports", reports)
+	}
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("Verify after a cancelled compaction failed: %v", err)
+	}
+
+	var last, lastTotal int
+	stats, err := tree.CompactWithProgress(btree.Progress{Report: func(done, total int) { last, lastTotal = done, total }})
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if last != lastTotal || last < stats.LivePages {
+		t.Fatalf("Expected a final report of all %d pages done, got %d of %d", stats.LivePages, last, lastTotal)
+	}
+	for i := 0; i < n; i += 97 {
+		if _, err := tree.Get([]byte(fmt.Sprintf("key-%05d", i))); err != nil {
+			t.Fatalf("Get after compaction failed: %v", err)
+		}
+	}
+}
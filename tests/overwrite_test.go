@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+func openOverwriteTree(t testing.TB, path string, inPlace bool) *btree.BTree {
+	t.Helper()
+	tree, err := btree.NewBTreeWithOptions(path, btree.Options{NoSync: true, UnsafeOverwriteInPlace: inPlace})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	return tree
+}
+
+func loadOverwriteKeys(t testing.TB, tree *btree.BTree, n int) {
+	t.Helper()
+	ops := make([]btree.Op, 0, n)
+	for i := 0; i < n; i++ {
+		ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("key-%05d", i)), Value: []byte("value-aaaa")})
+	}
+	if err := tree.Batch(ops, btree.BatchOptions{}); err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+}
+
+func pagesWritten(t testing.TB, tree *btree.BTree) uint64 {
+	t.Helper()
+	stats, err := tree.Stats(false)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	return stats.PagesWritten
+}
+
+// TestOverwriteInPlaceWritesOnePage checks a same-size overwrite writes only
+// the leaf's page, and the new values survive a reopen
+func TestOverwriteInPlaceWritesOnePage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overwrite.db")
+	tree := openOverwriteTree(t, path, true)
+	loadOverwriteKeys(t, tree, 2000)
+
+	before := pagesWritten(t, tree)
+	for i := 0; i < 100; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key-%05d", i*17)), []byte(fmt.Sprintf("value-%04d", i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if got := pagesWritten(t, tree) - before; got != 100 {
+		t.Fatalf("Expected 100 pages written for 100 overwrites, got %d", got)
+	}
+
+	// A longer value cannot fit and takes the copy-on-write path
+	if err := tree.Put([]byte("key-00001"), []byte("a longer value than before")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	tree = openOverwriteTree(t, path, false)
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		value, err := tree.Get([]byte(fmt.Sprintf("key-%05d", i*17)))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if want := fmt.Sprintf("value-%04d", i); string(value) != want {
+			t.Fatalf("Expected %q after reopen, got %q", want, value)
+		}
+	}
+	if value, err := tree.Get([]byte("key-00001")); err != nil || string(value) != "a longer value than before" {
+		t.Fatalf("Expected the longer value, got %q (%v)", value, err)
+	}
+}
+
+// TestOverwriteInPlaceKeepsGetResults checks a value returned by Get is not
+// changed by a later in-place overwrite of the same key
+func TestOverwriteInPlaceKeepsGetResults(t *testing.T) {
+	tree := openOverwriteTree(t, filepath.Join(t.TempDir(), "overwrite.db"), true)
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+	loadOverwriteKeys(t, tree, 10)
+
+	value, err := tree.Get([]byte("key-00003"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := tree.Put([]byte("key-00003"), []byte("value-bbbb")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if string(value) != "value-aaaa" {
+		t.Fatalf("Expected the earlier Get result to stay %q, got %q", "value-aaaa", value)
+	}
+}
+
+// TestOverwriteInPlaceSkippedDuringYieldingScan checks a scan paused to let
+// writers in still sees the values as of when it began
+func TestOverwriteInPlaceSkippedDuringYieldingScan(t *testing.T) {
+	tree, err := btree.NewBTreeWithOptions(filepath.Join(t.TempDir(), "overwrite.db"), btree.Options{NoSync: true, UnsafeOverwriteInPlace: true, YieldEvery: 10})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+	const n = 2000
+	loadOverwriteKeys(t, tree, n)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i = (i + 1) % n {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := tree.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("value-bbbb")); err != nil {
+				t.Errorf("Put during scan failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	var seen int
+	err = tree.Scan(nil, func(key, value []byte) bool {
+		if !bytes.Equal(value, []byte("value-aaaa")) {
+			t.Errorf("Scan saw %q for %s, written after it began", value, key)
+			return false
+		}
+		seen++
+		if seen%20 == 0 {
+			time.Sleep(100 * time.Microsecond)
+		}
+		return true
+	})
+	close(stop)
+	wg.Wait()
+
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if seen != n {
+		t.Fatalf("Expected the scan to see %d keys, got %d", n, seen)
+	}
+}
+
+// TestCopyOnWriteWritesOnlyThePath checks a copy-on-write Put writes one
+// page per level, with no child rewritten to update its parent pointer, and
+// that splits and merges stay correct without those updates
+func TestCopyOnWriteWritesOnlyThePath(t *testing.T) {
+	tree := openOverwriteTree(t, filepath.Join(t.TempDir(), "path.db"), false)
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+	loadOverwriteKeys(t, tree, 20000)
+	stats, err := tree.Stats(true)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+
+	const updates = 200
+	before := pagesWritten(t, tree)
+	for i := 0; i < updates; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key-%05d", (i*7919)%20000)), []byte("value-bbbb")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if got, want := pagesWritten(t, tree)-before, uint64(updates*stats.Depth); got != want {
+		t.Fatalf("Expected %d pages for %d updates at depth %d, got %d", want, updates, stats.Depth, got)
+	}
+
+	// New keys between the old ones split leaves, and deleting most keys
+	// merges them again
+	for i := 0; i < 20000; i += 2 {
+		before := pagesWritten(t, tree)
+		if err := tree.Put([]byte(fmt.Sprintf("key-%05d-new", i)), []byte("value-cccc")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if got := pagesWritten(t, tree) - before; got > uint64(2*stats.Depth+1) {
+			t.Fatalf("Expected an insert to write at most a split path, got %d pages", got)
+		}
+	}
+	for i := 0; i < 20000; i++ {
+		if i%10 == 0 {
+			continue
+		}
+		if err := tree.Delete([]byte(fmt.Sprintf("key-%05d", i))); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	for i := 0; i < 20000; i++ {
+		_, err := tree.Get([]byte(fmt.Sprintf("key-%05d", i)))
+		if kept := i%10 == 0; kept != (err == nil) {
+			t.Fatalf("Get key-%05d: kept=%v, got err %v", i, kept, err)
+		}
+		if i%2 == 0 {
+			if _, err := tree.Get([]byte(fmt.Sprintf("key-%05d-new", i))); err != nil {
+				t.Fatalf("Get key-%05d-new failed: %v", i, err)
+			}
+		}
+	}
+}
+
+// BenchmarkOverwriteSameSize compares the pages written per same-size
+// overwrite with and without UnsafeOverwriteInPlace
+func BenchmarkOverwriteSameSize(b *testing.B) {
+	for _, inPlace := range []bool{false, true} {
+		name := "cow"
+		if inPlace {
+			name = "in-place"
+		}
+		b.Run(name, func(b *testing.B) {
+			tree := openOverwriteTree(b, filepath.Join(b.TempDir(), "overwrite.db"), inPlace)
+			defer func() {
+				if closeErr := tree.Close(); closeErr != nil {
+					b.Logf("Warning: failed to close tree: %v", closeErr)
+				}
+			}()
+			loadOverwriteKeys(b, tree, 20000)
+
+			before := pagesWritten(b, tree)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("key-%05d", (i*7919)%20000)), []byte(fmt.Sprintf("value-%04d", i%10000))); err != nil {
+					b.Fatalf("Put failed: %v", err)
+				}
+			}
+			b.StopTimer()
+			pages := float64(pagesWritten(b, tree)-before) / float64(b.N)
+			b.ReportMetric(pages, "pages/op")
+			if inPlace && pages > 1.5 {
+				b.Fatalf("Expected about one page per in-place overwrite, got %.2f", pages)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// pageStoreBackends opens a tree over each PageStore implementation
+var pageStoreBackends = []struct {
+	name string
+	open func(t *testing.T) *btree.BTree
+}{
+	{"file", func(t *testing.T) *btree.BTree {
+		tree, err := btree.NewBTreeWithOptions(filepath.Join(t.TempDir(), "store.db"), btree.Options{NoSync: true})
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		return tree
+	}},
+	{"memory", func(t *testing.T) *btree.BTree {
+		tree, err := btree.NewBTreeWithStore(btree.NewMemPageStore(), btree.Options{})
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		return tree
+	}},
+}
+
+// TestPageStoreBackends runs random puts, deletes, batches and transactions
+// against a map model on every backend, checking Get, Scan and Verify before
+// and after a Compact
+func TestPageStoreBackends(t *testing.T) {
+	for _, backend := range pageStoreBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			tree := backend.open(t)
+			defer func() {
+				if closeErr := tree.Close(); closeErr != nil {
+					t.Logf("Warning: failed to close tree: %v", closeErr)
+				}
+			}()
+
+			rng := rand.New(rand.NewSource(1))
+			model := make(map[string]string)
+			key := func() []byte { return []byte(fmt.Sprintf("key-%05d", rng.Intn(2000))) }
+
+			for i := 0; i < 8000; i++ {
+				switch r := rng.Intn(10); {
+				case r < 6:
+					k, v := key(), []byte(fmt.Sprintf("value-%d", i))
+					if err := tree.Put(k, v); err != nil {
+						t.Fatalf("Put failed: %v", err)
+					}
+					model[string(k)] = string(v)
+				case r < 8:
+					k := key()
+					_ = tree.Delete(k)
+					delete(model, string(k))
+				case r < 9:
+					ops := make([]btree.Op, 50)
+					for j := range ops {
+						ops[j] = btree.Op{Key: key(), Value: []byte(fmt.Sprintf("batch-%d-%d", i, j))}
+					}
+					if err := tree.Batch(ops, btree.BatchOptions{}); err != nil {
+						t.Fatalf("Batch failed: %v", err)
+					}
+					for _, op := range ops {
+						model[string(op.Key)] = string(op.Value)
+					}
+				default:
+					k := key()
+					_, exists := model[string(k)]
+					ok, err := tree.Txn([]btree.Cond{{Key: k, Absent: true}}, []btree.Op{{Key: k, Value: []byte("txn")}})
+					if err != nil {
+						t.Fatalf("Txn failed: %v", err)
+					}
+					if ok == exists {
+						t.Fatalf("Txn on %s: applied=%v but key exists=%v", k, ok, exists)
+					}
+					if ok {
+						model[string(k)] = "txn"
+					}
+				}
+			}
+
+			check := func() {
+				t.Helper()
+				if err := tree.Verify(); err != nil {
+					t.Fatalf("Verify failed: %v", err)
+				}
+				for k, v := range model {
+					got, err := tree.Get([]byte(k))
+					if err != nil || string(got) != v {
+						t.Fatalf("Get %s: expected %q, got %q (%v)", k, v, got, err)
+					}
+				}
+				want := make([]string, 0, len(model))
+				for k := range model {
+					want = append(want, k)
+				}
+				sort.Strings(want)
+				var got []string
+				var prev []byte
+				if err := tree.Scan(nil, func(k, _ []byte) bool {
+					if prev != nil && bytes.Compare(prev, k) >= 0 {
+						t.Fatalf("Scan out of order: %s after %s", k, prev)
+					}
+					prev = append(prev[:0], k...)
+					got = append(got, string(k))
+					return true
+				}); err != nil {
+					t.Fatalf("Scan failed: %v", err)
+				}
+				if len(got) != len(want) {
+					t.Fatalf("Scan returned %d keys, expected %d", len(got), len(want))
+				}
+				for i := range want {
+					if got[i] != want[i] {
+						t.Fatalf("Scan key %d: expected %s, got %s", i, want[i], got[i])
+					}
+				}
+			}
+			check()
+
+			stats, err := tree.Compact()
+			if err != nil {
+				t.Fatalf("Compact failed: %v", err)
+			}
+			if stats.PagesFreed == 0 {
+				t.Fatalf("Expected Compact to free pages, got %+v", stats)
+			}
+			check()
+		})
+	}
+}
+
+// TestMemPageStoreReopen closes a tree over a memory store and opens another
+// over the same pages
+func TestMemPageStoreReopen(t *testing.T) {
+	pages := btree.NewMemPageStore()
+	tree, err := btree.NewBTreeWithStore(pages, btree.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("k%04d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	tree, err = btree.NewBTreeWithStore(pages, btree.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+	if _, err := tree.Get([]byte("k0999")); err != nil {
+		t.Fatalf("Get after reopen failed: %v", err)
+	}
+	if err := tree.Put([]byte("k"), []byte("v")); err == nil {
+		t.Fatal("Expected a read-only tree to refuse writes")
+	}
+}
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestPinnedKeysSurviveDropCache pins a few keys, writes enough to split
+// their leaves, drops the cache and checks reading them never misses while
+// other keys go back to disk
+func TestPinnedKeysSurviveDropCache(t *testing.T) {
+	database := openTestDB(t, "pin.db")
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key-%05d", i)) }
+	for i := 0; i < 5000; i += 2 {
+		if err := database.Put(key(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	misses := func() uint64 {
+		t.Helper()
+		stats, err := database.Stats(false)
+		if err != nil {
+			t.Fatalf("Stats failed: %v", err)
+		}
+		return stats.CacheMisses
+	}
+
+	// One key not written yet, pinned where it will land
+	pinned := [][]byte{key(10), key(2500), key(4998), key(777)}
+	if _, err := database.DropCache(); err != nil {
+		t.Fatalf("DropCache failed: %v", err)
+	}
+	if err := database.Pin(pinned); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+
+	for round := 0; round < 3; round++ {
+		// Fill the gaps, splitting the pinned keys' leaves as well
+		for i := 1 + round; i < 5000; i += 6 {
+			if err := database.Put(key(i), []byte(fmt.Sprintf("r%d", round))); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if _, err := database.DropCache(); err != nil {
+			t.Fatalf("DropCache failed: %v", err)
+		}
+		before := misses()
+		for _, k := range pinned {
+			if _, err := database.Get(k); err != nil && !errors.Is(err, btree.ErrKeyNotFound) {
+				t.Fatalf("Get %s failed: %v", k, err)
+			}
+		}
+		if got := misses() - before; got != 0 {
+			t.Fatalf("Round %d: expected pinned keys to read from the cache, got %d misses", round, got)
+		}
+		if _, err := database.Get(key(1500)); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if misses() == before {
+			t.Fatalf("Round %d: expected an unpinned key to miss after DropCache", round)
+		}
+	}
+
+	if err := database.Unpin(pinned); err != nil {
+		t.Fatalf("Unpin failed: %v", err)
+	}
+	if _, err := database.DropCache(); err != nil {
+		t.Fatalf("DropCache failed: %v", err)
+	}
+	before := misses()
+	if _, err := database.Get(key(2500)); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if misses() == before {
+		t.Fatal("Expected an unpinned key to miss after DropCache")
+	}
+}
+
+// TestPinBoundsPinnedPages checks Pin refuses keys whose paths span more
+// than MaxPinnedPages and leaves none of them pinned
+func TestPinBoundsPinnedPages(t *testing.T) {
+	database, err := db.OpenWithOptions(filepath.Join(t.TempDir(), "pinbound.db"), db.Options{MaxPinnedPages: 4})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+	var keys [][]byte
+	for i := 0; i < 2000; i++ {
+		k := []byte(fmt.Sprintf("key-%05d", i))
+		if err := database.Put(k, []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if i%200 == 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	if err := database.Pin(keys[:1]); err != nil {
+		t.Fatalf("Pin of one key failed: %v", err)
+	}
+	if err := database.Pin(keys); !errors.Is(err, btree.ErrTooManyPinned) {
+		t.Fatalf("Expected ErrTooManyPinned, got %v", err)
+	}
+	// The failed Pin left only the first key pinned
+	if err := database.Pin(keys[1:2]); err != nil {
+		t.Fatalf("Expected room for a second key after the failed Pin, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type pipelineResult struct {
+	Seq    int    `json:"seq"`
+	Status int    `json:"status"`
+	Index  uint64 `json:"index"`
+	Error  string `json:"error"`
+}
+
+// TestPipelineStreamsResults writes frames one at a time, reading each
+// result before sending the next so the response must stream while the
+// body is still open, then checks rejected frames and the final state
+func TestPipelineStreamsResults(t *testing.T) {
+	ts, database := startTestServer(t, nil)
+
+	body, send := io.Pipe()
+	resp := make(chan *http.Response, 1)
+	go func() {
+		r, err := http.Post(ts.URL+"/kv/pipeline", "application/x-ndjson", body)
+		if err != nil {
+			t.Errorf("Pipeline request failed: %v", err)
+			close(resp)
+			return
+		}
+		resp <- r
+	}()
+
+	if _, err := io.WriteString(send, `{"key":"a","value":"1"}`+"\n"); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	r, ok := <-resp
+	if !ok {
+		t.FailNow()
+	}
+	defer func() {
+		if closeErr := r.Body.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close response body: %v", closeErr)
+		}
+	}()
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", r.StatusCode)
+	}
+	results := json.NewDecoder(bufio.NewReader(r.Body))
+	var first pipelineResult
+	if err := results.Decode(&first); err != nil {
+		t.Fatalf("Failed to read first result: %v", err)
+	}
+	if first.Seq != 0 || first.Status != http.StatusOK || first.Index == 0 {
+		t.Fatalf("Unexpected first result: %+v", first)
+	}
+
+	frames := `{"key":"b","value":"2"}
+{"value":"no key"}
+{"key":"a","delete":true}
+`
+	if _, err := io.WriteString(send, frames); err != nil {
+		t.Fatalf("Failed to send frames: %v", err)
+	}
+	if err := send.Close(); err != nil {
+		t.Fatalf("Failed to close body: %v", err)
+	}
+
+	got := map[int]pipelineResult{}
+	for {
+		var res pipelineResult
+		if err := results.Decode(&res); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to read result: %v", err)
+		}
+		got[res.Seq] = res
+	}
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 more results, got %+v", got)
+	}
+	if got[1].Status != http.StatusOK || got[3].Status != http.StatusOK {
+		t.Fatalf("Expected writes to succeed: %+v", got)
+	}
+	if got[2].Status != http.StatusBadRequest {
+		t.Fatalf("Expected a frame without key to be rejected: %+v", got[2])
+	}
+	if got[3].Index <= got[1].Index || got[1].Index <= first.Index {
+		t.Fatalf("Expected writes to commit in frame order: %d, %d, %d", first.Index, got[1].Index, got[3].Index)
+	}
+
+	if v, err := database.Get([]byte("b")); err != nil || string(v) != "2" {
+		t.Fatalf("Expected b=2, got %q, %v", v, err)
+	}
+	if _, err := database.Get([]byte("a")); err == nil {
+		t.Fatalf("Expected a to be deleted")
+	}
+}
+
+// BenchmarkPipelinedWrites compares b.N writes as sequential PUT /kv
+// requests with the same writes sent through one /kv/pipeline request
+func BenchmarkPipelinedWrites(b *testing.B) {
+	b.Run("sequential", func(b *testing.B) {
+		ts, _ := startTestServer(b, nil)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/kv?key=k%08d&value=v", ts.URL, i), nil)
+			if err != nil {
+				b.Fatalf("Failed to build request: %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				b.Fatalf("Put failed: %v", err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				b.Fatalf("Unexpected status: %d", resp.StatusCode)
+			}
+		}
+	})
+
+	b.Run("pipelined", func(b *testing.B) {
+		ts, _ := startTestServer(b, nil)
+		var frames strings.Builder
+		for i := 0; i < b.N; i++ {
+			fmt.Fprintf(&frames, `{"key":"k%08d","value":"v"}`+"\n", i)
+		}
+		b.ResetTimer()
+		ok := pipelineWrite(b, ts, frames.String())
+		if ok != b.N {
+			b.Fatalf("Expected %d writes to succeed, got %d", b.N, ok)
+		}
+	})
+}
+
+// pipelineWrite sends frames to /kv/pipeline and counts successful results
+func pipelineWrite(b *testing.B, ts *httptest.Server, frames string) int {
+	resp, err := http.Post(ts.URL+"/kv/pipeline", "application/x-ndjson", strings.NewReader(frames))
+	if err != nil {
+		b.Fatalf("Pipeline request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	ok := 0
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var res pipelineResult
+		if err := dec.Decode(&res); err == io.EOF {
+			return ok
+		} else if err != nil {
+			b.Fatalf("Failed to read result: %v", err)
+		}
+		if res.Status == http.StatusOK {
+			ok++
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestBucketQuotaRejectsWrites fills a bucket to its key quota and checks
+// further inserts fail while overwrites, deletes and other buckets are
+// unaffected, and that the quota and usage survive a reopen
+func TestBucketQuotaRejectsWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for _, name := range []string{"small", "other"} {
+		if err := database.CreateBucket(name); err != nil {
+			t.Fatalf("Failed to create bucket %s: %v", name, err)
+		}
+	}
+	if err := database.SetBucketQuota("small", db.Quota{MaxKeys: 5}); err != nil {
+		t.Fatalf("Failed to set quota: %v", err)
+	}
+
+	small, err := database.Bucket("small")
+	if err != nil {
+		t.Fatalf("Failed to open bucket: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := small.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put %d within quota failed: %v", i, err)
+		}
+	}
+	if err := small.Put([]byte("k5"), []byte("v")); !errors.Is(err, db.ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded past the quota, got %v", err)
+	}
+	if _, err := small.Get([]byte("k5")); err == nil {
+		t.Fatalf("Rejected put was stored")
+	}
+	if err := small.Put([]byte("k0"), []byte("replaced")); err != nil {
+		t.Fatalf("Overwrite within quota failed: %v", err)
+	}
+
+	other, err := database.Bucket("other")
+	if err != nil {
+		t.Fatalf("Failed to open bucket: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := other.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put into unlimited bucket failed: %v", err)
+		}
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	database, err = db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+
+	info, err := database.BucketInfo("small")
+	if err != nil {
+		t.Fatalf("Failed to get bucket info: %v", err)
+	}
+	// k0=replaced plus k1..k4=v
+	want := db.BucketInfo{Name: "small", Quota: db.Quota{MaxKeys: 5}, Usage: db.BucketUsage{Keys: 5, Bytes: 2 + 8 + 4*3}}
+	if info != want {
+		t.Fatalf("Expected %+v after reopen, got %+v", want, info)
+	}
+
+	small, err = database.Bucket("small")
+	if err != nil {
+		t.Fatalf("Failed to open bucket: %v", err)
+	}
+	if err := small.Put([]byte("k5"), []byte("v")); !errors.Is(err, db.ErrQuotaExceeded) {
+		t.Fatalf("Expected quota to survive reopen, got %v", err)
+	}
+	if err := small.Delete([]byte("k1")); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := small.Put([]byte("k5"), []byte("v")); err != nil {
+		t.Fatalf("Put after freeing a key failed: %v", err)
+	}
+}
+
+// TestBucketValueCaps gives two buckets different value caps and checks
+// each enforces its own, that the caps survive a reopen, and that a bucket
+// record written before caps existed still reads
+func TestBucketValueCaps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "caps.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	caps := map[string]uint64{"flags": 8, "blobs": 512}
+	for name, size := range caps {
+		if err := database.CreateBucket(name); err != nil {
+			t.Fatalf("Failed to create bucket %s: %v", name, err)
+		}
+		if err := database.SetBucketQuota(name, db.Quota{MaxValueSize: size}); err != nil {
+			t.Fatalf("Failed to set quota on %s: %v", name, err)
+		}
+	}
+	if err := database.SetBucketQuota("blobs", db.Quota{MaxValueSize: btree.MaxValueSize + 1}); !errors.Is(err, db.ErrInvalidQuota) {
+		t.Fatalf("Expected ErrInvalidQuota for a cap past btree.MaxValueSize, got %v", err)
+	}
+
+	check := func() {
+		t.Helper()
+		for name, size := range caps {
+			b, err := database.Bucket(name)
+			if err != nil {
+				t.Fatalf("Failed to open bucket %s: %v", name, err)
+			}
+			if err := b.Put([]byte("at-cap"), bytes.Repeat([]byte("x"), int(size))); err != nil {
+				t.Fatalf("%s: put at its cap failed: %v", name, err)
+			}
+			if err := b.Put([]byte("over-cap"), bytes.Repeat([]byte("x"), int(size)+1)); !errors.Is(err, btree.ErrValueTooLarge) {
+				t.Fatalf("%s: expected ErrValueTooLarge past its cap, got %v", name, err)
+			}
+			if _, err := b.Get([]byte("over-cap")); err == nil {
+				t.Fatalf("%s: rejected put was stored", name)
+			}
+		}
+	}
+	check()
+
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	database, err = db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+	check()
+
+	// A version 1 record: MaxKeys 3, MaxBytes 0, one key of 4 bytes
+	v1 := make([]byte, 1+4*8)
+	v1[0] = 1
+	binary.BigEndian.PutUint64(v1[1:], 3)
+	binary.BigEndian.PutUint64(v1[17:], 1)
+	binary.BigEndian.PutUint64(v1[25:], 4)
+	if err := database.Put(db.BucketRegistryKey("legacy"), v1); err != nil {
+		t.Fatalf("Failed to write legacy record: %v", err)
+	}
+	info, err := database.BucketInfo("legacy")
+	if err != nil {
+		t.Fatalf("Failed to read legacy record: %v", err)
+	}
+	want := db.BucketInfo{Name: "legacy", Quota: db.Quota{MaxKeys: 3}, Usage: db.BucketUsage{Keys: 1, Bytes: 4}}
+	if info != want {
+		t.Fatalf("Expected %+v, got %+v", want, info)
+	}
+}
+
+// TestBucketQuotaOverHTTP sets a byte quota when creating a bucket, writes
+// through raft until the leader answers 507 and checks the listing reports
+// the quota and usage
+func TestBucketQuotaOverHTTP(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+
+	post := func(query string) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/buckets?"+query, "", nil)
+		if err != nil {
+			t.Fatalf("Create bucket request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected create status: %d", resp.StatusCode)
+		}
+	}
+	put := func(bucket, key string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?bucket="+bucket+"&key="+key+"&value=12345", nil)
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	post("name=limited&max_bytes=20")
+	post("name=free")
+
+	// Each key-value pair is 7 bytes, so the third would make 21
+	for _, key := range []string{"k1", "k2"} {
+		if code := put("limited", key); code != http.StatusOK {
+			t.Fatalf("Put within quota: expected 200, got %d", code)
+		}
+	}
+	if code := put("limited", "k3"); code != http.StatusInsufficientStorage {
+		t.Fatalf("Put past quota: expected 507, got %d", code)
+	}
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		if code := put("free", key); code != http.StatusOK {
+			t.Fatalf("Put into other bucket: expected 200, got %d", code)
+		}
+	}
+	if code := put("missing", "k1"); code != http.StatusNotFound {
+		t.Fatalf("Put into missing bucket: expected 404, got %d", code)
+	}
+
+	resp, err := http.Get(ts.URL + "/buckets")
+	if err != nil {
+		t.Fatalf("List buckets request failed: %v", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close response body: %v", closeErr)
+		}
+	}()
+	var got []struct {
+		Name     string `json:"name"`
+		Keys     uint64 `json:"keys"`
+		Bytes    uint64 `json:"bytes"`
+		MaxBytes uint64 `json:"max_bytes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode bucket list: %v", err)
+	}
+	if len(got) != 2 ||
+		got[0].Name != "free" || got[0].Keys != 4 || got[0].Bytes != 28 || got[0].MaxBytes != 0 ||
+		got[1].Name != "limited" || got[1].Keys != 2 || got[1].Bytes != 14 || got[1].MaxBytes != 20 {
+		t.Fatalf("Unexpected bucket list: %+v", got)
+	}
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/hashicorp/raft"
+)
+
+// TestFollowerReadIndexSeesLatestWrite writes through the leader and reads
+// straight back from a follower with read_index=true, which must reflect
+// the write without a stale read's lag
+func TestFollowerReadIndexSeesLatestWrite(t *testing.T) {
+	c := startTestCluster(t, 3)
+
+	urls := make(map[raft.ServerAddress]string)
+	servers := make([]*httptest.Server, len(c.nodes))
+	for i := range c.nodes {
+		mux := http.NewServeMux()
+		api.New(c.nodes[i], c.dbs[i]).
+			WithLeaderHTTP(func(leader raft.ServerAddress) string { return urls[leader] }).
+			Register(mux)
+		servers[i] = httptest.NewServer(mux)
+		t.Cleanup(servers[i].Close)
+		urls[raft.ServerAddress(c.addrs[i])] = servers[i].URL
+	}
+	leader := c.leader(t)
+	follower := (leader + 1) % len(c.nodes)
+
+	get := func(base, query string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(base + query)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", query, err)
+		}
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close response body: %v", closeErr)
+			}
+		}()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(body))
+	}
+
+	if code, _ := get(servers[follower].URL, "/raft/readindex"); code != http.StatusConflict {
+		t.Fatalf("Expected 409 for /raft/readindex on a follower, got %d", code)
+	}
+	if code, _ := get(servers[follower].URL, "/kv?key=k"); code != http.StatusConflict {
+		t.Fatalf("Expected 409 for a plain follower read, got %d", code)
+	}
+
+	for i := 0; i < 20; i++ {
+		want := fmt.Sprintf("v%d", i)
+		httpPut(t, servers[leader], "k", want)
+		code, got := get(servers[follower].URL, "/kv?key=k&read_index=true")
+		if code != http.StatusOK || got != want {
+			t.Fatalf("Expected read_index read of %q right after the write, got %d %q", want, code, got)
+		}
+	}
+
+	code, body := get(servers[follower].URL, "/scan?prefix=k&read_index=true")
+	if code != http.StatusOK || !strings.Contains(body, "v19") {
+		t.Fatalf("Expected read_index scan to see the last write, got %d %s", code, body)
+	}
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// loadReadaheadTree writes n keys of 100-byte values to a new file, enough
+// for several levels of pages, and returns its path
+func loadReadaheadTree(tb testing.TB, n int) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "readahead.db")
+	tree, err := btree.NewBTreeWithOptions(path, btree.Options{NoSync: true})
+	if err != nil {
+		tb.Fatalf("Failed to open tree: %v", err)
+	}
+	ops := make([]btree.Op, 0, n)
+	for i := 0; i < n; i++ {
+		ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("key-%06d", i)), Value: bytes.Repeat([]byte{byte(i)}, 100)})
+	}
+	if err := tree.Batch(ops, btree.BatchOptions{}); err != nil {
+		tb.Fatalf("Failed to load keys: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		tb.Fatalf("Failed to close tree: %v", err)
+	}
+	return path
+}
+
+// TestReadaheadScanUnchanged scans a cold tree with and without readahead,
+// from the start and from a key partway through, alongside concurrent
+// readers, and checks every scan returns the same items
+func TestReadaheadScanUnchanged(t *testing.T) {
+	const n = 20000
+	path := loadReadaheadTree(t, n)
+
+	scanAll := func(readahead int, start []byte) []btree.Item {
+		t.Helper()
+		tree, err := btree.NewBTreeWithOptions(path, btree.Options{ReadOnly: true, Readahead: readahead})
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		defer func() {
+			if closeErr := tree.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close tree: %v", closeErr)
+			}
+		}()
+
+		// Point reads share the cache with the prefetches
+		var wg sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func(r int) {
+				defer wg.Done()
+				for i := r; i < n; i += 97 {
+					if _, err := tree.Get([]byte(fmt.Sprintf("key-%06d", i))); err != nil {
+						t.Errorf("Get during scan failed: %v", err)
+						return
+					}
+				}
+			}(r)
+		}
+		var items []btree.Item
+		err = tree.Scan(start, func(key, value []byte) bool {
+			items = append(items, btree.Item{Key: append([]byte(nil), key...), Value: append([]byte(nil), value...)})
+			return true
+		})
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("Scan with readahead %d failed: %v", readahead, err)
+		}
+		return items
+	}
+
+	for _, start := range [][]byte{nil, []byte("key-012345")} {
+		want := scanAll(0, start)
+		if start == nil && len(want) != n {
+			t.Fatalf("Expected %d items, got %d", n, len(want))
+		}
+		for _, readahead := range []int{1, 8, btree.MaxReadahead, 10 * btree.MaxReadahead} {
+			got := scanAll(readahead, start)
+			if len(got) != len(want) {
+				t.Fatalf("Readahead %d from %q: expected %d items, got %d", readahead, start, len(want), len(got))
+			}
+			for i := range want {
+				if !bytes.Equal(got[i].Key, want[i].Key) || !bytes.Equal(got[i].Value, want[i].Value) {
+					t.Fatalf("Readahead %d from %q: item %d is %q, expected %q", readahead, start, i, got[i].Key, want[i].Key)
+				}
+			}
+		}
+	}
+}
+
+// slowPageStore adds a fixed latency to every page read, as a disk would
+type slowPageStore struct {
+	btree.PageStore
+	latency time.Duration
+}
+
+func (s slowPageStore) ReadPage(id btree.NodeID) ([]byte, error) {
+	time.Sleep(s.latency)
+	return s.PageStore.ReadPage(id)
+}
+
+// BenchmarkColdScan scans a large tree with an empty node cache, over a
+// store whose reads take 100µs, without readahead and with it
+func BenchmarkColdScan(b *testing.B) {
+	pages := btree.NewMemPageStore()
+	tree, err := btree.NewBTreeWithStore(pages, btree.Options{})
+	if err != nil {
+		b.Fatalf("Failed to open tree: %v", err)
+	}
+	ops := make([]btree.Op, 0, 50000)
+	for i := 0; i < cap(ops); i++ {
+		ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("key-%06d", i)), Value: make([]byte, 100)})
+	}
+	if err := tree.Batch(ops, btree.BatchOptions{}); err != nil {
+		b.Fatalf("Failed to load keys: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		b.Fatalf("Failed to close tree: %v", err)
+	}
+
+	for _, readahead := range []int{0, 16, 64} {
+		b.Run(fmt.Sprintf("readahead=%d", readahead), func(b *testing.B) {
+			tree, err := btree.NewBTreeWithStore(slowPageStore{pages, 100 * time.Microsecond}, btree.Options{ReadOnly: true, Readahead: readahead})
+			if err != nil {
+				b.Fatalf("Failed to open tree: %v", err)
+			}
+			defer func() {
+				if closeErr := tree.Close(); closeErr != nil {
+					b.Logf("Warning: failed to close tree: %v", closeErr)
+				}
+			}()
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tree.DropCache()
+				b.StartTimer()
+				if err := tree.Scan(nil, func(key, value []byte) bool { return true }); err != nil {
+					b.Fatalf("Scan failed: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// TestOpenReadOnlyReaderAt snapshots a database to a buffer, opens the tree
+// from that buffer and reads every key back, checking writes are refused
+func TestOpenReadOnlyReaderAt(t *testing.T) {
+	database := openTestDB(t, "source.db")
+	want := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		k, v := fmt.Sprintf("key-%05d", i), fmt.Sprintf("value-%d", i)
+		if err := database.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		want[k] = v
+	}
+	var buf bytes.Buffer
+	if err := database.SnapshotTo(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// The checksum trailer rides along past the last page and is never read
+	tree, err := btree.OpenReadOnlyReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to open tree from buffer: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+
+	for k, v := range want {
+		got, err := tree.Get([]byte(k))
+		if err != nil || string(got) != v {
+			t.Fatalf("Get %s: expected %q, got %q (%v)", k, v, got, err)
+		}
+	}
+	scanned := 0
+	if err := tree.Scan(nil, func(k, v []byte) bool {
+		if want[string(k)] != string(v) {
+			t.Fatalf("Scan %s: expected %q, got %q", k, want[string(k)], v)
+		}
+		scanned++
+		return true
+	}); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if scanned != len(want) {
+		t.Fatalf("Scan returned %d keys, expected %d", scanned, len(want))
+	}
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := tree.Put([]byte("k"), []byte("v")); !errors.Is(err, btree.ErrReadOnly) {
+		t.Fatalf("Expected Put to fail with ErrReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+	"github.com/hashicorp/raft"
+)
+
+// startLeaderlessServer starts a node that has neither bootstrapped nor
+// joined, and serves it with WithWaitForLeader(timeout)
+func startLeaderlessServer(t *testing.T, timeout time.Duration) (*httptest.Server, *raftnode.Node, string) {
+	t.Helper()
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "conure.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	addr := freeRaftAddr(t)
+	node, err := raftnode.StartNode(raftnode.Config{NodeID: "node1", RaftAddr: addr, DataDir: dir}, &raftnode.FSM{DB: database})
+	if err != nil {
+		t.Fatalf("Failed to start raft node: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := node.Shutdown(); err != nil {
+			t.Logf("Warning: failed to shut down raft node: %v", err)
+		}
+		if err := database.Close(); err != nil {
+			t.Logf("Warning: failed to close database: %v", err)
+		}
+	})
+
+	mux := http.NewServeMux()
+	api.New(node, database).WithWaitForLeader(timeout).Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts, node, addr
+}
+
+// getStatus fetches path from ts and returns its status and body
+func getStatus(t *testing.T, ts *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+// TestWaitForLeaderGatesAPI checks a node without a leader answers only
+// /healthz, /status, /version and /metrics, and serves normally once it has
+// one
+func TestWaitForLeaderGatesAPI(t *testing.T) {
+	ts, node, addr := startLeaderlessServer(t, 0)
+
+	if code, body := getStatus(t, ts, "/kv?key=k"); code != http.StatusServiceUnavailable || !strings.Contains(body, "no leader yet") {
+		t.Fatalf("Expected /kv to be held back before a leader, got %d %q", code, body)
+	}
+	if code, body := getStatus(t, ts, "/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, `"ready":false`) {
+		t.Fatalf("Expected /healthz to report not ready, got %d %q", code, body)
+	}
+	for _, path := range []string{"/status", "/version", "/metrics"} {
+		if code, _ := getStatus(t, ts, path); code != http.StatusOK {
+			t.Fatalf("Expected %s to answer before a leader, got %d", path, code)
+		}
+	}
+
+	f := node.Raft().BootstrapCluster(raft.Configuration{Servers: []raft.Server{{ID: "node1", Address: raft.ServerAddress(addr)}}})
+	if err := f.Error(); err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	waitFor(t, 10*time.Second, "node1 to become leader", node.IsLeader)
+
+	if code, body := getStatus(t, ts, "/healthz"); code != http.StatusOK || !strings.Contains(body, `"ready":true`) {
+		t.Fatalf("Expected /healthz to report ready, got %d %q", code, body)
+	}
+	httpPut(t, ts, "k", "v")
+	if code, body := getStatus(t, ts, "/kv?key=k"); code != http.StatusOK || strings.TrimSpace(body) != "v" {
+		t.Fatalf("Expected the read to be served, got %d %q", code, body)
+	}
+}
+
+// TestStartupTimeoutOpensAPI checks the API stops waiting for a leader once
+// the startup timeout passes
+func TestStartupTimeoutOpensAPI(t *testing.T) {
+	ts, _, _ := startLeaderlessServer(t, 100*time.Millisecond)
+	if code, _ := getStatus(t, ts, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected /healthz to report not ready, got %d", code)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if code, _ := getStatus(t, ts, "/healthz"); code != http.StatusOK {
+		t.Fatalf("Expected /healthz to report ready after the timeout, got %d", code)
+	}
+	if _, body := getStatus(t, ts, "/kv?key=k"); strings.Contains(body, "no leader yet") {
+		t.Fatalf("Expected /kv to be served after the timeout, got %q", body)
+	}
+}
+
+// TestNoLeaderAnswers503 checks a node that knows no leader answers reads
+// and writes meant for the leader with 503 and Retry-After, not a 409 with
+// an empty leader hint, while stale reads are still served
+func TestNoLeaderAnswers503(t *testing.T) {
+	ts, _, _ := startLeaderlessServer(t, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	check := func(method, path string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader("v"))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" || !strings.Contains(string(body), "no leader known") {
+			t.Fatalf("Expected %s %s to get 503 with Retry-After, got %d %q", method, path, resp.StatusCode, body)
+		}
+	}
+	check(http.MethodGet, "/kv?key=k")
+	check(http.MethodGet, "/scan?prefix=k")
+	check(http.MethodGet, "/kv?key=k&read_index=true")
+	check(http.MethodPut, "/kv?key=k")
+	check(http.MethodDelete, "/kv?key=k")
+	check(http.MethodPost, "/txn")
+
+	if code, _ := getStatus(t, ts, "/kv?key=k&stale=true"); code != http.StatusNotFound {
+		t.Fatalf("Expected a stale read to be served locally, got %d", code)
+	}
+}
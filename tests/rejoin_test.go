@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+	"github.com/conuredb/conuredb/pkg/join"
+	"github.com/hashicorp/raft"
+)
+
+// TestRemovedNodeRejoins removes a follower from the configuration and
+// checks its rejoin loop adds it back, leaving the configuration alone
+// while it is still a member
+func TestRemovedNodeRejoins(t *testing.T) {
+	c := startTestCluster(t, 3)
+	li := c.leader(t)
+	leader := c.nodes[li]
+
+	mux := http.NewServeMux()
+	api.New(leader, c.dbs[li]).Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	configuration := func() (raft.Configuration, uint64) {
+		f := leader.Raft().GetConfiguration()
+		if err := f.Error(); err != nil {
+			t.Fatalf("Failed to get configuration: %v", err)
+		}
+		return f.Configuration(), f.Index()
+	}
+	member := func() bool {
+		cfg, _ := configuration()
+		for _, sv := range cfg.Servers {
+			if sv.ID == "node3" {
+				return true
+			}
+		}
+		return false
+	}
+
+	_, before := configuration()
+	stop := join.StartRejoin("node3", c.addrs[2], []string{ts.URL}, join.RejoinOptions{Interval: 50 * time.Millisecond})
+	t.Cleanup(stop)
+	time.Sleep(300 * time.Millisecond)
+	if _, after := configuration(); after != before {
+		t.Fatalf("Rejoin loop reconfigured a healthy cluster: index %d -> %d", before, after)
+	}
+
+	if err := leader.Raft().RemoveServer("node3", 0, 5*time.Second).Error(); err != nil {
+		t.Fatalf("Failed to remove node3: %v", err)
+	}
+	if member() {
+		t.Fatal("Expected node3 to be removed")
+	}
+	waitFor(t, 10*time.Second, "node3 to rejoin", member)
+
+	// The rejoined node receives writes again
+	c.put(t, "after-rejoin", "v")
+	waitFor(t, 10*time.Second, "node3 to apply a write", func() bool {
+		v, err := c.dbs[2].Get([]byte("after-rejoin"))
+		return err == nil && string(v) == "v"
+	})
+}
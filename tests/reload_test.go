@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestReloadDropsStaleCache has a writer rewrite and compact the file under a
+// read-only reader that cached every page, so page IDs the reader cached now
+// hold other nodes, and checks the reader sees the new data after Reload
+func TestReloadDropsStaleCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload.db")
+	writer, err := db.OpenWithOptions(path, db.Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := writer.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close writer: %v", closeErr)
+		}
+	}()
+
+	const n = 2000
+	putAll := func(value string) {
+		t.Helper()
+		ops := make([]btree.Op, 0, n)
+		for i := 0; i < n; i++ {
+			ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("key-%05d", i)), Value: []byte(value)})
+		}
+		if err := writer.Batch(ops, btree.BatchOptions{}); err != nil {
+			t.Fatalf("Batch failed: %v", err)
+		}
+		if _, err := writer.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+	}
+	putAll("old")
+
+	reader, err := db.OpenWithOptions(path, db.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close reader: %v", closeErr)
+		}
+	}()
+	if items, err := reader.Scan(nil, nil, 0); err != nil || len(items) != n {
+		t.Fatalf("Reader scanned %d keys (%v), want %d", len(items), err, n)
+	}
+
+	putAll("new")
+	if err := writer.Delete([]byte("key-00000")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := reader.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	items, err := reader.Scan(nil, nil, 0)
+	if err != nil {
+		t.Fatalf("Scan after reload failed: %v", err)
+	}
+	if len(items) != n-1 {
+		t.Fatalf("Reader sees %d keys after reload, want %d", len(items), n-1)
+	}
+	for _, it := range items {
+		if string(it.Value) != "new" {
+			t.Fatalf("Reader sees %s=%q after reload, want new", it.Key, it.Value)
+		}
+	}
+	if _, err := reader.Get([]byte("key-00000")); err == nil {
+		t.Fatal("Expected the deleted key to be gone after reload")
+	}
+}
+
+// TestSecondWriterRefused checks a file open for writing cannot be opened
+// for writing again until it is closed, while read-only opens still work
+func TestSecondWriterRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "writer.db")
+	first, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := first.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if _, err := db.Open(path); !errors.Is(err, btree.ErrLocked) {
+		t.Fatalf("Expected a second writer to fail with ErrLocked, got %v", err)
+	}
+	reader, err := db.OpenWithOptions(path, db.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Expected a read-only open to succeed, got %v", err)
+	}
+	if v, err := reader.Get([]byte("k")); err != nil || string(v) != "v" {
+		t.Fatalf("Reader got %q (%v), want v", v, err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Failed to close reader: %v", err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	second, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Expected to open the file once its writer closed, got %v", err)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+}
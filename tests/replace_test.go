@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"fmt"
+	"iter"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// generation yields n keys named prefix-NNNN, all holding value
+func generation(prefix string, n int, value string) iter.Seq2[[]byte, []byte] {
+	return func(yield func(key, value []byte) bool) {
+		for i := n - 1; i >= 0; i-- {
+			if !yield([]byte(fmt.Sprintf("%s-%04d", prefix, i)), []byte(value)) {
+				return
+			}
+		}
+	}
+}
+
+// TestReplaceAllUnderConcurrentReads swaps between two datasets while
+// readers scan, checking every scan sees one complete dataset
+func TestReplaceAllUnderConcurrentReads(t *testing.T) {
+	database := openTestDB(t, "replace.db")
+	for i := 0; i < 50; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("stale-%02d", i)), []byte("x")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := database.CreateBucket("gone"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if err := database.ReplaceAll(generation("a", 500, "A")); err != nil {
+		t.Fatalf("ReplaceAll failed: %v", err)
+	}
+
+	var stop atomic.Bool
+	var scans atomic.Int64
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				items, err := database.Scan(nil, nil, 0)
+				if err != nil {
+					t.Errorf("Scan failed: %v", err)
+					return
+				}
+				want, value, prefix := 500, "A", "a-"
+				if len(items) > 0 && items[0].Key[0] == 'b' {
+					want, value, prefix = 300, "B", "b-"
+				}
+				if len(items) != want {
+					t.Errorf("Scan saw %d keys, want %d of the %s dataset", len(items), want, value)
+					return
+				}
+				for _, it := range items {
+					if string(it.Key[:2]) != prefix || string(it.Value) != value {
+						t.Errorf("Scan mixed datasets: %q=%q among the %s dataset", it.Key, it.Value, value)
+						return
+					}
+				}
+				scans.Add(1)
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		next := generation("b", 300, "B")
+		if i%2 == 1 {
+			next = generation("a", 500, "A")
+		}
+		if err := database.ReplaceAll(next); err != nil {
+			t.Fatalf("ReplaceAll %d failed: %v", i, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop.Store(true)
+	wg.Wait()
+	if scans.Load() == 0 {
+		t.Fatal("No scan completed")
+	}
+
+	// The last swap installed dataset A alone
+	if _, err := database.Get([]byte("stale-00")); err == nil {
+		t.Fatal("Expected keys from before the first swap to be gone")
+	}
+	if names, err := database.Buckets(); err != nil || len(names) != 0 {
+		t.Fatalf("Expected no buckets after the swap, got %v (%v)", names, err)
+	}
+	if v, err := database.Get([]byte("a-0123")); err != nil || string(v) != "A" {
+		t.Fatalf("Expected a-0123=A, got %q (%v)", v, err)
+	}
+}
+
+// TestReplaceAllReplicates applies a CmdReplaceAll through raft and checks
+// every node ends up with exactly the new dataset
+func TestReplaceAllReplicates(t *testing.T) {
+	c := startTestCluster(t, 3)
+	c.put(t, "old", "1")
+
+	cmd := raftnode.Command{Type: raftnode.CmdReplaceAll}
+	for key, value := range generation("new", 100, "v") {
+		cmd.Ops = append(cmd.Ops, btree.Op{Key: key, Value: value})
+	}
+	applied, err := c.nodes[c.leader(t)].Apply(cmd, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to apply replace: %v", err)
+	}
+
+	for i, n := range c.nodes {
+		if !n.WaitApplied(applied.Index, 10*time.Second) {
+			t.Fatalf("%s did not apply the replace", c.ids[i])
+		}
+		items, err := c.dbs[i].Scan(nil, nil, 0)
+		if err != nil {
+			t.Fatalf("Scan on %s failed: %v", c.ids[i], err)
+		}
+		if len(items) != 100 || string(items[0].Key) != "new-0000" {
+			t.Fatalf("%s holds %d keys starting at %q, want the 100 new keys", c.ids[i], len(items), items[0].Key)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteReportsReplicas checks writes report their commit index and a
+// replica count between a quorum and the cluster size, and that once a
+// follower is down the count leaves it out
+func TestWriteReportsReplicas(t *testing.T) {
+	c := startTestCluster(t, 3)
+	leader := c.leader(t)
+	ts := c.serveNode(t, leader, 5*time.Second)
+
+	put := func(key string) (uint64, int) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key="+url.QueryEscape(key), strings.NewReader("v"))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT %s failed: %v", key, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT %s: expected 200, got %d", key, resp.StatusCode)
+		}
+		index, err := strconv.ParseUint(resp.Header.Get("X-Conure-Commit-Index"), 10, 64)
+		if err != nil || resp.Header.Get("X-Conure-Commit-Index") != resp.Header.Get("X-Raft-Index") {
+			t.Fatalf("PUT %s: bad X-Conure-Commit-Index %q", key, resp.Header.Get("X-Conure-Commit-Index"))
+		}
+		replicas, err := strconv.Atoi(resp.Header.Get("X-Conure-Replicas"))
+		if err != nil {
+			t.Fatalf("PUT %s: bad X-Conure-Replicas %q", key, resp.Header.Get("X-Conure-Replicas"))
+		}
+		return index, replicas
+	}
+
+	var last uint64
+	for i := 0; i < 10; i++ {
+		index, replicas := put(fmt.Sprintf("healthy-%d", i))
+		if index <= last {
+			t.Fatalf("Expected commit indexes to grow, got %d after %d", index, last)
+		}
+		last = index
+		if replicas < 2 || replicas > 3 {
+			t.Fatalf("Expected 2 or 3 replicas with every node up, got %d", replicas)
+		}
+	}
+
+	// Stop one follower; the other still makes a quorum with the leader
+	lagging := (leader + 1) % len(c.nodes)
+	if err := c.nodes[lagging].Shutdown(); err != nil {
+		t.Fatalf("Failed to stop %s: %v", c.ids[lagging], err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, replicas := put(fmt.Sprintf("degraded-%d", i)); replicas != 2 {
+			t.Fatalf("Expected 2 replicas with %s down, got %d", c.ids[lagging], replicas)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// TestReservedKeysRejected checks every path that takes keys from a client,
+// over HTTP and RESP, refuses those starting with a NUL byte, where bucket,
+// version, lease and shared value metadata lives, and leaves it untouched
+func TestReservedKeysRejected(t *testing.T) {
+	ts, database := startTestServer(t, func(s *api.Server) { s.WithAdminToken("ops") })
+	if err := database.CreateBucket("b"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	registry := "\x00bucket:b"
+	before, err := database.Get([]byte(registry))
+	if err != nil {
+		t.Fatalf("Expected the bucket registry entry: %v", err)
+	}
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer ops")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		out, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(out)
+	}
+	key := url.QueryEscape(registry)
+	requests := []struct{ method, path, body string }{
+		{http.MethodGet, "/kv?key=" + key, ""},
+		{http.MethodPut, "/kv?key=" + key, "forged"},
+		{http.MethodDelete, "/kv?key=" + key, ""},
+		{http.MethodPut, "/kv?bucket=b&key=" + url.QueryEscape("\x00x"), "v"},
+		{http.MethodGet, "/kv/next?key=" + key, ""},
+		{http.MethodGet, "/scan?prefix=" + url.QueryEscape("\x00"), ""},
+		{http.MethodPost, "/txn", `{"ops":[{"key":"\u0000bucket:b","value":"forged"}]}`},
+		{http.MethodPost, "/txn", `{"conds":[{"key":"\u0000lease:l","absent":true}],"ops":[{"key":"k","value":"v"}]}`},
+		{http.MethodPost, "/admin/replace", `{"items":[{"key":"\u0000bucket:b","value":"forged"}]}`},
+		{http.MethodPost, "/admin/import?mode=merge", "k,v\n\x00bucket:b,forged\n"},
+	}
+	for _, r := range requests {
+		if code, _ := do(r.method, r.path, r.body); code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected 400, got %d", r.method, r.path, code)
+		}
+	}
+	// the pipeline answers each frame with its own status
+	if _, out := do(http.MethodPost, "/kv/pipeline", `{"key":"\u0000lease:l","value":"forged"}`+"\n"); !strings.Contains(out, `"status":400`) {
+		t.Errorf("Pipeline frame with a reserved key: expected status 400, got %s", out)
+	}
+
+	node, respDB := startTestNode(t)
+	c := startRESP(t, node, respDB)
+	for _, args := range [][]string{
+		{"GET", registry},
+		{"SET", registry, "forged"},
+		{"DEL", "k", registry},
+		{"SCAN", "0", "MATCH", "\x00*"},
+	} {
+		if got := c.do(args...); !strings.HasPrefix(got, "-ERR") || !strings.Contains(got, "reserved") {
+			t.Errorf("RESP %q: expected a reserved key error, got %q", args[0], got)
+		}
+	}
+
+	after, err := database.Get([]byte(registry))
+	if err != nil || string(after) != string(before) {
+		t.Fatalf("Registry entry changed from %q to %q (%v)", before, after, err)
+	}
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// TestResetRaftStateRejoins gives a node raft state from a cluster of its
+// own, which would stop it joining another, resets it and checks the node
+// then joins the other cluster as a new member with its database kept
+func TestResetRaftStateRejoins(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "conure.db")
+	addr := freeRaftAddr(t)
+
+	database, err := db.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	node, err := raftnode.StartNode(raftnode.Config{
+		NodeID:    "node2",
+		RaftAddr:  addr,
+		DataDir:   dir,
+		Bootstrap: true,
+	}, &raftnode.FSM{DB: database})
+	if err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	waitFor(t, 10*time.Second, "the stray node to become leader", node.IsLeader)
+	cmd := raftnode.Command{Type: raftnode.CmdPut, Key: []byte("stray"), Value: []byte("kept")}
+	if _, err := node.Apply(cmd, 5*time.Second); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	// A running node's state is left alone
+	if _, err := raftnode.ResetState(dir, ""); !errors.Is(err, raftnode.ErrRaftStateInUse) {
+		t.Fatalf("Expected ErrRaftStateInUse while the node runs, got %v", err)
+	}
+	if err := node.Shutdown(); err != nil {
+		t.Fatalf("Failed to shut down node: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	c := startTestCluster(t, 1)
+	c.put(t, "k", "v")
+
+	aside, err := raftnode.ResetState(dir, "")
+	if err != nil {
+		t.Fatalf("ResetState failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "raft")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the raft directory to be gone, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(aside, "log.bolt")); err != nil {
+		t.Fatalf("Expected the old raft log under %s: %v", aside, err)
+	}
+	if _, err := raftnode.ResetState(dir, ""); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected a second reset to find no state, got %v", err)
+	}
+
+	database, err = db.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	if v, err := database.Get([]byte("stray")); err != nil || string(v) != "kept" {
+		t.Fatalf("Expected the database to survive the reset, got %q, %v", v, err)
+	}
+	node, err = raftnode.StartNode(raftnode.Config{
+		NodeID:   "node2",
+		RaftAddr: addr,
+		DataDir:  dir,
+	}, &raftnode.FSM{DB: database})
+	if err != nil {
+		t.Fatalf("Failed to restart node: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := node.Shutdown(); err != nil {
+			t.Logf("Warning: failed to shut down node2: %v", err)
+		}
+		if err := database.Close(); err != nil {
+			t.Logf("Warning: failed to close database for node2: %v", err)
+		}
+	})
+
+	if err := c.nodes[0].AddVoter("node2", addr); err != nil {
+		t.Fatalf("Failed to add node2 as voter: %v", err)
+	}
+	waitFor(t, 10*time.Second, "node2 to replicate the cluster's write", func() bool {
+		v, err := database.Get([]byte("k"))
+		return err == nil && string(v) == "v"
+	})
+	if !c.nodes[0].IsLeader() {
+		t.Fatal("Expected node1 to stay leader after node2 joined")
+	}
+}
+
+// TestResetRaftStateOfGroup resets one raft group's state in a data
+// directory it shares with the default group and checks only that group's
+// is moved aside, and that group IDs StartNode refuses are refused too
+func TestResetRaftStateOfGroup(t *testing.T) {
+	dir := t.TempDir()
+	node, _ := startGroupNode(t, dir, "g")
+	marker := filepath.Join(dir, "raft", "log.bolt")
+	if err := os.MkdirAll(filepath.Dir(marker), 0o755); err != nil {
+		t.Fatalf("Failed to create the default group's raft directory: %v", err)
+	}
+	if err := os.WriteFile(marker, nil, 0o600); err != nil {
+		t.Fatalf("Failed to write the default group's log: %v", err)
+	}
+
+	if _, err := raftnode.ResetState(dir, "g"); !errors.Is(err, raftnode.ErrRaftStateInUse) {
+		t.Fatalf("Expected ErrRaftStateInUse while the group runs, got %v", err)
+	}
+	if err := node.Shutdown(); err != nil {
+		t.Fatalf("Failed to shut down group: %v", err)
+	}
+	aside, err := raftnode.ResetState(dir, "g")
+	if err != nil {
+		t.Fatalf("ResetState failed: %v", err)
+	}
+	if !strings.HasPrefix(filepath.Base(aside), "raft-g.reset-") {
+		t.Fatalf("Expected the group's state moved to raft-g.reset-<time>, got %s", aside)
+	}
+	if _, err := os.Stat(filepath.Join(aside, "log.bolt")); err != nil {
+		t.Fatalf("Expected the group's old raft log under %s: %v", aside, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "raft-g")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the group's raft directory to be gone, got %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("Expected the default group's raft state left alone: %v", err)
+	}
+
+	for _, group := range []string{".", "..", "a/b", `a\b`} {
+		if _, err := raftnode.ResetState(dir, group); err == nil || errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Expected group ID %q refused, got %v", group, err)
+		}
+	}
+}
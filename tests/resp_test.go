@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+	"github.com/conuredb/conuredb/pkg/resp"
+)
+
+// respClient speaks raw RESP to a server
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startRESP serves RESP for node on a loopback port and connects to it
+func startRESP(t *testing.T, node *raftnode.Node, database *db.DB) *respClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := resp.New(node, database)
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			t.Errorf("RESP server failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		if closeErr := srv.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close RESP server: %v", closeErr)
+		}
+	})
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close RESP connection: %v", closeErr)
+		}
+	})
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &respClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send writes a command as a RESP array of bulk strings
+func (c *respClient) send(args ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatalf("Failed to send %v: %v", args, err)
+	}
+}
+
+// reply reads one reply and renders it as a string: arrays as [a b],
+// bulk strings bare, nulls as (nil) and the rest with their type byte
+func (c *respClient) reply() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Failed to read reply: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		var n int
+		fmt.Sscanf(line[1:], "%d", &n)
+		if n < 0 {
+			return "(nil)"
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatalf("Failed to read bulk string: %v", err)
+		}
+		return string(buf[:n])
+	case '*':
+		var n int
+		fmt.Sscanf(line[1:], "%d", &n)
+		items := make([]string, n)
+		for i := range items {
+			items[i] = c.reply()
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	return line
+}
+
+// do sends a command and returns its reply
+func (c *respClient) do(args ...string) string {
+	c.t.Helper()
+	c.send(args...)
+	return c.reply()
+}
+
+// TestRESPSetThenGet drives the RESP server with a raw client: SET and GET,
+// pipelined commands, DEL and a paged SCAN
+func TestRESPSetThenGet(t *testing.T) {
+	node, database := startTestNode(t)
+	c := startRESP(t, node, database)
+
+	if got := c.do("SET", "greeting", "hello world"); got != "+OK" {
+		t.Fatalf("Expected +OK from SET, got %q", got)
+	}
+	if got := c.do("GET", "greeting"); got != "hello world" {
+		t.Fatalf("Expected GET to return the value set, got %q", got)
+	}
+	if got := c.do("GET", "missing"); got != "(nil)" {
+		t.Fatalf("Expected a null reply for a missing key, got %q", got)
+	}
+	if got := c.do("SET", "k", "v", "EX", "10"); !strings.HasPrefix(got, "-ERR") {
+		t.Fatalf("Expected an error for unsupported SET options, got %q", got)
+	}
+
+	// Pipelined writes are answered in order
+	for i := 0; i < 5; i++ {
+		c.send("SET", fmt.Sprintf("user:%d", i), fmt.Sprintf("name-%d", i))
+	}
+	for i := 0; i < 5; i++ {
+		if got := c.reply(); got != "+OK" {
+			t.Fatalf("Expected +OK for pipelined SET %d, got %q", i, got)
+		}
+	}
+
+	if got := c.do("DEL", "user:0", "user:1", "missing"); got != ":2" {
+		t.Fatalf("Expected DEL to count the 2 keys that existed, got %q", got)
+	}
+
+	var keys []string
+	cursor := "0"
+	for page := 0; ; page++ {
+		got := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "2")
+		next, body, ok := strings.Cut(strings.TrimPrefix(got, "["), " [")
+		if !ok || !strings.HasSuffix(body, "]]") {
+			t.Fatalf("Unexpected SCAN reply %q", got)
+		}
+		keys = append(keys, strings.Fields(strings.TrimSuffix(body, "]]"))...)
+		cursor = next
+		if cursor == "0" {
+			break
+		}
+		if page > 5 {
+			t.Fatalf("SCAN did not finish, keys so far %v", keys)
+		}
+	}
+	if strings.Join(keys, ",") != "user:2,user:3,user:4" {
+		t.Fatalf("Expected SCAN to page through user:2..4, got %v", keys)
+	}
+
+	if got := c.do("PING"); got != "+PONG" {
+		t.Fatalf("Expected +PONG, got %q", got)
+	}
+	if got := c.do("FLUSHALL"); !strings.HasPrefix(got, "-ERR unknown command") {
+		t.Fatalf("Expected an unknown command error, got %q", got)
+	}
+}
+
+// TestRESPFollowerRedirectsToLeader checks a follower answers writes and
+// linearizable reads with MOVED, and serves reads after READONLY
+func TestRESPFollowerRedirectsToLeader(t *testing.T) {
+	c := startTestCluster(t, 2)
+	leader := c.leader(t)
+	follower := 1 - leader
+	c.put(t, "k", "v")
+	waitFor(t, 5*time.Second, "the follower to apply k", func() bool {
+		_, err := c.dbs[follower].Get([]byte("k"))
+		return err == nil
+	})
+
+	client := startRESP(t, c.nodes[follower], c.dbs[follower])
+	leaderHost, _, _ := net.SplitHostPort(c.addrs[leader])
+	if got := client.do("SET", "k", "w"); !strings.HasPrefix(got, "-MOVED 0 "+leaderHost+":") {
+		t.Fatalf("Expected a MOVED redirect to %s, got %q", leaderHost, got)
+	}
+	if got := client.do("GET", "k"); !strings.HasPrefix(got, "-MOVED ") {
+		t.Fatalf("Expected GET on a follower to redirect, got %q", got)
+	}
+	if got := client.do("READONLY"); got != "+OK" {
+		t.Fatalf("Expected +OK from READONLY, got %q", got)
+	}
+	if got := client.do("GET", "k"); got != "v" {
+		t.Fatalf("Expected a stale read after READONLY, got %q", got)
+	}
+}
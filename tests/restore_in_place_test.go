@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// loadRestoreDB fills database with n keys of 300-byte values tagged tag
+// and compacts it, so the file is about the size of its data
+func loadRestoreDB(t *testing.T, database *db.DB, n int, tag string) {
+	t.Helper()
+	ops := make([]btree.Op, 0, n)
+	for i := 0; i < n; i++ {
+		value := append([]byte(tag), bytes.Repeat([]byte{'v'}, 300)...)
+		ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("key-%06d", i)), Value: value})
+	}
+	if err := database.Batch(ops, btree.BatchOptions{}); err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	if _, err := database.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+}
+
+// dirBytes is the total size of the files in dir
+func dirBytes(t *testing.T, dir string) int64 {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to list %s: %v", dir, err)
+	}
+	var total int64
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// peakReader samples the size of dir on every read, keeping the largest
+type peakReader struct {
+	t    *testing.T
+	r    io.Reader
+	dir  string
+	peak int64
+}
+
+func (p *peakReader) Read(b []byte) (int, error) {
+	p.peak = max(p.peak, dirBytes(p.t, p.dir))
+	return p.r.Read(b)
+}
+
+// TestRestoreInPlaceNeedsNoCopy restores a snapshot of about 7 MB over a
+// database of the same size, staged and in place, and checks the staged
+// restore needs room for a second copy while the in-place one does not
+func TestRestoreInPlaceNeedsNoCopy(t *testing.T) {
+	const n = 20000
+	source := openTestDB(t, "source.db")
+	loadRestoreDB(t, source, n, "new")
+	var snap bytes.Buffer
+	if err := source.SnapshotTo(&snap); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+
+	extra := func(inPlace bool) int64 {
+		t.Helper()
+		dir := t.TempDir()
+		target, err := db.Open(filepath.Join(dir, "target.db"))
+		if err != nil {
+			t.Fatalf("Failed to open target: %v", err)
+		}
+		defer func() {
+			if closeErr := target.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close target: %v", closeErr)
+			}
+		}()
+		loadRestoreDB(t, target, n, "old")
+
+		before := dirBytes(t, dir)
+		r := &peakReader{t: t, r: bytes.NewReader(snap.Bytes()), dir: dir}
+		if err := target.RestoreFromWithOptions(r, db.RestoreOptions{InPlace: inPlace}); err != nil {
+			t.Fatalf("Restore (in place %v) failed: %v", inPlace, err)
+		}
+		r.peak = max(r.peak, dirBytes(t, dir))
+
+		for i := 0; i < n; i += 997 {
+			got, err := target.Get([]byte(fmt.Sprintf("key-%06d", i)))
+			if err != nil || !bytes.HasPrefix(got, []byte("new")) {
+				t.Fatalf("Expected restored key %d, got %.8q, %v", i, got, err)
+			}
+		}
+		if err := target.Verify(); err != nil {
+			t.Fatalf("Verify after restore failed: %v", err)
+		}
+		return r.peak - before
+	}
+
+	size := int64(snap.Len())
+	staged := extra(false)
+	inPlace := extra(true)
+	t.Logf("Snapshot %d bytes; peak extra disk %d staged, %d in place", size, staged, inPlace)
+	if staged < size*3/4 {
+		t.Fatalf("Expected a staged restore to need about a second copy (%d bytes), got %d", size, staged)
+	}
+	if inPlace > size/10 {
+		t.Fatalf("Expected an in-place restore to need little extra disk, got %d of %d", inPlace, size)
+	}
+}
+
+// TestRestoreInPlaceFailures checks a stream with a bad header is refused
+// before the database is touched, and a corrupt one partway through leaves
+// it empty but usable
+func TestRestoreInPlaceFailures(t *testing.T) {
+	source := openTestDB(t, "source.db")
+	loadRestoreDB(t, source, 500, "new")
+	var snap bytes.Buffer
+	if err := source.SnapshotTo(&snap); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	target := openTestDB(t, "target.db")
+	if err := target.Put([]byte("live"), []byte("data")); err != nil {
+		t.Fatalf("Failed to put live key: %v", err)
+	}
+	inPlace := db.RestoreOptions{InPlace: true}
+
+	garbage := bytes.Repeat([]byte{0xAB}, btree.HeaderSize*2)
+	if err := target.RestoreFromWithOptions(bytes.NewReader(garbage), inPlace); !errors.Is(err, btree.ErrInvalidMagicNumber) {
+		t.Fatalf("Expected ErrInvalidMagicNumber, got %v", err)
+	}
+	if got, err := target.Get([]byte("live")); err != nil || string(got) != "data" {
+		t.Fatalf("Live database damaged by a refused header: %q, %v", got, err)
+	}
+
+	corrupt := append([]byte(nil), snap.Bytes()...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	err := target.RestoreFromWithOptions(bytes.NewReader(corrupt), inPlace)
+	if !errors.Is(err, db.ErrRestoreIncomplete) || !errors.Is(err, db.ErrSnapshotChecksum) {
+		t.Fatalf("Expected ErrRestoreIncomplete with ErrSnapshotChecksum, got %v", err)
+	}
+	if _, err := target.Get([]byte("live")); err == nil {
+		t.Fatal("Expected the database to be left empty")
+	}
+	if err := target.Put([]byte("after"), []byte("failure")); err != nil {
+		t.Fatalf("Expected the emptied database to take writes, got %v", err)
+	}
+
+	if err := target.RestoreFromWithOptions(bytes.NewReader(snap.Bytes()), inPlace); err != nil {
+		t.Fatalf("Failed to restore intact snapshot: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		if _, err := target.Get([]byte(fmt.Sprintf("key-%06d", i))); err != nil {
+			t.Fatalf("Restored database missing key %d: %v", i, err)
+		}
+	}
+	if _, err := target.Get([]byte("after")); err == nil {
+		t.Fatal("Expected restore to replace the dataset")
+	}
+}
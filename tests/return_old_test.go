@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestPutReturningOldIsAtomic has writers race to replace one key; if each
+// read-modify-write is atomic, every value is replaced exactly once
+func TestPutReturningOldIsAtomic(t *testing.T) {
+	database := openTestDB(t, "old.db")
+
+	old, existed, err := database.PutReturningOld([]byte("counter"), []byte("init"))
+	if err != nil || existed || old != nil {
+		t.Fatalf("Expected no previous value, got %q, %v, %v", old, existed, err)
+	}
+
+	const writers, perWriter = 8, 50
+	var (
+		mu       sync.Mutex
+		replaced = make(map[string]int)
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				old, existed, err := database.PutReturningOld([]byte("counter"), []byte(fmt.Sprintf("w%d-%d", w, i)))
+				if err != nil || !existed {
+					t.Errorf("PutReturningOld failed: %v (existed=%v)", err, existed)
+					return
+				}
+				mu.Lock()
+				replaced[string(old)]++
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	final, err := database.Get([]byte("counter"))
+	if err != nil {
+		t.Fatalf("Failed to get final value: %v", err)
+	}
+	if len(replaced) != writers*perWriter || replaced["init"] != 1 {
+		t.Fatalf("Expected %d distinct replaced values, got %d", writers*perWriter, len(replaced))
+	}
+	for v, n := range replaced {
+		if n != 1 {
+			t.Fatalf("Value %q replaced %d times", v, n)
+		}
+	}
+	if replaced[string(final)] != 0 {
+		t.Fatalf("Final value %q was reported as replaced", final)
+	}
+
+	old, existed, err = database.DeleteReturningOld([]byte("counter"))
+	if err != nil || !existed || string(old) != string(final) {
+		t.Fatalf("DeleteReturningOld returned %q, %v, %v; want %q", old, existed, err, final)
+	}
+	if _, existed, err = database.DeleteReturningOld([]byte("counter")); err != nil || existed {
+		t.Fatalf("Expected second delete to find nothing, got existed=%v err=%v", existed, err)
+	}
+}
+
+// TestReturnOldQueryParam checks ?return=old replicates through raft and
+// reports the replaced value
+func TestReturnOldQueryParam(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+
+	type oldResp struct {
+		Existed bool    `json:"existed"`
+		Old     *string `json:"old"`
+	}
+	do := func(method, query, body string) oldResp {
+		req, err := http.NewRequest(method, ts.URL+"/kv?"+query, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close response body: %v", closeErr)
+			}
+		}()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status %d for %s %s", resp.StatusCode, method, query)
+		}
+		var out oldResp
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return out
+	}
+
+	if got := do(http.MethodPut, "key=k&return=old", "v1"); got.Existed || got.Old != nil {
+		t.Fatalf("Expected no previous value, got %+v", got)
+	}
+	for i := 2; i <= 3; i++ {
+		got := do(http.MethodPut, "key=k&return=old", "v"+strconv.Itoa(i))
+		if want := "v" + strconv.Itoa(i-1); !got.Existed || got.Old == nil || *got.Old != want {
+			t.Fatalf("Expected previous value %s, got %+v", want, got)
+		}
+	}
+	if got := do(http.MethodDelete, "key=k&return=old", ""); !got.Existed || got.Old == nil || *got.Old != "v3" {
+		t.Fatalf("Expected delete to return v3, got %+v", got)
+	}
+}
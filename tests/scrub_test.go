@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestScrubberFindsCorruptPage flips bytes in one live leaf on disk and
+// checks the background scrubber reports it with ErrPageChecksum within a
+// pass, without any read touching the key
+func TestScrubberFindsCorruptPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scrub.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for i := 0; i < 2000; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("value")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := database.Put([]byte("key-1000"), []byte("needle")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	i := bytes.Index(data, []byte("needle"))
+	if i < 0 {
+		t.Fatal("Expected the value on disk")
+	}
+	data[i] ^= 0xFF
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write corrupted file: %v", err)
+	}
+	page := btree.NodeID(i / btree.NodeSize)
+
+	database, err = db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+
+	found := make(chan btree.CorruptPage, 16)
+	stop := database.StartScrubber(db.ScrubOptions{
+		Interval:       time.Hour,
+		PagesPerSecond: 2000,
+		OnCorrupt:      func(p btree.CorruptPage) { found <- p },
+	})
+	defer stop()
+
+	select {
+	case got := <-found:
+		if got.ID != page || !errors.Is(got.Err, btree.ErrPageChecksum) {
+			t.Fatalf("Expected page %d to fail its checksum, got page %d: %v", page, got.ID, got.Err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the scrubber to report the corrupt page")
+	}
+	waitFor(t, 5*time.Second, "the scrub pass to finish", func() bool {
+		return database.ScrubStats().Passes == 1
+	})
+	stats := database.ScrubStats()
+	if stats.CorruptPages != 1 || stats.Pages < 10 {
+		t.Fatalf("Expected one corrupt page among the pages scrubbed, got %+v", stats)
+	}
+
+	// The rest of the tree is still readable
+	if v, err := database.Get([]byte("key-0001")); err != nil || string(v) != "value" {
+		t.Fatalf("Expected key-0001 to still read, got %q, %v", v, err)
+	}
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestSealPersistsAcrossOpen seals a database and checks a fresh open still
+// serves reads but refuses every kind of write
+func TestSealPersistsAcrossOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sealed.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("archived")); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	if err := database.Seal(); err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+	if err := database.Put([]byte("late"), []byte("v")); !errors.Is(err, btree.ErrSealed) {
+		t.Fatalf("Expected ErrSealed before reopen, got %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	database, err = db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen sealed database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close test database: %v", closeErr)
+		}
+	}()
+	if !database.Sealed() {
+		t.Fatal("Expected reopened database to be sealed")
+	}
+
+	for i := 0; i < 50; i++ {
+		if got, err := database.Get([]byte(fmt.Sprintf("key-%02d", i))); err != nil || string(got) != "archived" {
+			t.Fatalf("Failed to read key %d from sealed database: %q, %v", i, got, err)
+		}
+	}
+
+	writes := map[string]func() error{
+		"put":    func() error { return database.Put([]byte("key-00"), []byte("changed")) },
+		"delete": func() error { return database.Delete([]byte("key-01")) },
+		"batch": func() error {
+			return database.Batch([]btree.Op{{Key: []byte("new"), Value: []byte("v")}}, btree.BatchOptions{})
+		},
+		"compact": func() error { _, err := database.Compact(); return err },
+		"restore": func() error { return database.RestoreFrom(bytes.NewReader(nil)) },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, btree.ErrSealed) {
+			t.Fatalf("Expected %s to fail with ErrSealed, got %v", name, err)
+		}
+	}
+	if got, _ := database.Get([]byte("key-00")); string(got) != "archived" {
+		t.Fatalf("Sealed value changed to %q", got)
+	}
+}
+
+// TestUnknownHeaderFlagRefused sets a header flag this release does not
+// know, as a newer one might for a format change, and checks the file is
+// refused on open rather than misread
+func TestUnknownHeaderFlagRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "future.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := database.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	flags := data[btree.HeaderSize-4 : btree.HeaderSize]
+	binary.LittleEndian.PutUint32(flags, binary.LittleEndian.Uint32(flags)|1<<31)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, opts := range []db.Options{{}, {ReadOnly: true}} {
+		if database, err := db.OpenWithOptions(path, opts); !errors.Is(err, btree.ErrUnknownFlags) {
+			if err == nil {
+				_ = database.Close()
+			}
+			t.Fatalf("Expected ErrUnknownFlags opening with %+v, got %v", opts, err)
+		}
+	}
+}
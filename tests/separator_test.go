@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// longKey shares a long common suffix with every other key, so only its
+// first few bytes are needed to route to it
+func longKey(i int) []byte {
+	return []byte(fmt.Sprintf("%06d/", i) + strings.Repeat("x", 110))
+}
+
+// TestTruncatedSeparatorsRoute fills trees with long keys with and without
+// TruncateSeparators and checks internal pages hold short separators, the
+// tree is shallower or no deeper, and every key is still found
+func TestTruncatedSeparatorsRoute(t *testing.T) {
+	const n = 20000
+	order := rand.New(rand.NewSource(1)).Perm(n)
+
+	build := func(truncate bool) (*btree.BTree, btree.Stats) {
+		t.Helper()
+		tree, err := btree.NewBTreeWithOptions(filepath.Join(t.TempDir(), "sep.db"), btree.Options{NoSync: true, TruncateSeparators: truncate})
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		t.Cleanup(func() {
+			if closeErr := tree.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close tree: %v", closeErr)
+			}
+		})
+		ops := make([]btree.Op, 0, 500)
+		for _, i := range order {
+			ops = append(ops, btree.Op{Key: longKey(i), Value: []byte("v")})
+			if len(ops) == cap(ops) {
+				if err := tree.Batch(ops, btree.BatchOptions{}); err != nil {
+					t.Fatalf("Batch failed: %v", err)
+				}
+				ops = ops[:0]
+			}
+		}
+		// Deletes rebalance leaves, which picks new separators too
+		for i := 0; i < n; i += 3 {
+			if err := tree.Delete(longKey(i)); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+		}
+		if err := tree.Verify(); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		stats, err := tree.Stats(true)
+		if err != nil {
+			t.Fatalf("Stats failed: %v", err)
+		}
+		return tree, stats
+	}
+
+	full, fullStats := build(false)
+	short, shortStats := build(true)
+
+	longest := func(h btree.Histogram) int {
+		for i := len(h) - 1; i >= 0; i-- {
+			if h[i].Count > 0 {
+				return h[i].UpperBound
+			}
+		}
+		return 0
+	}
+	if got := longest(shortStats.SeparatorSizes); got > 8 {
+		t.Fatalf("Expected truncated separators of at most 8 bytes, got up to %d: %+v", got, shortStats.SeparatorSizes)
+	}
+	if got := longest(fullStats.SeparatorSizes); got < 64 {
+		t.Fatalf("Expected full-length separators without truncation, got up to %d", got)
+	}
+	if shortStats.InternalPages >= fullStats.InternalPages {
+		t.Fatalf("Expected fewer internal pages with truncation, got %d vs %d", shortStats.InternalPages, fullStats.InternalPages)
+	}
+
+	for i := 0; i < n; i++ {
+		for _, tree := range []*btree.BTree{full, short} {
+			_, err := tree.Get(longKey(i))
+			if deleted := i%3 == 0; deleted != (err != nil) {
+				t.Fatalf("Get %d: deleted=%v, got err %v", i, deleted, err)
+			}
+		}
+	}
+	// A key between two stored ones that shares the separator's prefix
+	if _, err := short.Get([]byte("000001/")); err == nil {
+		t.Fatal("Expected a prefix of a stored key not to be found")
+	}
+}
+
+// TestMaxSeparatorBytes fills trees with keys that share a 100-byte prefix
+// in groups of 16, so separators within a group are long and those between
+// groups short, and checks MaxSeparatorBytes moves splits to the group
+// boundaries. Keys sharing a prefix longer than the bound throughout must
+// still split, store full separators and be found.
+func TestMaxSeparatorBytes(t *testing.T) {
+	grouped := func(i int) []byte {
+		return []byte(fmt.Sprintf("%04d/%s%03d", i/16, strings.Repeat("x", 100), i%16))
+	}
+	sameHead := func(i int) []byte {
+		return []byte(strings.Repeat("p", 120) + fmt.Sprintf("%06d", i))
+	}
+	const n = 10000
+	order := rand.New(rand.NewSource(2)).Perm(n)
+
+	build := func(key func(int) []byte, bound int) btree.Stats {
+		t.Helper()
+		tree, err := btree.NewBTreeWithOptions(filepath.Join(t.TempDir(), "sep.db"), btree.Options{NoSync: true, TruncateSeparators: true, MaxSeparatorBytes: bound})
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		t.Cleanup(func() {
+			if closeErr := tree.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close tree: %v", closeErr)
+			}
+		})
+		ops := make([]btree.Op, 0, 500)
+		for _, i := range order {
+			ops = append(ops, btree.Op{Key: key(i), Value: []byte("v")})
+			if len(ops) == cap(ops) {
+				if err := tree.Batch(ops, btree.BatchOptions{}); err != nil {
+					t.Fatalf("Batch failed: %v", err)
+				}
+				ops = ops[:0]
+			}
+		}
+		if err := tree.Verify(); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			if v, err := tree.Get(key(i)); err != nil || string(v) != "v" {
+				t.Fatalf("Get %q: got %q (%v)", key(i), v, err)
+			}
+		}
+		stats, err := tree.Stats(true)
+		if err != nil {
+			t.Fatalf("Stats failed: %v", err)
+		}
+		return stats
+	}
+	longer := func(h btree.Histogram, size int) int {
+		count := 0
+		for _, b := range h {
+			if b.UpperBound > size {
+				count += b.Count
+			}
+		}
+		return count
+	}
+
+	unbounded := build(grouped, 0)
+	bounded := build(grouped, 16)
+	if longer(unbounded.SeparatorSizes, 16) == 0 {
+		t.Fatalf("Expected long separators without a bound: %+v", unbounded.SeparatorSizes)
+	}
+	if got := longer(bounded.SeparatorSizes, 16); got != 0 {
+		t.Fatalf("Expected no separator over 16 bytes, got %d: %+v", got, bounded.SeparatorSizes)
+	}
+	if bounded.InternalPages > unbounded.InternalPages {
+		t.Fatalf("Expected no more internal pages with the bound, got %d vs %d", bounded.InternalPages, unbounded.InternalPages)
+	}
+
+	// No split point helps: separators stay full length and pages still fit
+	fallback := build(sameHead, 16)
+	if longer(fallback.SeparatorSizes, 64) == 0 {
+		t.Fatalf("Expected full-length separators when every key shares the prefix: %+v", fallback.SeparatorSizes)
+	}
+	if fallback.Depth > 4 {
+		t.Fatalf("Expected a tree of at most 4 levels, got %d", fallback.Depth)
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// memSink collects a persisted raft snapshot in memory
+type memSink struct {
+	bytes.Buffer
+	cancelled bool
+}
+
+func (s *memSink) ID() string    { return "test" }
+func (s *memSink) Close() error  { return nil }
+func (s *memSink) Cancel() error { s.cancelled = true; return nil }
+
+// persistSnapshot snapshots fsm's database the way raft would
+func persistSnapshot(t *testing.T, fsm *raftnode.FSM) []byte {
+	t.Helper()
+	snap, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	sink := &memSink{}
+	if err := snap.Persist(sink); err != nil || sink.cancelled {
+		t.Fatalf("Failed to persist snapshot: %v", err)
+	}
+	return sink.Bytes()
+}
+
+// TestSnapshotCompressionRoundTrips persists the same database with and
+// without gzip and restores each into FSMs set either way, so old
+// uncompressed snapshots keep restoring after compression is enabled
+func TestSnapshotCompressionRoundTrips(t *testing.T) {
+	source := openTestDB(t, "source.db")
+	for i := 0; i < 500; i++ {
+		if err := source.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+
+	plain := persistSnapshot(t, &raftnode.FSM{DB: source})
+	gzipped := persistSnapshot(t, &raftnode.FSM{DB: source, SnapshotCompression: raftnode.SnapshotCompressionGzip})
+	if len(gzipped)*4 > len(plain) {
+		t.Fatalf("Expected gzip to shrink the snapshot at least 4x: %d -> %d bytes", len(plain), len(gzipped))
+	}
+
+	for _, tc := range []struct {
+		name    string
+		snap    []byte
+		setting raftnode.SnapshotCompression
+	}{
+		{"plain into none", plain, raftnode.SnapshotCompressionNone},
+		{"plain into gzip", plain, raftnode.SnapshotCompressionGzip},
+		{"gzip into gzip", gzipped, raftnode.SnapshotCompressionGzip},
+		{"gzip into none", gzipped, raftnode.SnapshotCompressionNone},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			target := openTestDB(t, "target.db")
+			if err := target.Put([]byte("stale"), []byte("gone after restore")); err != nil {
+				t.Fatalf("Failed to put: %v", err)
+			}
+			fsm := &raftnode.FSM{DB: target, SnapshotCompression: tc.setting}
+			if err := fsm.Restore(io.NopCloser(bytes.NewReader(tc.snap))); err != nil {
+				t.Fatalf("Failed to restore: %v", err)
+			}
+			for _, i := range []int{0, 250, 499} {
+				got, err := target.Get([]byte(fmt.Sprintf("key-%04d", i)))
+				if err != nil || string(got) != "value" {
+					t.Fatalf("key-%04d after restore: %q, %v", i, got, err)
+				}
+			}
+			if _, err := target.Get([]byte("stale")); err == nil {
+				t.Fatalf("Restore kept a key the snapshot does not have")
+			}
+		})
+	}
+}
+
+// TestUnknownSnapshotCompressionRejected checks config validation
+func TestUnknownSnapshotCompressionRejected(t *testing.T) {
+	if c, err := raftnode.ParseSnapshotCompression(""); err != nil || c != raftnode.SnapshotCompressionNone {
+		t.Fatalf("Expected empty to mean none, got %q, %v", c, err)
+	}
+	if _, err := raftnode.ParseSnapshotCompression("snappy"); err == nil {
+		t.Fatalf("Expected an unsupported compression to be rejected")
+	}
+}
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/conuredb/conuredb/db"
+)
+
+// openTestDB opens a fresh database in a temp dir, closed on cleanup
+func openTestDB(t *testing.T, name string) *db.DB {
+	t.Helper()
+	database, err := db.Open(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close test database: %v", closeErr)
+		}
+	})
+	return database
+}
+
+// TestRestoreRejectsCorruptSnapshot flips a byte in a snapshot stream and
+// checks restore refuses it while the live database stays intact
+func TestRestoreRejectsCorruptSnapshot(t *testing.T) {
+	source := openTestDB(t, "source.db")
+	for i := 0; i < 100; i++ {
+		if err := source.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("snapshot")); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	var snap bytes.Buffer
+	if err := source.SnapshotTo(&snap); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+
+	target := openTestDB(t, "target.db")
+	if err := target.Put([]byte("live"), []byte("data")); err != nil {
+		t.Fatalf("Failed to put live key: %v", err)
+	}
+
+	corrupt := append([]byte(nil), snap.Bytes()...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if err := target.RestoreFrom(bytes.NewReader(corrupt)); !errors.Is(err, db.ErrSnapshotChecksum) {
+		t.Fatalf("Expected ErrSnapshotChecksum, got %v", err)
+	}
+	truncated := snap.Bytes()[:snap.Len()-100]
+	if err := target.RestoreFrom(bytes.NewReader(truncated)); !errors.Is(err, db.ErrSnapshotNoChecksum) {
+		t.Fatalf("Expected ErrSnapshotNoChecksum, got %v", err)
+	}
+	if got, err := target.Get([]byte("live")); err != nil || string(got) != "data" {
+		t.Fatalf("Live database damaged by rejected restore: %q, %v", got, err)
+	}
+
+	if err := target.RestoreFrom(bytes.NewReader(snap.Bytes())); err != nil {
+		t.Fatalf("Failed to restore intact snapshot: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := target.Get([]byte(fmt.Sprintf("key-%03d", i))); err != nil {
+			t.Fatalf("Restored database missing key %d: %v", i, err)
+		}
+	}
+	if _, err := target.Get([]byte("live")); err == nil {
+		t.Fatal("Expected restore to replace the live dataset")
+	}
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+)
+
+// splitTestItemSize is the serialized size of an item in a page
+func splitTestItemSize(it btree.Item) int {
+	return 2 + len(it.Key) + 4 + len(it.Value)
+}
+
+// splitTestLeaves closes tree and returns the items of each of its leaves,
+// in key order, read back from pages
+func splitTestLeaves(t *testing.T, tree *btree.BTree, pages btree.PageStore) [][]btree.Item {
+	t.Helper()
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Failed to close tree: %v", err)
+	}
+	storage, err := btree.OpenStorageWithStore(pages, btree.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer func() {
+		if closeErr := storage.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close storage: %v", closeErr)
+		}
+	}()
+
+	root, err := storage.GetRootNode()
+	if err != nil {
+		t.Fatalf("Failed to read root: %v", err)
+	}
+	var leaves [][]btree.Item
+	var walk func(node *btree.Node)
+	walk = func(node *btree.Node) {
+		if node.Type() == btree.LeafNode {
+			leaves = append(leaves, node.Items())
+			return
+		}
+		for _, id := range node.Children() {
+			child, err := storage.GetNode(id)
+			if err != nil {
+				t.Fatalf("Failed to read page %d: %v", id, err)
+			}
+			walk(child)
+		}
+	}
+	walk(root)
+	for i, leaf := range leaves {
+		size := btree.NodeHeaderSize
+		for _, it := range leaf {
+			size += splitTestItemSize(it)
+		}
+		if size > btree.MaxNodeBytes {
+			t.Fatalf("Leaf %d holds %d bytes, more than a page", i, size)
+		}
+	}
+	return leaves
+}
+
+// TestSplitByBytesBalancesSizes fills one leaf with 100 small values followed
+// by large ones until it splits, and checks a count split halves the items
+// while a byte split halves the bytes
+func TestSplitByBytesBalancesSizes(t *testing.T) {
+	for _, strategy := range []btree.SplitStrategy{btree.SplitByCount, btree.SplitByBytes} {
+		pages := btree.NewMemPageStore()
+		// A fill factor of 0.5 leaves ascending inserts to the strategy
+		tree, err := btree.NewBTreeWithStore(pages, btree.Options{AppendFillFactor: 0.5, SplitStrategy: strategy})
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		for i := 0; i < 110; i++ {
+			size := 10
+			if i >= 100 {
+				size = 200
+			}
+			if err := tree.Put([]byte(fmt.Sprintf("k-%03d", i)), bytes.Repeat([]byte{'v'}, size)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+
+		leaves := splitTestLeaves(t, tree, pages)
+		if len(leaves) != 2 {
+			t.Fatalf("Strategy %d: expected one split into 2 leaves, got %d", strategy, len(leaves))
+		}
+		var sizes [2]int
+		for i, leaf := range leaves {
+			for _, it := range leaf {
+				sizes[i] += splitTestItemSize(it)
+			}
+		}
+		switch strategy {
+		case btree.SplitByCount:
+			if len(leaves[0]) != 55 || len(leaves[1]) != 55 {
+				t.Fatalf("Expected a count split to leave 55 items each side, got %d and %d", len(leaves[0]), len(leaves[1]))
+			}
+		case btree.SplitByBytes:
+			// No split point can do better than one item's size
+			if diff := sizes[0] - sizes[1]; diff < -211 || diff > 211 {
+				t.Fatalf("Expected a byte split to balance sizes, got %d and %d bytes", sizes[0], sizes[1])
+			}
+			if len(leaves[0]) != 100 {
+				t.Fatalf("Expected a byte split to keep the 100 small values left, got %d", len(leaves[0]))
+			}
+		}
+	}
+}
+
+// TestSplitAppendPacksInterleavedSequences appends timestamps under 20
+// device prefixes in turn, and checks SplitAppend packs the leaves well
+// past the half-full ones SplitByCount leaves
+func TestSplitAppendPacksInterleavedSequences(t *testing.T) {
+	const devices, points = 20, 500
+
+	leafCount := func(strategy btree.SplitStrategy) int {
+		t.Helper()
+		pages := btree.NewMemPageStore()
+		tree, err := btree.NewBTreeWithStore(pages, btree.Options{SplitStrategy: strategy})
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		for ts := 0; ts < points; ts++ {
+			for d := 0; d < devices; d++ {
+				key := []byte(fmt.Sprintf("dev-%02d/%06d", d, ts))
+				if err := tree.Put(key, bytes.Repeat([]byte{byte(ts)}, 50)); err != nil {
+					t.Fatalf("Put failed: %v", err)
+				}
+			}
+		}
+		for d := 0; d < devices; d += 7 {
+			key := []byte(fmt.Sprintf("dev-%02d/%06d", d, points-1))
+			if _, err := tree.Get(key); err != nil {
+				t.Fatalf("Get %s failed: %v", key, err)
+			}
+		}
+
+		leaves := splitTestLeaves(t, tree, pages)
+		total := 0
+		for _, leaf := range leaves {
+			total += len(leaf)
+		}
+		if total != devices*points {
+			t.Fatalf("Strategy %d: expected %d items in the leaves, got %d", strategy, devices*points, total)
+		}
+		return len(leaves)
+	}
+
+	byCount := leafCount(btree.SplitByCount)
+	appended := leafCount(btree.SplitAppend)
+	t.Logf("Leaves: %d split by count, %d split as appends", byCount, appended)
+	if appended*4 > byCount*3 {
+		t.Fatalf("Expected SplitAppend to need at most 75%% of the %d leaves split by count, got %d", byCount, appended)
+	}
+}
+
+// TestSplitStrategiesRandomInserts inserts keys in random order with values
+// of widely varying size under each strategy and checks every page is valid
+// and every key reads back
+func TestSplitStrategiesRandomInserts(t *testing.T) {
+	for _, strategy := range []btree.SplitStrategy{btree.SplitByCount, btree.SplitByBytes, btree.SplitAppend} {
+		pages := btree.NewMemPageStore()
+		tree, err := btree.NewBTreeWithStore(pages, btree.Options{SplitStrategy: strategy})
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		rng := rand.New(rand.NewSource(int64(strategy)))
+		want := make(map[string]int)
+		for _, i := range rng.Perm(5000) {
+			key := fmt.Sprintf("key-%05d", i)
+			size := 1 + rng.Intn(20)
+			if i%10 == 0 {
+				size = 200 + rng.Intn(btree.MaxValueSize-200)
+			}
+			if err := tree.Put([]byte(key), bytes.Repeat([]byte{'v'}, size)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			want[key] = size
+		}
+		for key, size := range want {
+			value, err := tree.Get([]byte(key))
+			if err != nil || len(value) != size {
+				t.Fatalf("Strategy %d: expected %s to read %d bytes, got %d, %v", strategy, key, size, len(value), err)
+			}
+		}
+		if leaves := splitTestLeaves(t, tree, pages); len(leaves) < 2 {
+			t.Fatalf("Strategy %d: expected the tree to split, got %d leaves", strategy, len(leaves))
+		}
+	}
+}
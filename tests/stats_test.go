@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestStatsSizeHistograms loads keys and values with known sizes and checks
+// that the full stats traversal buckets them by power of two
+func TestStatsSizeHistograms(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "stats.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close test database: %v", closeErr)
+		}
+	}()
+
+	// 100 keys of 8 bytes, 50 keys of 20 bytes; values of 0, 3, 64 and 1000 bytes
+	valueSizes := []int{0, 3, 64, 1000}
+	for i := 0; i < 150; i++ {
+		key := fmt.Sprintf("k%07d", i)
+		if i >= 100 {
+			key = fmt.Sprintf("long-key-%011d", i)
+		}
+		value := bytes.Repeat([]byte("v"), valueSizes[i%len(valueSizes)])
+		if err := database.Put([]byte(key), value); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+
+	shallow, err := database.Stats(false)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if shallow.Full || shallow.Keys != 0 || shallow.KeySizes != nil {
+		t.Fatalf("Expected no traversal without full, got %+v", shallow)
+	}
+
+	stats, err := database.Stats(true)
+	if err != nil {
+		t.Fatalf("Failed to get full stats: %v", err)
+	}
+	if stats.Keys != 150 {
+		t.Fatalf("Expected 150 keys, got %d", stats.Keys)
+	}
+	if stats.LeafPages < 2 || stats.Depth < 2 {
+		t.Fatalf("Expected a multi-level tree, got depth %d with %d leaves", stats.Depth, stats.LeafPages)
+	}
+
+	counts := func(h btree.Histogram) map[int]int {
+		m := make(map[int]int)
+		for _, b := range h {
+			if b.Count > 0 {
+				m[b.UpperBound] = b.Count
+			}
+		}
+		return m
+	}
+	assertBuckets := func(name string, h btree.Histogram, want map[int]int) {
+		t.Helper()
+		got := counts(h)
+		if len(got) != len(want) {
+			t.Fatalf("%s buckets: expected %v, got %v", name, want, got)
+		}
+		for le, n := range want {
+			if got[le] != n {
+				t.Fatalf("%s bucket le=%d: expected %d, got %d (all %v)", name, le, n, got[le], got)
+			}
+		}
+	}
+	assertBuckets("key", stats.KeySizes, map[int]int{8: 100, 32: 50})
+	// 0 -> le 1, 3 -> le 4, 64 -> le 64, 1000 -> le 1024; 150 keys split 38/38/37/37
+	assertBuckets("value", stats.ValueSizes, map[int]int{1: 38, 4: 38, 64: 37, 1024: 37})
+}
+
+// TestStatsEndpoint checks /stats only traverses the tree when asked to
+func TestStatsEndpoint(t *testing.T) {
+	ts, database := startTestServer(t, nil)
+	for i := 0; i < 10; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put entry %d: %v", i, err)
+		}
+	}
+
+	get := func(path string) btree.Stats {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Stats request failed: %v", err)
+		}
+		defer func() {
+			if closeErr := resp.Body.Close(); closeErr != nil {
+				t.Logf("Warning: failed to close response body: %v", closeErr)
+			}
+		}()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected stats status: %d", resp.StatusCode)
+		}
+		var stats btree.Stats
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			t.Fatalf("Failed to decode stats: %v", err)
+		}
+		return stats
+	}
+
+	if stats := get("/stats"); stats.Full || stats.PageCount == 0 {
+		t.Fatalf("Unexpected shallow stats: %+v", stats)
+	}
+	stats := get("/stats?full=true")
+	if !stats.Full || stats.Keys != 10 || len(stats.ValueSizes) == 0 {
+		t.Fatalf("Unexpected full stats: %+v", stats)
+	}
+}
+
+// TestNodeCacheHitsAndMisses reopens a database so the cache is empty, then
+// reads one key twice: the first lookup misses on every page on its path and
+// the second is served entirely from the cache
+func TestNodeCacheHitsAndMisses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for i := 0; i < 2000; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put entry %d: %v", i, err)
+		}
+	}
+	full, err := database.Stats(true)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if full.Depth < 2 {
+		t.Fatalf("Expected a tree at least two levels deep, got %d", full.Depth)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	database, err = db.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+
+	counts := func() (uint64, uint64) {
+		t.Helper()
+		stats, err := database.Stats(false)
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		return stats.CacheHits, stats.CacheMisses
+	}
+	depth := uint64(full.Depth)
+
+	hits0, misses0 := counts()
+	if _, err := database.Get([]byte("key-01234")); err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	hits1, misses1 := counts()
+	if hits1 != hits0 || misses1-misses0 != depth {
+		t.Fatalf("First read: expected no hits and %d misses, got %d and %d", depth, hits1-hits0, misses1-misses0)
+	}
+
+	if _, err := database.Get([]byte("key-01234")); err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	hits2, misses2 := counts()
+	if hits2-hits1 != depth || misses2 != misses1 {
+		t.Fatalf("Second read: expected %d hits and no misses, got %d and %d", depth, hits2-hits1, misses2-misses1)
+	}
+}
+
+// TestCacheMetricsExported checks /metrics carries the cache counters and
+// the dirty page gauge
+func TestCacheMetricsExported(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Metrics request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	for _, want := range []string{"# TYPE conure_node_cache_hits_total counter", "conure_node_cache_misses_total ", "# TYPE conure_dirty_pages gauge", "conure_dirty_spills_total "} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("Expected %q in metrics, got:\n%s", want, body)
+		}
+	}
+}
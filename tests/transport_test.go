@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/db"
+	"github.com/conuredb/conuredb/pkg/raftnode"
+)
+
+// TestAdvertiseAddressDistinctFromBind binds every interface and checks the
+// advertised name, not the bind address, lands in the raft configuration
+func TestAdvertiseAddressDistinctFromBind(t *testing.T) {
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "conure.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	_, port, err := net.SplitHostPort(freeRaftAddr(t))
+	if err != nil {
+		t.Fatalf("Failed to parse address: %v", err)
+	}
+	advertise := net.JoinHostPort("localhost", port)
+
+	node, err := raftnode.StartNode(raftnode.Config{
+		NodeID:           "node1",
+		RaftAddr:         net.JoinHostPort("0.0.0.0", port),
+		AdvertiseAddr:    advertise,
+		DataDir:          dir,
+		Bootstrap:        true,
+		MaxPool:          5,
+		TransportTimeout: 2 * time.Second,
+	}, &raftnode.FSM{DB: database})
+	if err != nil {
+		t.Fatalf("Failed to start raft node: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := node.Shutdown(); err != nil {
+			t.Logf("Warning: failed to shut down raft node: %v", err)
+		}
+		if err := database.Close(); err != nil {
+			t.Logf("Warning: failed to close database: %v", err)
+		}
+	})
+	waitFor(t, 10*time.Second, "node1 to become leader", node.IsLeader)
+
+	f := node.Raft().GetConfiguration()
+	if err := f.Error(); err != nil {
+		t.Fatalf("Failed to get configuration: %v", err)
+	}
+	servers := f.Configuration().Servers
+	if len(servers) != 1 || string(servers[0].Address) != advertise {
+		t.Fatalf("Expected configuration to advertise %s, got %+v", advertise, servers)
+	}
+	if leader := string(node.Leader()); leader != advertise {
+		t.Fatalf("Expected leader address %s, got %s", advertise, leader)
+	}
+}
+
+// TestUnadvertisableBindAddressRejected refuses to bind 0.0.0.0 without an
+// advertise address, since peers could never dial it
+func TestUnadvertisableBindAddressRejected(t *testing.T) {
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "conure.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close test database: %v", closeErr)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(freeRaftAddr(t))
+	_, err = raftnode.StartNode(raftnode.Config{
+		NodeID:   "node1",
+		RaftAddr: net.JoinHostPort("0.0.0.0", port),
+		DataDir:  dir,
+	}, &raftnode.FSM{DB: database})
+	if err == nil {
+		t.Fatal("Expected an error for an unadvertisable raft address")
+	}
+}
+
+// TestRaftTimeoutsApplied starts a node with WAN-style timeouts and checks
+// raft runs with them, and that an inconsistent lease is rejected
+func TestRaftTimeoutsApplied(t *testing.T) {
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "conure.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	cfg := raftnode.Config{
+		NodeID:             "node1",
+		RaftAddr:           freeRaftAddr(t),
+		DataDir:            dir,
+		Bootstrap:          true,
+		HeartbeatTimeout:   1500 * time.Millisecond,
+		ElectionTimeout:    2 * time.Second,
+		LeaderLeaseTimeout: 750 * time.Millisecond,
+		CommitTimeout:      100 * time.Millisecond,
+	}
+
+	bad := cfg
+	bad.LeaderLeaseTimeout = 2 * time.Second
+	if _, err := raftnode.StartNode(bad, &raftnode.FSM{DB: database}); err == nil {
+		t.Fatal("Expected a lease timeout above the heartbeat timeout to be rejected")
+	}
+
+	node, err := raftnode.StartNode(cfg, &raftnode.FSM{DB: database})
+	if err != nil {
+		t.Fatalf("Failed to start raft node: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := node.Shutdown(); err != nil {
+			t.Logf("Warning: failed to shut down raft node: %v", err)
+		}
+		if err := database.Close(); err != nil {
+			t.Logf("Warning: failed to close database: %v", err)
+		}
+	})
+
+	rc := node.RaftConfig()
+	if rc.HeartbeatTimeout != cfg.HeartbeatTimeout || rc.ElectionTimeout != cfg.ElectionTimeout ||
+		rc.LeaderLeaseTimeout != cfg.LeaderLeaseTimeout || rc.CommitTimeout != cfg.CommitTimeout {
+		t.Fatalf("Timeouts not applied: %+v", rc)
+	}
+	live := node.Raft().ReloadableConfig()
+	if live.HeartbeatTimeout != cfg.HeartbeatTimeout || live.ElectionTimeout != cfg.ElectionTimeout {
+		t.Fatalf("Raft is running with heartbeat %v and election %v", live.HeartbeatTimeout, live.ElectionTimeout)
+	}
+}
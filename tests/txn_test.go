@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// txnPost sends a /txn request and reports whether it succeeded
+func txnPost(baseURL string, body any) (bool, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.Post(baseURL+"/txn", "application/json", bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("status %d: %s", resp.StatusCode, msg)
+	}
+	var out struct {
+		Succeeded bool   `json:"succeeded"`
+		Index     uint64 `json:"index"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, err
+	}
+	return out.Succeeded, nil
+}
+
+// getBalance reads an account through a linearizable GET
+func getBalance(baseURL, key string) (int, error) {
+	resp, err := http.Get(baseURL + "/kv?key=" + url.QueryEscape(key))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("GET %s: status %d", key, resp.StatusCode)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(body)))
+}
+
+// TestTxnTransfersKeepInvariant moves funds between two accounts from several
+// goroutines at once. Each transfer reads both balances and commits only if
+// neither changed meanwhile; the total must never change and no balance may
+// go negative.
+func TestTxnTransfersKeepInvariant(t *testing.T) {
+	ts, database := startTestServer(t, nil)
+	const total = 1000
+	accounts := []string{"acct:alice", "acct:bob"}
+
+	ok, err := txnPost(ts.URL, map[string]any{
+		"conds": []map[string]any{{"key": accounts[0], "absent": true}, {"key": accounts[1], "absent": true}},
+		"ops":   []map[string]any{{"key": accounts[0], "value": strconv.Itoa(total)}, {"key": accounts[1], "value": "0"}},
+	})
+	if err != nil || !ok {
+		t.Fatalf("Failed to open accounts: ok=%v err=%v", ok, err)
+	}
+
+	const workers, transfers = 4, 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	conflicts := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Even workers pay alice to bob, odd ones bob to alice
+			from, to := accounts[w%2], accounts[1-w%2]
+			for done := 0; done < transfers; {
+				fromBal, err := getBalance(ts.URL, from)
+				if err != nil {
+					t.Errorf("Worker %d: %v", w, err)
+					return
+				}
+				toBal, err := getBalance(ts.URL, to)
+				if err != nil {
+					t.Errorf("Worker %d: %v", w, err)
+					return
+				}
+				amount := 7
+				if fromBal < amount {
+					amount = fromBal
+				}
+				ok, err := txnPost(ts.URL, map[string]any{
+					"conds": []map[string]any{
+						{"key": from, "value": strconv.Itoa(fromBal)},
+						{"key": to, "value": strconv.Itoa(toBal)},
+					},
+					"ops": []map[string]any{
+						{"key": from, "value": strconv.Itoa(fromBal - amount)},
+						{"key": to, "value": strconv.Itoa(toBal + amount)},
+					},
+				})
+				if err != nil {
+					t.Errorf("Worker %d: txn failed: %v", w, err)
+					return
+				}
+				if !ok {
+					mu.Lock()
+					conflicts++
+					mu.Unlock()
+					continue
+				}
+				done++
+			}
+		}(w)
+	}
+
+	// Check the invariant on consistent snapshots while transfers run
+	stop := make(chan struct{})
+	checked := make(chan struct{})
+	go func() {
+		defer close(checked)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			items, err := database.Scan([]byte("acct:"), nil, 0)
+			if err != nil {
+				t.Errorf("Scan failed: %v", err)
+				return
+			}
+			sum := 0
+			for _, it := range items {
+				n, err := strconv.Atoi(string(it.Value))
+				if err != nil || n < 0 {
+					t.Errorf("Bad balance %q for %s", it.Value, it.Key)
+					return
+				}
+				sum += n
+			}
+			if sum != total {
+				t.Errorf("Invariant broken: balances sum to %d, want %d", sum, total)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	<-checked
+
+	alice, err := getBalance(ts.URL, accounts[0])
+	if err != nil {
+		t.Fatalf("Failed to read final balance: %v", err)
+	}
+	bob, err := getBalance(ts.URL, accounts[1])
+	if err != nil {
+		t.Fatalf("Failed to read final balance: %v", err)
+	}
+	if alice+bob != total {
+		t.Fatalf("Final balances %d + %d != %d", alice, bob, total)
+	}
+	t.Logf("Final balances alice=%d bob=%d after %d conflicting attempts", alice, bob, conflicts)
+}
+
+// TestTxnLimitsRejectOversized checks /txn refuses requests over the op
+// count, body size and raft command limits with 413 and applies nothing
+func TestTxnLimitsRejectOversized(t *testing.T) {
+	ts, database := startTestServer(t, func(s *api.Server) { s.WithTxnLimits(10, 1<<24) })
+	ops := func(n int, value string) map[string]any {
+		list := make([]map[string]any, n)
+		for i := range list {
+			list[i] = map[string]any{"key": fmt.Sprintf("k%d", i), "value": value}
+		}
+		return map[string]any{"ops": list}
+	}
+
+	_, err := txnPost(ts.URL, ops(11, "v"))
+	if err == nil || !strings.Contains(err.Error(), "status 413") || !strings.Contains(err.Error(), "11 conditions and ops, limit is 10") {
+		t.Fatalf("Expected 413 naming the op limit, got %v", err)
+	}
+	// Base64 in the raft command takes the value past 8 MiB
+	_, err = txnPost(ts.URL, ops(1, strings.Repeat("x", 7<<20)))
+	if err == nil || !strings.Contains(err.Error(), "status 413") || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("Expected 413 for a command over the raft entry limit, got %v", err)
+	}
+	if _, err := database.Get([]byte("k0")); err == nil {
+		t.Fatal("Expected a refused txn to apply nothing")
+	}
+	if ok, err := txnPost(ts.URL, ops(10, "v")); err != nil || !ok {
+		t.Fatalf("Expected a txn at the limit to apply, got %v, %v", ok, err)
+	}
+
+	small, _ := startTestServer(t, func(s *api.Server) { s.WithTxnLimits(0, 1024) })
+	_, err = txnPost(small.URL, ops(1, strings.Repeat("x", 2048)))
+	if err == nil || !strings.Contains(err.Error(), "status 413") || !strings.Contains(err.Error(), "exceeds 1024 bytes") {
+		t.Fatalf("Expected 413 naming the body limit, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/conuredb/conuredb/pkg/api"
+)
+
+// getVersioned reads key through ts, returning its value and version
+func getVersioned(t *testing.T, ts *httptest.Server, key string) (string, uint64) {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/kv?key=" + key)
+	if err != nil {
+		t.Errorf("GET %s failed: %v", key, err)
+		return "", 0
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("GET %s: status %d, err %v", key, resp.StatusCode, err)
+		return "", 0
+	}
+	version, err := strconv.ParseUint(resp.Header.Get("X-Conure-Version"), 10, 64)
+	if err != nil {
+		t.Errorf("GET %s: bad X-Conure-Version %q", key, resp.Header.Get("X-Conure-Version"))
+	}
+	return strings.TrimSuffix(string(body), "\n"), version
+}
+
+// putIfVersion writes key through ts if it is still at version, returning
+// the status and the raft index the write committed at
+func putIfVersion(t *testing.T, ts *httptest.Server, key, value string, version uint64) (int, uint64) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key="+key, strings.NewReader(value))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("If-Version", strconv.FormatUint(version, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("PUT %s failed: %v", key, err)
+		return 0, 0
+	}
+	defer func() { _ = resp.Body.Close() }()
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Raft-Index"), 10, 64)
+	return resp.StatusCode, index
+}
+
+// TestIfVersionReadModifyWrite has workers increment one counter by reading
+// its version and writing back with If-Version, retrying on 412. If the
+// check is atomic no increment is lost.
+func TestIfVersionReadModifyWrite(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+
+	// Version zero means the key must not have been written yet
+	code, index := putIfVersion(t, ts, "counter", "0", 0)
+	if code != http.StatusOK {
+		t.Fatalf("Expected create at version 0 to succeed, got %d", code)
+	}
+	if code, _ := putIfVersion(t, ts, "counter", "0", 0); code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected second create at version 0 to get 412, got %d", code)
+	}
+	if _, version := getVersioned(t, ts, "counter"); version != index {
+		t.Fatalf("Expected version %d after create, got %d", index, version)
+	}
+
+	const workers, perWorker = 4, 10
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		conflicts int
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; {
+				value, version := getVersioned(t, ts, "counter")
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					t.Errorf("Counter holds %q: %v", value, err)
+					return
+				}
+				switch code, _ := putIfVersion(t, ts, "counter", strconv.Itoa(n+1), version); code {
+				case http.StatusOK:
+					i++
+				case http.StatusPreconditionFailed:
+					mu.Lock()
+					conflicts++
+					mu.Unlock()
+				default:
+					t.Errorf("Unexpected status %d", code)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _ := getVersioned(t, ts, "counter"); value != strconv.Itoa(workers*perWorker) {
+		t.Fatalf("Expected counter %d, got %s (%d conflicts retried)", workers*perWorker, value, conflicts)
+	}
+	t.Logf("%d version conflicts retried", conflicts)
+}
+
+// TestVersionsHiddenAndKeptThroughReplace checks the records holding
+// versions stay out of scans, exports and key counts, and that keys written
+// by a replace carry a version, so a create at version zero fails on them
+func TestVersionsHiddenAndKeptThroughReplace(t *testing.T) {
+	ts, database := startTestServer(t, func(s *api.Server) { s.WithAdminToken("ops") })
+	if code, _ := putIfVersion(t, ts, "a", "1", 0); code != http.StatusOK {
+		t.Fatalf("Expected create to succeed, got %d", code)
+	}
+
+	items, err := database.Scan(nil, nil, 0)
+	if err != nil || len(items) != 1 || string(items[0].Key) != "a" {
+		t.Fatalf("Expected Scan to return only a, got %d items (%v)", len(items), err)
+	}
+	resp, err := http.Get(ts.URL + "/scan")
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	var scanned struct {
+		Items []struct {
+			Key string `json:"key"`
+		} `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&scanned)
+	_ = resp.Body.Close()
+	if err != nil || len(scanned.Items) != 1 {
+		t.Fatalf("Expected /scan to return one item, got %+v (%v)", scanned.Items, err)
+	}
+	var buf bytes.Buffer
+	if err := database.ExportCSV(&buf); err != nil || buf.String() != "a,1\n" {
+		t.Fatalf("Expected the export to hold only a, got %q (%v)", buf.String(), err)
+	}
+	stats, err := database.Stats(true)
+	if err != nil || stats.Keys != 1 || stats.ReservedKeys == 0 {
+		t.Fatalf("Expected 1 key and the version counted as reserved, got %d and %d (%v)", stats.Keys, stats.ReservedKeys, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/admin/replace", strings.NewReader(`{"items":[{"key":"a","value":"2"},{"key":"b","value":"3"}]}`))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer ops")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected replace to succeed, got %d", resp.StatusCode)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, version := getVersioned(t, ts, key); version == 0 {
+			t.Errorf("Expected %s to carry a version after replace", key)
+		}
+		if code, _ := putIfVersion(t, ts, key, "x", 0); code != http.StatusPreconditionFailed {
+			t.Errorf("Expected create of %s at version 0 to get 412, got %d", key, code)
+		}
+	}
+}
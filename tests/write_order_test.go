@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentPutsHighestIndexWins races many clients writing different
+// values to one key and checks every write got its own raft index and the
+// value left behind is the one committed last
+func TestConcurrentPutsHighestIndexWins(t *testing.T) {
+	ts, _ := startTestServer(t, nil)
+
+	const clients = 20
+	const rounds = 5
+	var mu sync.Mutex
+	byIndex := make(map[uint64]string)
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				value := fmt.Sprintf("client-%d-round-%d", c, i)
+				req, err := http.NewRequest(http.MethodPut, ts.URL+"/kv?key=contended", strings.NewReader(value))
+				if err != nil {
+					t.Errorf("Failed to build request: %v", err)
+					return
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Errorf("PUT failed: %v", err)
+					return
+				}
+				_ = resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("Expected 200 from PUT, got %d", resp.StatusCode)
+					return
+				}
+				index, err := strconv.ParseUint(resp.Header.Get("X-Raft-Index"), 10, 64)
+				if err != nil {
+					t.Errorf("Bad X-Raft-Index %q", resp.Header.Get("X-Raft-Index"))
+					return
+				}
+				mu.Lock()
+				if prev, dup := byIndex[index]; dup {
+					t.Errorf("Writes %q and %q both reported index %d", prev, value, index)
+				}
+				byIndex[index] = value
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	var highest uint64
+	for index := range byIndex {
+		highest = max(highest, index)
+	}
+	resp, err := http.Get(ts.URL + "/kv?key=contended")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close response body: %v", closeErr)
+		}
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read value: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(body)), byIndex[highest]; got != want {
+		t.Fatalf("Expected the value written at the highest index %d (%q), got %q", highest, want, got)
+	}
+}
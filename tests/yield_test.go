@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/conuredb/conuredb/btree"
+	"github.com/conuredb/conuredb/db"
+)
+
+// TestScanYieldsToWriters runs a slow scan alongside a writer and checks
+// writes commit while it runs, yet the scan sees only the keys that existed
+// when it began
+func TestScanYieldsToWriters(t *testing.T) {
+	tree, err := btree.NewBTreeWithOptions(filepath.Join(t.TempDir(), "yield.db"), btree.Options{NoSync: true, YieldEvery: 50})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+
+	const n = 3000
+	ops := make([]btree.Op, 0, n)
+	for i := 0; i < n; i++ {
+		ops = append(ops, btree.Op{Key: []byte(fmt.Sprintf("key-%05d", i)), Value: []byte("v")})
+	}
+	if err := tree.Batch(ops, btree.BatchOptions{}); err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+
+	var written atomic.Int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// "new-" sorts after "key-", so the scan would reach these
+			if err := tree.Put([]byte(fmt.Sprintf("new-%05d", i)), []byte("v")); err != nil {
+				t.Errorf("Put during scan failed: %v", err)
+				return
+			}
+			written.Add(1)
+		}
+	}()
+
+	var seen int
+	var during int64
+	err = tree.Scan(nil, func(key, _ []byte) bool {
+		if seen == 0 {
+			during = -written.Load()
+		}
+		if !bytes.HasPrefix(key, []byte("key-")) {
+			t.Errorf("Scan saw %q, written after it began", key)
+			return false
+		}
+		seen++
+		if seen%20 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return true
+	})
+	during += written.Load()
+	close(stop)
+	wg.Wait()
+
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if seen != n {
+		t.Fatalf("Expected the scan to see %d keys, got %d", n, seen)
+	}
+	if during < 5 {
+		t.Fatalf("Expected writes to commit during the scan, got %d", during)
+	}
+}
+
+// TestDBTraversalsYieldUnderWrites runs Verify and full Stats on a yielding
+// database while writes and a compaction proceed, checking nothing deadlocks
+// and the tree stays sound
+func TestDBTraversalsYieldUnderWrites(t *testing.T) {
+	database, err := db.OpenWithOptions(filepath.Join(t.TempDir(), "yield.db"), db.Options{NoSync: true, YieldEvery: 5})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer func() {
+		if closeErr := database.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close database: %v", closeErr)
+		}
+	}()
+	for i := 0; i < 2000; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := database.Verify(); err != nil {
+				t.Errorf("Verify during writes failed: %v", err)
+				return
+			}
+			if _, err := database.Stats(true); err != nil {
+				t.Errorf("Stats during writes failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 300; i++ {
+		if err := database.Put([]byte(fmt.Sprintf("key-%05d", i*7)), []byte("changed")); err != nil {
+			t.Fatalf("Put during traversals failed: %v", err)
+		}
+		if i == 150 {
+			if _, err := database.Compact(); err != nil {
+				t.Fatalf("Compact during traversals failed: %v", err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if err := database.Verify(); err != nil {
+		t.Fatalf("Verify after writes failed: %v", err)
+	}
+}
+
+// TestMaxReadersBoundsPinnedScans holds yielding scans open up to MaxReaders
+// and checks the next one fails cleanly, Stats reports the open readers, and
+// scans work again once they finish
+func TestMaxReadersBoundsPinnedScans(t *testing.T) {
+	const limit = 2
+	tree, err := btree.NewBTreeWithOptions(filepath.Join(t.TempDir(), "readers.db"), btree.Options{NoSync: true, YieldEvery: 10, MaxReaders: limit})
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	defer func() {
+		if closeErr := tree.Close(); closeErr != nil {
+			t.Logf("Warning: failed to close tree: %v", closeErr)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	started := make(chan struct{}, limit)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first := true
+			if err := tree.Scan(nil, func(_, _ []byte) bool {
+				if first {
+					first = false
+					started <- struct{}{}
+					<-release
+				}
+				return true
+			}); err != nil {
+				t.Errorf("Held scan failed: %v", err)
+			}
+		}()
+	}
+	var once sync.Once
+	finish := func() {
+		once.Do(func() { close(release) })
+		wg.Wait()
+	}
+	defer finish()
+	for i := 0; i < limit; i++ {
+		<-started
+	}
+
+	err = tree.Scan(nil, func(_, _ []byte) bool { return true })
+	if !errors.Is(err, btree.ErrTooManyReaders) {
+		t.Fatalf("Expected ErrTooManyReaders past the limit, got %v", err)
+	}
+	if _, err := tree.Stats(true); !errors.Is(err, btree.ErrTooManyReaders) {
+		t.Fatalf("Expected a full Stats past the limit to fail, got %v", err)
+	}
+	stats, err := tree.Stats(false)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.OpenReaders != limit || stats.OldestReaderSeconds <= 0 {
+		t.Fatalf("Expected %d open readers with an age, got %d (%.3fs)", limit, stats.OpenReaders, stats.OldestReaderSeconds)
+	}
+
+	finish()
+
+	var n int
+	if err := tree.Scan(nil, func(_, _ []byte) bool { n++; return true }); err != nil {
+		t.Fatalf("Scan after readers finished failed: %v", err)
+	}
+	if n != 100 {
+		t.Fatalf("Expected 100 keys, got %d", n)
+	}
+	if stats, err := tree.Stats(false); err != nil || stats.OpenReaders != 0 {
+		t.Fatalf("Expected no open readers, got %d (%v)", stats.OpenReaders, err)
+	}
+}